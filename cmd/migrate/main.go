@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"inkdown-sync-server/internal/config"
+	"inkdown-sync-server/internal/storage"
+)
+
+// Applies the pending database migrations and exits. The server runs the same
+// migrations at startup; this command allows running them ahead of a deploy.
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	repos, err := storage.Open(context.Background(), cfg.Database)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	defer repos.Close()
+
+	log.Printf("%s database is up to date", cfg.Database.Driver)
+}
@@ -12,15 +12,13 @@ import (
 	"time"
 
 	"inkdown-sync-server/internal/config"
+	"inkdown-sync-server/internal/domain"
 	"inkdown-sync-server/internal/handler"
 	"inkdown-sync-server/internal/middleware"
-	"inkdown-sync-server/internal/repository"
 	"inkdown-sync-server/internal/service"
+	"inkdown-sync-server/internal/storage"
 	"inkdown-sync-server/internal/websocket"
 
-	_ "github.com/go-kivik/kivik/v4/couchdb"
-
-	"github.com/go-kivik/kivik/v4"
 	"github.com/gorilla/mux"
 )
 
@@ -30,41 +28,26 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	couchURL := fmt.Sprintf("http://%s:%s@%s:%s",
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.Host,
-		cfg.Database.Port,
-	)
-
-	client, err := kivik.New("couch", couchURL)
-	if err != nil {
-		log.Fatalf("Failed to connect to CouchDB: %v", err)
-	}
-
-	exists, err := client.DBExists(context.Background(), cfg.Database.Name)
+	repos, err := storage.Open(context.Background(), cfg.Database)
 	if err != nil {
-		log.Fatalf("Failed to check database existence: %v", err)
-	}
-
-	if !exists {
-		if err := client.CreateDB(context.Background(), cfg.Database.Name); err != nil {
-			log.Fatalf("Failed to create database: %v", err)
-		}
-		log.Printf("Created database: %s", cfg.Database.Name)
+		log.Fatalf("Failed to open %s storage: %v", cfg.Database.Driver, err)
 	}
-
-	userRepo := repository.NewUserRepository(client, cfg.Database.Name)
-	deviceRepo := repository.NewDeviceRepository(client, cfg.Database.Name)
-	keyStoreRepo := repository.NewKeyStoreRepository(client, cfg.Database.Name)
-	noteRepo := repository.NewNoteRepository(client, cfg.Database.Name)
-	workspaceRepo := repository.NewWorkspaceRepository(client, cfg.Database.Name)
-	cliTokenRepo := repository.NewCLITokenRepository(client, cfg.Database.Name)
-
-	baseURL := fmt.Sprintf("%s/%s", couchURL, cfg.Database.Name)
-	versionRepo := repository.NewNoteVersionRepository(baseURL)
-	syncMetadataRepo := repository.NewSyncMetadataRepository(baseURL)
-	conflictRepo := repository.NewConflictRepository(baseURL)
+	defer repos.Close()
+
+	userRepo := repos.Users
+	deviceRepo := repos.Devices
+	keyStoreRepo := repos.KeyStores
+	noteRepo := repos.Notes
+	workspaceRepo := repos.Workspaces
+	cliTokenRepo := repos.CLITokens
+	usageRepo := repos.Usage
+	tombstoneRepo := repos.Tombstones
+	jobRepo := repos.Jobs
+	versionRepo := repos.Versions
+	syncMetadataRepo := repos.SyncMetadata
+	conflictRepo := repos.Conflicts
+	crdtRepo := repos.CRDT
+	chunkRepo := repos.Chunks
 
 	// WebSocket Manager
 	wsManager := websocket.NewManager(
@@ -72,32 +55,69 @@ func main() {
 		cfg.WebSocket.WriteWait,
 		cfg.WebSocket.PongWait,
 		cfg.WebSocket.PingPeriod,
+		websocket.DeliveryPolicy{
+			ReplayBuffer:    cfg.WebSocket.ReplayBuffer,
+			AckTimeout:      cfg.WebSocket.AckTimeout,
+			ReplayRetention: cfg.WebSocket.ReplayRetention,
+		},
 	)
 	go wsManager.Run()
 
-	authService := service.NewAuthService(userRepo, cfg.JWT.Secret, cfg.JWT.Expiration, cfg.JWT.RefreshTokenExpiration)
 	userService := service.NewUserService(userRepo)
 	deviceService := service.NewDeviceService(deviceRepo)
 	securityService := service.NewSecurityService(keyStoreRepo)
 	cliTokenService := service.NewCLITokenService(cliTokenRepo, userRepo)
 
-	syncService := service.NewSyncService(noteRepo, versionRepo, syncMetadataRepo, wsManager)
-	conflictService := service.NewConflictService(conflictRepo, versionRepo, noteRepo)
-	noteService := service.NewNoteService(noteRepo, versionRepo, conflictService, syncService)
-	workspaceService := service.NewWorkspaceService(workspaceRepo, noteRepo)
+	syncService := service.NewSyncService(noteRepo, versionRepo, syncMetadataRepo, tombstoneRepo, workspaceRepo, wsManager)
+	chunkService := service.NewChunkService(chunkRepo, noteRepo)
+	usageService := service.NewUsageService(usageRepo, userRepo, noteRepo, versionRepo, conflictRepo, chunkRepo, crdtRepo, service.QuotaLimits{
+		PerUser:      cfg.Quota.MaxBytesPerUser,
+		PerWorkspace: cfg.Quota.MaxBytesPerWorkspace,
+		PerNote:      cfg.Quota.MaxNoteBytes,
+	})
+	conflictService := service.NewConflictService(conflictRepo, versionRepo, noteRepo, workspaceRepo, syncService, chunkService, usageService, service.ConflictExpiryPolicy{
+		MaxAge: cfg.Conflict.ExpireAfter,
+		Action: domain.ResolutionStrategy(cfg.Conflict.ExpireAction),
+	})
+	trashService := service.NewTrashService(noteRepo, workspaceRepo, versionRepo, conflictRepo, crdtRepo, chunkRepo, tombstoneRepo, syncService, usageService, cfg.Trash.Retention, cfg.Trash.TombstoneRetention)
+	workspaceService := service.NewWorkspaceService(workspaceRepo, noteRepo, userRepo, jobRepo, conflictRepo, crdtRepo, trashService, syncService, usageService)
+	authService := service.NewAuthService(userRepo, workspaceService, cfg.JWT.Secret, cfg.JWT.Expiration, cfg.JWT.RefreshTokenExpiration)
+	archiveService := service.NewArchiveService(workspaceService, noteRepo, versionRepo, chunkRepo, usageService)
+	noteService := service.NewNoteService(noteRepo, versionRepo, conflictService, syncService, usageService, workspaceService, chunkService)
+	crdtService := service.NewCRDTService(crdtRepo, noteRepo, workspaceService, syncService, usageService, service.CRDTPolicy{
+		SnapshotEvery:  cfg.CRDT.SnapshotEvery,
+		MaxUpdateBytes: cfg.CRDT.MaxUpdateBytes,
+	})
+
+	if err := workspaceService.MigrateDefaultWorkspaces(context.Background()); err != nil {
+		log.Printf("Default workspace migration failed: %v", err)
+	}
 
-	wsMessageHandler := handler.NewWebSocketMessageHandler(syncService)
+	wsMessageHandler := handler.NewWebSocketMessageHandler(syncService, crdtService, cfg.Server.RequestTimeout)
 	wsManager.SetMessageHandler(wsMessageHandler)
 
+	// Background jobs stop when the server shuts down
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go usageService.RunReconciler(jobsCtx, cfg.Quota.ReconcileInterval)
+	go trashService.RunPurger(jobsCtx, cfg.Trash.PurgeInterval)
+	go workspaceService.RunJobs(jobsCtx, cfg.Jobs.PollInterval)
+	go conflictService.RunExpirer(jobsCtx, cfg.Conflict.ExpireInterval)
+
 	authHandler := handler.NewAuthHandler(authService)
 	userHandler := handler.NewUserHandler(userService)
 	deviceHandler := handler.NewDeviceHandler(deviceService)
 	securityHandler := handler.NewSecurityHandler(securityService)
 	noteHandler := handler.NewNoteHandler(noteService)
+	chunkHandler := handler.NewChunkHandler(chunkService)
 	wsHandler := handler.NewWebSocketHandler(wsManager, cfg.JWT.Secret)
 	syncHandler := handler.NewSyncHandler(syncService, conflictService)
 	workspaceHandler := handler.NewWorkspaceHandler(workspaceService)
+	archiveHandler := handler.NewArchiveHandler(workspaceService, archiveService)
 	cliTokenHandler := handler.NewCLITokenHandler(cliTokenService)
+	usageHandler := handler.NewUsageHandler(usageService)
+	trashHandler := handler.NewTrashHandler(trashService)
 
 	r := mux.NewRouter()
 
@@ -109,6 +129,7 @@ func main() {
 	))
 
 	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(middleware.TimeoutMiddleware(cfg.Server.RequestTimeout))
 
 	api.HandleFunc("/auth/register", authHandler.Register).Methods("POST", "OPTIONS")
 	api.HandleFunc("/auth/login", authHandler.Login).Methods("POST", "OPTIONS")
@@ -123,6 +144,7 @@ func main() {
 
 	protected.HandleFunc("/users/me", userHandler.GetMe).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/users/me", userHandler.UpdateMe).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/users/me/usage", usageHandler.GetMe).Methods("GET", "OPTIONS")
 
 	protected.HandleFunc("/cli/tokens", cliTokenHandler.Create).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/cli/tokens", cliTokenHandler.List).Methods("GET", "OPTIONS")
@@ -142,18 +164,36 @@ func main() {
 	protected.HandleFunc("/notes/{id}", noteHandler.Get).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/notes/{id}", noteHandler.Update).Methods("PUT", "OPTIONS")
 	protected.HandleFunc("/notes/{id}", noteHandler.Delete).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/notes/{id}/move", noteHandler.Move).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/notes/{id}/restore", trashHandler.Restore).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/notes/{id}/chunks/missing", chunkHandler.Missing).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/notes/{id}/chunks/fetch", chunkHandler.Fetch).Methods("POST", "OPTIONS")
+
+	protected.HandleFunc("/trash", trashHandler.List).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/trash/{id}", trashHandler.Purge).Methods("DELETE", "OPTIONS")
 
 	protected.HandleFunc("/workspaces", workspaceHandler.Create).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/workspaces", workspaceHandler.List).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/workspaces/import", archiveHandler.Import).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/workspaces/transfers", workspaceHandler.ListTransfers).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/workspaces/{id}", workspaceHandler.Get).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/workspaces/{id}", workspaceHandler.Update).Methods("PUT", "OPTIONS")
 	protected.HandleFunc("/workspaces/{id}", workspaceHandler.Delete).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/workspaces/{id}/export", archiveHandler.Export).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/workspaces/{id}/archive", workspaceHandler.Archive).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/workspaces/{id}/unarchive", workspaceHandler.Unarchive).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/workspaces/{id}/default", workspaceHandler.SetDefault).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/workspaces/{id}/transfer", workspaceHandler.RequestTransfer).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/workspaces/{id}/transfer/accept", workspaceHandler.AcceptTransfer).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/workspaces/{id}/transfer/decline", workspaceHandler.DeclineTransfer).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/jobs/{id}", workspaceHandler.GetJob).Methods("GET", "OPTIONS")
 
 	protected.HandleFunc("/sync/request", syncHandler.ProcessSync).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/sync/changes", syncHandler.GetChanges).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/sync/manifest", syncHandler.GetManifest).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/sync/batch-diff", syncHandler.BatchDiff).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/sync/conflicts", syncHandler.ListConflicts).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/sync/conflicts/{id}", syncHandler.GetConflict).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/sync/resolve/{id}", syncHandler.ResolveConflict).Methods("POST", "OPTIONS")
 
 	// These routes use CLI tokens (ink_xxxxx) instead of JWT
@@ -189,7 +229,14 @@ func main() {
 
 	go func() {
 		log.Printf("Starting Inkdown Sync Server on %s (env: %s)", addr, cfg.Server.Env)
-		log.Printf("Connected to CouchDB at %s:%s", cfg.Database.Host, cfg.Database.Port)
+		switch cfg.Database.Driver {
+		case storage.DriverSQLite:
+			log.Printf("Using SQLite database at %s", cfg.Database.Path)
+		case storage.DriverMemory:
+			log.Printf("Using in-memory database")
+		default:
+			log.Printf("Connected to CouchDB at %s:%s", cfg.Database.Host, cfg.Database.Port)
+		}
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed to start: %v", err)
 		}
@@ -200,6 +247,7 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
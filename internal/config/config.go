@@ -17,15 +17,23 @@ type Config struct {
 	RateLimit RateLimitConfig
 	CORS      CORSConfig
 	Logging   LoggingConfig
+	Quota     QuotaConfig
+	Trash     TrashConfig
+	Jobs      JobsConfig
+	Conflict  ConflictConfig
+	CRDT      CRDTConfig
 }
 
 type ServerConfig struct {
-	Port string
-	Host string
-	Env  string
+	Port           string
+	Host           string
+	Env            string
+	RequestTimeout time.Duration
 }
 
 type DatabaseConfig struct {
+	Driver   string // couchdb, sqlite or memory
+	Path     string // SQLite database file
 	Host     string
 	Port     string
 	User     string
@@ -47,6 +55,9 @@ type WebSocketConfig struct {
 	PongWait        time.Duration
 	PingPeriod      time.Duration
 	MaxConnPerUser  int
+	ReplayBuffer    int           // unacked messages kept per device
+	AckTimeout      time.Duration // resend unacked messages after it, 0 never resends
+	ReplayRetention time.Duration // how long a disconnected device can resume
 }
 
 type RateLimitConfig struct {
@@ -64,6 +75,34 @@ type LoggingConfig struct {
 	Level string
 }
 
+type QuotaConfig struct {
+	MaxBytesPerUser      int64
+	MaxBytesPerWorkspace int64
+	MaxNoteBytes         int64
+	ReconcileInterval    time.Duration
+}
+
+type TrashConfig struct {
+	Retention          time.Duration
+	PurgeInterval      time.Duration
+	TombstoneRetention time.Duration
+}
+
+type JobsConfig struct {
+	PollInterval time.Duration
+}
+
+type CRDTConfig struct {
+	SnapshotEvery  int   // updates between snapshot requests, 0 never asks
+	MaxUpdateBytes int64 // 0 accepts updates of any size
+}
+
+type ConflictConfig struct {
+	ExpireAfter    time.Duration // 0 keeps unresolved conflicts forever
+	ExpireAction   string        // expired, server, client or lww
+	ExpireInterval time.Duration
+}
+
 func Load() (*Config, error) {
 	godotenv.Load()
 
@@ -77,13 +116,78 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid REFRESH_TOKEN_EXPIRATION: %w", err)
 	}
 
+	reconcileInterval, err := getEnvAsInterval("QUOTA_RECONCILE_INTERVAL", "1h")
+	if err != nil {
+		return nil, err
+	}
+
+	trashRetention, err := time.ParseDuration(getEnv("TRASH_RETENTION", "720h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRASH_RETENTION: %w", err)
+	}
+
+	trashPurgeInterval, err := getEnvAsInterval("TRASH_PURGE_INTERVAL", "1h")
+	if err != nil {
+		return nil, err
+	}
+
+	tombstoneRetention, err := time.ParseDuration(getEnv("TOMBSTONE_RETENTION", "2160h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOMBSTONE_RETENTION: %w", err)
+	}
+
+	jobPollInterval, err := getEnvAsInterval("JOB_POLL_INTERVAL", "30s")
+	if err != nil {
+		return nil, err
+	}
+
+	requestTimeout, err := time.ParseDuration(getEnv("REQUEST_TIMEOUT", "15s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REQUEST_TIMEOUT: %w", err)
+	}
+
+	conflictExpireAfter, err := time.ParseDuration(getEnv("CONFLICT_EXPIRE_AFTER", "720h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONFLICT_EXPIRE_AFTER: %w", err)
+	}
+
+	conflictExpireInterval, err := getEnvAsInterval("CONFLICT_EXPIRE_INTERVAL", "1h")
+	if err != nil {
+		return nil, err
+	}
+
+	wsAckTimeout, err := time.ParseDuration(getEnv("WS_ACK_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WS_ACK_TIMEOUT: %w", err)
+	}
+
+	wsReplayRetention, err := time.ParseDuration(getEnv("WS_REPLAY_RETENTION", "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WS_REPLAY_RETENTION: %w", err)
+	}
+
+	wsReplayBuffer := getEnvAsInt("WS_REPLAY_BUFFER", 1000)
+	if wsReplayBuffer < 0 {
+		return nil, fmt.Errorf("invalid WS_REPLAY_BUFFER: must not be negative")
+	}
+
+	conflictExpireAction := getEnv("CONFLICT_EXPIRE_ACTION", "expired")
+	switch conflictExpireAction {
+	case "expired", "server", "client", "lww":
+	default:
+		return nil, fmt.Errorf("invalid CONFLICT_EXPIRE_ACTION: %q", conflictExpireAction)
+	}
+
 	return &Config{
 		Server: ServerConfig{
-			Port: getEnv("PORT", "8080"),
-			Host: getEnv("HOST", "0.0.0.0"),
-			Env:  getEnv("ENV", "development"),
+			Port:           getEnv("PORT", "8080"),
+			Host:           getEnv("HOST", "0.0.0.0"),
+			Env:            getEnv("ENV", "development"),
+			RequestTimeout: requestTimeout,
 		},
 		Database: DatabaseConfig{
+			Driver:   getEnv("DB_DRIVER", "couchdb"),
+			Path:     getEnv("DB_PATH", "inkdown.db"),
 			Host:     getEnv("DB_HOST", "localhost"),
 			Port:     getEnv("DB_PORT", "5984"),
 			User:     getEnv("DB_USER", "admin"),
@@ -103,6 +207,9 @@ func Load() (*Config, error) {
 			PongWait:        60 * time.Second,
 			PingPeriod:      54 * time.Second,
 			MaxConnPerUser:  getEnvAsInt("WS_MAX_CONN_PER_USER", 5),
+			ReplayBuffer:    wsReplayBuffer,
+			AckTimeout:      wsAckTimeout,
+			ReplayRetention: wsReplayRetention,
 		},
 		RateLimit: RateLimitConfig{
 			RequestsPerMinute: getEnvAsInt("RATE_LIMIT_REQUESTS_PER_MINUTE", 60),
@@ -116,6 +223,29 @@ func Load() (*Config, error) {
 		Logging: LoggingConfig{
 			Level: getEnv("LOG_LEVEL", "info"),
 		},
+		Quota: QuotaConfig{
+			MaxBytesPerUser:      getEnvAsInt64("QUOTA_MAX_BYTES_PER_USER", 1073741824),
+			MaxBytesPerWorkspace: getEnvAsInt64("QUOTA_MAX_BYTES_PER_WORKSPACE", 0),
+			MaxNoteBytes:         getEnvAsInt64("QUOTA_MAX_NOTE_BYTES", 10485760),
+			ReconcileInterval:    reconcileInterval,
+		},
+		Trash: TrashConfig{
+			Retention:          trashRetention,
+			PurgeInterval:      trashPurgeInterval,
+			TombstoneRetention: tombstoneRetention,
+		},
+		Jobs: JobsConfig{
+			PollInterval: jobPollInterval,
+		},
+		Conflict: ConflictConfig{
+			ExpireAfter:    conflictExpireAfter,
+			ExpireAction:   conflictExpireAction,
+			ExpireInterval: conflictExpireInterval,
+		},
+		CRDT: CRDTConfig{
+			SnapshotEvery:  getEnvAsInt("CRDT_SNAPSHOT_EVERY", 200),
+			MaxUpdateBytes: getEnvAsInt64("CRDT_MAX_UPDATE_BYTES", 1048576),
+		},
 	}, nil
 }
 
@@ -134,6 +264,27 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsInt64(key string, defaultValue int64) int64 {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseInt(valueStr, 10, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsInterval parses the period of a background job, which has to be
+// positive
+func getEnvAsInterval(key, defaultValue string) (time.Duration, error) {
+	interval, err := time.ParseDuration(getEnv(key, defaultValue))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be positive", key)
+	}
+	return interval, nil
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	valueStr := getEnv(key, "")
 	if value, err := strconv.ParseBool(valueStr); err == nil {
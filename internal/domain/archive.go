@@ -0,0 +1,28 @@
+package domain
+
+import "time"
+
+// ArchiveFormatVersion is the version of the workspace archive layout
+const ArchiveFormatVersion = 1
+
+// ArchiveManifest is stored as manifest.json, the first entry of a workspace
+// archive
+type ArchiveManifest struct {
+	FormatVersion    int              `json:"format_version"`
+	Workspace        ArchiveWorkspace `json:"workspace"`
+	ExportedAt       time.Time        `json:"exported_at"`
+	NoteCount        int              `json:"note_count"`
+	VersionCount     int              `json:"version_count"`
+	IncludesVersions bool             `json:"includes_versions"`
+}
+
+type ArchiveWorkspace struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ImportWorkspaceResponse struct {
+	Workspace    *WorkspaceResponse `json:"workspace"`
+	NoteCount    int                `json:"note_count"`
+	VersionCount int                `json:"version_count"`
+}
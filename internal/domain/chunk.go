@@ -0,0 +1,32 @@
+package domain
+
+import "time"
+
+// Chunk is an independently encrypted piece of the content of a note. Chunks
+// are addressed by the hex SHA-256 of their data and stored once per note, so
+// the versions of a note share the chunks they have in common.
+type Chunk struct {
+	NoteID    string    `json:"note_id"`
+	Hash      string    `json:"hash"`
+	Data      string    `json:"data"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChunkData is a chunk as uploaded and downloaded by devices
+type ChunkData struct {
+	Hash string `json:"hash"`
+	Data string `json:"data"`
+}
+
+type ChunkHashesRequest struct {
+	Hashes []string `json:"hashes" validate:"required,max=1000"`
+}
+
+type MissingChunksResponse struct {
+	Missing []string `json:"missing"`
+}
+
+type ChunksResponse struct {
+	Chunks []*ChunkData `json:"chunks"`
+}
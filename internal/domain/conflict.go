@@ -16,12 +16,27 @@ const (
 	ResolutionServer ResolutionStrategy = "server"
 	ResolutionClient ResolutionStrategy = "client"
 	ResolutionManual ResolutionStrategy = "manual"
+
+	// ResolutionDuplicate keeps the server note and stores the client's
+	// edits as a conflicted copy next to it.
+	ResolutionDuplicate ResolutionStrategy = "duplicate"
+
+	// ResolutionRestore and ResolutionKeepDeleted only apply to delete
+	// conflicts: the note is either brought back with the edits or left in
+	// the trash.
+	ResolutionRestore     ResolutionStrategy = "restore"
+	ResolutionKeepDeleted ResolutionStrategy = "keep_deleted"
+
+	// ResolutionExpired marks a conflict that was dropped after going
+	// unresolved for too long. It leaves the note as it is.
+	ResolutionExpired ResolutionStrategy = "expired"
 )
 
 type Conflict struct {
 	ID               string             `json:"id"`
 	NoteID           string             `json:"note_id"`
 	UserID           string             `json:"user_id"`
+	WorkspaceID      string             `json:"workspace_id,omitempty"`
 	Type             ConflictType       `json:"type"`
 	BaseVersion      int64              `json:"base_version"`
 	ServerVersion    int64              `json:"server_version"`
@@ -32,9 +47,103 @@ type Conflict struct {
 	DetectedAt       time.Time          `json:"detected_at"`
 	ResolvedAt       *time.Time         `json:"resolved_at,omitempty"`
 	ResolutionChoice ResolutionStrategy `json:"resolution_choice,omitempty"`
+	// AutoResolved is set when the workspace's conflict policy resolved the
+	// conflict on detection, leaving the note at ResolvedVersion.
+	AutoResolved    bool  `json:"auto_resolved,omitempty"`
+	ResolvedVersion int64 `json:"resolved_version,omitempty"`
+}
+
+// NoteWorkspaceID returns the workspace of the conflicting note. Conflicts
+// recorded before WorkspaceID existed only carry it in ServerNote.
+func (c *Conflict) NoteWorkspaceID() string {
+	if c.WorkspaceID == "" && c.ServerNote != nil {
+		return c.ServerNote.WorkspaceID
+	}
+	return c.WorkspaceID
+}
+
+// Summary returns the conflict without the note payloads
+func (c *Conflict) Summary() *ConflictSummary {
+	return &ConflictSummary{
+		ID:               c.ID,
+		NoteID:           c.NoteID,
+		WorkspaceID:      c.NoteWorkspaceID(),
+		Type:             c.Type,
+		BaseVersion:      c.BaseVersion,
+		ServerVersion:    c.ServerVersion,
+		ClientVersion:    c.ClientVersion,
+		DeviceID:         c.DeviceID,
+		DetectedAt:       c.DetectedAt,
+		ResolvedAt:       c.ResolvedAt,
+		ResolutionChoice: c.ResolutionChoice,
+		AutoResolved:     c.AutoResolved,
+	}
+}
+
+type ConflictSummary struct {
+	ID               string             `json:"id"`
+	NoteID           string             `json:"note_id"`
+	WorkspaceID      string             `json:"workspace_id"`
+	Type             ConflictType       `json:"type"`
+	BaseVersion      int64              `json:"base_version"`
+	ServerVersion    int64              `json:"server_version"`
+	ClientVersion    int64              `json:"client_version"`
+	DeviceID         string             `json:"device_id"`
+	DetectedAt       time.Time          `json:"detected_at"`
+	ResolvedAt       *time.Time         `json:"resolved_at,omitempty"`
+	ResolutionChoice ResolutionStrategy `json:"resolution_choice,omitempty"`
+	AutoResolved     bool               `json:"auto_resolved,omitempty"`
+}
+
+// ConflictDetail is a conflict together with the encrypted versions a device
+// needs for a three-way merge: Base is the version both sides started from
+// and Intermediate the versions the server went through after it, oldest
+// first. Base is nil when that version is no longer stored.
+type ConflictDetail struct {
+	*Conflict
+	Base         *NoteVersion   `json:"base,omitempty"`
+	Intermediate []*NoteVersion `json:"intermediate_versions"`
+}
+
+// ConflictFilter selects a page of a user's conflicts, newest first. Empty
+// fields match every conflict and a zero Limit returns all of them.
+type ConflictFilter struct {
+	UnresolvedOnly bool
+	NoteID         string
+	WorkspaceID    string
+	DeviceID       string
+	Limit          int
+	Offset         int
+}
+
+// Matches reports whether c passes the filter, ignoring Limit and Offset
+func (f ConflictFilter) Matches(c *Conflict) bool {
+	if f.UnresolvedOnly && c.ResolvedAt != nil {
+		return false
+	}
+	if f.NoteID != "" && c.NoteID != f.NoteID {
+		return false
+	}
+	if f.WorkspaceID != "" && c.NoteWorkspaceID() != f.WorkspaceID {
+		return false
+	}
+	if f.DeviceID != "" && c.DeviceID != f.DeviceID {
+		return false
+	}
+	return true
+}
+
+// IsConflictPolicy reports whether s can be used as a workspace conflict
+// policy
+func IsConflictPolicy(s ResolutionStrategy) bool {
+	switch s {
+	case ResolutionManual, ResolutionLWW, ResolutionServer, ResolutionClient, ResolutionDuplicate:
+		return true
+	}
+	return false
 }
 
 type ConflictResolutionRequest struct {
-	Strategy ResolutionStrategy `json:"strategy" validate:"required,oneof=lww server client manual"`
+	Strategy ResolutionStrategy `json:"strategy" validate:"required,oneof=lww server client manual duplicate restore keep_deleted"`
 	NoteData *UpdateNoteRequest `json:"note_data,omitempty"`
 }
@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// CRDTUpdate is an encrypted CRDT update (for example a Yjs or Automerge
+// delta) of a note. The server cannot read it; it only numbers the updates of
+// each note in the order they arrive and relays them.
+type CRDTUpdate struct {
+	NoteID    string    `json:"note_id"`
+	Seq       int64     `json:"seq"`
+	UserID    string    `json:"user_id"`
+	DeviceID  string    `json:"device_id"`
+	Data      string    `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CRDTSnapshot is the encrypted document state of a note after every update
+// up to Seq. Devices upload it so the updates it covers can be dropped.
+type CRDTSnapshot struct {
+	NoteID    string    `json:"note_id"`
+	Seq       int64     `json:"seq"`
+	DeviceID  string    `json:"device_id"`
+	Data      string    `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CRDTState is what a device needs to catch up on a note: the latest snapshot
+// if the updates it is missing were compacted into it, and the updates that
+// follow. LatestSeq is the sequence number of the newest update.
+type CRDTState struct {
+	NoteID    string        `json:"note_id"`
+	Snapshot  *CRDTSnapshot `json:"snapshot,omitempty"`
+	Updates   []*CRDTUpdate `json:"updates"`
+	LatestSeq int64         `json:"latest_seq"`
+	HasMore   bool          `json:"has_more"`
+}
@@ -0,0 +1,39 @@
+package domain
+
+import "time"
+
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusCompleted JobStatus = "completed"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+const (
+	JobTypeWorkspaceDelete   = "workspace_delete"
+	JobTypeWorkspaceTransfer = "workspace_transfer"
+)
+
+// Workspace deletion modes
+const (
+	WorkspaceDeleteTrash = "trash" // notes go to the trash and expire from there
+	WorkspaceDeletePurge = "purge" // notes are permanently deleted, leaving tombstones
+	WorkspaceDeleteMove  = "move"  // notes are moved to the owner's default workspace
+)
+
+// Job is a long running operation processed in the background
+type Job struct {
+	ID          string            `json:"id"`
+	UserID      string            `json:"user_id"`
+	Type        string            `json:"type"`
+	Status      JobStatus         `json:"status"`
+	Params      map[string]string `json:"params,omitempty"`
+	Total       int               `json:"total"`
+	Processed   int               `json:"processed"`
+	Error       string            `json:"error,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
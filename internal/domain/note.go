@@ -1,6 +1,10 @@
 package domain
 
-import "time"
+import (
+	"time"
+
+	"inkdown-sync-server/pkg/hlc"
+)
 
 type NoteType string
 
@@ -20,13 +24,30 @@ type Note struct {
 	EncryptedContent string `json:"encrypted_content,omitempty"`
 	EncryptionAlgo   string `json:"encryption_algo"`
 	Nonce            string `json:"nonce"`
+	// Chunks lists in order the hashes of the chunks of a note stored as
+	// chunked content, whose EncryptedContent is then empty. ContentSize is
+	// the total size of those chunks.
+	Chunks      []string `json:"chunks,omitempty"`
+	ContentSize int64    `json:"content_size,omitempty"`
 
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	IsDeleted      bool      `json:"is_deleted"`
-	Version        int64     `json:"version"`
-	ContentHash    string    `json:"content_hash"`
-	LastEditDevice string    `json:"last_edit_device"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	IsDeleted      bool       `json:"is_deleted"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	Version        int64      `json:"version"`
+	ContentHash    string     `json:"content_hash"`
+	LastEditDevice string     `json:"last_edit_device"`
+	// EditedAt is when the last edit was made on the device, and HLC its
+	// hybrid logical clock timestamp, which orders edits for LWW resolution.
+	EditedAt time.Time     `json:"edited_at"`
+	HLC      hlc.Timestamp `json:"hlc,omitzero"`
+	// ConflictID is set on conflicted copies to the conflict whose losing
+	// edits they hold
+	ConflictID string `json:"conflict_id,omitempty"`
+
+	// Rev is the storage revision the note was read at. When set, Update
+	// only succeeds if the stored note is still at this revision.
+	Rev string `json:"-"`
 }
 
 type CreateNoteRequest struct {
@@ -39,6 +60,10 @@ type CreateNoteRequest struct {
 	Nonce            string   `json:"nonce" validate:"required"`
 	ContentHash      string   `json:"content_hash"`
 	DeviceID         string   `json:"device_id" validate:"required"`
+	// Chunks replaces EncryptedContent for chunked content. NewChunks must
+	// carry every chunk it lists.
+	Chunks    []string    `json:"chunks,omitempty"`
+	NewChunks []ChunkData `json:"new_chunks,omitempty"`
 }
 
 type UpdateNoteRequest struct {
@@ -51,21 +76,50 @@ type UpdateNoteRequest struct {
 	ExpectedVersion  *int64  `json:"expected_version"`
 	ContentHash      *string `json:"content_hash"`
 	DeviceID         string  `json:"device_id"`
+	// EditedAt and HLC are taken from the device's clocks when the edit was
+	// made. The server assigns them if they are missing.
+	EditedAt *time.Time    `json:"edited_at,omitempty"`
+	HLC      hlc.Timestamp `json:"hlc,omitzero"`
+	// Chunks replaces the content with chunked content. NewChunks only needs
+	// the chunks the server does not have for the note yet. ContentSize is
+	// set by the server once the chunks are checked.
+	Chunks      []string    `json:"chunks,omitempty"`
+	NewChunks   []ChunkData `json:"new_chunks,omitempty"`
+	ContentSize int64       `json:"content_size,omitempty"`
 }
 
 type NoteResponse struct {
-	ID               string    `json:"id"`
-	WorkspaceID      string    `json:"workspace_id"`
-	ParentID         *string   `json:"parent_id"`
-	Type             NoteType  `json:"type"`
-	EncryptedTitle   string    `json:"encrypted_title"`
-	EncryptedContent string    `json:"encrypted_content,omitempty"`
-	EncryptionAlgo   string    `json:"encryption_algo"`
-	Nonce            string    `json:"nonce"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
-	IsDeleted        bool      `json:"is_deleted"`
-	Version          int64     `json:"version"`
-	ContentHash      string    `json:"content_hash"`
-	LastEditDevice   string    `json:"last_edit_device"`
+	ID               string        `json:"id"`
+	WorkspaceID      string        `json:"workspace_id"`
+	ParentID         *string       `json:"parent_id"`
+	Type             NoteType      `json:"type"`
+	EncryptedTitle   string        `json:"encrypted_title"`
+	EncryptedContent string        `json:"encrypted_content,omitempty"`
+	EncryptionAlgo   string        `json:"encryption_algo"`
+	Nonce            string        `json:"nonce"`
+	Chunks           []string      `json:"chunks,omitempty"`
+	ContentSize      int64         `json:"content_size,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+	IsDeleted        bool          `json:"is_deleted"`
+	DeletedAt        *time.Time    `json:"deleted_at,omitempty"`
+	Version          int64         `json:"version"`
+	ContentHash      string        `json:"content_hash"`
+	LastEditDevice   string        `json:"last_edit_device"`
+	EditedAt         time.Time     `json:"edited_at"`
+	HLC              hlc.Timestamp `json:"hlc,omitzero"`
+	ConflictID       string        `json:"conflict_id,omitempty"`
+	// ResolvedConflict is set when the write conflicted and the workspace's
+	// conflict policy resolved it into this note
+	ResolvedConflict *ConflictSummary `json:"resolved_conflict,omitempty"`
+}
+
+type MoveNoteRequest struct {
+	WorkspaceID string  `json:"workspace_id" validate:"required"`
+	ParentID    *string `json:"parent_id"`
+	DeviceID    string  `json:"device_id" validate:"required"`
+}
+
+type RestoreNoteRequest struct {
+	DeviceID string `json:"device_id"`
 }
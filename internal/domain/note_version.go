@@ -1,14 +1,22 @@
 package domain
 
-import "time"
+import (
+	"time"
+
+	"inkdown-sync-server/pkg/hlc"
+)
 
 type NoteVersion struct {
-	ID               string    `json:"id"`
-	NoteID           string    `json:"note_id"`
-	Version          int64     `json:"version"`
-	EncryptedContent string    `json:"encrypted_content"`
-	EncryptedTitle   string    `json:"encrypted_title"`
-	ContentHash      string    `json:"content_hash"`
-	DeviceID         string    `json:"device_id"`
-	CreatedAt        time.Time `json:"created_at"`
+	ID               string        `json:"id"`
+	NoteID           string        `json:"note_id"`
+	Version          int64         `json:"version"`
+	EncryptedContent string        `json:"encrypted_content"`
+	Chunks           []string      `json:"chunks,omitempty"`
+	ContentSize      int64         `json:"content_size,omitempty"`
+	EncryptedTitle   string        `json:"encrypted_title"`
+	ContentHash      string        `json:"content_hash"`
+	DeviceID         string        `json:"device_id"`
+	CreatedAt        time.Time     `json:"created_at"`
+	EditedAt         time.Time     `json:"edited_at"`
+	HLC              hlc.Timestamp `json:"hlc,omitzero"`
 }
@@ -8,6 +8,7 @@ type SyncMetadata struct {
 	LastSyncTime     time.Time        `json:"last_sync_time"`
 	NoteVersions     map[string]int64 `json:"note_versions"`
 	PendingConflicts []string         `json:"pending_conflicts"`
+	WorkspaceIDs     []string         `json:"workspace_ids,omitempty"`
 	UpdatedAt        time.Time        `json:"updated_at"`
 }
 
@@ -15,6 +16,9 @@ type SyncRequest struct {
 	DeviceID     string           `json:"device_id" validate:"required"`
 	LastSyncTime time.Time        `json:"last_sync_time"`
 	NoteVersions map[string]int64 `json:"note_versions"`
+	// WorkspaceIDs replaces the device's subscription when present; an empty
+	// list resets it to every workspace
+	WorkspaceIDs []string `json:"workspace_ids,omitempty"`
 }
 
 type SyncResponse struct {
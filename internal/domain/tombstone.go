@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// Tombstone records a permanently purged note so that devices which have not
+// synced since the deletion still receive a delete operation.
+type Tombstone struct {
+	NoteID      string    `json:"note_id"`
+	UserID      string    `json:"user_id"`
+	WorkspaceID string    `json:"workspace_id"`
+	Version     int64     `json:"version"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	PurgedAt    time.Time `json:"purged_at"`
+}
@@ -0,0 +1,46 @@
+package domain
+
+import "time"
+
+type Usage struct {
+	UserID        string                     `json:"user_id"`
+	ContentBytes  int64                      `json:"content_bytes"`
+	VersionBytes  int64                      `json:"version_bytes"`
+	ConflictBytes int64                      `json:"conflict_bytes"`
+	Workspaces    map[string]*WorkspaceUsage `json:"workspaces"`
+	ReconciledAt  *time.Time                 `json:"reconciled_at,omitempty"`
+	UpdatedAt     time.Time                  `json:"updated_at"`
+	// Rev is the storage revision the usage was read at. Save fails with a
+	// conflict if the stored usage changed since; empty means not stored yet.
+	Rev string `json:"-"`
+}
+
+type WorkspaceUsage struct {
+	ContentBytes int64 `json:"content_bytes"`
+	VersionBytes int64 `json:"version_bytes"`
+}
+
+func (u *Usage) TotalBytes() int64 {
+	return u.ContentBytes + u.VersionBytes + u.ConflictBytes
+}
+
+func (w *WorkspaceUsage) TotalBytes() int64 {
+	return w.ContentBytes + w.VersionBytes
+}
+
+type UsageResponse struct {
+	ContentBytes  int64                              `json:"content_bytes"`
+	VersionBytes  int64                              `json:"version_bytes"`
+	ConflictBytes int64                              `json:"conflict_bytes"`
+	TotalBytes    int64                              `json:"total_bytes"`
+	QuotaBytes    int64                              `json:"quota_bytes"`
+	Workspaces    map[string]*WorkspaceUsageResponse `json:"workspaces"`
+	ReconciledAt  *time.Time                         `json:"reconciled_at,omitempty"`
+}
+
+type WorkspaceUsageResponse struct {
+	ContentBytes int64 `json:"content_bytes"`
+	VersionBytes int64 `json:"version_bytes"`
+	TotalBytes   int64 `json:"total_bytes"`
+	QuotaBytes   int64 `json:"quota_bytes"`
+}
@@ -3,27 +3,59 @@ package domain
 import "time"
 
 type Workspace struct {
-	ID        string    `json:"id"`
-	OwnerID   string    `json:"owner_id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	IsDefault bool      `json:"is_default"`
+	ID                  string     `json:"id"`
+	OwnerID             string     `json:"owner_id"`
+	Name                string     `json:"name"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	IsDefault           bool       `json:"is_default"`
+	IsArchived          bool       `json:"is_archived"`
+	ArchivedAt          *time.Time `json:"archived_at,omitempty"`
+	PendingOwnerID      string     `json:"pending_owner_id,omitempty"`
+	TransferRequestedAt *time.Time `json:"transfer_requested_at,omitempty"`
+	// ConflictPolicy is applied to conflicts on the workspace's notes as soon
+	// as they are detected. Empty means manual.
+	ConflictPolicy ResolutionStrategy `json:"conflict_policy,omitempty"`
+
+	// Rev is the storage revision the workspace was read at. When set, Update
+	// only succeeds if the stored workspace is still at this revision.
+	Rev string `json:"-"`
 }
 
 type CreateWorkspaceRequest struct {
-	Name string `json:"name" validate:"required,min=1,max=100"`
+	Name           string             `json:"name" validate:"required,min=1,max=100"`
+	ConflictPolicy ResolutionStrategy `json:"conflict_policy,omitempty"`
 }
 
 type UpdateWorkspaceRequest struct {
-	Name string `json:"name,omitempty"`
+	Name           string             `json:"name,omitempty"`
+	ConflictPolicy ResolutionStrategy `json:"conflict_policy,omitempty"`
+}
+
+type TransferWorkspaceRequest struct {
+	Email string `json:"email" validate:"required,email"`
 }
 
 type WorkspaceResponse struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	IsDefault bool      `json:"is_default"`
-	NoteCount int       `json:"note_count,omitempty"`
+	ID                  string             `json:"id"`
+	OwnerID             string             `json:"owner_id"`
+	Name                string             `json:"name"`
+	CreatedAt           time.Time          `json:"created_at"`
+	UpdatedAt           time.Time          `json:"updated_at"`
+	IsDefault           bool               `json:"is_default"`
+	IsArchived          bool               `json:"is_archived"`
+	ArchivedAt          *time.Time         `json:"archived_at,omitempty"`
+	PendingOwnerID      string             `json:"pending_owner_id,omitempty"`
+	TransferRequestedAt *time.Time         `json:"transfer_requested_at,omitempty"`
+	ConflictPolicy      ResolutionStrategy `json:"conflict_policy"`
+	NoteCount           int                `json:"note_count,omitempty"`
+	TotalBytes          int64              `json:"total_bytes,omitempty"`
+	LastModifiedAt      *time.Time         `json:"last_modified_at,omitempty"`
+}
+
+// WorkspaceStats summarizes the live notes of a workspace
+type WorkspaceStats struct {
+	NoteCount      int
+	TotalBytes     int64
+	LastModifiedAt *time.Time
 }
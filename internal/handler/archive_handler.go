@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"inkdown-sync-server/internal/middleware"
+	"inkdown-sync-server/internal/service"
+	"inkdown-sync-server/pkg/response"
+
+	"github.com/gorilla/mux"
+)
+
+// maxImportSize bounds the size of an uploaded workspace archive
+const maxImportSize = 512 << 20
+
+type ArchiveHandler struct {
+	workspaceService *service.WorkspaceService
+	archiveService   *service.ArchiveService
+}
+
+func NewArchiveHandler(workspaceService *service.WorkspaceService, archiveService *service.ArchiveService) *ArchiveHandler {
+	return &ArchiveHandler{
+		workspaceService: workspaceService,
+		archiveService:   archiveService,
+	}
+}
+
+func (h *ArchiveHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	workspace, err := h.workspaceService.Get(r.Context(), userID, workspaceID)
+	if err != nil {
+		if err == service.ErrAccessDenied {
+			response.Error(w, http.StatusForbidden, "access denied")
+			return
+		}
+		if err == service.ErrWorkspaceNotFound {
+			response.Error(w, http.StatusNotFound, "workspace not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	includeVersions := r.URL.Query().Get("versions") == "true"
+	filename := strings.ReplaceAll(workspace.ID, ":", "-") + ".tar.gz"
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are already sent, so a failure can only be logged
+	if err := h.archiveService.Export(r.Context(), workspace, includeVersions, w); err != nil {
+		log.Printf("failed to export workspace %s: %v", workspace.ID, err)
+	}
+}
+
+func (h *ArchiveHandler) Import(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	name := r.URL.Query().Get("name")
+
+	result, err := h.archiveService.Import(r.Context(), userID, name, body)
+	if err != nil {
+		if writeQuotaError(w, err) {
+			return
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			response.Error(w, http.StatusRequestEntityTooLarge, "archive too large")
+			return
+		}
+		if errors.Is(err, service.ErrInvalidArchive) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, result)
+}
@@ -35,7 +35,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.authService.Register(&req); err != nil {
+	if err := h.authService.Register(r.Context(), &req); err != nil {
 		response.BadRequest(w, err.Error())
 		return
 	}
@@ -57,7 +57,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	loginResp, err := h.authService.Login(&req)
+	loginResp, err := h.authService.Login(r.Context(), &req)
 	if err != nil {
 		response.Unauthorized(w, err.Error())
 		return
@@ -78,7 +78,7 @@ func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenResp, err := h.authService.RefreshToken(&req)
+	tokenResp, err := h.authService.RefreshToken(r.Context(), &req)
 	if err != nil {
 		response.Unauthorized(w, err.Error())
 		return
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/middleware"
+	"inkdown-sync-server/internal/repository"
+	"inkdown-sync-server/internal/service"
+	"inkdown-sync-server/pkg/response"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type ChunkHandler struct {
+	chunkService *service.ChunkService
+	validate     *validator.Validate
+}
+
+func NewChunkHandler(chunkService *service.ChunkService) *ChunkHandler {
+	return &ChunkHandler{
+		chunkService: chunkService,
+		validate:     validator.New(),
+	}
+}
+
+// Missing reports which of the listed chunks the note does not have, so a
+// device knows what to upload with its next write
+func (h *ChunkHandler) Missing(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.decodeHashes(w, r)
+	if !ok {
+		return
+	}
+
+	missing, err := h.chunkService.Missing(r.Context(), userID, mux.Vars(r)["id"], req.Hashes)
+	if err != nil {
+		writeChunkError(w, err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, &domain.MissingChunksResponse{Missing: missing})
+}
+
+// Fetch returns the listed chunks of the note
+func (h *ChunkHandler) Fetch(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.decodeHashes(w, r)
+	if !ok {
+		return
+	}
+
+	chunks, err := h.chunkService.Fetch(r.Context(), userID, mux.Vars(r)["id"], req.Hashes)
+	if err != nil {
+		writeChunkError(w, err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, &domain.ChunksResponse{Chunks: chunks})
+}
+
+func (h *ChunkHandler) decodeHashes(w http.ResponseWriter, r *http.Request) (string, *domain.ChunkHashesRequest, bool) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return "", nil, false
+	}
+
+	var req domain.ChunkHashesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return "", nil, false
+	}
+	if err := h.validate.Struct(req); err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return "", nil, false
+	}
+
+	return userID, &req, true
+}
+
+func writeChunkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrNoteAccessDenied):
+		response.Error(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, repository.ErrNotFound):
+		response.Error(w, http.StatusNotFound, "note not found")
+	default:
+		response.Error(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// writeChunkedContentError writes the response for chunked writes that were
+// rejected and reports whether err was one of them. Missing chunks are listed
+// so the device can upload them and retry.
+func writeChunkedContentError(w http.ResponseWriter, err error) bool {
+	var missingErr *service.MissingChunksError
+	if errors.As(err, &missingErr) {
+		response.JSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   "missing_chunks",
+			"missing": missingErr.Hashes,
+		})
+		return true
+	}
+
+	if errors.Is(err, service.ErrInvalidChunks) {
+		response.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return true
+	}
+
+	return false
+}
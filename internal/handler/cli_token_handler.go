@@ -37,13 +37,13 @@ func (h *CLITokenHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenResp, err := h.cliTokenService.LoginAndCreateToken(&req)
+	tokenResp, err := h.cliTokenService.LoginAndCreateToken(r.Context(), &req)
 	if err != nil {
 		response.Unauthorized(w, err.Error())
 		return
 	}
 	clientIP := getClientIP(r)
-	h.cliTokenService.UpdateLastUsed(tokenResp.ID, clientIP)
+	h.cliTokenService.UpdateLastUsed(r.Context(), tokenResp.ID, clientIP)
 
 	response.Success(w, tokenResp)
 }
@@ -55,7 +55,7 @@ func (h *CLITokenHandler) Validate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, cliToken, err := h.cliTokenService.ValidateToken(token)
+	user, cliToken, err := h.cliTokenService.ValidateToken(r.Context(), token)
 	if err != nil {
 		response.Unauthorized(w, err.Error())
 		return
@@ -63,7 +63,7 @@ func (h *CLITokenHandler) Validate(w http.ResponseWriter, r *http.Request) {
 
 	// Update last used
 	clientIP := getClientIP(r)
-	h.cliTokenService.UpdateLastUsed(cliToken.ID, clientIP)
+	h.cliTokenService.UpdateLastUsed(r.Context(), cliToken.ID, clientIP)
 
 	response.Success(w, map[string]interface{}{
 		"valid":  true,
@@ -86,7 +86,7 @@ func (h *CLITokenHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenResp, err := h.cliTokenService.CreateToken(userID, &req)
+	tokenResp, err := h.cliTokenService.CreateToken(r.Context(), userID, &req)
 	if err != nil {
 		response.BadRequest(w, err.Error())
 		return
@@ -98,7 +98,7 @@ func (h *CLITokenHandler) Create(w http.ResponseWriter, r *http.Request) {
 func (h *CLITokenHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 
-	tokens, err := h.cliTokenService.ListTokens(userID)
+	tokens, err := h.cliTokenService.ListTokens(r.Context(), userID)
 	if err != nil {
 		response.InternalError(w, err.Error())
 		return
@@ -114,7 +114,7 @@ func (h *CLITokenHandler) Get(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	tokenID := mux.Vars(r)["id"]
 
-	token, err := h.cliTokenService.GetToken(userID, tokenID)
+	token, err := h.cliTokenService.GetToken(r.Context(), userID, tokenID)
 	if err != nil {
 		response.NotFound(w, err.Error())
 		return
@@ -127,7 +127,7 @@ func (h *CLITokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	tokenID := mux.Vars(r)["id"]
 
-	if err := h.cliTokenService.RevokeToken(userID, tokenID); err != nil {
+	if err := h.cliTokenService.RevokeToken(r.Context(), userID, tokenID); err != nil {
 		response.BadRequest(w, err.Error())
 		return
 	}
@@ -141,7 +141,7 @@ func (h *CLITokenHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	tokenID := mux.Vars(r)["id"]
 
-	if err := h.cliTokenService.DeleteToken(userID, tokenID); err != nil {
+	if err := h.cliTokenService.DeleteToken(r.Context(), userID, tokenID); err != nil {
 		response.BadRequest(w, err.Error())
 		return
 	}
@@ -39,7 +39,7 @@ func (h *DeviceHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
-	device, err := h.service.Register(userID, &req)
+	device, err := h.service.Register(r.Context(), userID, &req)
 	if err != nil {
 		response.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to register device"})
 		return
@@ -51,7 +51,7 @@ func (h *DeviceHandler) Register(w http.ResponseWriter, r *http.Request) {
 func (h *DeviceHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
-	devices, err := h.service.List(userID)
+	devices, err := h.service.List(r.Context(), userID)
 	if err != nil {
 		response.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to list devices"})
 		return
@@ -70,7 +70,7 @@ func (h *DeviceHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
-	if err := h.service.Revoke(userID, deviceID); err != nil {
+	if err := h.service.Revoke(r.Context(), userID, deviceID); err != nil {
 		if err.Error() == "unauthorized: device does not belong to user" {
 			response.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
 			return
@@ -2,10 +2,13 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"inkdown-sync-server/internal/domain"
 	"inkdown-sync-server/internal/middleware"
+	"inkdown-sync-server/internal/repository"
 	"inkdown-sync-server/internal/service"
 	"inkdown-sync-server/pkg/response"
 
@@ -39,8 +42,19 @@ func (h *NoteHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
-	note, err := h.service.Create(userID, &req)
+	note, err := h.service.Create(r.Context(), userID, &req)
 	if err != nil {
+		if writeQuotaError(w, err) || writeTreeError(w, err) || writeChunkedContentError(w, err) {
+			return
+		}
+		if errors.Is(err, service.ErrWorkspaceNotFound) {
+			response.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			response.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		}
 		response.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create note"})
 		return
 	}
@@ -51,7 +65,7 @@ func (h *NoteHandler) Create(w http.ResponseWriter, r *http.Request) {
 func (h *NoteHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
-	notes, err := h.service.List(userID)
+	notes, err := h.service.List(r.Context(), userID)
 	if err != nil {
 		response.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to list notes"})
 		return
@@ -70,7 +84,7 @@ func (h *NoteHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
-	note, err := h.service.GetByID(userID, noteID)
+	note, err := h.service.GetByID(r.Context(), userID, noteID)
 	if err != nil {
 		if err.Error() == "unauthorized: note does not belong to user" {
 			response.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
@@ -100,18 +114,13 @@ func (h *NoteHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
-	note, err := h.service.Update(userID, noteID, &req)
+	note, err := h.service.Update(r.Context(), userID, noteID, &req)
 	if err != nil {
 		if err.Error() == "unauthorized: note does not belong to user" {
 			response.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
 			return
 		}
-		// Check if it's a conflict error
-		if conflictErr, ok := err.(*service.ConflictError); ok {
-			response.JSON(w, http.StatusConflict, map[string]interface{}{
-				"error":    "version_conflict",
-				"conflict": conflictErr.Conflict,
-			})
+		if writeQuotaError(w, err) || writeTreeError(w, err) || writeVersionConflict(w, err) || writeChunkedContentError(w, err) {
 			return
 		}
 		response.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update note"})
@@ -131,14 +140,127 @@ func (h *NoteHandler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
-	if err := h.service.Delete(userID, noteID); err != nil {
+	deviceID := r.URL.Query().Get("device_id")
+
+	var expectedVersion *int64
+	if versionParam := r.URL.Query().Get("expected_version"); versionParam != "" {
+		version, err := strconv.ParseInt(versionParam, 10, 64)
+		if err != nil {
+			response.JSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expected_version parameter"})
+			return
+		}
+		expectedVersion = &version
+	}
+
+	if err := h.service.Delete(r.Context(), userID, noteID, deviceID, expectedVersion); err != nil {
 		if err.Error() == "unauthorized: note does not belong to user" {
 			response.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
 			return
 		}
+		if writeTreeError(w, err) || writeVersionConflict(w, err) {
+			return
+		}
 		response.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete note"})
 		return
 	}
 
 	response.JSON(w, http.StatusOK, map[string]string{"message": "Note deleted successfully"})
 }
+
+func (h *NoteHandler) Move(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	noteID := vars["id"]
+	if noteID == "" {
+		response.JSON(w, http.StatusBadRequest, map[string]string{"error": "Note ID is required"})
+		return
+	}
+
+	var req domain.MoveNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.JSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+		return
+	}
+
+	if err := h.validate.Struct(req); err != nil {
+		response.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.GetUserID(r)
+
+	note, err := h.service.Move(r.Context(), userID, noteID, &req)
+	if err != nil {
+		if writeQuotaError(w, err) || writeTreeError(w, err) || writeVersionConflict(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrNoteAccessDenied), errors.Is(err, service.ErrAccessDenied):
+			response.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		case errors.Is(err, service.ErrWorkspaceNotFound):
+			response.JSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		case errors.Is(err, service.ErrNoteDeleted):
+			response.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			response.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to move note"})
+		}
+		return
+	}
+
+	response.JSON(w, http.StatusOK, note)
+}
+
+// writeTreeError writes the response for tree operations that were rejected,
+// including writes to archived workspaces, and reports whether err was one of
+// them.
+func writeTreeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrWorkspaceArchived):
+		response.JSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidParent):
+		response.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrMoveCycle):
+		response.JSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// writeVersionConflict writes the response for writes that lost a race with
+// another write to the same note and reports whether err was one of them.
+func writeVersionConflict(w http.ResponseWriter, err error) bool {
+	var conflictErr *service.ConflictError
+	if errors.As(err, &conflictErr) {
+		response.JSON(w, http.StatusConflict, map[string]interface{}{
+			"error":    "version_conflict",
+			"conflict": conflictErr.Conflict,
+		})
+		return true
+	}
+
+	if errors.Is(err, repository.ErrConflict) {
+		response.JSON(w, http.StatusConflict, map[string]string{"error": "note was modified concurrently"})
+		return true
+	}
+	return false
+}
+
+// writeQuotaError writes the response for storage limit errors and reports
+// whether err was one of them.
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, service.ErrNoteTooLarge) {
+		response.JSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+		return true
+	}
+
+	var quotaErr *service.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		response.JSON(w, http.StatusInsufficientStorage, map[string]interface{}{
+			"error": "quota_exceeded",
+			"quota": quotaErr,
+		})
+		return true
+	}
+
+	return false
+}
@@ -38,7 +38,7 @@ func (h *SecurityHandler) UploadKey(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
-	if err := h.service.UploadKey(userID, &req); err != nil {
+	if err := h.service.UploadKey(r.Context(), userID, &req); err != nil {
 		response.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to upload key"})
 		return
 	}
@@ -49,7 +49,7 @@ func (h *SecurityHandler) UploadKey(w http.ResponseWriter, r *http.Request) {
 func (h *SecurityHandler) GetKey(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
-	key, err := h.service.GetKey(userID)
+	key, err := h.service.GetKey(r.Context(), userID)
 	if err != nil {
 		response.JSON(w, http.StatusNotFound, map[string]string{"error": "Key not found"})
 		return
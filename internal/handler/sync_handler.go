@@ -2,11 +2,14 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
 	"inkdown-sync-server/internal/middleware"
+	"inkdown-sync-server/internal/repository"
 	"inkdown-sync-server/internal/service"
 	"inkdown-sync-server/pkg/response"
 
@@ -38,7 +41,7 @@ func (h *SyncHandler) ProcessSync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res, err := h.syncService.ProcessSyncRequest(userID, req.DeviceID, &req)
+	res, err := h.syncService.ProcessSyncRequest(r.Context(), userID, req.DeviceID, &req)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -65,7 +68,7 @@ func (h *SyncHandler) GetChanges(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	changes, err := h.syncService.GetChangesSince(userID, since)
+	changes, err := h.syncService.GetChangesSince(r.Context(), userID, since)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -84,13 +87,80 @@ func (h *SyncHandler) ListConflicts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conflicts, err := h.conflictService.ListByUser(userID)
+	query := r.URL.Query()
+	filter := domain.ConflictFilter{
+		UnresolvedOnly: query.Get("unresolved") == "true",
+		NoteID:         query.Get("note_id"),
+		WorkspaceID:    query.Get("workspace_id"),
+		DeviceID:       query.Get("device_id"),
+	}
+
+	var err error
+	if filter.Limit, err = intParam(query.Get("limit")); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid limit parameter")
+		return
+	}
+	if filter.Offset, err = intParam(query.Get("offset")); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid offset parameter")
+		return
+	}
+
+	conflicts, hasMore, err := h.conflictService.List(r.Context(), userID, filter)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	response.JSON(w, http.StatusOK, conflicts)
+	var items interface{} = conflicts
+	if query.Get("view") == "summary" {
+		summaries := make([]*domain.ConflictSummary, 0, len(conflicts))
+		for _, c := range conflicts {
+			summaries = append(summaries, c.Summary())
+		}
+		items = summaries
+	} else if conflicts == nil {
+		items = []*domain.Conflict{}
+	}
+
+	response.JSON(w, http.StatusOK, map[string]interface{}{
+		"conflicts": items,
+		"has_more":  hasMore,
+	})
+}
+
+// intParam parses an optional non-negative integer query parameter
+func intParam(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid integer")
+	}
+	return n, nil
+}
+
+// GetConflict returns a conflict with the base and intermediate versions a
+// device needs to merge it
+func (h *SyncHandler) GetConflict(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	detail, err := h.conflictService.Detail(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeConflictError(w, err)
+		return
+	}
+
+	if detail.UserID != userID {
+		response.Error(w, http.StatusForbidden, "unauthorized")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, detail)
 }
 
 func (h *SyncHandler) ResolveConflict(w http.ResponseWriter, r *http.Request) {
@@ -108,9 +178,9 @@ func (h *SyncHandler) ResolveConflict(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conflict, err := h.conflictService.Get(conflictID)
+	conflict, err := h.conflictService.Get(r.Context(), conflictID)
 	if err != nil {
-		response.Error(w, http.StatusNotFound, "conflict not found")
+		writeConflictError(w, err)
 		return
 	}
 
@@ -119,9 +189,9 @@ func (h *SyncHandler) ResolveConflict(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	note, err := h.conflictService.ApplyResolution(conflictID, req.Strategy, req.NoteData)
+	note, err := h.conflictService.ApplyResolution(r.Context(), conflictID, req.Strategy, req.NoteData)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeConflictError(w, err)
 		return
 	}
 
@@ -131,6 +201,26 @@ func (h *SyncHandler) ResolveConflict(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// writeConflictError maps repository errors from conflict operations to HTTP
+// status codes
+func writeConflictError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		response.Error(w, http.StatusNotFound, "conflict not found")
+	case errors.Is(err, service.ErrNoteChanged), errors.Is(err, service.ErrConflictResolved):
+		response.Error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, repository.ErrConflict):
+		response.Error(w, http.StatusConflict, "conflict was modified concurrently")
+	case errors.Is(err, service.ErrInvalidResolution):
+		response.Error(w, http.StatusBadRequest, err.Error())
+	default:
+		if writeChunkedContentError(w, err) {
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
 func (h *SyncHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 	if userID == "" {
@@ -140,7 +230,7 @@ func (h *SyncHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 
 	workspaceID := r.URL.Query().Get("workspace_id")
 
-	manifest, err := h.syncService.GetManifest(userID, workspaceID)
+	manifest, err := h.syncService.GetManifest(r.Context(), userID, workspaceID)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -162,7 +252,7 @@ func (h *SyncHandler) BatchDiff(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	diff, err := h.syncService.ProcessBatchDiff(userID, &req)
+	diff, err := h.syncService.ProcessBatchDiff(r.Context(), userID, &req)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, err.Error())
 		return
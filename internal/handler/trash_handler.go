@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/middleware"
+	"inkdown-sync-server/internal/service"
+	"inkdown-sync-server/pkg/response"
+
+	"github.com/gorilla/mux"
+)
+
+type TrashHandler struct {
+	trashService *service.TrashService
+}
+
+func NewTrashHandler(trashService *service.TrashService) *TrashHandler {
+	return &TrashHandler{
+		trashService: trashService,
+	}
+}
+
+func (h *TrashHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	notes, err := h.trashService.List(r.Context(), userID)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.JSON(w, http.StatusOK, notes)
+}
+
+func (h *TrashHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID := vars["id"]
+
+	var req domain.RestoreNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	note, err := h.trashService.Restore(r.Context(), userID, noteID, req.DeviceID)
+	if err != nil {
+		writeTrashError(w, err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, note)
+}
+
+func (h *TrashHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID := vars["id"]
+
+	if err := h.trashService.Purge(r.Context(), userID, noteID); err != nil {
+		writeTrashError(w, err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]string{"message": "note permanently deleted"})
+}
+
+func writeTrashError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrNoteAccessDenied):
+		response.Error(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrNoteNotInTrash):
+		response.Error(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrWorkspaceArchived):
+		response.Error(w, http.StatusConflict, err.Error())
+	default:
+		response.Error(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+
+	"inkdown-sync-server/internal/middleware"
+	"inkdown-sync-server/internal/service"
+	"inkdown-sync-server/pkg/response"
+)
+
+type UsageHandler struct {
+	usageService *service.UsageService
+}
+
+func NewUsageHandler(usageService *service.UsageService) *UsageHandler {
+	return &UsageHandler{
+		usageService: usageService,
+	}
+}
+
+func (h *UsageHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	usage, err := h.usageService.GetUsage(r.Context(), userID)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.JSON(w, http.StatusOK, usage)
+}
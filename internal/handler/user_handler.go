@@ -26,7 +26,7 @@ func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userService.GetByID(userID)
+	user, err := h.userService.GetByID(r.Context(), userID)
 	if err != nil {
 		response.NotFound(w, "User not found")
 		return
@@ -56,7 +56,7 @@ func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userService.UpdateUsername(userID, req.Username)
+	user, err := h.userService.UpdateUsername(r.Context(), userID, req.Username)
 	if err != nil {
 		response.BadRequest(w, err.Error())
 		return
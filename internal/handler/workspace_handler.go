@@ -1,24 +1,30 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"inkdown-sync-server/internal/domain"
 	"inkdown-sync-server/internal/middleware"
+	"inkdown-sync-server/internal/repository"
 	"inkdown-sync-server/internal/service"
 	"inkdown-sync-server/pkg/response"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 )
 
 type WorkspaceHandler struct {
 	workspaceService *service.WorkspaceService
+	validate         *validator.Validate
 }
 
 func NewWorkspaceHandler(workspaceService *service.WorkspaceService) *WorkspaceHandler {
 	return &WorkspaceHandler{
 		workspaceService: workspaceService,
+		validate:         validator.New(),
 	}
 }
 
@@ -35,9 +41,9 @@ func (h *WorkspaceHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	workspace, err := h.workspaceService.Create(userID, &req)
+	workspace, err := h.workspaceService.Create(r.Context(), userID, &req)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeWorkspaceError(w, err)
 		return
 	}
 
@@ -51,7 +57,7 @@ func (h *WorkspaceHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	workspaces, err := h.workspaceService.List(userID)
+	workspaces, err := h.workspaceService.List(r.Context(), userID)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -70,7 +76,7 @@ func (h *WorkspaceHandler) Get(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	workspaceID := vars["id"]
 
-	workspace, err := h.workspaceService.Get(userID, workspaceID)
+	workspace, err := h.workspaceService.Get(r.Context(), userID, workspaceID)
 	if err != nil {
 		if err == service.ErrAccessDenied {
 			response.Error(w, http.StatusForbidden, "access denied")
@@ -103,17 +109,9 @@ func (h *WorkspaceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	workspace, err := h.workspaceService.Update(userID, workspaceID, &req)
+	workspace, err := h.workspaceService.Update(r.Context(), userID, workspaceID, &req)
 	if err != nil {
-		if err == service.ErrAccessDenied {
-			response.Error(w, http.StatusForbidden, "access denied")
-			return
-		}
-		if err == service.ErrWorkspaceNotFound {
-			response.Error(w, http.StatusNotFound, "workspace not found")
-			return
-		}
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeWorkspaceError(w, err)
 		return
 	}
 
@@ -130,7 +128,10 @@ func (h *WorkspaceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	workspaceID := vars["id"]
 
-	err := h.workspaceService.Delete(userID, workspaceID)
+	mode := r.URL.Query().Get("mode")
+	deviceID := r.URL.Query().Get("device_id")
+
+	job, err := h.workspaceService.Delete(r.Context(), userID, workspaceID, mode, deviceID)
 	if err != nil {
 		if err == service.ErrAccessDenied {
 			response.Error(w, http.StatusForbidden, "access denied")
@@ -140,7 +141,7 @@ func (h *WorkspaceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 			response.Error(w, http.StatusNotFound, "workspace not found")
 			return
 		}
-		if err.Error() == "cannot delete default workspace" {
+		if err == service.ErrDefaultWorkspace || err == service.ErrInvalidDeleteMode || err == service.ErrNoDefaultWorkspace {
 			response.Error(w, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -148,5 +149,152 @@ func (h *WorkspaceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response.JSON(w, http.StatusOK, map[string]string{"message": "workspace deleted"})
+	response.JSON(w, http.StatusAccepted, job)
+}
+
+func (h *WorkspaceHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	jobID := vars["id"]
+
+	job, err := h.workspaceService.GetJob(r.Context(), userID, jobID)
+	if err != nil {
+		if err == service.ErrAccessDenied || errors.Is(err, repository.ErrJobNotFound) {
+			response.Error(w, http.StatusNotFound, "job not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.JSON(w, http.StatusOK, job)
+}
+
+func (h *WorkspaceHandler) Archive(w http.ResponseWriter, r *http.Request) {
+	h.applyLifecycle(w, r, h.workspaceService.Archive)
+}
+
+func (h *WorkspaceHandler) Unarchive(w http.ResponseWriter, r *http.Request) {
+	h.applyLifecycle(w, r, h.workspaceService.Unarchive)
+}
+
+func (h *WorkspaceHandler) SetDefault(w http.ResponseWriter, r *http.Request) {
+	h.applyLifecycle(w, r, h.workspaceService.SetDefault)
+}
+
+// AcceptTransfer returns the job that moves the workspace's notes to the
+// new owner
+func (h *WorkspaceHandler) AcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	job, err := h.workspaceService.AcceptTransfer(r.Context(), userID, workspaceID)
+	if err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.JSON(w, http.StatusAccepted, job)
+}
+
+func (h *WorkspaceHandler) DeclineTransfer(w http.ResponseWriter, r *http.Request) {
+	h.applyLifecycle(w, r, h.workspaceService.DeclineTransfer)
+}
+
+func (h *WorkspaceHandler) RequestTransfer(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var req domain.TransferWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validate.Struct(req); err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	workspace, err := h.workspaceService.RequestTransfer(r.Context(), userID, workspaceID, &req)
+	if err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, workspace)
+}
+
+func (h *WorkspaceHandler) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	workspaces, err := h.workspaceService.ListIncomingTransfers(r.Context(), userID)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.JSON(w, http.StatusOK, workspaces)
+}
+
+// applyLifecycle runs a workspace state change that only needs the user and
+// the workspace ID
+func (h *WorkspaceHandler) applyLifecycle(w http.ResponseWriter, r *http.Request, apply func(ctx context.Context, userID, workspaceID string) (*domain.WorkspaceResponse, error)) {
+	userID := middleware.GetUserID(r)
+	if userID == "" {
+		response.Error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	workspace, err := apply(r.Context(), userID, workspaceID)
+	if err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, workspace)
+}
+
+func writeWorkspaceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrAccessDenied):
+		response.Error(w, http.StatusForbidden, "access denied")
+	case errors.Is(err, service.ErrWorkspaceNotFound):
+		response.Error(w, http.StatusNotFound, "workspace not found")
+	case errors.Is(err, service.ErrWorkspaceArchived), errors.Is(err, service.ErrNoPendingTransfer):
+		response.Error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, repository.ErrConflict):
+		response.Error(w, http.StatusConflict, "workspace was modified concurrently")
+	case errors.Is(err, service.ErrDefaultImmutable), errors.Is(err, service.ErrInvalidTransfer), errors.Is(err, service.ErrInvalidPolicy):
+		response.Error(w, http.StatusBadRequest, err.Error())
+	default:
+		if writeQuotaError(w, err) {
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, err.Error())
+	}
 }
@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"inkdown-sync-server/internal/domain"
 	"inkdown-sync-server/internal/service"
@@ -76,6 +80,15 @@ func (h *WebSocketHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 	clientID := uuid.New().String()
 	client := websocket.NewClient(clientID, userID, deviceID, conn, h.manager)
 
+	if workspaceIDs := r.URL.Query().Get("workspace_ids"); workspaceIDs != "" {
+		h.manager.Subscribe(client, strings.Split(workspaceIDs, ","))
+	}
+
+	// A reconnecting device resumes after the last message it acked
+	if lastSeq, err := strconv.ParseInt(r.URL.Query().Get("last_seq"), 10, 64); err == nil {
+		client.ResumeFrom(lastSeq)
+	}
+
 	h.manager.Register <- client
 
 	go client.WritePump()
@@ -83,19 +96,43 @@ func (h *WebSocketHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 }
 
 type WebSocketMessageHandler struct {
-	syncService *service.SyncService
+	syncService    *service.SyncService
+	crdtService    *service.CRDTService
+	requestTimeout time.Duration
 }
 
-func NewWebSocketMessageHandler(syncService *service.SyncService) *WebSocketMessageHandler {
+func NewWebSocketMessageHandler(syncService *service.SyncService, crdtService *service.CRDTService, requestTimeout time.Duration) *WebSocketMessageHandler {
 	return &WebSocketMessageHandler{
-		syncService: syncService,
+		syncService:    syncService,
+		crdtService:    crdtService,
+		requestTimeout: requestTimeout,
 	}
 }
 
-func (h *WebSocketMessageHandler) HandleWebSocketMessage(client *websocket.Client, msg *websocket.Message) error {
+// HandleWebSocketMessage handles a single client message. ctx is canceled
+// when the client disconnects; each message also gets the request timeout.
+func (h *WebSocketMessageHandler) HandleWebSocketMessage(ctx context.Context, client *websocket.Client, msg *websocket.Message) error {
+	if h.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.requestTimeout)
+		defer cancel()
+	}
+
 	switch msg.Type {
 	case websocket.TypeSyncRequest:
-		return h.handleSyncRequest(client, msg)
+		return h.handleSyncRequest(ctx, client, msg)
+
+	case websocket.TypeSubscribe:
+		return h.handleSubscribe(ctx, client, msg)
+
+	case websocket.TypeCRDTUpdate:
+		return h.handleCRDTUpdate(ctx, client, msg)
+
+	case websocket.TypeCRDTSync:
+		return h.handleCRDTSync(ctx, client, msg)
+
+	case websocket.TypeCRDTSnapshot:
+		return h.handleCRDTSnapshot(ctx, client, msg)
 
 	case websocket.TypePing:
 		return h.handlePing(client)
@@ -107,48 +144,113 @@ func (h *WebSocketMessageHandler) HandleWebSocketMessage(client *websocket.Clien
 	return nil
 }
 
-func (h *WebSocketMessageHandler) handleSyncRequest(client *websocket.Client, msg *websocket.Message) error {
+func (h *WebSocketMessageHandler) handleSyncRequest(ctx context.Context, client *websocket.Client, msg *websocket.Message) error {
 	var payload websocket.SyncRequestPayload
 	if err := msg.UnmarshalPayload(&payload); err != nil {
 		return err
 	}
 
+	// The device is the one the connection was opened for, whatever the
+	// payload claims
 	syncReq := &domain.SyncRequest{
-		DeviceID:     payload.DeviceID,
+		DeviceID:     client.DeviceID,
 		LastSyncTime: payload.LastSyncTime,
 		NoteVersions: payload.NoteVersions,
+		WorkspaceIDs: payload.WorkspaceIDs,
+	}
+
+	if payload.WorkspaceIDs != nil {
+		client.Manager.Subscribe(client, payload.WorkspaceIDs)
 	}
 
-	response, err := h.syncService.ProcessSyncRequest(client.UserID, payload.DeviceID, syncReq)
+	response, err := h.syncService.ProcessSyncRequest(ctx, client.UserID, client.DeviceID, syncReq)
 	if err != nil {
 		return err
 	}
 
-	responseMsg, err := websocket.NewMessage(websocket.TypeSyncResponse, &websocket.SyncResponsePayload{
+	return sendMessage(client, websocket.TypeSyncResponse, &websocket.SyncResponsePayload{
 		Changes:  convertToWSChanges(response.Changes),
 		HasMore:  response.HasMore,
 		SyncTime: response.SyncTime,
 	})
+}
+
+func (h *WebSocketMessageHandler) handleSubscribe(ctx context.Context, client *websocket.Client, msg *websocket.Message) error {
+	var payload websocket.SubscribePayload
+	if err := msg.UnmarshalPayload(&payload); err != nil {
+		return err
+	}
+
+	client.Manager.Subscribe(client, payload.WorkspaceIDs)
+
+	ack := &websocket.AckPayload{Success: true}
+	if err := h.syncService.SetDeviceWorkspaces(ctx, client.UserID, client.DeviceID, payload.WorkspaceIDs); err != nil {
+		ack = &websocket.AckPayload{Success: false, Error: err.Error()}
+	}
+
+	return sendMessage(client, websocket.TypeAck, ack)
+}
+
+// handleCRDTUpdate stores and relays a CRDT update, acking it with the
+// sequence number it got
+func (h *WebSocketMessageHandler) handleCRDTUpdate(ctx context.Context, client *websocket.Client, msg *websocket.Message) error {
+	var payload websocket.CRDTUpdatePayload
+	if err := msg.UnmarshalPayload(&payload); err != nil {
+		return err
+	}
+
+	ack := &websocket.AckPayload{MessageID: payload.MessageID, Success: true}
+	update, err := h.crdtService.Publish(ctx, client.UserID, client.DeviceID, payload.NoteID, payload.Data)
 	if err != nil {
+		ack = &websocket.AckPayload{MessageID: payload.MessageID, Success: false, Error: err.Error()}
+	} else {
+		ack.Seq = update.Seq
+	}
+
+	return sendMessage(client, websocket.TypeAck, ack)
+}
+
+// handleCRDTSync answers with the updates of a note the device is missing
+func (h *WebSocketMessageHandler) handleCRDTSync(ctx context.Context, client *websocket.Client, msg *websocket.Message) error {
+	var payload websocket.CRDTSyncPayload
+	if err := msg.UnmarshalPayload(&payload); err != nil {
 		return err
 	}
 
-	responseBytes, _ := json.Marshal(responseMsg)
-	client.Send <- responseBytes
+	state, err := h.crdtService.State(ctx, client.UserID, payload.NoteID, payload.AfterSeq)
+	if err != nil {
+		return sendMessage(client, websocket.TypeAck, &websocket.AckPayload{Success: false, Error: err.Error()})
+	}
 
-	return nil
+	return sendMessage(client, websocket.TypeCRDTSync, state)
 }
 
-func (h *WebSocketMessageHandler) handlePing(client *websocket.Client) error {
-	pongMsg, err := websocket.NewMessage(websocket.TypePong, nil)
+func (h *WebSocketMessageHandler) handleCRDTSnapshot(ctx context.Context, client *websocket.Client, msg *websocket.Message) error {
+	var payload websocket.CRDTSnapshotPayload
+	if err := msg.UnmarshalPayload(&payload); err != nil {
+		return err
+	}
+
+	ack := &websocket.AckPayload{MessageID: payload.MessageID, Success: true, Seq: payload.Seq}
+	if err := h.crdtService.SaveSnapshot(ctx, client.UserID, client.DeviceID, payload.NoteID, payload.Seq, payload.Data); err != nil {
+		ack = &websocket.AckPayload{MessageID: payload.MessageID, Success: false, Error: err.Error()}
+	}
+
+	return sendMessage(client, websocket.TypeAck, ack)
+}
+
+// sendMessage queues a message for client, numbered in its device's stream
+func sendMessage(client *websocket.Client, msgType websocket.MessageType, payload interface{}) error {
+	msg, err := websocket.NewMessage(msgType, payload)
 	if err != nil {
 		return err
 	}
 
-	pongBytes, _ := json.Marshal(pongMsg)
-	client.Send <- pongBytes
+	return client.Manager.SendToClient(client.ID, msg)
+}
 
-	return nil
+func (h *WebSocketMessageHandler) handlePing(client *websocket.Client) error {
+	return sendMessage(client, websocket.TypePong, nil)
 }
 
 func convertToWSChanges(changes []*domain.NoteChange) []websocket.NoteChange {
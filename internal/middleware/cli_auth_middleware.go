@@ -37,15 +37,17 @@ func CLIAuthMiddleware(cliTokenService *service.CLITokenService) func(http.Handl
 				return
 			}
 
-			user, cliToken, err := cliTokenService.ValidateToken(token)
+			user, cliToken, err := cliTokenService.ValidateToken(r.Context(), token)
 			if err != nil {
 				response.Unauthorized(w, "Invalid or revoked CLI token")
 				return
 			}
 
+			// The update outlives the request, so it must not be canceled with it
+			lastUsedCtx := context.WithoutCancel(r.Context())
 			go func() {
 				clientIP := getClientIPFromRequest(r)
-				cliTokenService.UpdateLastUsed(cliToken.ID, clientIP)
+				cliTokenService.UpdateLastUsed(lastUsedCtx, cliToken.ID, clientIP)
 			}()
 
 			// Add user info to context
@@ -0,0 +1,157 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+// Migration is a schema change applied once per database. Versions must be
+// unique and increasing; applied migrations must never be edited, a change to
+// a view or index is shipped as a new migration instead.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *kivik.DB) error
+}
+
+type migrationDoc struct {
+	ID          string    `json:"_id"`
+	Rev         string    `json:"_rev,omitempty"`
+	DocType     string    `json:"doc_type"`
+	Version     int       `json:"version"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// Runner applies the pending migrations to a database and records each
+// applied one as a migration:<version> document.
+type Runner struct {
+	db         *kivik.DB
+	migrations []Migration
+}
+
+func NewRunner(client *kivik.Client, dbName string) *Runner {
+	return &Runner{
+		db:         client.DB(dbName),
+		migrations: migrations,
+	}
+}
+
+// Run applies the pending migrations in version order and returns how many
+// were applied. Running it again is a no-op.
+func (r *Runner) Run(ctx context.Context) (int, error) {
+	applied := 0
+	for _, m := range r.migrations {
+		done, err := r.isApplied(ctx, m)
+		if err != nil {
+			return applied, err
+		}
+		if done {
+			continue
+		}
+
+		if err := m.Up(ctx, r.db); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+
+		if err := r.record(ctx, m); err != nil {
+			return applied, err
+		}
+
+		log.Printf("Applied migration %d: %s", m.Version, m.Description)
+		applied++
+	}
+
+	return applied, nil
+}
+
+func (r *Runner) isApplied(ctx context.Context, m Migration) (bool, error) {
+	var doc migrationDoc
+	err := r.db.Get(ctx, migrationID(m)).ScanDoc(&doc)
+	if err == nil {
+		return true, nil
+	}
+	if kivik.HTTPStatus(err) == 404 {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check migration %d: %w", m.Version, err)
+}
+
+func (r *Runner) record(ctx context.Context, m Migration) error {
+	doc := migrationDoc{
+		ID:          migrationID(m),
+		DocType:     "migration",
+		Version:     m.Version,
+		Description: m.Description,
+		AppliedAt:   time.Now().UTC(),
+	}
+
+	if _, err := r.db.Put(ctx, doc.ID, doc); err != nil {
+		// Another instance applied the same migration concurrently
+		if kivik.HTTPStatus(err) == 409 {
+			return nil
+		}
+		return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+	}
+
+	return nil
+}
+
+func migrationID(m Migration) string {
+	return fmt.Sprintf("migration:%04d", m.Version)
+}
+
+type viewDef struct {
+	Map    string `json:"map"`
+	Reduce string `json:"reduce,omitempty"`
+}
+
+type designDoc struct {
+	ID       string             `json:"_id"`
+	Rev      string             `json:"_rev,omitempty"`
+	Language string             `json:"language"`
+	Views    map[string]viewDef `json:"views"`
+}
+
+// putDesignDoc installs a design document, replacing its views when a
+// different definition is already stored.
+func putDesignDoc(ctx context.Context, db *kivik.DB, want designDoc) error {
+	var current designDoc
+	err := db.Get(ctx, want.ID).ScanDoc(&current)
+	switch {
+	case err == nil:
+		if reflect.DeepEqual(current.Views, want.Views) {
+			return nil
+		}
+		want.Rev = current.Rev
+	case kivik.HTTPStatus(err) != 404:
+		return fmt.Errorf("failed to get %s: %w", want.ID, err)
+	}
+
+	if _, err := db.Put(ctx, want.ID, want); err != nil {
+		return fmt.Errorf("failed to install %s: %w", want.ID, err)
+	}
+
+	return nil
+}
+
+// mangoIndex is a Mango index stored in its own design document
+type mangoIndex struct {
+	Name   string
+	Fields []string
+}
+
+func createIndexes(ctx context.Context, db *kivik.DB, indexes []mangoIndex) error {
+	for _, idx := range indexes {
+		def := map[string]interface{}{"fields": idx.Fields}
+		if err := db.CreateIndex(ctx, "idx-"+idx.Name, idx.Name, def); err != nil {
+			return fmt.Errorf("failed to create index %s: %w", idx.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package migration
+
+import (
+	"context"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+// migrations lists every migration in version order. Append only.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "version and conflict views",
+		Up: func(ctx context.Context, db *kivik.DB) error {
+			if err := putDesignDoc(ctx, db, designDoc{
+				ID:       "_design/versions",
+				Language: "javascript",
+				Views: map[string]viewDef{
+					// Version documents carry their ID in "id" when they were
+					// created through a plain POST.
+					"by_note": {Map: `function (doc) {
+  var id = doc._id.indexOf("version:") === 0 ? doc._id : doc.id;
+  if (id && id.indexOf("version:") === 0 && doc.note_id) {
+    emit(doc.note_id, doc);
+  }
+}`},
+				},
+			}); err != nil {
+				return err
+			}
+
+			return putDesignDoc(ctx, db, designDoc{
+				ID:       "_design/conflicts",
+				Language: "javascript",
+				Views: map[string]viewDef{
+					"by_user": {Map: `function (doc) {
+  if (doc._id.indexOf("conflict:") === 0) {
+    emit(doc.user_id, doc);
+  }
+}`},
+					"by_note": {Map: `function (doc) {
+  if (doc._id.indexOf("conflict:") === 0) {
+    emit(doc.note_id, doc);
+  }
+}`},
+				},
+			})
+		},
+	},
+	{
+		Version:     2,
+		Description: "workspace stats view",
+		Up: func(ctx context.Context, db *kivik.DB) error {
+			// Rows are keyed by [user_id, workspace_id] and reduce to the note
+			// count, the encrypted size and the latest updated_at.
+			return putDesignDoc(ctx, db, designDoc{
+				ID:       "_design/notes",
+				Language: "javascript",
+				Views: map[string]viewDef{
+					"stats_by_workspace": {
+						Map: `function (doc) {
+  if (doc._id.indexOf("note:") === 0 && doc.user_id && doc.workspace_id && !doc.is_deleted) {
+    emit([doc.user_id, doc.workspace_id], {
+      count: 1,
+      size: (doc.encrypted_title || "").length + (doc.encrypted_content || "").length,
+      updated_at: doc.updated_at
+    });
+  }
+}`,
+						Reduce: `function (keys, values, rereduce) {
+  var result = {count: 0, size: 0, updated_at: null};
+  for (var i = 0; i < values.length; i++) {
+    result.count += values[i].count;
+    result.size += values[i].size;
+    if (values[i].updated_at && (!result.updated_at || values[i].updated_at > result.updated_at)) {
+      result.updated_at = values[i].updated_at;
+    }
+  }
+  return result;
+}`,
+					},
+				},
+			})
+		},
+	},
+	{
+		Version:     3,
+		Description: "mango indexes for repository queries",
+		Up: func(ctx context.Context, db *kivik.DB) error {
+			return createIndexes(ctx, db, []mangoIndex{
+				{Name: "notes-by-user", Fields: []string{"user_id", "encrypted_title"}},
+				{Name: "notes-by-workspace", Fields: []string{"workspace_id", "encrypted_title"}},
+				{Name: "notes-by-parent", Fields: []string{"parent_id", "encrypted_title"}},
+				{Name: "notes-deleted", Fields: []string{"is_deleted", "deleted_at"}},
+				{Name: "workspaces-by-owner", Fields: []string{"doc_type", "owner_id"}},
+				{Name: "workspaces-by-pending-owner", Fields: []string{"doc_type", "pending_owner_id"}},
+				{Name: "tombstones-by-user", Fields: []string{"doc_type", "user_id"}},
+				{Name: "tombstones-by-purge", Fields: []string{"doc_type", "purged_at"}},
+				{Name: "jobs-by-status", Fields: []string{"doc_type", "status"}},
+				{Name: "users-by-email", Fields: []string{"email"}},
+				{Name: "users-by-username", Fields: []string{"username"}},
+				{Name: "devices-by-user", Fields: []string{"user_id", "os"}},
+				{Name: "cli-tokens-by-token", Fields: []string{"token", "is_revoked"}},
+				{Name: "cli-tokens-by-user", Fields: []string{"user_id", "created_at"}},
+			})
+		},
+	},
+	{
+		Version:     4,
+		Description: "versions view keyed by note and version",
+		Up: func(ctx context.Context, db *kivik.DB) error {
+			return putDesignDoc(ctx, db, designDoc{
+				ID:       "_design/versions",
+				Language: "javascript",
+				Views: map[string]viewDef{
+					"by_note": {Map: `function (doc) {
+  var id = doc._id.indexOf("version:") === 0 ? doc._id : doc.id;
+  if (id && id.indexOf("version:") === 0 && doc.note_id) {
+    emit(doc.note_id, doc);
+  }
+}`},
+					"by_note_version": {Map: `function (doc) {
+  var id = doc._id.indexOf("version:") === 0 ? doc._id : doc.id;
+  if (id && id.indexOf("version:") === 0 && doc.note_id) {
+    emit([doc.note_id, doc.version], null);
+  }
+}`},
+				},
+			})
+		},
+	},
+	{
+		Version:     5,
+		Description: "conflict views ordered by detection time",
+		Up: func(ctx context.Context, db *kivik.DB) error {
+			return putDesignDoc(ctx, db, designDoc{
+				ID:       "_design/conflicts",
+				Language: "javascript",
+				Views: map[string]viewDef{
+					"by_user": {Map: `function (doc) {
+  if (doc._id.indexOf("conflict:") === 0) {
+    emit(doc.user_id, doc);
+  }
+}`},
+					"by_note": {Map: `function (doc) {
+  if (doc._id.indexOf("conflict:") === 0) {
+    emit(doc.note_id, doc);
+  }
+}`},
+					"by_user_detected": {Map: `function (doc) {
+  if (doc._id.indexOf("conflict:") === 0) {
+    emit([doc.user_id, doc.detected_at], doc);
+  }
+}`},
+					"unresolved_by_detected": {Map: `function (doc) {
+  if (doc._id.indexOf("conflict:") === 0 && !doc.resolved_at) {
+    emit(doc.detected_at, doc);
+  }
+}`},
+				},
+			})
+		},
+	},
+	{
+		Version:     6,
+		Description: "chunk sizes view and chunked content in workspace stats",
+		Up: func(ctx context.Context, db *kivik.DB) error {
+			if err := putDesignDoc(ctx, db, designDoc{
+				ID:       "_design/chunks",
+				Language: "javascript",
+				Views: map[string]viewDef{
+					"sizes": {Map: `function (doc) {
+  if (doc._id.indexOf("chunk:") === 0) {
+    emit([doc.note_id, doc.hash], doc.size);
+  }
+}`},
+				},
+			}); err != nil {
+				return err
+			}
+
+			return putDesignDoc(ctx, db, designDoc{
+				ID:       "_design/notes",
+				Language: "javascript",
+				Views: map[string]viewDef{
+					"stats_by_workspace": {
+						Map: `function (doc) {
+  if (doc._id.indexOf("note:") === 0 && doc.user_id && doc.workspace_id && !doc.is_deleted) {
+    emit([doc.user_id, doc.workspace_id], {
+      count: 1,
+      size: (doc.encrypted_title || "").length + (doc.encrypted_content || "").length + (doc.content_size || 0),
+      updated_at: doc.updated_at
+    });
+  }
+}`,
+						Reduce: `function (keys, values, rereduce) {
+  var result = {count: 0, size: 0, updated_at: null};
+  for (var i = 0; i < values.length; i++) {
+    result.count += values[i].count;
+    result.size += values[i].size;
+    if (values[i].updated_at && (!result.updated_at || values[i].updated_at > result.updated_at)) {
+      result.updated_at = values[i].updated_at;
+    }
+  }
+  return result;
+}`,
+					},
+				},
+			})
+		},
+	},
+}
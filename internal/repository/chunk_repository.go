@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"inkdown-sync-server/internal/domain"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+// ChunkRepository stores the content chunks of each note
+type ChunkRepository interface {
+	// Save stores chunks; a chunk the note already has is left as is
+	Save(ctx context.Context, chunks []*domain.Chunk) error
+	// Get returns the chunks of the note among hashes. Hashes the note has
+	// no chunk for are skipped.
+	Get(ctx context.Context, noteID string, hashes []string) ([]*domain.Chunk, error)
+	// Sizes returns the size of each chunk of the note among hashes
+	Sizes(ctx context.Context, noteID string, hashes []string) (map[string]int64, error)
+	// TotalSize returns the size of every chunk stored for the note
+	TotalSize(ctx context.Context, noteID string) (int64, error)
+	DeleteAll(ctx context.Context, noteID string) error
+}
+
+type chunkRepository struct {
+	client *kivik.Client
+	dbName string
+}
+
+type chunkDoc struct {
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.Chunk
+}
+
+func NewChunkRepository(client *kivik.Client, dbName string) ChunkRepository {
+	return &chunkRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func chunkDocID(noteID, hash string) string {
+	return fmt.Sprintf("chunk:%s:%s", noteID, hash)
+}
+
+func (r *chunkRepository) Save(ctx context.Context, chunks []*domain.Chunk) error {
+	db := r.client.DB(r.dbName)
+
+	for _, chunk := range chunks {
+		doc := chunkDoc{DocType: "chunk", Chunk: *chunk}
+
+		// A chunk is identified by its content, so an existing document
+		// already holds the same data.
+		if _, err := db.Put(ctx, chunkDocID(chunk.NoteID, chunk.Hash), doc); err != nil {
+			if errors.Is(wrapError(err), ErrConflict) {
+				continue
+			}
+			return fmt.Errorf("failed to save chunk: %w", wrapError(err))
+		}
+	}
+
+	return nil
+}
+
+func (r *chunkRepository) Get(ctx context.Context, noteID string, hashes []string) ([]*domain.Chunk, error) {
+	keys := make([]string, len(hashes))
+	for i, hash := range hashes {
+		keys[i] = chunkDocID(noteID, hash)
+	}
+
+	rows := r.client.DB(r.dbName).AllDocs(ctx, kivik.Params(map[string]interface{}{
+		"include_docs": true,
+		"keys":         keys,
+	}))
+	defer rows.Close()
+
+	var chunks []*domain.Chunk
+	for rows.Next() {
+		var doc chunkDoc
+		// Keys without a document come back as rows without one
+		if err := rows.ScanDoc(&doc); err != nil || doc.Hash == "" {
+			continue
+		}
+		chunk := doc.Chunk
+		chunks = append(chunks, &chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get chunks: %w", err)
+	}
+
+	return chunks, nil
+}
+
+func (r *chunkRepository) Sizes(ctx context.Context, noteID string, hashes []string) (map[string]int64, error) {
+	keys := make([]interface{}, len(hashes))
+	for i, hash := range hashes {
+		keys[i] = []string{noteID, hash}
+	}
+
+	rows := r.client.DB(r.dbName).Query(ctx, "_design/chunks", "_view/sizes", kivik.Params(map[string]interface{}{
+		"keys": keys,
+	}))
+	defer rows.Close()
+
+	sizes := make(map[string]int64, len(hashes))
+	for rows.Next() {
+		var key [2]string
+		if err := rows.ScanKey(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk key: %w", err)
+		}
+		var size int64
+		if err := rows.ScanValue(&size); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk size: %w", err)
+		}
+		sizes[key[1]] = size
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get chunk sizes: %w", err)
+	}
+
+	return sizes, nil
+}
+
+func (r *chunkRepository) TotalSize(ctx context.Context, noteID string) (int64, error) {
+	rows := r.client.DB(r.dbName).Query(ctx, "_design/chunks", "_view/sizes", kivik.Params(map[string]interface{}{
+		"startkey": []interface{}{noteID},
+		"endkey":   []interface{}{noteID, map[string]interface{}{}},
+	}))
+	defer rows.Close()
+
+	var total int64
+	for rows.Next() {
+		var size int64
+		if err := rows.ScanValue(&size); err != nil {
+			return 0, fmt.Errorf("failed to scan chunk size: %w", err)
+		}
+		total += size
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to get chunk sizes: %w", err)
+	}
+
+	return total, nil
+}
+
+func (r *chunkRepository) DeleteAll(ctx context.Context, noteID string) error {
+	db := r.client.DB(r.dbName)
+
+	rows := db.AllDocs(ctx, kivik.Params(map[string]interface{}{
+		"startkey": fmt.Sprintf("chunk:%s:", noteID),
+		"endkey":   fmt.Sprintf("chunk:%s:\ufff0", noteID),
+	}))
+	defer rows.Close()
+
+	for rows.Next() {
+		id, err := rows.ID()
+		if err != nil {
+			return fmt.Errorf("failed to read chunk id: %w", err)
+		}
+		var value struct {
+			Rev string `json:"rev"`
+		}
+		if err := rows.ScanValue(&value); err != nil {
+			return fmt.Errorf("failed to read chunk revision: %w", err)
+		}
+		if _, err := db.Delete(ctx, id, value.Rev); err != nil {
+			return fmt.Errorf("failed to delete chunk: %w", err)
+		}
+	}
+
+	return rows.Err()
+}
@@ -11,13 +11,13 @@ import (
 )
 
 type CLITokenRepository interface {
-	Create(token *domain.CLIToken) error
-	FindByID(id string) (*domain.CLIToken, error)
-	FindByToken(hashedToken string) (*domain.CLIToken, error)
-	FindByUserID(userID string) ([]*domain.CLIToken, error)
-	UpdateLastUsed(id string, ip string) error
-	Revoke(id string) error
-	Delete(id string) error
+	Create(ctx context.Context, token *domain.CLIToken) error
+	FindByID(ctx context.Context, id string) (*domain.CLIToken, error)
+	FindByToken(ctx context.Context, hashedToken string) (*domain.CLIToken, error)
+	FindByUserID(ctx context.Context, userID string) ([]*domain.CLIToken, error)
+	UpdateLastUsed(ctx context.Context, id string, ip string) error
+	Revoke(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string) error
 }
 
 type cliTokenRepository struct {
@@ -32,11 +32,11 @@ func NewCLITokenRepository(client *kivik.Client, dbName string) CLITokenReposito
 	}
 }
 
-func (r *cliTokenRepository) Create(token *domain.CLIToken) error {
+func (r *cliTokenRepository) Create(ctx context.Context, token *domain.CLIToken) error {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("cli_token:%s", token.ID)
-	_, err := db.Put(context.Background(), docID, token)
+	_, err := db.Put(ctx, docID, token)
 	if err != nil {
 		return fmt.Errorf("failed to create CLI token: %w", err)
 	}
@@ -44,11 +44,11 @@ func (r *cliTokenRepository) Create(token *domain.CLIToken) error {
 	return nil
 }
 
-func (r *cliTokenRepository) FindByID(id string) (*domain.CLIToken, error) {
+func (r *cliTokenRepository) FindByID(ctx context.Context, id string) (*domain.CLIToken, error) {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("cli_token:%s", id)
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 
 	var token domain.CLIToken
 	if err := row.ScanDoc(&token); err != nil {
@@ -58,7 +58,7 @@ func (r *cliTokenRepository) FindByID(id string) (*domain.CLIToken, error) {
 	return &token, nil
 }
 
-func (r *cliTokenRepository) FindByToken(hashedToken string) (*domain.CLIToken, error) {
+func (r *cliTokenRepository) FindByToken(ctx context.Context, hashedToken string) (*domain.CLIToken, error) {
 	db := r.client.DB(r.dbName)
 
 	query := map[string]interface{}{
@@ -69,7 +69,7 @@ func (r *cliTokenRepository) FindByToken(hashedToken string) (*domain.CLIToken,
 		"limit": 1,
 	}
 
-	rows := db.Find(context.Background(), query)
+	rows := db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to query CLI token: %w", err)
 	}
@@ -87,7 +87,7 @@ func (r *cliTokenRepository) FindByToken(hashedToken string) (*domain.CLIToken,
 	return &token, nil
 }
 
-func (r *cliTokenRepository) FindByUserID(userID string) ([]*domain.CLIToken, error) {
+func (r *cliTokenRepository) FindByUserID(ctx context.Context, userID string) ([]*domain.CLIToken, error) {
 	db := r.client.DB(r.dbName)
 
 	query := map[string]interface{}{
@@ -99,7 +99,7 @@ func (r *cliTokenRepository) FindByUserID(userID string) ([]*domain.CLIToken, er
 		},
 	}
 
-	rows := db.Find(context.Background(), query)
+	rows := db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to query CLI tokens: %w", err)
 	}
@@ -117,8 +117,8 @@ func (r *cliTokenRepository) FindByUserID(userID string) ([]*domain.CLIToken, er
 	return tokens, nil
 }
 
-func (r *cliTokenRepository) UpdateLastUsed(id string, ip string) error {
-	token, err := r.FindByID(id)
+func (r *cliTokenRepository) UpdateLastUsed(ctx context.Context, id string, ip string) error {
+	token, err := r.FindByID(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -129,7 +129,7 @@ func (r *cliTokenRepository) UpdateLastUsed(id string, ip string) error {
 
 	db := r.client.DB(r.dbName)
 	docID := fmt.Sprintf("cli_token:%s", id)
-	_, err = db.Put(context.Background(), docID, token)
+	_, err = db.Put(ctx, docID, token)
 	if err != nil {
 		return fmt.Errorf("failed to update CLI token: %w", err)
 	}
@@ -137,8 +137,8 @@ func (r *cliTokenRepository) UpdateLastUsed(id string, ip string) error {
 	return nil
 }
 
-func (r *cliTokenRepository) Revoke(id string) error {
-	token, err := r.FindByID(id)
+func (r *cliTokenRepository) Revoke(ctx context.Context, id string) error {
+	token, err := r.FindByID(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -149,7 +149,7 @@ func (r *cliTokenRepository) Revoke(id string) error {
 
 	db := r.client.DB(r.dbName)
 	docID := fmt.Sprintf("cli_token:%s", id)
-	_, err = db.Put(context.Background(), docID, token)
+	_, err = db.Put(ctx, docID, token)
 	if err != nil {
 		return fmt.Errorf("failed to revoke CLI token: %w", err)
 	}
@@ -157,11 +157,11 @@ func (r *cliTokenRepository) Revoke(id string) error {
 	return nil
 }
 
-func (r *cliTokenRepository) Delete(id string) error {
+func (r *cliTokenRepository) Delete(ctx context.Context, id string) error {
 	db := r.client.DB(r.dbName)
 	docID := fmt.Sprintf("cli_token:%s", id)
 
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 	var doc map[string]interface{}
 	if err := row.ScanDoc(&doc); err != nil {
 		return fmt.Errorf("CLI token not found: %w", err)
@@ -172,7 +172,7 @@ func (r *cliTokenRepository) Delete(id string) error {
 		return fmt.Errorf("failed to get document revision")
 	}
 
-	_, err := db.Delete(context.Background(), docID, rev)
+	_, err := db.Delete(ctx, docID, rev)
 	if err != nil {
 		return fmt.Errorf("failed to delete CLI token: %w", err)
 	}
@@ -1,238 +1,280 @@
 package repository
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
+	"strings"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
+
+	"github.com/go-kivik/kivik/v4"
 )
 
 type ConflictRepository interface {
-	Create(conflict *domain.Conflict) error
-	Get(conflictID string) (*domain.Conflict, error)
-	ListByUser(userID string) ([]*domain.Conflict, error)
-	ListByNote(noteID string) ([]*domain.Conflict, error)
-	MarkResolved(conflictID string, choice domain.ResolutionStrategy) error
-	Delete(conflictID string) error
+	Create(ctx context.Context, conflict *domain.Conflict) error
+	Get(ctx context.Context, conflictID string) (*domain.Conflict, error)
+	ListByUser(ctx context.Context, userID string) ([]*domain.Conflict, error)
+	ListByNote(ctx context.Context, noteID string) ([]*domain.Conflict, error)
+	// List returns the user's conflicts matching filter, newest first
+	List(ctx context.Context, userID string, filter domain.ConflictFilter) ([]*domain.Conflict, error)
+	// ListUnresolvedBefore returns unresolved conflicts of every user that
+	// were detected before cutoff
+	ListUnresolvedBefore(ctx context.Context, cutoff time.Time) ([]*domain.Conflict, error)
+	// MarkResolved fails with ErrConflict if the conflict already is resolved
+	MarkResolved(ctx context.Context, conflictID string, choice domain.ResolutionStrategy) error
+	// UpdateResolution replaces the strategy recorded for a resolved
+	// conflict. An empty choice reopens the conflict.
+	UpdateResolution(ctx context.Context, conflictID string, choice domain.ResolutionStrategy) error
+	// Reassign moves every conflict of a note to userID
+	Reassign(ctx context.Context, noteID, userID string) error
+	Delete(ctx context.Context, conflictID string) error
 }
 
-type conflictRepo struct {
-	baseURL string
-	client  *http.Client
+type conflictRepository struct {
+	client *kivik.Client
+	dbName string
 }
 
-func NewConflictRepository(baseURL string) ConflictRepository {
-	return &conflictRepo{
-		baseURL: baseURL,
-		client:  &http.Client{Timeout: 10 * time.Second},
-	}
+type conflictDoc struct {
+	DocID   string `json:"_id"`
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.Conflict
 }
 
-func (r *conflictRepo) Create(conflict *domain.Conflict) error {
-	doc := map[string]interface{}{
-		"_id":               fmt.Sprintf("conflict:%s", conflict.ID),
-		"note_id":           conflict.NoteID,
-		"user_id":           conflict.UserID,
-		"type":              conflict.Type,
-		"base_version":      conflict.BaseVersion,
-		"server_version":    conflict.ServerVersion,
-		"client_version":    conflict.ClientVersion,
-		"server_note":       conflict.ServerNote,
-		"client_data":       conflict.ClientData,
-		"device_id":         conflict.DeviceID,
-		"detected_at":       conflict.DetectedAt,
-		"resolved_at":       conflict.ResolvedAt,
-		"resolution_choice": conflict.ResolutionChoice,
+func NewConflictRepository(client *kivik.Client, dbName string) ConflictRepository {
+	return &conflictRepository{
+		client: client,
+		dbName: dbName,
 	}
+}
 
-	data, err := json.Marshal(doc)
-	if err != nil {
-		return err
-	}
+func (r *conflictRepository) Create(ctx context.Context, conflict *domain.Conflict) error {
+	db := r.client.DB(r.dbName)
 
-	resp, err := r.client.Post(r.baseURL, "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return err
+	doc := conflictDoc{
+		DocID:    conflictDocID(conflict.ID),
+		DocType:  "conflict",
+		Conflict: *conflict,
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to create conflict: status %d", resp.StatusCode)
+	if _, err := db.Put(ctx, doc.DocID, doc); err != nil {
+		return fmt.Errorf("failed to create conflict: %w", wrapError(err))
 	}
 
 	return nil
 }
 
-func (r *conflictRepo) Get(conflictID string) (*domain.Conflict, error) {
-	url := fmt.Sprintf("%s/conflict:%s", r.baseURL, conflictID)
-
-	resp, err := r.client.Get(url)
+func (r *conflictRepository) Get(ctx context.Context, conflictID string) (*domain.Conflict, error) {
+	doc, err := r.get(ctx, conflictID)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("conflict not found")
-	}
+	return doc.toConflict(), nil
+}
 
-	var conflict domain.Conflict
-	if err := json.NewDecoder(resp.Body).Decode(&conflict); err != nil {
-		return nil, err
-	}
+func (r *conflictRepository) ListByUser(ctx context.Context, userID string) ([]*domain.Conflict, error) {
+	return r.query(ctx, "by_user", userID)
+}
 
-	return &conflict, nil
+func (r *conflictRepository) ListByNote(ctx context.Context, noteID string) ([]*domain.Conflict, error) {
+	return r.query(ctx, "by_note", noteID)
 }
 
-func (r *conflictRepo) ListByUser(userID string) ([]*domain.Conflict, error) {
-	viewURL := fmt.Sprintf("%s/_design/conflicts/_view/by_user?key=\"%s\"", r.baseURL, userID)
+func (r *conflictRepository) List(ctx context.Context, userID string, filter domain.ConflictFilter) ([]*domain.Conflict, error) {
+	rows := r.client.DB(r.dbName).Query(ctx, "_design/conflicts", "_view/by_user_detected", kivik.Params(map[string]interface{}{
+		"startkey":   []interface{}{userID, map[string]interface{}{}},
+		"endkey":     []interface{}{userID},
+		"descending": true,
+	}))
+	defer rows.Close()
+
+	// The view only narrows by user; the remaining filters are applied while
+	// reading so the scan stops once the page is full.
+	var conflicts []*domain.Conflict
+	skipped := 0
+	for rows.Next() {
+		var doc conflictDoc
+		if err := rows.ScanValue(&doc); err != nil {
+			return nil, fmt.Errorf("failed to scan conflict: %w", err)
+		}
 
-	resp, err := r.client.Get(viewURL)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+		conflict := doc.toConflict()
+		if !filter.Matches(conflict) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
 
-	var result struct {
-		Rows []struct {
-			Value domain.Conflict `json:"value"`
-		} `json:"rows"`
+		conflicts = append(conflicts, conflict)
+		if filter.Limit > 0 && len(conflicts) == filter.Limit {
+			break
+		}
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list conflicts: %w", err)
 	}
 
-	conflicts := make([]*domain.Conflict, len(result.Rows))
-	for i, row := range result.Rows {
-		c := row.Value
-		conflicts[i] = &c
+	return conflicts, nil
+}
+
+func (r *conflictRepository) ListUnresolvedBefore(ctx context.Context, cutoff time.Time) ([]*domain.Conflict, error) {
+	rows := r.client.DB(r.dbName).Query(ctx, "_design/conflicts", "_view/unresolved_by_detected", kivik.Params(map[string]interface{}{
+		"endkey":        cutoff.UTC(),
+		"inclusive_end": false,
+	}))
+	defer rows.Close()
+
+	var conflicts []*domain.Conflict
+	for rows.Next() {
+		var doc conflictDoc
+		if err := rows.ScanValue(&doc); err != nil {
+			return nil, fmt.Errorf("failed to scan conflict: %w", err)
+		}
+		conflicts = append(conflicts, doc.toConflict())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list stale conflicts: %w", err)
 	}
 
 	return conflicts, nil
 }
 
-func (r *conflictRepo) ListByNote(noteID string) ([]*domain.Conflict, error) {
-	viewURL := fmt.Sprintf("%s/_design/conflicts/_view/by_note?key=\"%s\"", r.baseURL, noteID)
-
-	resp, err := r.client.Get(viewURL)
+func (r *conflictRepository) MarkResolved(ctx context.Context, conflictID string, choice domain.ResolutionStrategy) error {
+	doc, err := r.get(ctx, conflictID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
 
-	var result struct {
-		Rows []struct {
-			Value domain.Conflict `json:"value"`
-		} `json:"rows"`
+	if doc.ResolvedAt != nil {
+		return fmt.Errorf("conflict already resolved: %w", ErrConflict)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
+	now := time.Now()
+	doc.ResolvedAt = &now
+	doc.ResolutionChoice = choice
+	doc.DocType = "conflict"
 
-	conflicts := make([]*domain.Conflict, len(result.Rows))
-	for i, row := range result.Rows {
-		c := row.Value
-		conflicts[i] = &c
+	if _, err := r.client.DB(r.dbName).Put(ctx, doc.DocID, doc); err != nil {
+		return fmt.Errorf("failed to mark conflict as resolved: %w", wrapError(err))
 	}
 
-	return conflicts, nil
+	return nil
 }
 
-func (r *conflictRepo) MarkResolved(conflictID string, choice domain.ResolutionStrategy) error {
-	conflict, err := r.Get(conflictID)
+func (r *conflictRepository) UpdateResolution(ctx context.Context, conflictID string, choice domain.ResolutionStrategy) error {
+	doc, err := r.get(ctx, conflictID)
 	if err != nil {
 		return err
 	}
 
-	now := time.Now()
-	conflict.ResolvedAt = &now
-	conflict.ResolutionChoice = choice
-
-	url := fmt.Sprintf("%s/conflict:%s", r.baseURL, conflictID)
-	respGet, err := r.client.Get(url)
-	if err != nil {
-		return err
+	if choice == "" {
+		doc.ResolvedAt = nil
 	}
+	doc.ResolutionChoice = choice
+	doc.DocType = "conflict"
 
-	var existingDoc map[string]interface{}
-	json.NewDecoder(respGet.Body).Decode(&existingDoc)
-	respGet.Body.Close()
-
-	doc := map[string]interface{}{
-		"_id":               fmt.Sprintf("conflict:%s", conflictID),
-		"_rev":              existingDoc["_rev"],
-		"note_id":           conflict.NoteID,
-		"user_id":           conflict.UserID,
-		"type":              conflict.Type,
-		"base_version":      conflict.BaseVersion,
-		"server_version":    conflict.ServerVersion,
-		"client_version":    conflict.ClientVersion,
-		"server_note":       conflict.ServerNote,
-		"client_data":       conflict.ClientData,
-		"device_id":         conflict.DeviceID,
-		"detected_at":       conflict.DetectedAt,
-		"resolved_at":       conflict.ResolvedAt,
-		"resolution_choice": conflict.ResolutionChoice,
-	}
-
-	data, err := json.Marshal(doc)
-	if err != nil {
-		return err
+	if _, err := r.client.DB(r.dbName).Put(ctx, doc.DocID, doc); err != nil {
+		return fmt.Errorf("failed to update conflict resolution: %w", wrapError(err))
 	}
 
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(data))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
 
-	resp, err := r.client.Do(req)
-	if err != nil {
-		return err
+func (r *conflictRepository) Reassign(ctx context.Context, noteID, userID string) error {
+	rows := r.client.DB(r.dbName).Query(ctx, "_design/conflicts", "_view/by_note", kivik.Params(map[string]interface{}{
+		"key": noteID,
+	}))
+	defer rows.Close()
+
+	var docs []conflictDoc
+	for rows.Next() {
+		var doc conflictDoc
+		if err := rows.ScanValue(&doc); err != nil {
+			return fmt.Errorf("failed to scan conflict: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list conflicts: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to mark conflict as resolved: status %d", resp.StatusCode)
+	for _, doc := range docs {
+		if doc.UserID == userID {
+			continue
+		}
+
+		doc.UserID = userID
+		if doc.ServerNote != nil {
+			doc.ServerNote.UserID = userID
+		}
+		doc.DocType = "conflict"
+		if _, err := r.client.DB(r.dbName).Put(ctx, doc.DocID, doc); err != nil {
+			return fmt.Errorf("failed to reassign conflict: %w", wrapError(err))
+		}
 	}
 
 	return nil
 }
 
-func (r *conflictRepo) Delete(conflictID string) error {
-	url := fmt.Sprintf("%s/conflict:%s", r.baseURL, conflictID)
+func (r *conflictRepository) Delete(ctx context.Context, conflictID string) error {
+	db := r.client.DB(r.dbName)
+	docID := conflictDocID(conflictID)
 
-	resp, err := r.client.Get(url)
+	rev, err := db.GetRev(ctx, docID)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to get conflict: %w", wrapError(err))
 	}
 
-	var doc map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&doc)
-	resp.Body.Close()
+	if _, err := db.Delete(ctx, docID, rev); err != nil {
+		return fmt.Errorf("failed to delete conflict: %w", wrapError(err))
+	}
 
-	if rev, ok := doc["_rev"].(string); ok {
-		deleteURL := fmt.Sprintf("%s?rev=%s", url, rev)
-		req, err := http.NewRequest(http.MethodDelete, deleteURL, nil)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		resp, err := r.client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
+func (r *conflictRepository) get(ctx context.Context, conflictID string) (*conflictDoc, error) {
+	var doc conflictDoc
+	if err := r.client.DB(r.dbName).Get(ctx, conflictDocID(conflictID)).ScanDoc(&doc); err != nil {
+		return nil, fmt.Errorf("failed to get conflict: %w", wrapError(err))
+	}
+	return &doc, nil
+}
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to delete conflict: status %d", resp.StatusCode)
+func (r *conflictRepository) query(ctx context.Context, view, key string) ([]*domain.Conflict, error) {
+	rows := r.client.DB(r.dbName).Query(ctx, "_design/conflicts", "_view/"+view, kivik.Params(map[string]interface{}{
+		"key": key,
+	}))
+	defer rows.Close()
+
+	var conflicts []*domain.Conflict
+	for rows.Next() {
+		var doc conflictDoc
+		if err := rows.ScanValue(&doc); err != nil {
+			return nil, fmt.Errorf("failed to scan conflict: %w", err)
 		}
+		conflicts = append(conflicts, doc.toConflict())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list conflicts: %w", err)
 	}
 
-	return nil
+	return conflicts, nil
+}
+
+// toConflict returns the stored conflict. Older documents only carry the ID
+// in their document ID.
+func (d *conflictDoc) toConflict() *domain.Conflict {
+	conflict := d.Conflict
+	if conflict.ID == "" {
+		conflict.ID = strings.TrimPrefix(d.DocID, "conflict:")
+	}
+	return &conflict
+}
+
+func conflictDocID(conflictID string) string {
+	return "conflict:" + conflictID
 }
@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"inkdown-sync-server/internal/domain"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+// CRDTRepository stores the CRDT update log and the latest snapshot of each
+// note
+type CRDTRepository interface {
+	// AppendUpdate stores update under the note's next sequence number and
+	// sets update.Seq to it. Numbers keep growing after compaction.
+	AppendUpdate(ctx context.Context, update *domain.CRDTUpdate) error
+	// ListUpdates returns up to limit updates with a sequence number above
+	// afterSeq, oldest first
+	ListUpdates(ctx context.Context, noteID string, afterSeq int64, limit int) ([]*domain.CRDTUpdate, error)
+	// LatestSeq returns the sequence number of the note's newest update, or
+	// of its snapshot if the log is empty
+	LatestSeq(ctx context.Context, noteID string) (int64, error)
+	GetSnapshot(ctx context.Context, noteID string) (*domain.CRDTSnapshot, error)
+	// SaveSnapshot replaces the note's snapshot and drops the updates it
+	// covers
+	SaveSnapshot(ctx context.Context, snapshot *domain.CRDTSnapshot) error
+	// Reassign moves the note's updates to userID
+	Reassign(ctx context.Context, noteID, userID string) error
+	// TotalSize returns the size of the note's updates and snapshot data
+	TotalSize(ctx context.Context, noteID string) (int64, error)
+	DeleteAll(ctx context.Context, noteID string) error
+}
+
+// appendRetries bounds how often AppendUpdate retries after another update
+// took the sequence number it tried
+const appendRetries = 5
+
+type crdtRepository struct {
+	client *kivik.Client
+	dbName string
+}
+
+type crdtUpdateDoc struct {
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.CRDTUpdate
+}
+
+type crdtSnapshotDoc struct {
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.CRDTSnapshot
+}
+
+func NewCRDTRepository(client *kivik.Client, dbName string) CRDTRepository {
+	return &crdtRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+// Update IDs are zero padded so _all_docs lists a note's log in order
+func crdtUpdateDocID(noteID string, seq int64) string {
+	return fmt.Sprintf("crdt:%s:%020d", noteID, seq)
+}
+
+func crdtSnapshotDocID(noteID string) string {
+	return fmt.Sprintf("crdt_snapshot:%s", noteID)
+}
+
+func (r *crdtRepository) AppendUpdate(ctx context.Context, update *domain.CRDTUpdate) error {
+	db := r.client.DB(r.dbName)
+
+	for attempt := 0; attempt < appendRetries; attempt++ {
+		latest, err := r.LatestSeq(ctx, update.NoteID)
+		if err != nil {
+			return err
+		}
+
+		doc := crdtUpdateDoc{DocType: "crdt_update", CRDTUpdate: *update}
+		doc.Seq = latest + 1
+
+		// Creating a document that exists fails, so two devices never get
+		// the same sequence number.
+		if _, err := db.Put(ctx, crdtUpdateDocID(update.NoteID, doc.Seq), doc); err != nil {
+			if errors.Is(wrapError(err), ErrConflict) {
+				continue
+			}
+			return fmt.Errorf("failed to append crdt update: %w", wrapError(err))
+		}
+
+		update.Seq = doc.Seq
+		return nil
+	}
+
+	return fmt.Errorf("failed to append crdt update: %w", ErrConflict)
+}
+
+func (r *crdtRepository) ListUpdates(ctx context.Context, noteID string, afterSeq int64, limit int) ([]*domain.CRDTUpdate, error) {
+	rows := r.client.DB(r.dbName).AllDocs(ctx, kivik.Params(map[string]interface{}{
+		"include_docs": true,
+		"startkey":     crdtUpdateDocID(noteID, afterSeq+1),
+		"endkey":       fmt.Sprintf("crdt:%s:\ufff0", noteID),
+		"limit":        limit,
+	}))
+	defer rows.Close()
+
+	var updates []*domain.CRDTUpdate
+	for rows.Next() {
+		var doc crdtUpdateDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			return nil, fmt.Errorf("failed to scan crdt update: %w", err)
+		}
+		update := doc.CRDTUpdate
+		updates = append(updates, &update)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list crdt updates: %w", err)
+	}
+
+	return updates, nil
+}
+
+func (r *crdtRepository) LatestSeq(ctx context.Context, noteID string) (int64, error) {
+	rows := r.client.DB(r.dbName).AllDocs(ctx, kivik.Params(map[string]interface{}{
+		"startkey":   fmt.Sprintf("crdt:%s:\ufff0", noteID),
+		"endkey":     fmt.Sprintf("crdt:%s:", noteID),
+		"descending": true,
+		"limit":      1,
+	}))
+	defer rows.Close()
+
+	var latest int64
+	if rows.Next() {
+		id, err := rows.ID()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read crdt update id: %w", err)
+		}
+		seq := id[strings.LastIndex(id, ":")+1:]
+		if latest, err = strconv.ParseInt(seq, 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid crdt update id %q: %w", id, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read latest crdt update: %w", err)
+	}
+
+	if latest == 0 {
+		snapshot, err := r.GetSnapshot(ctx, noteID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return 0, err
+		}
+		if snapshot != nil {
+			latest = snapshot.Seq
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *crdtRepository) GetSnapshot(ctx context.Context, noteID string) (*domain.CRDTSnapshot, error) {
+	var doc crdtSnapshotDoc
+	if err := r.client.DB(r.dbName).Get(ctx, crdtSnapshotDocID(noteID)).ScanDoc(&doc); err != nil {
+		return nil, fmt.Errorf("failed to get crdt snapshot: %w", wrapError(err))
+	}
+
+	snapshot := doc.CRDTSnapshot
+	return &snapshot, nil
+}
+
+func (r *crdtRepository) SaveSnapshot(ctx context.Context, snapshot *domain.CRDTSnapshot) error {
+	db := r.client.DB(r.dbName)
+	docID := crdtSnapshotDocID(snapshot.NoteID)
+
+	doc := crdtSnapshotDoc{DocType: "crdt_snapshot", CRDTSnapshot: *snapshot}
+	rev, err := db.GetRev(ctx, docID)
+	if err != nil && kivik.HTTPStatus(err) != 404 {
+		return fmt.Errorf("failed to get crdt snapshot revision: %w", err)
+	}
+	doc.Rev = rev
+
+	if _, err := db.Put(ctx, docID, doc); err != nil {
+		return fmt.Errorf("failed to save crdt snapshot: %w", wrapError(err))
+	}
+
+	return r.deleteUpdates(ctx, snapshot.NoteID, snapshot.Seq)
+}
+
+func (r *crdtRepository) Reassign(ctx context.Context, noteID, userID string) error {
+	db := r.client.DB(r.dbName)
+
+	rows := db.AllDocs(ctx, kivik.Params(map[string]interface{}{
+		"include_docs": true,
+		"startkey":     fmt.Sprintf("crdt:%s:", noteID),
+		"endkey":       fmt.Sprintf("crdt:%s:\ufff0", noteID),
+	}))
+	defer rows.Close()
+
+	for rows.Next() {
+		id, err := rows.ID()
+		if err != nil {
+			return fmt.Errorf("failed to read crdt update id: %w", err)
+		}
+		var doc crdtUpdateDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			return fmt.Errorf("failed to scan crdt update: %w", err)
+		}
+		if doc.UserID == userID {
+			continue
+		}
+
+		doc.UserID = userID
+		if _, err := db.Put(ctx, id, doc); err != nil {
+			return fmt.Errorf("failed to reassign crdt update: %w", wrapError(err))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list crdt updates: %w", err)
+	}
+
+	return nil
+}
+
+func (r *crdtRepository) TotalSize(ctx context.Context, noteID string) (int64, error) {
+	rows := r.client.DB(r.dbName).AllDocs(ctx, kivik.Params(map[string]interface{}{
+		"include_docs": true,
+		"startkey":     fmt.Sprintf("crdt:%s:", noteID),
+		"endkey":       fmt.Sprintf("crdt:%s:\ufff0", noteID),
+	}))
+	defer rows.Close()
+
+	var total int64
+	for rows.Next() {
+		var doc crdtUpdateDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			return 0, fmt.Errorf("failed to scan crdt update: %w", err)
+		}
+		total += int64(len(doc.Data))
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list crdt updates: %w", err)
+	}
+
+	snapshot, err := r.GetSnapshot(ctx, noteID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return total, nil
+		}
+		return 0, err
+	}
+	return total + int64(len(snapshot.Data)), nil
+}
+
+func (r *crdtRepository) DeleteAll(ctx context.Context, noteID string) error {
+	db := r.client.DB(r.dbName)
+
+	if err := r.deleteUpdates(ctx, noteID, -1); err != nil {
+		return err
+	}
+
+	rev, err := db.GetRev(ctx, crdtSnapshotDocID(noteID))
+	if err != nil {
+		if kivik.HTTPStatus(err) == 404 {
+			return nil
+		}
+		return fmt.Errorf("failed to get crdt snapshot revision: %w", err)
+	}
+	if _, err := db.Delete(ctx, crdtSnapshotDocID(noteID), rev); err != nil {
+		return fmt.Errorf("failed to delete crdt snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// deleteUpdates removes the note's updates up to throughSeq, or all of them
+// if throughSeq is negative
+func (r *crdtRepository) deleteUpdates(ctx context.Context, noteID string, throughSeq int64) error {
+	db := r.client.DB(r.dbName)
+
+	endkey := fmt.Sprintf("crdt:%s:\ufff0", noteID)
+	if throughSeq >= 0 {
+		endkey = crdtUpdateDocID(noteID, throughSeq)
+	}
+
+	rows := db.AllDocs(ctx, kivik.Params(map[string]interface{}{
+		"startkey": fmt.Sprintf("crdt:%s:", noteID),
+		"endkey":   endkey,
+	}))
+	defer rows.Close()
+
+	for rows.Next() {
+		id, err := rows.ID()
+		if err != nil {
+			return fmt.Errorf("failed to read crdt update id: %w", err)
+		}
+		var value struct {
+			Rev string `json:"rev"`
+		}
+		if err := rows.ScanValue(&value); err != nil {
+			return fmt.Errorf("failed to read crdt update revision: %w", err)
+		}
+		if _, err := db.Delete(ctx, id, value.Rev); err != nil {
+			return fmt.Errorf("failed to delete crdt update: %w", err)
+		}
+	}
+
+	return rows.Err()
+}
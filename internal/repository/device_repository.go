@@ -11,11 +11,11 @@ import (
 )
 
 type DeviceRepository interface {
-	Create(device *domain.Device) error
-	List(userID string) ([]*domain.Device, error)
-	FindByID(deviceID string) (*domain.Device, error)
-	Revoke(deviceID string) error
-	UpdateLastActive(deviceID string) error
+	Create(ctx context.Context, device *domain.Device) error
+	List(ctx context.Context, userID string) ([]*domain.Device, error)
+	FindByID(ctx context.Context, deviceID string) (*domain.Device, error)
+	Revoke(ctx context.Context, deviceID string) error
+	UpdateLastActive(ctx context.Context, deviceID string) error
 }
 
 type deviceRepository struct {
@@ -30,11 +30,11 @@ func NewDeviceRepository(client *kivik.Client, dbName string) DeviceRepository {
 	}
 }
 
-func (r *deviceRepository) Create(device *domain.Device) error {
+func (r *deviceRepository) Create(ctx context.Context, device *domain.Device) error {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("device:%s", device.ID)
-	_, err := db.Put(context.Background(), docID, device)
+	_, err := db.Put(ctx, docID, device)
 	if err != nil {
 		return fmt.Errorf("failed to create device: %w", err)
 	}
@@ -42,7 +42,7 @@ func (r *deviceRepository) Create(device *domain.Device) error {
 	return nil
 }
 
-func (r *deviceRepository) List(userID string) ([]*domain.Device, error) {
+func (r *deviceRepository) List(ctx context.Context, userID string) ([]*domain.Device, error) {
 	db := r.client.DB(r.dbName)
 
 	query := map[string]interface{}{
@@ -54,7 +54,7 @@ func (r *deviceRepository) List(userID string) ([]*domain.Device, error) {
 
 	query["selector"].(map[string]interface{})["os"] = map[string]interface{}{"$exists": true}
 
-	rows := db.Find(context.Background(), query)
+	rows := db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to list devices: %w", err)
 	}
@@ -72,11 +72,11 @@ func (r *deviceRepository) List(userID string) ([]*domain.Device, error) {
 	return devices, nil
 }
 
-func (r *deviceRepository) FindByID(deviceID string) (*domain.Device, error) {
+func (r *deviceRepository) FindByID(ctx context.Context, deviceID string) (*domain.Device, error) {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("device:%s", deviceID)
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 
 	var device domain.Device
 	if err := row.ScanDoc(&device); err != nil {
@@ -86,8 +86,8 @@ func (r *deviceRepository) FindByID(deviceID string) (*domain.Device, error) {
 	return &device, nil
 }
 
-func (r *deviceRepository) Revoke(deviceID string) error {
-	device, err := r.FindByID(deviceID)
+func (r *deviceRepository) Revoke(ctx context.Context, deviceID string) error {
+	device, err := r.FindByID(ctx, deviceID)
 	if err != nil {
 		return err
 	}
@@ -98,14 +98,14 @@ func (r *deviceRepository) Revoke(deviceID string) error {
 	docID := fmt.Sprintf("device:%s", deviceID)
 
 	var rawDoc map[string]interface{}
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 	if err := row.ScanDoc(&rawDoc); err != nil {
 		return err
 	}
 
 	rawDoc["is_revoked"] = true
 
-	_, err = db.Put(context.Background(), docID, rawDoc)
+	_, err = db.Put(ctx, docID, rawDoc)
 	if err != nil {
 		return fmt.Errorf("failed to revoke device: %w", err)
 	}
@@ -113,19 +113,19 @@ func (r *deviceRepository) Revoke(deviceID string) error {
 	return nil
 }
 
-func (r *deviceRepository) UpdateLastActive(deviceID string) error {
+func (r *deviceRepository) UpdateLastActive(ctx context.Context, deviceID string) error {
 	db := r.client.DB(r.dbName)
 	docID := fmt.Sprintf("device:%s", deviceID)
 
 	var rawDoc map[string]interface{}
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 	if err := row.ScanDoc(&rawDoc); err != nil {
 		return err
 	}
 
 	rawDoc["last_active"] = time.Now()
 
-	_, err := db.Put(context.Background(), docID, rawDoc)
+	_, err := db.Put(ctx, docID, rawDoc)
 	if err != nil {
 		return fmt.Errorf("failed to update last active: %w", err)
 	}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("document update conflict")
+)
+
+// wrapError translates CouchDB status codes into the repository sentinels,
+// keeping the original error in the chain.
+func wrapError(err error) error {
+	switch kivik.HTTPStatus(err) {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	default:
+		return err
+	}
+}
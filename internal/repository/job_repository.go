@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"inkdown-sync-server/internal/domain"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+type JobRepository interface {
+	Create(ctx context.Context, job *domain.Job) error
+	Get(ctx context.Context, id string) (*domain.Job, error)
+	Update(ctx context.Context, job *domain.Job) error
+	ListUnfinished(ctx context.Context) ([]*domain.Job, error)
+}
+
+type jobRepository struct {
+	client *kivik.Client
+	dbName string
+}
+
+type jobDoc struct {
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.Job
+}
+
+func NewJobRepository(client *kivik.Client, dbName string) JobRepository {
+	return &jobRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *jobRepository) Create(ctx context.Context, job *domain.Job) error {
+	db := r.client.DB(r.dbName)
+
+	doc := jobDoc{
+		DocType: "job",
+		Job:     *job,
+	}
+
+	if _, err := db.Put(ctx, job.ID, doc); err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+
+	return nil
+}
+
+func (r *jobRepository) Get(ctx context.Context, id string) (*domain.Job, error) {
+	db := r.client.DB(r.dbName)
+
+	var doc jobDoc
+	if err := db.Get(ctx, id).ScanDoc(&doc); err != nil {
+		if kivik.HTTPStatus(err) == 404 {
+			return nil, ErrJobNotFound
+		}
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+
+	job := doc.Job
+	return &job, nil
+}
+
+func (r *jobRepository) Update(ctx context.Context, job *domain.Job) error {
+	db := r.client.DB(r.dbName)
+
+	rev, err := db.GetRev(ctx, job.ID)
+	if err != nil {
+		if kivik.HTTPStatus(err) == 404 {
+			return ErrJobNotFound
+		}
+		return fmt.Errorf("failed to get job revision: %w", err)
+	}
+
+	doc := jobDoc{
+		Rev:     rev,
+		DocType: "job",
+		Job:     *job,
+	}
+
+	if _, err := db.Put(ctx, job.ID, doc); err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+
+	return nil
+}
+
+func (r *jobRepository) ListUnfinished(ctx context.Context) ([]*domain.Job, error) {
+	db := r.client.DB(r.dbName)
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"doc_type": "job",
+			"status": map[string]interface{}{
+				"$in": []domain.JobStatus{domain.JobStatusPending, domain.JobStatusRunning},
+			},
+		},
+	}
+
+	rows := db.Find(ctx, query)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*domain.Job
+	for rows.Next() {
+		var doc jobDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			continue
+		}
+		job := doc.Job
+		jobs = append(jobs, &job)
+	}
+
+	return jobs, nil
+}
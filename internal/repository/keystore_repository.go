@@ -11,8 +11,8 @@ import (
 )
 
 type KeyStoreRepository interface {
-	Save(key *domain.EncryptedMasterKey) error
-	Get(userID string) (*domain.EncryptedMasterKey, error)
+	Save(ctx context.Context, key *domain.EncryptedMasterKey) error
+	Get(ctx context.Context, userID string) (*domain.EncryptedMasterKey, error)
 }
 
 type keyStoreRepository struct {
@@ -27,12 +27,12 @@ func NewKeyStoreRepository(client *kivik.Client, dbName string) KeyStoreReposito
 	}
 }
 
-func (r *keyStoreRepository) Save(key *domain.EncryptedMasterKey) error {
+func (r *keyStoreRepository) Save(ctx context.Context, key *domain.EncryptedMasterKey) error {
 	db := r.client.DB(r.dbName)
 	docID := fmt.Sprintf("key_store:%s", key.UserID)
 
 	var rawDoc map[string]interface{}
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 
 	if err := row.ScanDoc(&rawDoc); err == nil {
 		rawDoc["encrypted_key"] = key.EncryptedKey
@@ -41,12 +41,12 @@ func (r *keyStoreRepository) Save(key *domain.EncryptedMasterKey) error {
 		rawDoc["encryption_algo"] = key.EncryptionAlgo
 		rawDoc["updated_at"] = time.Now()
 
-		_, err := db.Put(context.Background(), docID, rawDoc)
+		_, err := db.Put(ctx, docID, rawDoc)
 		if err != nil {
 			return fmt.Errorf("failed to update key store: %w", err)
 		}
 	} else {
-		_, err := db.Put(context.Background(), docID, key)
+		_, err := db.Put(ctx, docID, key)
 		if err != nil {
 			return fmt.Errorf("failed to create key store: %w", err)
 		}
@@ -55,11 +55,11 @@ func (r *keyStoreRepository) Save(key *domain.EncryptedMasterKey) error {
 	return nil
 }
 
-func (r *keyStoreRepository) Get(userID string) (*domain.EncryptedMasterKey, error) {
+func (r *keyStoreRepository) Get(ctx context.Context, userID string) (*domain.EncryptedMasterKey, error) {
 	db := r.client.DB(r.dbName)
 	docID := fmt.Sprintf("key_store:%s", userID)
 
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 
 	var key domain.EncryptedMasterKey
 	if err := row.ScanDoc(&key); err != nil {
@@ -11,12 +11,26 @@ import (
 )
 
 type NoteRepository interface {
-	Create(note *domain.Note) error
-	FindByID(id string) (*domain.Note, error)
-	List(userID string) ([]*domain.Note, error)
-	ListByWorkspace(workspaceID string) ([]*domain.Note, error)
-	Update(note *domain.Note) error
-	Delete(id string) error
+	Create(ctx context.Context, note *domain.Note) error
+	FindByID(ctx context.Context, id string) (*domain.Note, error)
+	List(ctx context.Context, userID string) ([]*domain.Note, error)
+	ListByWorkspace(ctx context.Context, workspaceID string) ([]*domain.Note, error)
+	ListChildren(ctx context.Context, parentID string) ([]*domain.Note, error)
+	Update(ctx context.Context, note *domain.Note) error
+	Delete(ctx context.Context, id string) error
+	ListDeleted(ctx context.Context, userID string) ([]*domain.Note, error)
+	// ListDeletedBefore lists the notes trashed before cutoff. Notes trashed
+	// before deleted_at was recorded go by updated_at.
+	ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]*domain.Note, error)
+	Restore(ctx context.Context, id string) error
+	Purge(ctx context.Context, id string) error
+	WorkspaceStats(ctx context.Context, userID string) (map[string]*domain.WorkspaceStats, error)
+}
+
+// noteDoc reads a note together with its CouchDB revision
+type noteDoc struct {
+	Rev string `json:"_rev"`
+	domain.Note
 }
 
 type noteRepository struct {
@@ -31,33 +45,36 @@ func NewNoteRepository(client *kivik.Client, dbName string) NoteRepository {
 	}
 }
 
-func (r *noteRepository) Create(note *domain.Note) error {
+func (r *noteRepository) Create(ctx context.Context, note *domain.Note) error {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("note:%s", note.ID)
-	_, err := db.Put(context.Background(), docID, note)
+	rev, err := db.Put(ctx, docID, note)
 	if err != nil {
-		return fmt.Errorf("failed to create note: %w", err)
+		return fmt.Errorf("failed to create note: %w", wrapError(err))
 	}
+	note.Rev = rev
 
 	return nil
 }
 
-func (r *noteRepository) FindByID(id string) (*domain.Note, error) {
+func (r *noteRepository) FindByID(ctx context.Context, id string) (*domain.Note, error) {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("note:%s", id)
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 
-	var note domain.Note
-	if err := row.ScanDoc(&note); err != nil {
-		return nil, fmt.Errorf("failed to find note: %w", err)
+	var doc noteDoc
+	if err := row.ScanDoc(&doc); err != nil {
+		return nil, fmt.Errorf("failed to find note: %w", wrapError(err))
 	}
 
+	note := doc.Note
+	note.Rev = doc.Rev
 	return &note, nil
 }
 
-func (r *noteRepository) List(userID string) ([]*domain.Note, error) {
+func (r *noteRepository) List(ctx context.Context, userID string) ([]*domain.Note, error) {
 	db := r.client.DB(r.dbName)
 
 	query := map[string]interface{}{
@@ -67,7 +84,7 @@ func (r *noteRepository) List(userID string) ([]*domain.Note, error) {
 		},
 	}
 
-	rows := db.Find(context.Background(), query)
+	rows := db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to list notes: %w", err)
 	}
@@ -81,11 +98,14 @@ func (r *noteRepository) List(userID string) ([]*domain.Note, error) {
 		}
 		notes = append(notes, &note)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list notes: %w", err)
+	}
 
 	return notes, nil
 }
 
-func (r *noteRepository) ListByWorkspace(workspaceID string) ([]*domain.Note, error) {
+func (r *noteRepository) ListByWorkspace(ctx context.Context, workspaceID string) ([]*domain.Note, error) {
 	db := r.client.DB(r.dbName)
 
 	query := map[string]interface{}{
@@ -95,7 +115,7 @@ func (r *noteRepository) ListByWorkspace(workspaceID string) ([]*domain.Note, er
 		},
 	}
 
-	rows := db.Find(context.Background(), query)
+	rows := db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to list notes by workspace: %w", err)
 	}
@@ -109,29 +129,86 @@ func (r *noteRepository) ListByWorkspace(workspaceID string) ([]*domain.Note, er
 		}
 		notes = append(notes, &note)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list notes by workspace: %w", err)
+	}
 
 	return notes, nil
 }
 
-func (r *noteRepository) Update(note *domain.Note) error {
+func (r *noteRepository) ListChildren(ctx context.Context, parentID string) ([]*domain.Note, error) {
+	db := r.client.DB(r.dbName)
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"parent_id":       parentID,
+			"encrypted_title": map[string]interface{}{"$exists": true},
+		},
+	}
+
+	rows := db.Find(ctx, query)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list child notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []*domain.Note
+	for rows.Next() {
+		var note domain.Note
+		if err := rows.ScanDoc(&note); err != nil {
+			continue
+		}
+		notes = append(notes, &note)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list child notes: %w", err)
+	}
+
+	return notes, nil
+}
+
+// Update stores the mutable fields of note. If note.Rev is set the write is
+// rejected with ErrConflict when the stored note has moved past it; CouchDB
+// also rejects the Put itself if another write lands in between.
+func (r *noteRepository) Update(ctx context.Context, note *domain.Note) error {
 	db := r.client.DB(r.dbName)
 	docID := fmt.Sprintf("note:%s", note.ID)
 
 	var existingDoc map[string]interface{}
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 	if err := row.ScanDoc(&existingDoc); err != nil {
-		return fmt.Errorf("failed to fetch existing note for update: %w", err)
+		return fmt.Errorf("failed to fetch existing note for update: %w", wrapError(err))
+	}
+
+	if note.Rev != "" && existingDoc["_rev"] != note.Rev {
+		return fmt.Errorf("failed to update note: %w", ErrConflict)
 	}
 
+	existingDoc["user_id"] = note.UserID
+	existingDoc["workspace_id"] = note.WorkspaceID
 	existingDoc["encrypted_title"] = note.EncryptedTitle
 	existingDoc["encrypted_content"] = note.EncryptedContent
+	if len(note.Chunks) > 0 {
+		existingDoc["chunks"] = note.Chunks
+		existingDoc["content_size"] = note.ContentSize
+	} else {
+		delete(existingDoc, "chunks")
+		delete(existingDoc, "content_size")
+	}
 	existingDoc["encryption_algo"] = note.EncryptionAlgo
 	existingDoc["nonce"] = note.Nonce
 	existingDoc["content_hash"] = note.ContentHash
 	existingDoc["last_edit_device"] = note.LastEditDevice
+	existingDoc["edited_at"] = note.EditedAt
+	if note.HLC.IsZero() {
+		delete(existingDoc, "hlc")
+	} else {
+		existingDoc["hlc"] = note.HLC
+	}
 	existingDoc["updated_at"] = time.Now()
 	existingDoc["version"] = note.Version // Service should increment this
 	existingDoc["is_deleted"] = note.IsDeleted
+	existingDoc["deleted_at"] = note.DeletedAt
 
 	if note.ParentID != nil {
 		existingDoc["parent_id"] = *note.ParentID
@@ -139,35 +216,194 @@ func (r *noteRepository) Update(note *domain.Note) error {
 		existingDoc["parent_id"] = nil
 	}
 
-	_, err := db.Put(context.Background(), docID, existingDoc)
+	rev, err := db.Put(ctx, docID, existingDoc)
 	if err != nil {
-		return fmt.Errorf("failed to update note: %w", err)
+		return fmt.Errorf("failed to update note: %w", wrapError(err))
 	}
+	note.Rev = rev
 
 	return nil
 }
 
-func (r *noteRepository) Delete(id string) error {
+func (r *noteRepository) Delete(ctx context.Context, id string) error {
 	db := r.client.DB(r.dbName)
 	docID := fmt.Sprintf("note:%s", id)
 
 	var existingDoc map[string]interface{}
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 	if err := row.ScanDoc(&existingDoc); err != nil {
 		return err
 	}
 
 	existingDoc["is_deleted"] = true
+	existingDoc["deleted_at"] = time.Now().UTC()
 	existingDoc["updated_at"] = time.Now()
 
 	if v, ok := existingDoc["version"].(float64); ok {
 		existingDoc["version"] = int64(v) + 1
 	}
 
-	_, err := db.Put(context.Background(), docID, existingDoc)
+	_, err := db.Put(ctx, docID, existingDoc)
 	if err != nil {
-		return fmt.Errorf("failed to delete note: %w", err)
+		return fmt.Errorf("failed to delete note: %w", wrapError(err))
 	}
 
 	return nil
 }
+
+func (r *noteRepository) ListDeleted(ctx context.Context, userID string) ([]*domain.Note, error) {
+	db := r.client.DB(r.dbName)
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"user_id":         userID,
+			"is_deleted":      true,
+			"encrypted_title": map[string]interface{}{"$exists": true},
+		},
+	}
+
+	rows := db.Find(ctx, query)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list deleted notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []*domain.Note
+	for rows.Next() {
+		var note domain.Note
+		if err := rows.ScanDoc(&note); err != nil {
+			continue
+		}
+		notes = append(notes, &note)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list deleted notes: %w", err)
+	}
+
+	return notes, nil
+}
+
+func (r *noteRepository) ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]*domain.Note, error) {
+	db := r.client.DB(r.dbName)
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"is_deleted":      true,
+			"encrypted_title": map[string]interface{}{"$exists": true},
+			"$or": []interface{}{
+				map[string]interface{}{"deleted_at": map[string]interface{}{"$lt": cutoff.UTC().Format(time.RFC3339Nano)}},
+				map[string]interface{}{
+					"deleted_at": map[string]interface{}{"$exists": false},
+					"updated_at": map[string]interface{}{"$lt": cutoff.UTC().Format(time.RFC3339Nano)},
+				},
+			},
+		},
+	}
+
+	rows := db.Find(ctx, query)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list expired notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []*domain.Note
+	for rows.Next() {
+		var note domain.Note
+		if err := rows.ScanDoc(&note); err != nil {
+			continue
+		}
+		notes = append(notes, &note)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list expired notes: %w", err)
+	}
+
+	return notes, nil
+}
+
+func (r *noteRepository) Restore(ctx context.Context, id string) error {
+	db := r.client.DB(r.dbName)
+	docID := fmt.Sprintf("note:%s", id)
+
+	var existingDoc map[string]interface{}
+	row := db.Get(ctx, docID)
+	if err := row.ScanDoc(&existingDoc); err != nil {
+		return err
+	}
+
+	existingDoc["is_deleted"] = false
+	existingDoc["deleted_at"] = nil
+	existingDoc["updated_at"] = time.Now()
+
+	if v, ok := existingDoc["version"].(float64); ok {
+		existingDoc["version"] = int64(v) + 1
+	}
+
+	_, err := db.Put(ctx, docID, existingDoc)
+	if err != nil {
+		return fmt.Errorf("failed to restore note: %w", wrapError(err))
+	}
+
+	return nil
+}
+
+func (r *noteRepository) Purge(ctx context.Context, id string) error {
+	db := r.client.DB(r.dbName)
+	docID := fmt.Sprintf("note:%s", id)
+
+	rev, err := db.GetRev(ctx, docID)
+	if err != nil {
+		return fmt.Errorf("failed to find note for purge: %w", err)
+	}
+
+	if _, err := db.Delete(ctx, docID, rev); err != nil {
+		return fmt.Errorf("failed to purge note: %w", err)
+	}
+
+	return nil
+}
+
+// WorkspaceStats returns the stats of every workspace holding live notes of
+// the user, keyed by workspace ID. It reads the reduced stats_by_workspace
+// view instead of the notes themselves.
+func (r *noteRepository) WorkspaceStats(ctx context.Context, userID string) (map[string]*domain.WorkspaceStats, error) {
+	db := r.client.DB(r.dbName)
+
+	rows := db.Query(ctx, "_design/notes", "_view/stats_by_workspace", kivik.Params(map[string]interface{}{
+		"startkey":    []interface{}{userID},
+		"endkey":      []interface{}{userID, map[string]interface{}{}},
+		"group_level": 2,
+	}))
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query workspace stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]*domain.WorkspaceStats)
+	for rows.Next() {
+		var key []string
+		if err := rows.ScanKey(&key); err != nil || len(key) != 2 {
+			continue
+		}
+
+		var value struct {
+			Count     int        `json:"count"`
+			Size      int64      `json:"size"`
+			UpdatedAt *time.Time `json:"updated_at"`
+		}
+		if err := rows.ScanValue(&value); err != nil {
+			continue
+		}
+
+		stats[key[1]] = &domain.WorkspaceStats{
+			NoteCount:      value.Count,
+			TotalBytes:     value.Size,
+			LastModifiedAt: value.UpdatedAt,
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query workspace stats: %w", err)
+	}
+
+	return stats, nil
+}
@@ -1,135 +1,164 @@
 package repository
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
+
+	"github.com/go-kivik/kivik/v4"
 )
 
 type NoteVersionRepository interface {
-	SaveVersion(note *domain.Note) error
-	GetVersions(noteID string, limit int) ([]*domain.NoteVersion, error)
-	GetVersion(noteID string, version int64) (*domain.NoteVersion, error)
-	DeleteOldVersions(noteID string, keepLast int) error
+	SaveVersion(ctx context.Context, note *domain.Note) error
+	GetVersions(ctx context.Context, noteID string, limit int) ([]*domain.NoteVersion, error)
+	GetVersion(ctx context.Context, noteID string, version int64) (*domain.NoteVersion, error)
+	DeleteOldVersions(ctx context.Context, noteID string, keepLast int) error
+	DeleteAll(ctx context.Context, noteID string) error
 }
 
-type noteVersionRepo struct {
-	baseURL string
-	client  *http.Client
+type noteVersionRepository struct {
+	client *kivik.Client
+	dbName string
 }
 
-func NewNoteVersionRepository(baseURL string) NoteVersionRepository {
-	return &noteVersionRepo{
-		baseURL: baseURL,
-		client:  &http.Client{Timeout: 10 * time.Second},
-	}
+type versionDoc struct {
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.NoteVersion
 }
 
-func (r *noteVersionRepo) SaveVersion(note *domain.Note) error {
-	version := &domain.NoteVersion{
-		ID:               fmt.Sprintf("version:%s:%d", note.ID, note.Version),
-		NoteID:           note.ID,
-		Version:          note.Version,
-		EncryptedContent: note.EncryptedContent,
-		EncryptedTitle:   note.EncryptedTitle,
-		ContentHash:      note.ContentHash,
-		DeviceID:         note.LastEditDevice,
-		CreatedAt:        time.Now(),
+func NewNoteVersionRepository(client *kivik.Client, dbName string) NoteVersionRepository {
+	return &noteVersionRepository{
+		client: client,
+		dbName: dbName,
 	}
+}
 
-	data, err := json.Marshal(version)
-	if err != nil {
-		return err
+func (r *noteVersionRepository) SaveVersion(ctx context.Context, note *domain.Note) error {
+	db := r.client.DB(r.dbName)
+
+	doc := versionDoc{
+		DocType: "version",
+		NoteVersion: domain.NoteVersion{
+			ID:               versionDocID(note.ID, note.Version),
+			NoteID:           note.ID,
+			Version:          note.Version,
+			EncryptedContent: note.EncryptedContent,
+			Chunks:           note.Chunks,
+			ContentSize:      note.ContentSize,
+			EncryptedTitle:   note.EncryptedTitle,
+			ContentHash:      note.ContentHash,
+			DeviceID:         note.LastEditDevice,
+			CreatedAt:        time.Now(),
+			EditedAt:         note.EditedAt,
+			HLC:              note.HLC,
+		},
 	}
 
-	resp, err := r.client.Post(r.baseURL, "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return err
+	rev, err := db.GetRev(ctx, doc.ID)
+	if err != nil && kivik.HTTPStatus(err) != 404 {
+		return fmt.Errorf("failed to get version revision: %w", err)
 	}
-	defer resp.Body.Close()
+	doc.Rev = rev
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to save version: status %d", resp.StatusCode)
+	if _, err := db.Put(ctx, doc.ID, doc); err != nil {
+		return fmt.Errorf("failed to save version: %w", wrapError(err))
 	}
 
 	return nil
 }
 
-func (r *noteVersionRepo) GetVersions(noteID string, limit int) ([]*domain.NoteVersion, error) {
-	viewURL := fmt.Sprintf("%s/_design/versions/_view/by_note?key=\"%s\"&limit=%d&descending=true",
-		r.baseURL, noteID, limit)
-
-	resp, err := r.client.Get(viewURL)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		Rows []struct {
-			Value domain.NoteVersion `json:"value"`
-		} `json:"rows"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+// GetVersions returns up to limit versions of a note, newest first
+func (r *noteVersionRepository) GetVersions(ctx context.Context, noteID string, limit int) ([]*domain.NoteVersion, error) {
+	rows := r.queryByNote(ctx, noteID, map[string]interface{}{
+		"include_docs": true,
+		"limit":        limit,
+	})
+	defer rows.Close()
+
+	var versions []*domain.NoteVersion
+	for rows.Next() {
+		var doc versionDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		version := doc.NoteVersion
+		versions = append(versions, &version)
 	}
-
-	versions := make([]*domain.NoteVersion, len(result.Rows))
-	for i, row := range result.Rows {
-		v := row.Value
-		versions[i] = &v
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get versions: %w", err)
 	}
 
 	return versions, nil
 }
 
-func (r *noteVersionRepo) GetVersion(noteID string, version int64) (*domain.NoteVersion, error) {
-	docID := fmt.Sprintf("version:%s:%d", noteID, version)
-	url := fmt.Sprintf("%s/%s", r.baseURL, docID)
+func (r *noteVersionRepository) GetVersion(ctx context.Context, noteID string, version int64) (*domain.NoteVersion, error) {
+	db := r.client.DB(r.dbName)
 
-	resp, err := r.client.Get(url)
-	if err != nil {
-		return nil, err
+	var doc versionDoc
+	if err := db.Get(ctx, versionDocID(noteID, version)).ScanDoc(&doc); err != nil {
+		return nil, fmt.Errorf("failed to get version: %w", wrapError(err))
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("version not found")
-	}
+	v := doc.NoteVersion
+	return &v, nil
+}
 
-	var noteVersion domain.NoteVersion
-	if err := json.NewDecoder(resp.Body).Decode(&noteVersion); err != nil {
-		return nil, err
-	}
+// DeleteOldVersions keeps the keepLast newest versions of a note
+func (r *noteVersionRepository) DeleteOldVersions(ctx context.Context, noteID string, keepLast int) error {
+	return r.deleteVersions(ctx, noteID, keepLast)
+}
 
-	return &noteVersion, nil
+func (r *noteVersionRepository) DeleteAll(ctx context.Context, noteID string) error {
+	return r.deleteVersions(ctx, noteID, 0)
 }
 
-func (r *noteVersionRepo) DeleteOldVersions(noteID string, keepLast int) error {
-	versions, err := r.GetVersions(noteID, 100)
-	if err != nil {
-		return err
-	}
+// deleteVersions deletes the versions of a note after the skip newest ones
+func (r *noteVersionRepository) deleteVersions(ctx context.Context, noteID string, skip int) error {
+	db := r.client.DB(r.dbName)
 
-	if len(versions) <= keepLast {
-		return nil
+	rows := r.queryByNote(ctx, noteID, map[string]interface{}{
+		"include_docs": true,
+		"skip":         skip,
+	})
+	defer rows.Close()
+
+	type docRef struct {
+		ID  string `json:"_id"`
+		Rev string `json:"_rev"`
 	}
 
-	toDelete := versions[keepLast:]
-	for _, v := range toDelete {
-		url := fmt.Sprintf("%s/%s", r.baseURL, v.ID)
-		req, err := http.NewRequest(http.MethodDelete, url, nil)
-		if err != nil {
-			continue
+	var refs []docRef
+	for rows.Next() {
+		var ref docRef
+		if err := rows.ScanDoc(&ref); err != nil {
+			return fmt.Errorf("failed to scan version: %w", err)
 		}
+		refs = append(refs, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list versions: %w", err)
+	}
 
-		r.client.Do(req)
+	for _, ref := range refs {
+		if _, err := db.Delete(ctx, ref.ID, ref.Rev); err != nil && kivik.HTTPStatus(err) != 404 {
+			return fmt.Errorf("failed to delete version: %w", wrapError(err))
+		}
 	}
 
 	return nil
 }
+
+func (r *noteVersionRepository) queryByNote(ctx context.Context, noteID string, params map[string]interface{}) *kivik.ResultSet {
+	params["startkey"] = []interface{}{noteID, map[string]interface{}{}}
+	params["endkey"] = []interface{}{noteID}
+	params["descending"] = true
+
+	return r.client.DB(r.dbName).Query(ctx, "_design/versions", "_view/by_note_version", kivik.Params(params))
+}
+
+func versionDocID(noteID string, version int64) string {
+	return fmt.Sprintf("version:%s:%d", noteID, version)
+}
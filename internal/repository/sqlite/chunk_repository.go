@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type chunkRepository struct {
+	db *sql.DB
+}
+
+func NewChunkRepository(db *sql.DB) repository.ChunkRepository {
+	return &chunkRepository{db: db}
+}
+
+// The hashes are passed as one JSON array and expanded with json_each
+const chunkHashesFilter = "note_id = ? AND hash IN (SELECT value FROM json_each(?))"
+
+func (r *chunkRepository) Save(ctx context.Context, chunks []*domain.Chunk) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save chunks: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, chunk := range chunks {
+		data, err := encode(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO chunks (note_id, hash, size, data) VALUES (?, ?, ?, ?)
+			ON CONFLICT (note_id, hash) DO NOTHING`,
+			chunk.NoteID, chunk.Hash, chunk.Size, data); err != nil {
+			return fmt.Errorf("failed to save chunk: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *chunkRepository) Get(ctx context.Context, noteID string, hashes []string) ([]*domain.Chunk, error) {
+	keys, err := encode(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := queryAll[domain.Chunk](ctx, r.db, "SELECT data FROM chunks WHERE "+chunkHashesFilter, noteID, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chunks: %w", err)
+	}
+	return chunks, nil
+}
+
+func (r *chunkRepository) Sizes(ctx context.Context, noteID string, hashes []string) (map[string]int64, error) {
+	keys, err := encode(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT hash, size FROM chunks WHERE "+chunkHashesFilter, noteID, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chunk sizes: %w", err)
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64, len(hashes))
+	for rows.Next() {
+		var hash string
+		var size int64
+		if err := rows.Scan(&hash, &size); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk size: %w", err)
+		}
+		sizes[hash] = size
+	}
+
+	return sizes, rows.Err()
+}
+
+func (r *chunkRepository) TotalSize(ctx context.Context, noteID string) (int64, error) {
+	var total int64
+	if err := r.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size), 0) FROM chunks WHERE note_id = ?", noteID).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to get chunk sizes: %w", err)
+	}
+	return total, nil
+}
+
+func (r *chunkRepository) DeleteAll(ctx context.Context, noteID string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM chunks WHERE note_id = ?", noteID); err != nil {
+		return fmt.Errorf("failed to delete chunks: %w", err)
+	}
+	return nil
+}
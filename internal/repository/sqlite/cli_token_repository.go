@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type cliTokenRepository struct {
+	db *sql.DB
+}
+
+func NewCLITokenRepository(db *sql.DB) repository.CLITokenRepository {
+	return &cliTokenRepository{db: db}
+}
+
+func (r *cliTokenRepository) Create(ctx context.Context, token *domain.CLIToken) error {
+	data, err := encode(token)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO cli_tokens (id, user_id, token, is_revoked, created_at, data)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		token.ID, token.UserID, token.Token, boolToInt(token.IsRevoked), formatTime(token.CreatedAt), data); err != nil {
+		return fmt.Errorf("failed to create CLI token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *cliTokenRepository) FindByID(ctx context.Context, id string) (*domain.CLIToken, error) {
+	token, err := queryOne[domain.CLIToken](ctx, r.db, "SELECT data FROM cli_tokens WHERE id = ?", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("CLI token not found")
+		}
+		return nil, fmt.Errorf("CLI token not found: %w", err)
+	}
+	return token, nil
+}
+
+func (r *cliTokenRepository) FindByToken(ctx context.Context, hashedToken string) (*domain.CLIToken, error) {
+	token, err := queryOne[domain.CLIToken](ctx, r.db,
+		"SELECT data FROM cli_tokens WHERE token = ? AND is_revoked = 0 LIMIT 1", hashedToken)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("CLI token not found or revoked")
+		}
+		return nil, fmt.Errorf("failed to query CLI token: %w", err)
+	}
+	return token, nil
+}
+
+func (r *cliTokenRepository) FindByUserID(ctx context.Context, userID string) ([]*domain.CLIToken, error) {
+	tokens, err := queryAll[domain.CLIToken](ctx, r.db,
+		"SELECT data FROM cli_tokens WHERE user_id = ? ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query CLI tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (r *cliTokenRepository) UpdateLastUsed(ctx context.Context, id string, ip string) error {
+	token, err := r.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	token.LastUsedAt = &now
+	token.LastUsedIP = ip
+
+	if err := r.save(ctx, token); err != nil {
+		return fmt.Errorf("failed to update CLI token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *cliTokenRepository) Revoke(ctx context.Context, id string) error {
+	token, err := r.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	token.IsRevoked = true
+	token.RevokedAt = &now
+
+	if err := r.save(ctx, token); err != nil {
+		return fmt.Errorf("failed to revoke CLI token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *cliTokenRepository) Delete(ctx context.Context, id string) error {
+	changed, err := exec(ctx, r.db, "DELETE FROM cli_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete CLI token: %w", err)
+	}
+	if !changed {
+		return fmt.Errorf("CLI token not found")
+	}
+
+	return nil
+}
+
+func (r *cliTokenRepository) save(ctx context.Context, token *domain.CLIToken) error {
+	data, err := encode(token)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, "UPDATE cli_tokens SET is_revoked = ?, data = ? WHERE id = ?",
+		boolToInt(token.IsRevoked), data, token.ID)
+	return err
+}
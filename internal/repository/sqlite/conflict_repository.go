@@ -0,0 +1,195 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type conflictRepository struct {
+	db *sql.DB
+}
+
+func NewConflictRepository(db *sql.DB) repository.ConflictRepository {
+	return &conflictRepository{db: db}
+}
+
+func (r *conflictRepository) Create(ctx context.Context, conflict *domain.Conflict) error {
+	data, err := encode(conflict)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO conflicts (id, user_id, note_id, workspace_id, device_id, detected_at, is_resolved, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		conflict.ID, conflict.UserID, conflict.NoteID, conflict.NoteWorkspaceID(), conflict.DeviceID,
+		formatTime(conflict.DetectedAt), boolToInt(conflict.ResolvedAt != nil), data); err != nil {
+		return fmt.Errorf("failed to create conflict: %w", err)
+	}
+
+	return nil
+}
+
+func (r *conflictRepository) Get(ctx context.Context, conflictID string) (*domain.Conflict, error) {
+	conflict, err := queryOne[domain.Conflict](ctx, r.db, "SELECT data FROM conflicts WHERE id = ?", conflictID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("conflict not found: %w", repository.ErrNotFound)
+		}
+		return nil, err
+	}
+	return conflict, nil
+}
+
+func (r *conflictRepository) ListByUser(ctx context.Context, userID string) ([]*domain.Conflict, error) {
+	return queryAll[domain.Conflict](ctx, r.db, "SELECT data FROM conflicts WHERE user_id = ?", userID)
+}
+
+func (r *conflictRepository) ListByNote(ctx context.Context, noteID string) ([]*domain.Conflict, error) {
+	return queryAll[domain.Conflict](ctx, r.db, "SELECT data FROM conflicts WHERE note_id = ?", noteID)
+}
+
+func (r *conflictRepository) List(ctx context.Context, userID string, filter domain.ConflictFilter) ([]*domain.Conflict, error) {
+	query := "SELECT data FROM conflicts WHERE user_id = ?"
+	args := []interface{}{userID}
+
+	if filter.UnresolvedOnly {
+		query += " AND is_resolved = 0"
+	}
+	if filter.NoteID != "" {
+		query += " AND note_id = ?"
+		args = append(args, filter.NoteID)
+	}
+	if filter.WorkspaceID != "" {
+		query += " AND workspace_id = ?"
+		args = append(args, filter.WorkspaceID)
+	}
+	if filter.DeviceID != "" {
+		query += " AND device_id = ?"
+		args = append(args, filter.DeviceID)
+	}
+
+	query += " ORDER BY detected_at DESC, id"
+	if filter.Limit > 0 || filter.Offset > 0 {
+		limit := filter.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, filter.Offset)
+	}
+
+	conflicts, err := queryAll[domain.Conflict](ctx, r.db, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conflicts: %w", err)
+	}
+	return conflicts, nil
+}
+
+func (r *conflictRepository) ListUnresolvedBefore(ctx context.Context, cutoff time.Time) ([]*domain.Conflict, error) {
+	conflicts, err := queryAll[domain.Conflict](ctx, r.db,
+		"SELECT data FROM conflicts WHERE is_resolved = 0 AND detected_at < ? ORDER BY detected_at", formatTime(cutoff))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stale conflicts: %w", err)
+	}
+	return conflicts, nil
+}
+
+func (r *conflictRepository) MarkResolved(ctx context.Context, conflictID string, choice domain.ResolutionStrategy) error {
+	conflict, err := r.Get(ctx, conflictID)
+	if err != nil {
+		return err
+	}
+
+	if conflict.ResolvedAt != nil {
+		return fmt.Errorf("conflict already resolved: %w", repository.ErrConflict)
+	}
+
+	now := time.Now()
+	conflict.ResolvedAt = &now
+	conflict.ResolutionChoice = choice
+
+	data, err := encode(conflict)
+	if err != nil {
+		return err
+	}
+
+	// Another resolution may have won since the conflict was read
+	changed, err := exec(ctx, r.db, "UPDATE conflicts SET is_resolved = 1, data = ? WHERE id = ? AND is_resolved = 0", data, conflictID)
+	if err != nil {
+		return fmt.Errorf("failed to mark conflict as resolved: %w", err)
+	}
+	if !changed {
+		return fmt.Errorf("conflict already resolved: %w", repository.ErrConflict)
+	}
+
+	return nil
+}
+
+func (r *conflictRepository) UpdateResolution(ctx context.Context, conflictID string, choice domain.ResolutionStrategy) error {
+	conflict, err := r.Get(ctx, conflictID)
+	if err != nil {
+		return err
+	}
+
+	if choice == "" {
+		conflict.ResolvedAt = nil
+	}
+	conflict.ResolutionChoice = choice
+
+	data, err := encode(conflict)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, "UPDATE conflicts SET is_resolved = ?, data = ? WHERE id = ?",
+		boolToInt(conflict.ResolvedAt != nil), data, conflictID); err != nil {
+		return fmt.Errorf("failed to update conflict resolution: %w", err)
+	}
+
+	return nil
+}
+
+func (r *conflictRepository) Reassign(ctx context.Context, noteID, userID string) error {
+	conflicts, err := r.ListByNote(ctx, noteID)
+	if err != nil {
+		return err
+	}
+
+	for _, conflict := range conflicts {
+		if conflict.UserID == userID {
+			continue
+		}
+
+		conflict.UserID = userID
+		if conflict.ServerNote != nil {
+			conflict.ServerNote.UserID = userID
+		}
+
+		data, err := encode(conflict)
+		if err != nil {
+			return err
+		}
+		if _, err := exec(ctx, r.db, "UPDATE conflicts SET user_id = ?, data = ? WHERE id = ?", userID, data, conflict.ID); err != nil {
+			return fmt.Errorf("failed to reassign conflict: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *conflictRepository) Delete(ctx context.Context, conflictID string) error {
+	changed, err := exec(ctx, r.db, "DELETE FROM conflicts WHERE id = ?", conflictID)
+	if err != nil {
+		return fmt.Errorf("failed to delete conflict: %w", err)
+	}
+	if !changed {
+		return fmt.Errorf("conflict not found: %w", repository.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type crdtRepository struct {
+	db *sql.DB
+}
+
+func NewCRDTRepository(db *sql.DB) repository.CRDTRepository {
+	return &crdtRepository{db: db}
+}
+
+// latestSeqQuery selects the newest sequence number of a note, taking the
+// snapshot into account once its updates were dropped. It takes the note ID
+// twice.
+const latestSeqQuery = `SELECT MAX(
+	COALESCE((SELECT MAX(seq) FROM crdt_updates WHERE note_id = ?), 0),
+	COALESCE((SELECT seq FROM crdt_snapshots WHERE note_id = ?), 0))`
+
+func (r *crdtRepository) AppendUpdate(ctx context.Context, update *domain.CRDTUpdate) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to append crdt update: %w", err)
+	}
+	defer tx.Rollback()
+
+	var latest int64
+	if err := tx.QueryRowContext(ctx, latestSeqQuery, update.NoteID, update.NoteID).Scan(&latest); err != nil {
+		return fmt.Errorf("failed to read crdt sequence: %w", err)
+	}
+
+	stored := *update
+	stored.Seq = latest + 1
+	data, err := encode(&stored)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO crdt_updates (note_id, seq, data) VALUES (?, ?, ?)",
+		stored.NoteID, stored.Seq, data); err != nil {
+		return fmt.Errorf("failed to append crdt update: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to append crdt update: %w", err)
+	}
+
+	update.Seq = stored.Seq
+	return nil
+}
+
+func (r *crdtRepository) ListUpdates(ctx context.Context, noteID string, afterSeq int64, limit int) ([]*domain.CRDTUpdate, error) {
+	updates, err := queryAll[domain.CRDTUpdate](ctx, r.db,
+		"SELECT data FROM crdt_updates WHERE note_id = ? AND seq > ? ORDER BY seq LIMIT ?", noteID, afterSeq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list crdt updates: %w", err)
+	}
+	return updates, nil
+}
+
+func (r *crdtRepository) LatestSeq(ctx context.Context, noteID string) (int64, error) {
+	var latest int64
+	if err := r.db.QueryRowContext(ctx, latestSeqQuery, noteID, noteID).Scan(&latest); err != nil {
+		return 0, fmt.Errorf("failed to read crdt sequence: %w", err)
+	}
+	return latest, nil
+}
+
+func (r *crdtRepository) GetSnapshot(ctx context.Context, noteID string) (*domain.CRDTSnapshot, error) {
+	snapshot, err := queryOne[domain.CRDTSnapshot](ctx, r.db, "SELECT data FROM crdt_snapshots WHERE note_id = ?", noteID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get crdt snapshot: %w", repository.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get crdt snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+func (r *crdtRepository) SaveSnapshot(ctx context.Context, snapshot *domain.CRDTSnapshot) error {
+	data, err := encode(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save crdt snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO crdt_snapshots (note_id, seq, data) VALUES (?, ?, ?)
+		ON CONFLICT (note_id) DO UPDATE SET seq = excluded.seq, data = excluded.data`,
+		snapshot.NoteID, snapshot.Seq, data); err != nil {
+		return fmt.Errorf("failed to save crdt snapshot: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM crdt_updates WHERE note_id = ? AND seq <= ?", snapshot.NoteID, snapshot.Seq); err != nil {
+		return fmt.Errorf("failed to compact crdt updates: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *crdtRepository) Reassign(ctx context.Context, noteID, userID string) error {
+	updates, err := queryAll[domain.CRDTUpdate](ctx, r.db, "SELECT data FROM crdt_updates WHERE note_id = ?", noteID)
+	if err != nil {
+		return fmt.Errorf("failed to list crdt updates: %w", err)
+	}
+
+	for _, update := range updates {
+		if update.UserID == userID {
+			continue
+		}
+
+		update.UserID = userID
+		data, err := encode(update)
+		if err != nil {
+			return err
+		}
+		if _, err := r.db.ExecContext(ctx, "UPDATE crdt_updates SET data = ? WHERE note_id = ? AND seq = ?", data, noteID, update.Seq); err != nil {
+			return fmt.Errorf("failed to reassign crdt update: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *crdtRepository) TotalSize(ctx context.Context, noteID string) (int64, error) {
+	var total int64
+	if err := r.db.QueryRowContext(ctx, `SELECT
+		COALESCE((SELECT SUM(LENGTH(CAST(json_extract(data, '$.data') AS BLOB))) FROM crdt_updates WHERE note_id = ?), 0) +
+		COALESCE((SELECT LENGTH(CAST(json_extract(data, '$.data') AS BLOB)) FROM crdt_snapshots WHERE note_id = ?), 0)`,
+		noteID, noteID).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to get crdt sizes: %w", err)
+	}
+	return total, nil
+}
+
+func (r *crdtRepository) DeleteAll(ctx context.Context, noteID string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM crdt_updates WHERE note_id = ?", noteID); err != nil {
+		return fmt.Errorf("failed to delete crdt updates: %w", err)
+	}
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM crdt_snapshots WHERE note_id = ?", noteID); err != nil {
+		return fmt.Errorf("failed to delete crdt snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type deviceRepository struct {
+	db *sql.DB
+}
+
+func NewDeviceRepository(db *sql.DB) repository.DeviceRepository {
+	return &deviceRepository{db: db}
+}
+
+func (r *deviceRepository) Create(ctx context.Context, device *domain.Device) error {
+	data, err := encode(device)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, "INSERT INTO devices (id, user_id, data) VALUES (?, ?, ?)",
+		device.ID, device.UserID, data); err != nil {
+		return fmt.Errorf("failed to create device: %w", err)
+	}
+
+	return nil
+}
+
+func (r *deviceRepository) List(ctx context.Context, userID string) ([]*domain.Device, error) {
+	devices, err := queryAll[domain.Device](ctx, r.db, "SELECT data FROM devices WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	return devices, nil
+}
+
+func (r *deviceRepository) FindByID(ctx context.Context, deviceID string) (*domain.Device, error) {
+	device, err := queryOne[domain.Device](ctx, r.db, "SELECT data FROM devices WHERE id = ?", deviceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to find device: not found")
+		}
+		return nil, fmt.Errorf("failed to find device: %w", err)
+	}
+	return device, nil
+}
+
+func (r *deviceRepository) Revoke(ctx context.Context, deviceID string) error {
+	device, err := r.FindByID(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	device.IsRevoked = true
+	if err := r.save(ctx, device); err != nil {
+		return fmt.Errorf("failed to revoke device: %w", err)
+	}
+
+	return nil
+}
+
+func (r *deviceRepository) UpdateLastActive(ctx context.Context, deviceID string) error {
+	device, err := r.FindByID(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	device.LastActive = time.Now()
+	if err := r.save(ctx, device); err != nil {
+		return fmt.Errorf("failed to update last active: %w", err)
+	}
+
+	return nil
+}
+
+func (r *deviceRepository) save(ctx context.Context, device *domain.Device) error {
+	data, err := encode(device)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, "UPDATE devices SET data = ? WHERE id = ?", data, device.ID)
+	return err
+}
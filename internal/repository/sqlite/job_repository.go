@@ -0,0 +1,69 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type jobRepository struct {
+	db *sql.DB
+}
+
+func NewJobRepository(db *sql.DB) repository.JobRepository {
+	return &jobRepository{db: db}
+}
+
+func (r *jobRepository) Create(ctx context.Context, job *domain.Job) error {
+	data, err := encode(job)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, "INSERT INTO jobs (id, status, data) VALUES (?, ?, ?)", job.ID, job.Status, data); err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+
+	return nil
+}
+
+func (r *jobRepository) Get(ctx context.Context, id string) (*domain.Job, error) {
+	job, err := queryOne[domain.Job](ctx, r.db, "SELECT data FROM jobs WHERE id = ?", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrJobNotFound
+		}
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return job, nil
+}
+
+func (r *jobRepository) Update(ctx context.Context, job *domain.Job) error {
+	data, err := encode(job)
+	if err != nil {
+		return err
+	}
+
+	changed, err := exec(ctx, r.db, "UPDATE jobs SET status = ?, data = ? WHERE id = ?", job.Status, data, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	if !changed {
+		return repository.ErrJobNotFound
+	}
+
+	return nil
+}
+
+func (r *jobRepository) ListUnfinished(ctx context.Context) ([]*domain.Job, error) {
+	jobs, err := queryAll[domain.Job](ctx, r.db, "SELECT data FROM jobs WHERE status IN (?, ?)",
+		domain.JobStatusPending, domain.JobStatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return jobs, nil
+}
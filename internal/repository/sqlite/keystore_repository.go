@@ -0,0 +1,52 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type keyStoreRepository struct {
+	db *sql.DB
+}
+
+func NewKeyStoreRepository(db *sql.DB) repository.KeyStoreRepository {
+	return &keyStoreRepository{db: db}
+}
+
+// Save stores the user's key, keeping the creation time of an existing one
+func (r *keyStoreRepository) Save(ctx context.Context, key *domain.EncryptedMasterKey) error {
+	stored := *key
+	if existing, err := r.Get(ctx, key.UserID); err == nil {
+		stored.CreatedAt = existing.CreatedAt
+		stored.UpdatedAt = time.Now()
+	}
+
+	data, err := encode(&stored)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO key_stores (user_id, data) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET data = excluded.data`, key.UserID, data); err != nil {
+		return fmt.Errorf("failed to save key store: %w", err)
+	}
+
+	return nil
+}
+
+func (r *keyStoreRepository) Get(ctx context.Context, userID string) (*domain.EncryptedMasterKey, error) {
+	key, err := queryOne[domain.EncryptedMasterKey](ctx, r.db, "SELECT data FROM key_stores WHERE user_id = ?", userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get key store: not found")
+		}
+		return nil, fmt.Errorf("failed to get key store: %w", err)
+	}
+	return key, nil
+}
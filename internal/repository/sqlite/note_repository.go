@@ -0,0 +1,246 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type noteRepository struct {
+	db *sql.DB
+}
+
+func NewNoteRepository(db *sql.DB) repository.NoteRepository {
+	return &noteRepository{db: db}
+}
+
+func (r *noteRepository) Create(ctx context.Context, note *domain.Note) error {
+	data, err := encode(note)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `INSERT INTO notes (id, user_id, workspace_id, parent_id, is_deleted, deleted_at, updated_at, size, data, rev)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 1)`,
+		note.ID, note.UserID, note.WorkspaceID, note.ParentID, boolToInt(note.IsDeleted),
+		formatOptionalTime(note.DeletedAt), formatTime(note.UpdatedAt), noteSize(note), data)
+	if err != nil {
+		return fmt.Errorf("failed to create note: %w", err)
+	}
+	note.Rev = "1"
+
+	return nil
+}
+
+func (r *noteRepository) FindByID(ctx context.Context, id string) (*domain.Note, error) {
+	var data string
+	var rev int64
+	err := r.db.QueryRowContext(ctx, "SELECT data, rev FROM notes WHERE id = ?", id).Scan(&data, &rev)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to find note: %w", repository.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to find note: %w", err)
+	}
+
+	var note domain.Note
+	if err := json.Unmarshal([]byte(data), &note); err != nil {
+		return nil, fmt.Errorf("failed to decode note: %w", err)
+	}
+	note.Rev = strconv.FormatInt(rev, 10)
+
+	return &note, nil
+}
+
+func (r *noteRepository) List(ctx context.Context, userID string) ([]*domain.Note, error) {
+	notes, err := queryAll[domain.Note](ctx, r.db, "SELECT data FROM notes WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notes: %w", err)
+	}
+	return notes, nil
+}
+
+func (r *noteRepository) ListByWorkspace(ctx context.Context, workspaceID string) ([]*domain.Note, error) {
+	notes, err := queryAll[domain.Note](ctx, r.db, "SELECT data FROM notes WHERE workspace_id = ?", workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notes by workspace: %w", err)
+	}
+	return notes, nil
+}
+
+func (r *noteRepository) ListChildren(ctx context.Context, parentID string) ([]*domain.Note, error) {
+	notes, err := queryAll[domain.Note](ctx, r.db, "SELECT data FROM notes WHERE parent_id = ?", parentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list child notes: %w", err)
+	}
+	return notes, nil
+}
+
+// Update stores the mutable fields of note; ID, type and creation time are
+// kept from the stored note. If note.Rev is set the write fails with
+// ErrConflict when the stored note has changed since it was read.
+func (r *noteRepository) Update(ctx context.Context, note *domain.Note) error {
+	existing, err := r.FindByID(ctx, note.ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch existing note for update: %w", err)
+	}
+	if note.Rev != "" && note.Rev != existing.Rev {
+		return fmt.Errorf("failed to update note: %w", repository.ErrConflict)
+	}
+
+	existing.UserID = note.UserID
+	existing.WorkspaceID = note.WorkspaceID
+	existing.ParentID = note.ParentID
+	existing.EncryptedTitle = note.EncryptedTitle
+	existing.EncryptedContent = note.EncryptedContent
+	existing.Chunks = note.Chunks
+	existing.ContentSize = note.ContentSize
+	existing.EncryptionAlgo = note.EncryptionAlgo
+	existing.Nonce = note.Nonce
+	existing.ContentHash = note.ContentHash
+	existing.LastEditDevice = note.LastEditDevice
+	existing.EditedAt = note.EditedAt
+	existing.HLC = note.HLC
+	existing.UpdatedAt = time.Now()
+	existing.Version = note.Version
+	existing.IsDeleted = note.IsDeleted
+	existing.DeletedAt = note.DeletedAt
+
+	if err := r.save(ctx, existing); err != nil {
+		return fmt.Errorf("failed to update note: %w", err)
+	}
+	note.Rev = existing.Rev
+
+	return nil
+}
+
+func (r *noteRepository) Delete(ctx context.Context, id string) error {
+	note, err := r.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deletedAt := now.UTC()
+	note.IsDeleted = true
+	note.DeletedAt = &deletedAt
+	note.UpdatedAt = now
+	note.Version++
+
+	if err := r.save(ctx, note); err != nil {
+		return fmt.Errorf("failed to delete note: %w", err)
+	}
+
+	return nil
+}
+
+func (r *noteRepository) ListDeleted(ctx context.Context, userID string) ([]*domain.Note, error) {
+	notes, err := queryAll[domain.Note](ctx, r.db, "SELECT data FROM notes WHERE user_id = ? AND is_deleted = 1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted notes: %w", err)
+	}
+	return notes, nil
+}
+
+func (r *noteRepository) ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]*domain.Note, error) {
+	notes, err := queryAll[domain.Note](ctx, r.db, `SELECT data FROM notes WHERE is_deleted = 1
+		AND (deleted_at < ? OR (deleted_at IS NULL AND updated_at < ?))`, formatTime(cutoff), formatTime(cutoff))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired notes: %w", err)
+	}
+	return notes, nil
+}
+
+func (r *noteRepository) Restore(ctx context.Context, id string) error {
+	note, err := r.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	note.IsDeleted = false
+	note.DeletedAt = nil
+	note.UpdatedAt = time.Now()
+	note.Version++
+
+	if err := r.save(ctx, note); err != nil {
+		return fmt.Errorf("failed to restore note: %w", err)
+	}
+
+	return nil
+}
+
+func (r *noteRepository) Purge(ctx context.Context, id string) error {
+	changed, err := exec(ctx, r.db, "DELETE FROM notes WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to purge note: %w", err)
+	}
+	if !changed {
+		return fmt.Errorf("failed to find note for purge: not found")
+	}
+
+	return nil
+}
+
+func (r *noteRepository) WorkspaceStats(ctx context.Context, userID string) (map[string]*domain.WorkspaceStats, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT workspace_id, COUNT(*), SUM(size), MAX(updated_at) FROM notes
+		WHERE user_id = ? AND is_deleted = 0 AND workspace_id != ''
+		GROUP BY workspace_id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workspace stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]*domain.WorkspaceStats)
+	for rows.Next() {
+		var workspaceID, updatedAt string
+		ws := &domain.WorkspaceStats{}
+		if err := rows.Scan(&workspaceID, &ws.NoteCount, &ws.TotalBytes, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan workspace stats: %w", err)
+		}
+
+		if t, err := time.Parse(timeLayout, updatedAt); err == nil {
+			ws.LastModifiedAt = &t
+		}
+		stats[workspaceID] = ws
+	}
+
+	return stats, rows.Err()
+}
+
+// save writes note back if the stored row is still at note.Rev and advances
+// note.Rev.
+func (r *noteRepository) save(ctx context.Context, note *domain.Note) error {
+	data, err := encode(note)
+	if err != nil {
+		return err
+	}
+	rev, err := strconv.ParseInt(note.Rev, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid note revision %q: %w", note.Rev, err)
+	}
+
+	changed, err := exec(ctx, r.db, `UPDATE notes SET user_id = ?, workspace_id = ?, parent_id = ?, is_deleted = ?,
+		deleted_at = ?, updated_at = ?, size = ?, data = ?, rev = rev + 1 WHERE id = ? AND rev = ?`,
+		note.UserID, note.WorkspaceID, note.ParentID, boolToInt(note.IsDeleted),
+		formatOptionalTime(note.DeletedAt), formatTime(note.UpdatedAt), noteSize(note), data, note.ID, rev)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return repository.ErrConflict
+	}
+
+	note.Rev = strconv.FormatInt(rev+1, 10)
+	return nil
+}
+
+func noteSize(note *domain.Note) int64 {
+	return int64(len(note.EncryptedTitle)+len(note.EncryptedContent)) + note.ContentSize
+}
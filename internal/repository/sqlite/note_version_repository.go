@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type noteVersionRepository struct {
+	db *sql.DB
+}
+
+func NewNoteVersionRepository(db *sql.DB) repository.NoteVersionRepository {
+	return &noteVersionRepository{db: db}
+}
+
+func (r *noteVersionRepository) SaveVersion(ctx context.Context, note *domain.Note) error {
+	version := &domain.NoteVersion{
+		ID:               fmt.Sprintf("version:%s:%d", note.ID, note.Version),
+		NoteID:           note.ID,
+		Version:          note.Version,
+		EncryptedContent: note.EncryptedContent,
+		Chunks:           note.Chunks,
+		ContentSize:      note.ContentSize,
+		EncryptedTitle:   note.EncryptedTitle,
+		ContentHash:      note.ContentHash,
+		DeviceID:         note.LastEditDevice,
+		CreatedAt:        time.Now(),
+		EditedAt:         note.EditedAt,
+		HLC:              note.HLC,
+	}
+
+	data, err := encode(version)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO note_versions (note_id, version, data) VALUES (?, ?, ?)
+		ON CONFLICT (note_id, version) DO UPDATE SET data = excluded.data`,
+		version.NoteID, version.Version, data); err != nil {
+		return fmt.Errorf("failed to save version: %w", err)
+	}
+
+	return nil
+}
+
+func (r *noteVersionRepository) GetVersions(ctx context.Context, noteID string, limit int) ([]*domain.NoteVersion, error) {
+	versions, err := queryAll[domain.NoteVersion](ctx, r.db,
+		"SELECT data FROM note_versions WHERE note_id = ? ORDER BY version DESC LIMIT ?", noteID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get versions: %w", err)
+	}
+	return versions, nil
+}
+
+func (r *noteVersionRepository) GetVersion(ctx context.Context, noteID string, version int64) (*domain.NoteVersion, error) {
+	v, err := queryOne[domain.NoteVersion](ctx, r.db,
+		"SELECT data FROM note_versions WHERE note_id = ? AND version = ?", noteID, version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("version not found: %w", repository.ErrNotFound)
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+func (r *noteVersionRepository) DeleteOldVersions(ctx context.Context, noteID string, keepLast int) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM note_versions WHERE note_id = ? AND version NOT IN (
+		SELECT version FROM note_versions WHERE note_id = ? ORDER BY version DESC LIMIT ?)`,
+		noteID, noteID, keepLast)
+	if err != nil {
+		return fmt.Errorf("failed to delete old versions: %w", err)
+	}
+	return nil
+}
+
+func (r *noteVersionRepository) DeleteAll(ctx context.Context, noteID string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM note_versions WHERE note_id = ?", noteID); err != nil {
+		return fmt.Errorf("failed to delete versions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// Package sqlite implements the repository interfaces on an embedded SQLite
+// database. Each table keeps the columns that are queried and the full
+// domain object as JSON in a data column.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// MemoryDSN opens a private in-memory database that lives as long as the
+// returned *sql.DB.
+const MemoryDSN = ":memory:"
+
+// timeLayout is fixed-width so stored timestamps compare correctly as text
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Open opens the database at dsn and brings its schema up to date
+func Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	// A single connection serializes writes and keeps an in-memory database
+	// alive across queries.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("PRAGMA foreign_keys = ON; PRAGMA journal_mode = WAL;"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to configure sqlite database: %w", err)
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// schema lists the schema migrations in version order. Append only.
+var schema = []string{
+	`CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		email TEXT NOT NULL UNIQUE,
+		username TEXT NOT NULL UNIQUE,
+		data TEXT NOT NULL
+	);
+	CREATE TABLE devices (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX devices_by_user ON devices (user_id);
+	CREATE TABLE key_stores (
+		user_id TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	);
+	CREATE TABLE cli_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		token TEXT NOT NULL,
+		is_revoked INTEGER NOT NULL,
+		created_at TEXT NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX cli_tokens_by_token ON cli_tokens (token);
+	CREATE INDEX cli_tokens_by_user ON cli_tokens (user_id, created_at);
+	CREATE TABLE workspaces (
+		id TEXT PRIMARY KEY,
+		owner_id TEXT NOT NULL,
+		is_default INTEGER NOT NULL,
+		pending_owner_id TEXT NOT NULL,
+		data TEXT NOT NULL,
+		rev INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE INDEX workspaces_by_owner ON workspaces (owner_id);
+	CREATE INDEX workspaces_by_pending_owner ON workspaces (pending_owner_id);
+	CREATE TABLE notes (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		workspace_id TEXT NOT NULL,
+		parent_id TEXT,
+		is_deleted INTEGER NOT NULL,
+		deleted_at TEXT,
+		updated_at TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX notes_by_user ON notes (user_id, workspace_id);
+	CREATE INDEX notes_by_workspace ON notes (workspace_id);
+	CREATE INDEX notes_by_parent ON notes (parent_id);
+	CREATE INDEX notes_deleted ON notes (is_deleted, deleted_at);
+	CREATE TABLE note_versions (
+		note_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (note_id, version)
+	);
+	CREATE TABLE conflicts (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		note_id TEXT NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX conflicts_by_user ON conflicts (user_id);
+	CREATE INDEX conflicts_by_note ON conflicts (note_id);
+	CREATE TABLE sync_metadata (
+		user_id TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (user_id, device_id)
+	);
+	CREATE TABLE tombstones (
+		note_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		purged_at TEXT NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX tombstones_by_user ON tombstones (user_id);
+	CREATE INDEX tombstones_by_purge ON tombstones (purged_at);
+	CREATE TABLE usage (
+		user_id TEXT PRIMARY KEY,
+		data TEXT NOT NULL,
+		rev INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE TABLE jobs (
+		id TEXT PRIMARY KEY,
+		status TEXT NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX jobs_by_status ON jobs (status);`,
+	`ALTER TABLE notes ADD COLUMN rev INTEGER NOT NULL DEFAULT 0;`,
+	// Existing rows keep their JSON detected_at, which sorts the same way
+	// within the second.
+	`ALTER TABLE conflicts ADD COLUMN workspace_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE conflicts ADD COLUMN device_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE conflicts ADD COLUMN detected_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE conflicts ADD COLUMN is_resolved INTEGER NOT NULL DEFAULT 0;
+	UPDATE conflicts SET
+		workspace_id = COALESCE(NULLIF(json_extract(data, '$.workspace_id'), ''), json_extract(data, '$.server_note.workspace_id'), ''),
+		device_id = COALESCE(json_extract(data, '$.device_id'), ''),
+		detected_at = COALESCE(json_extract(data, '$.detected_at'), ''),
+		is_resolved = json_extract(data, '$.resolved_at') IS NOT NULL;
+	CREATE INDEX conflicts_by_user_detected ON conflicts (user_id, detected_at);
+	CREATE INDEX conflicts_unresolved ON conflicts (is_resolved, detected_at);`,
+	`CREATE TABLE crdt_updates (
+		note_id TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (note_id, seq)
+	);
+	CREATE TABLE crdt_snapshots (
+		note_id TEXT PRIMARY KEY,
+		seq INTEGER NOT NULL,
+		data TEXT NOT NULL
+	);`,
+	`CREATE TABLE chunks (
+		note_id TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (note_id, hash)
+	);`,
+}
+
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := current; i < len(schema); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(schema[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("schema migration %d failed: %w", i+1, err)
+		}
+
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)",
+			i+1, formatTime(time.Now())); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record schema migration %d: %w", i+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+func formatOptionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return formatTime(*t)
+}
+
+func encode(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// queryOne decodes the data column of the single row returned by query. It
+// returns sql.ErrNoRows when nothing matches.
+func queryOne[T any](ctx context.Context, db *sql.DB, query string, args ...interface{}) (*T, error) {
+	var data string
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&data); err != nil {
+		return nil, err
+	}
+
+	var v T
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// queryAll decodes the data column of every row returned by query
+func queryAll[T any](ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]*T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*T
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var v T
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			return nil, err
+		}
+		result = append(result, &v)
+	}
+
+	return result, rows.Err()
+}
+
+// exec runs a write and reports whether it changed any row
+func exec(ctx context.Context, db *sql.DB, query string, args ...interface{}) (bool, error) {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
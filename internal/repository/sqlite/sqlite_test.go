@@ -0,0 +1,454 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := Open(MemoryDSN)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestNoteRepository_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	repo := NewNoteRepository(openTestDB(t))
+
+	parentID := "dir1"
+	note := &domain.Note{
+		ID:               "n1",
+		UserID:           "user1",
+		WorkspaceID:      "ws1",
+		ParentID:         &parentID,
+		Type:             domain.NoteTypeFile,
+		EncryptedTitle:   "title",
+		EncryptedContent: "content",
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		Version:          1,
+	}
+	if err := repo.Create(ctx, note); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	note.UserID = "user2"
+	note.EncryptedContent = "changed"
+	note.Version = 2
+	if err := repo.Update(ctx, note); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	stored, err := repo.FindByID(ctx, "n1")
+	if err != nil {
+		t.Fatalf("FindByID failed: %v", err)
+	}
+	if stored.UserID != "user2" || stored.EncryptedContent != "changed" || stored.Version != 2 {
+		t.Errorf("update not persisted: %+v", stored)
+	}
+
+	children, _ := repo.ListChildren(ctx, "dir1")
+	if len(children) != 1 {
+		t.Errorf("expected 1 child, got %d", len(children))
+	}
+
+	if err := repo.Delete(ctx, "n1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	deleted, _ := repo.ListDeleted(ctx, "user2")
+	if len(deleted) != 1 || deleted[0].Version != 3 {
+		t.Fatalf("expected deleted note at version 3, got %+v", deleted)
+	}
+
+	// Trashed before deleted_at was recorded
+	repo.Create(ctx, &domain.Note{ID: "legacy", UserID: "user2", IsDeleted: true, UpdatedAt: time.Now().Add(-time.Hour)})
+
+	expired, _ := repo.ListDeletedBefore(ctx, time.Now().Add(time.Minute))
+	if len(expired) != 2 {
+		t.Errorf("expected 2 expired notes, got %d", len(expired))
+	}
+	if expired, _ := repo.ListDeletedBefore(ctx, time.Now().Add(-2*time.Hour)); len(expired) != 0 {
+		t.Errorf("expected no notes trashed two hours ago, got %d", len(expired))
+	}
+
+	if err := repo.Restore(ctx, "n1"); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if err := repo.Purge(ctx, "n1"); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if _, err := repo.FindByID(ctx, "n1"); err == nil {
+		t.Error("expected purged note to be gone")
+	}
+}
+
+func TestNoteRepository_UpdateStaleRev(t *testing.T) {
+	ctx := context.Background()
+	repo := NewNoteRepository(openTestDB(t))
+
+	if err := repo.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", EncryptedTitle: "title", Version: 1}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	first, _ := repo.FindByID(ctx, "n1")
+	second, _ := repo.FindByID(ctx, "n1")
+
+	first.EncryptedTitle = "first"
+	first.Version = 2
+	if err := repo.Update(ctx, first); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	second.EncryptedTitle = "second"
+	second.Version = 2
+	if err := repo.Update(ctx, second); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	stored, _ := repo.FindByID(ctx, "n1")
+	if stored.EncryptedTitle != "first" || stored.Rev != first.Rev {
+		t.Errorf("expected first write at rev %s, got %+v", first.Rev, stored)
+	}
+}
+
+func TestNoteRepository_WorkspaceStats(t *testing.T) {
+	ctx := context.Background()
+	repo := NewNoteRepository(openTestDB(t))
+
+	latest := time.Now()
+	repo.Create(ctx, &domain.Note{ID: "a", UserID: "user1", WorkspaceID: "ws1", EncryptedTitle: "ab", EncryptedContent: "cd", UpdatedAt: latest.Add(-time.Hour)})
+	repo.Create(ctx, &domain.Note{ID: "b", UserID: "user1", WorkspaceID: "ws1", EncryptedTitle: "e", UpdatedAt: latest})
+	repo.Create(ctx, &domain.Note{ID: "c", UserID: "user1", WorkspaceID: "ws1", EncryptedTitle: "gone", IsDeleted: true, UpdatedAt: latest})
+	repo.Create(ctx, &domain.Note{ID: "d", UserID: "user2", WorkspaceID: "ws2", EncryptedTitle: "other", UpdatedAt: latest})
+
+	stats, err := repo.WorkspaceStats(ctx, "user1")
+	if err != nil {
+		t.Fatalf("WorkspaceStats failed: %v", err)
+	}
+
+	ws := stats["ws1"]
+	if len(stats) != 1 || ws == nil {
+		t.Fatalf("expected stats for ws1 only, got %v", stats)
+	}
+	if ws.NoteCount != 2 || ws.TotalBytes != 5 {
+		t.Errorf("expected 2 notes and 5 bytes, got %d notes and %d bytes", ws.NoteCount, ws.TotalBytes)
+	}
+	if ws.LastModifiedAt == nil || !ws.LastModifiedAt.Equal(latest) {
+		t.Errorf("expected last modified %v, got %v", latest, ws.LastModifiedAt)
+	}
+}
+
+func TestUsageRepository_StaleRev(t *testing.T) {
+	ctx := context.Background()
+	repo := NewUsageRepository(openTestDB(t))
+
+	usage, _ := repo.Get(ctx, "user1")
+	concurrent, _ := repo.Get(ctx, "user1")
+
+	usage.ContentBytes = 10
+	if err := repo.Save(ctx, usage); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Created by the first save, so the second one must re-read
+	concurrent.ContentBytes = 20
+	if err := repo.Save(ctx, concurrent); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("expected ErrConflict creating usage twice, got %v", err)
+	}
+
+	stale, _ := repo.Get(ctx, "user1")
+	usage.ContentBytes = 15
+	if err := repo.Save(ctx, usage); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	stale.ContentBytes = 30
+	if err := repo.Save(ctx, stale); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("expected ErrConflict for a stale revision, got %v", err)
+	}
+
+	stored, _ := repo.Get(ctx, "user1")
+	if stored.ContentBytes != 15 {
+		t.Errorf("expected 15 bytes, got %d", stored.ContentBytes)
+	}
+}
+
+func TestNoteVersionRepository_KeepsNewest(t *testing.T) {
+	ctx := context.Background()
+	repo := NewNoteVersionRepository(openTestDB(t))
+
+	for v := int64(1); v <= 5; v++ {
+		if err := repo.SaveVersion(ctx, &domain.Note{ID: "n1", Version: v}); err != nil {
+			t.Fatalf("SaveVersion failed: %v", err)
+		}
+	}
+
+	if err := repo.DeleteOldVersions(ctx, "n1", 2); err != nil {
+		t.Fatalf("DeleteOldVersions failed: %v", err)
+	}
+
+	versions, _ := repo.GetVersions(ctx, "n1", 10)
+	if len(versions) != 2 || versions[0].Version != 5 || versions[1].Version != 4 {
+		t.Fatalf("expected versions 5 and 4, got %+v", versions)
+	}
+
+	if _, err := repo.GetVersion(ctx, "n1", 1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for deleted version, got %v", err)
+	}
+}
+
+func TestCRDTRepository_Compaction(t *testing.T) {
+	ctx := context.Background()
+	repo := NewCRDTRepository(openTestDB(t))
+
+	for i := 0; i < 3; i++ {
+		update := &domain.CRDTUpdate{NoteID: "n1", DeviceID: "d1", Data: "delta"}
+		if err := repo.AppendUpdate(ctx, update); err != nil {
+			t.Fatalf("AppendUpdate failed: %v", err)
+		}
+		if update.Seq != int64(i+1) {
+			t.Fatalf("expected seq %d, got %d", i+1, update.Seq)
+		}
+	}
+
+	if _, err := repo.GetSnapshot(ctx, "n1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound before the first snapshot, got %v", err)
+	}
+
+	if err := repo.SaveSnapshot(ctx, &domain.CRDTSnapshot{NoteID: "n1", Seq: 3, Data: "state"}); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	if updates, _ := repo.ListUpdates(ctx, "n1", 0, 10); len(updates) != 0 {
+		t.Fatalf("expected compacted updates to be dropped, got %d", len(updates))
+	}
+
+	// Numbering continues after the snapshot
+	update := &domain.CRDTUpdate{NoteID: "n1", DeviceID: "d2", Data: "delta"}
+	repo.AppendUpdate(ctx, update)
+	if update.Seq != 4 {
+		t.Errorf("expected seq 4 after compaction, got %d", update.Seq)
+	}
+
+	updates, _ := repo.ListUpdates(ctx, "n1", 2, 10)
+	if len(updates) != 1 || updates[0].Seq != 4 || updates[0].DeviceID != "d2" {
+		t.Errorf("expected only update 4, got %+v", updates)
+	}
+
+	if size, err := repo.TotalSize(ctx, "n1"); err != nil || size != int64(len("state")+len("delta")) {
+		t.Errorf("expected the snapshot and update 4 to be counted, got %d (%v)", size, err)
+	}
+
+	if err := repo.Reassign(ctx, "n1", "user2"); err != nil {
+		t.Fatalf("Reassign failed: %v", err)
+	}
+	if updates, _ := repo.ListUpdates(ctx, "n1", 0, 10); len(updates) != 1 || updates[0].UserID != "user2" {
+		t.Errorf("expected the update to move to user2, got %+v", updates)
+	}
+}
+
+func TestChunkRepository_SaveAndGet(t *testing.T) {
+	ctx := context.Background()
+	repo := NewChunkRepository(openTestDB(t))
+
+	chunks := []*domain.Chunk{
+		{NoteID: "n1", Hash: "a", Data: "aaaa", Size: 4},
+		{NoteID: "n1", Hash: "b", Data: "bb", Size: 2},
+		{NoteID: "n2", Hash: "a", Data: "aaaa", Size: 4},
+	}
+	if err := repo.Save(ctx, chunks); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	// Saving a chunk again is a no-op
+	if err := repo.Save(ctx, chunks[:1]); err != nil {
+		t.Fatalf("Save of an existing chunk failed: %v", err)
+	}
+
+	sizes, err := repo.Sizes(ctx, "n1", []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("Sizes failed: %v", err)
+	}
+	if len(sizes) != 2 || sizes["a"] != 4 || sizes["b"] != 2 {
+		t.Errorf("unexpected sizes %v", sizes)
+	}
+	if total, err := repo.TotalSize(ctx, "n1"); err != nil || total != 6 {
+		t.Errorf("expected 6 bytes stored for n1, got %d (%v)", total, err)
+	}
+
+	if err := repo.DeleteAll(ctx, "n1"); err != nil {
+		t.Fatalf("DeleteAll failed: %v", err)
+	}
+	if got, _ := repo.Get(ctx, "n1", []string{"a", "b"}); len(got) != 0 {
+		t.Errorf("expected the chunks of n1 to be deleted, got %d", len(got))
+	}
+	if got, _ := repo.Get(ctx, "n2", []string{"a"}); len(got) != 1 || got[0].Data != "aaaa" {
+		t.Errorf("expected the chunk of n2 to be kept, got %+v", got)
+	}
+}
+
+func TestConflictRepository_NotFound(t *testing.T) {
+	ctx := context.Background()
+	repo := NewConflictRepository(openTestDB(t))
+
+	if _, err := repo.Get(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound from Get, got %v", err)
+	}
+	if err := repo.MarkResolved(ctx, "missing", domain.ResolutionServer); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound from MarkResolved, got %v", err)
+	}
+	if err := repo.Delete(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound from Delete, got %v", err)
+	}
+}
+
+func TestConflictRepository_List(t *testing.T) {
+	ctx := context.Background()
+	repo := NewConflictRepository(openTestDB(t))
+
+	now := time.Now()
+	repo.Create(ctx, &domain.Conflict{ID: "old", UserID: "user1", NoteID: "n1", WorkspaceID: "ws1", DeviceID: "d1", DetectedAt: now.Add(-2 * time.Hour)})
+	repo.Create(ctx, &domain.Conflict{ID: "new", UserID: "user1", NoteID: "n2", WorkspaceID: "ws1", DeviceID: "d2", DetectedAt: now})
+	repo.Create(ctx, &domain.Conflict{ID: "legacy", UserID: "user1", NoteID: "n3", ServerNote: &domain.Note{WorkspaceID: "ws2"}, DetectedAt: now.Add(-time.Hour)})
+	repo.MarkResolved(ctx, "new", domain.ResolutionServer)
+	if err := repo.MarkResolved(ctx, "new", domain.ResolutionClient); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict resolving twice, got %v", err)
+	}
+
+	all, err := repo.List(ctx, "user1", domain.ConflictFilter{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(all) != 3 || all[0].ID != "new" || all[2].ID != "old" {
+		t.Fatalf("expected 3 conflicts newest first, got %d", len(all))
+	}
+
+	unresolved, _ := repo.List(ctx, "user1", domain.ConflictFilter{UnresolvedOnly: true, WorkspaceID: "ws1"})
+	if len(unresolved) != 1 || unresolved[0].ID != "old" {
+		t.Errorf("expected only the unresolved ws1 conflict, got %d", len(unresolved))
+	}
+
+	legacy, _ := repo.List(ctx, "user1", domain.ConflictFilter{WorkspaceID: "ws2"})
+	if len(legacy) != 1 || legacy[0].ID != "legacy" {
+		t.Errorf("expected the workspace to come from the server note, got %d", len(legacy))
+	}
+
+	page, _ := repo.List(ctx, "user1", domain.ConflictFilter{Limit: 1, Offset: 1})
+	if len(page) != 1 || page[0].ID != "legacy" {
+		t.Errorf("expected second conflict on its own page, got %d", len(page))
+	}
+
+	stale, err := repo.ListUnresolvedBefore(ctx, now.Add(-30*time.Minute))
+	if err != nil {
+		t.Fatalf("ListUnresolvedBefore failed: %v", err)
+	}
+	if len(stale) != 2 {
+		t.Errorf("expected 2 stale conflicts, got %d", len(stale))
+	}
+
+	if err := repo.UpdateResolution(ctx, "new", ""); err != nil {
+		t.Fatalf("UpdateResolution failed: %v", err)
+	}
+	if reopened, _ := repo.List(ctx, "user1", domain.ConflictFilter{UnresolvedOnly: true, NoteID: "n2"}); len(reopened) != 1 || reopened[0].ResolvedAt != nil {
+		t.Errorf("expected the conflict to be reopened, got %+v", reopened)
+	}
+	repo.MarkResolved(ctx, "new", domain.ResolutionLWW)
+	if err := repo.UpdateResolution(ctx, "new", domain.ResolutionRestore); err != nil {
+		t.Fatalf("UpdateResolution failed: %v", err)
+	}
+	if c, _ := repo.Get(ctx, "new"); c.ResolvedAt == nil || c.ResolutionChoice != domain.ResolutionRestore {
+		t.Errorf("expected the recorded strategy to change, got %+v", c)
+	}
+
+	if err := repo.Reassign(ctx, "n3", "user2"); err != nil {
+		t.Fatalf("Reassign failed: %v", err)
+	}
+	moved, _ := repo.List(ctx, "user2", domain.ConflictFilter{})
+	if len(moved) != 1 || moved[0].ID != "legacy" || moved[0].ServerNote.UserID != "user2" {
+		t.Errorf("expected the legacy conflict to move to user2, got %+v", moved)
+	}
+}
+
+func TestWorkspaceRepository_Errors(t *testing.T) {
+	ctx := context.Background()
+	repo := NewWorkspaceRepository(openTestDB(t))
+
+	ws := &domain.Workspace{ID: "ws1", OwnerID: "user1", IsDefault: true}
+	if err := repo.Create(ctx, ws); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := repo.Create(ctx, ws); !errors.Is(err, repository.ErrWorkspaceExists) {
+		t.Errorf("expected ErrWorkspaceExists, got %v", err)
+	}
+
+	if _, err := repo.Get(ctx, "missing"); !errors.Is(err, repository.ErrWorkspaceNotFound) {
+		t.Errorf("expected ErrWorkspaceNotFound, got %v", err)
+	}
+
+	def, err := repo.GetDefault(ctx, "user1")
+	if err != nil || def.ID != "ws1" {
+		t.Errorf("expected default ws1, got %v, %v", def, err)
+	}
+
+	ws.IsDefault = false
+	ws.PendingOwnerID = "user2"
+	if err := repo.Update(ctx, ws); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := repo.GetDefault(ctx, "user1"); !errors.Is(err, repository.ErrWorkspaceNotFound) {
+		t.Errorf("expected no default workspace, got %v", err)
+	}
+
+	pending, _ := repo.GetPendingTransfers(ctx, "user2")
+	if len(pending) != 1 {
+		t.Errorf("expected 1 pending transfer, got %d", len(pending))
+	}
+
+	stale, _ := repo.Get(ctx, "ws1")
+	ws.PendingOwnerID = ""
+	if err := repo.Update(ctx, ws); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	stale.OwnerID = "user2"
+	if err := repo.Update(ctx, stale); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict for a stale revision, got %v", err)
+	}
+
+	if err := repo.Delete(ctx, "ws1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := repo.Delete(ctx, "ws1"); !errors.Is(err, repository.ErrWorkspaceNotFound) {
+		t.Errorf("expected ErrWorkspaceNotFound, got %v", err)
+	}
+}
+
+func TestUserRepository_Lookup(t *testing.T) {
+	ctx := context.Background()
+	repo := NewUserRepository(openTestDB(t))
+
+	if err := repo.Create(ctx, &domain.User{ID: "user1", Email: "a@example.com", Username: "alice"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if exists, _ := repo.EmailExists(ctx, "a@example.com"); !exists {
+		t.Error("expected email to exist")
+	}
+	if exists, _ := repo.UsernameExists(ctx, "bob"); exists {
+		t.Error("expected username bob to be free")
+	}
+
+	user, err := repo.FindByUsername(ctx, "alice")
+	if err != nil || user.ID != "user1" {
+		t.Errorf("expected user1, got %v, %v", user, err)
+	}
+}
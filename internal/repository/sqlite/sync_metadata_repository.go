@@ -0,0 +1,92 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type syncMetadataRepository struct {
+	db *sql.DB
+}
+
+func NewSyncMetadataRepository(db *sql.DB) repository.SyncMetadataRepository {
+	return &syncMetadataRepository{db: db}
+}
+
+func (r *syncMetadataRepository) Get(ctx context.Context, userID, deviceID string) (*domain.SyncMetadata, error) {
+	metadata, err := queryOne[domain.SyncMetadata](ctx, r.db,
+		"SELECT data FROM sync_metadata WHERE user_id = ? AND device_id = ?", userID, deviceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &domain.SyncMetadata{
+				UserID:           userID,
+				DeviceID:         deviceID,
+				LastSyncTime:     time.Time{},
+				NoteVersions:     make(map[string]int64),
+				PendingConflicts: []string{},
+				UpdatedAt:        time.Now(),
+			}, nil
+		}
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+func (r *syncMetadataRepository) Upsert(ctx context.Context, metadata *domain.SyncMetadata) error {
+	stored := *metadata
+	stored.UpdatedAt = time.Now()
+
+	data, err := encode(&stored)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO sync_metadata (user_id, device_id, data) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET data = excluded.data`,
+		metadata.UserID, metadata.DeviceID, data); err != nil {
+		return fmt.Errorf("failed to upsert sync metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (r *syncMetadataRepository) UpdateLastSync(ctx context.Context, userID, deviceID string, timestamp time.Time) error {
+	metadata, err := r.Get(ctx, userID, deviceID)
+	if err != nil {
+		return err
+	}
+
+	metadata.LastSyncTime = timestamp
+	return r.Upsert(ctx, metadata)
+}
+
+func (r *syncMetadataRepository) UpdateNoteVersion(ctx context.Context, userID, deviceID, noteID string, version int64) error {
+	metadata, err := r.Get(ctx, userID, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if metadata.NoteVersions == nil {
+		metadata.NoteVersions = make(map[string]int64)
+	}
+	metadata.NoteVersions[noteID] = version
+
+	return r.Upsert(ctx, metadata)
+}
+
+func (r *syncMetadataRepository) UpdateWorkspaces(ctx context.Context, userID, deviceID string, workspaceIDs []string) error {
+	metadata, err := r.Get(ctx, userID, deviceID)
+	if err != nil {
+		return err
+	}
+
+	metadata.WorkspaceIDs = workspaceIDs
+	return r.Upsert(ctx, metadata)
+}
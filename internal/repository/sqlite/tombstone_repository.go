@@ -0,0 +1,55 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type tombstoneRepository struct {
+	db *sql.DB
+}
+
+func NewTombstoneRepository(db *sql.DB) repository.TombstoneRepository {
+	return &tombstoneRepository{db: db}
+}
+
+func (r *tombstoneRepository) Create(ctx context.Context, tombstone *domain.Tombstone) error {
+	data, err := encode(tombstone)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO tombstones (note_id, user_id, purged_at, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (note_id) DO UPDATE SET user_id = excluded.user_id, purged_at = excluded.purged_at, data = excluded.data`,
+		tombstone.NoteID, tombstone.UserID, formatTime(tombstone.PurgedAt), data); err != nil {
+		return fmt.Errorf("failed to create tombstone: %w", err)
+	}
+
+	return nil
+}
+
+func (r *tombstoneRepository) ListByUser(ctx context.Context, userID string) ([]*domain.Tombstone, error) {
+	tombstones, err := queryAll[domain.Tombstone](ctx, r.db, "SELECT data FROM tombstones WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tombstones: %w", err)
+	}
+	return tombstones, nil
+}
+
+func (r *tombstoneRepository) DeletePurgedBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM tombstones WHERE purged_at < ?", formatTime(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired tombstones: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
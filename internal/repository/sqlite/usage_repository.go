@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type usageRepository struct {
+	db *sql.DB
+}
+
+func NewUsageRepository(db *sql.DB) repository.UsageRepository {
+	return &usageRepository{db: db}
+}
+
+func (r *usageRepository) Get(ctx context.Context, userID string) (*domain.Usage, error) {
+	var data string
+	var rev int64
+	err := r.db.QueryRowContext(ctx, "SELECT data, rev FROM usage WHERE user_id = ?", userID).Scan(&data, &rev)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &domain.Usage{
+				UserID:     userID,
+				Workspaces: make(map[string]*domain.WorkspaceUsage),
+				UpdatedAt:  time.Now(),
+			}, nil
+		}
+		return nil, fmt.Errorf("failed to get usage: %w", err)
+	}
+
+	var usage domain.Usage
+	if err := json.Unmarshal([]byte(data), &usage); err != nil {
+		return nil, fmt.Errorf("failed to decode usage: %w", err)
+	}
+	usage.Rev = strconv.FormatInt(rev, 10)
+
+	if usage.Workspaces == nil {
+		usage.Workspaces = make(map[string]*domain.WorkspaceUsage)
+	}
+
+	return &usage, nil
+}
+
+// Save stores usage if the stored row is still at usage.Rev and advances
+// usage.Rev. An empty Rev only creates the row.
+func (r *usageRepository) Save(ctx context.Context, usage *domain.Usage) error {
+	data, err := encode(usage)
+	if err != nil {
+		return err
+	}
+
+	var rev int64
+	if usage.Rev != "" {
+		if rev, err = strconv.ParseInt(usage.Rev, 10, 64); err != nil {
+			return fmt.Errorf("invalid usage revision %q: %w", usage.Rev, err)
+		}
+	}
+
+	var changed bool
+	if rev == 0 {
+		changed, err = exec(ctx, r.db, "INSERT INTO usage (user_id, data, rev) VALUES (?, ?, 1) ON CONFLICT (user_id) DO NOTHING",
+			usage.UserID, data)
+	} else {
+		changed, err = exec(ctx, r.db, "UPDATE usage SET data = ?, rev = rev + 1 WHERE user_id = ? AND rev = ?",
+			data, usage.UserID, rev)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save usage: %w", err)
+	}
+	if !changed {
+		return repository.ErrConflict
+	}
+
+	usage.Rev = strconv.FormatInt(rev+1, 10)
+	return nil
+}
@@ -0,0 +1,102 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type userRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) repository.UserRepository {
+	return &userRepository{db: db}
+}
+
+func (r *userRepository) Create(ctx context.Context, user *domain.User) error {
+	data, err := encode(user)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, "INSERT INTO users (id, email, username, data) VALUES (?, ?, ?, ?)",
+		user.ID, user.Email, user.Username, data); err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *userRepository) FindByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return r.findOne(ctx, "SELECT data FROM users WHERE email = ?", email)
+}
+
+func (r *userRepository) FindByID(ctx context.Context, id string) (*domain.User, error) {
+	user, err := r.findOne(ctx, "SELECT data FROM users WHERE id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by ID: %w", err)
+	}
+	return user, nil
+}
+
+func (r *userRepository) FindByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return r.findOne(ctx, "SELECT data FROM users WHERE username = ?", username)
+}
+
+func (r *userRepository) Update(ctx context.Context, user *domain.User) error {
+	data, err := encode(user)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, "UPDATE users SET email = ?, username = ?, data = ? WHERE id = ?",
+		user.Email, user.Username, data, user.ID); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *userRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	return r.exists(ctx, "SELECT 1 FROM users WHERE email = ?", email)
+}
+
+func (r *userRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
+	return r.exists(ctx, "SELECT 1 FROM users WHERE username = ?", username)
+}
+
+func (r *userRepository) List(ctx context.Context) ([]*domain.User, error) {
+	users, err := queryAll[domain.User](ctx, r.db, "SELECT data FROM users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, nil
+}
+
+func (r *userRepository) findOne(ctx context.Context, query string, arg string) (*domain.User, error) {
+	user, err := queryOne[domain.User](ctx, r.db, query, arg)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to query user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *userRepository) exists(ctx context.Context, query string, arg string) (bool, error) {
+	var one int
+	err := r.db.QueryRowContext(ctx, query, arg).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
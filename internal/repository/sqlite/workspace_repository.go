@@ -0,0 +1,135 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type workspaceRepository struct {
+	db *sql.DB
+}
+
+func NewWorkspaceRepository(db *sql.DB) repository.WorkspaceRepository {
+	return &workspaceRepository{db: db}
+}
+
+func (r *workspaceRepository) Create(ctx context.Context, workspace *domain.Workspace) error {
+	data, err := encode(workspace)
+	if err != nil {
+		return err
+	}
+
+	created, err := exec(ctx, r.db, `INSERT INTO workspaces (id, owner_id, is_default, pending_owner_id, data)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		workspace.ID, workspace.OwnerID, boolToInt(workspace.IsDefault), workspace.PendingOwnerID, data)
+	if err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+	if !created {
+		return repository.ErrWorkspaceExists
+	}
+	workspace.Rev = "1"
+
+	return nil
+}
+
+func (r *workspaceRepository) Get(ctx context.Context, id string) (*domain.Workspace, error) {
+	var data string
+	var rev int64
+	err := r.db.QueryRowContext(ctx, "SELECT data, rev FROM workspaces WHERE id = ?", id).Scan(&data, &rev)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrWorkspaceNotFound
+		}
+		return nil, fmt.Errorf("failed to get workspace: %w", err)
+	}
+
+	var workspace domain.Workspace
+	if err := json.Unmarshal([]byte(data), &workspace); err != nil {
+		return nil, fmt.Errorf("failed to decode workspace: %w", err)
+	}
+	workspace.Rev = strconv.FormatInt(rev, 10)
+
+	return &workspace, nil
+}
+
+func (r *workspaceRepository) GetByOwner(ctx context.Context, ownerID string) ([]*domain.Workspace, error) {
+	workspaces, err := queryAll[domain.Workspace](ctx, r.db, "SELECT data FROM workspaces WHERE owner_id = ?", ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+func (r *workspaceRepository) GetDefault(ctx context.Context, ownerID string) (*domain.Workspace, error) {
+	workspace, err := queryOne[domain.Workspace](ctx, r.db,
+		"SELECT data FROM workspaces WHERE owner_id = ? AND is_default = 1 LIMIT 1", ownerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrWorkspaceNotFound
+		}
+		return nil, fmt.Errorf("failed to query default workspace: %w", err)
+	}
+	return workspace, nil
+}
+
+func (r *workspaceRepository) GetPendingTransfers(ctx context.Context, userID string) ([]*domain.Workspace, error) {
+	workspaces, err := queryAll[domain.Workspace](ctx, r.db, "SELECT data FROM workspaces WHERE pending_owner_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workspace transfers: %w", err)
+	}
+	return workspaces, nil
+}
+
+// Update stores workspace. If workspace.Rev is set the write fails with
+// ErrConflict when the stored workspace has changed since it was read.
+func (r *workspaceRepository) Update(ctx context.Context, workspace *domain.Workspace) error {
+	existing, err := r.Get(ctx, workspace.ID)
+	if err != nil {
+		return err
+	}
+	if workspace.Rev != "" && workspace.Rev != existing.Rev {
+		return fmt.Errorf("failed to update workspace: %w", repository.ErrConflict)
+	}
+	rev, err := strconv.ParseInt(existing.Rev, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid workspace revision %q: %w", existing.Rev, err)
+	}
+
+	data, err := encode(workspace)
+	if err != nil {
+		return err
+	}
+
+	changed, err := exec(ctx, r.db, `UPDATE workspaces SET owner_id = ?, is_default = ?, pending_owner_id = ?, data = ?,
+		rev = rev + 1 WHERE id = ? AND rev = ?`,
+		workspace.OwnerID, boolToInt(workspace.IsDefault), workspace.PendingOwnerID, data, workspace.ID, rev)
+	if err != nil {
+		return fmt.Errorf("failed to update workspace: %w", err)
+	}
+	if !changed {
+		return fmt.Errorf("failed to update workspace: %w", repository.ErrConflict)
+	}
+	workspace.Rev = strconv.FormatInt(rev+1, 10)
+
+	return nil
+}
+
+func (r *workspaceRepository) Delete(ctx context.Context, id string) error {
+	changed, err := exec(ctx, r.db, "DELETE FROM workspaces WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete workspace: %w", err)
+	}
+	if !changed {
+		return repository.ErrWorkspaceNotFound
+	}
+
+	return nil
+}
@@ -1,140 +1,138 @@
 package repository
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
+
+	"github.com/go-kivik/kivik/v4"
 )
 
 type SyncMetadataRepository interface {
-	Get(userID, deviceID string) (*domain.SyncMetadata, error)
-	Upsert(metadata *domain.SyncMetadata) error
-	UpdateLastSync(userID, deviceID string, timestamp time.Time) error
-	UpdateNoteVersion(userID, deviceID, noteID string, version int64) error
+	Get(ctx context.Context, userID, deviceID string) (*domain.SyncMetadata, error)
+	Upsert(ctx context.Context, metadata *domain.SyncMetadata) error
+	UpdateLastSync(ctx context.Context, userID, deviceID string, timestamp time.Time) error
+	UpdateNoteVersion(ctx context.Context, userID, deviceID, noteID string, version int64) error
+	UpdateWorkspaces(ctx context.Context, userID, deviceID string, workspaceIDs []string) error
 }
 
-type syncMetadataRepo struct {
-	baseURL string
-	client  *http.Client
+type syncMetadataRepository struct {
+	client *kivik.Client
+	dbName string
 }
 
-func NewSyncMetadataRepository(baseURL string) SyncMetadataRepository {
-	return &syncMetadataRepo{
-		baseURL: baseURL,
-		client:  &http.Client{Timeout: 10 * time.Second},
-	}
+type syncMetadataDoc struct {
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.SyncMetadata
 }
 
-func (r *syncMetadataRepo) Get(userID, deviceID string) (*domain.SyncMetadata, error) {
-	docID := fmt.Sprintf("sync:%s:%s", userID, deviceID)
-	url := fmt.Sprintf("%s/%s", r.baseURL, docID)
-
-	resp, err := r.client.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return &domain.SyncMetadata{
-			UserID:           userID,
-			DeviceID:         deviceID,
-			LastSyncTime:     time.Time{},
-			NoteVersions:     make(map[string]int64),
-			PendingConflicts: []string{},
-			UpdatedAt:        time.Now(),
-		}, nil
+func NewSyncMetadataRepository(client *kivik.Client, dbName string) SyncMetadataRepository {
+	return &syncMetadataRepository{
+		client: client,
+		dbName: dbName,
 	}
+}
 
-	var metadata domain.SyncMetadata
-	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+// Get returns the sync state of a device, or an empty one if it never synced
+func (r *syncMetadataRepository) Get(ctx context.Context, userID, deviceID string) (*domain.SyncMetadata, error) {
+	doc, err := r.get(ctx, userID, deviceID)
+	if err != nil {
 		return nil, err
 	}
 
+	metadata := doc.SyncMetadata
 	return &metadata, nil
 }
 
-func (r *syncMetadataRepo) Upsert(metadata *domain.SyncMetadata) error {
-	docID := fmt.Sprintf("sync:%s:%s", metadata.UserID, metadata.DeviceID)
+func (r *syncMetadataRepository) Upsert(ctx context.Context, metadata *domain.SyncMetadata) error {
+	db := r.client.DB(r.dbName)
+	docID := syncMetadataDocID(metadata.UserID, metadata.DeviceID)
 
-	existing, _ := r.Get(metadata.UserID, metadata.DeviceID)
-
-	doc := map[string]interface{}{
-		"_id":               docID,
-		"user_id":           metadata.UserID,
-		"device_id":         metadata.DeviceID,
-		"last_sync_time":    metadata.LastSyncTime,
-		"note_versions":     metadata.NoteVersions,
-		"pending_conflicts": metadata.PendingConflicts,
-		"updated_at":        time.Now(),
+	rev, err := db.GetRev(ctx, docID)
+	if err != nil && kivik.HTTPStatus(err) != 404 {
+		return fmt.Errorf("failed to get sync metadata revision: %w", err)
 	}
 
-	if existing != nil {
-		url := fmt.Sprintf("%s/%s", r.baseURL, docID)
-		resp, _ := r.client.Get(url)
-		if resp != nil && resp.StatusCode == http.StatusOK {
-			var existingDoc map[string]interface{}
-			json.NewDecoder(resp.Body).Decode(&existingDoc)
-			if rev, ok := existingDoc["_rev"].(string); ok {
-				doc["_rev"] = rev
-			}
-			resp.Body.Close()
-		}
+	doc := syncMetadataDoc{
+		Rev:          rev,
+		DocType:      "sync_metadata",
+		SyncMetadata: *metadata,
 	}
+	doc.UpdatedAt = time.Now()
 
-	data, err := json.Marshal(doc)
-	if err != nil {
-		return err
+	if _, err := db.Put(ctx, docID, doc); err != nil {
+		return fmt.Errorf("failed to upsert sync metadata: %w", wrapError(err))
 	}
 
-	url := fmt.Sprintf("%s/%s", r.baseURL, docID)
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(data))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
 
-	resp, err := r.client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+func (r *syncMetadataRepository) UpdateLastSync(ctx context.Context, userID, deviceID string, timestamp time.Time) error {
+	return r.update(ctx, userID, deviceID, func(m *domain.SyncMetadata) {
+		m.LastSyncTime = timestamp
+	})
+}
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to upsert sync metadata: status %d", resp.StatusCode)
-	}
+func (r *syncMetadataRepository) UpdateNoteVersion(ctx context.Context, userID, deviceID, noteID string, version int64) error {
+	return r.update(ctx, userID, deviceID, func(m *domain.SyncMetadata) {
+		if m.NoteVersions == nil {
+			m.NoteVersions = make(map[string]int64)
+		}
+		m.NoteVersions[noteID] = version
+	})
+}
 
-	return nil
+func (r *syncMetadataRepository) UpdateWorkspaces(ctx context.Context, userID, deviceID string, workspaceIDs []string) error {
+	return r.update(ctx, userID, deviceID, func(m *domain.SyncMetadata) {
+		m.WorkspaceIDs = workspaceIDs
+	})
 }
 
-func (r *syncMetadataRepo) UpdateLastSync(userID, deviceID string, timestamp time.Time) error {
-	metadata, err := r.Get(userID, deviceID)
+// update applies fn to the stored metadata and writes it back with the
+// revision it was read at.
+func (r *syncMetadataRepository) update(ctx context.Context, userID, deviceID string, fn func(*domain.SyncMetadata)) error {
+	doc, err := r.get(ctx, userID, deviceID)
 	if err != nil {
 		return err
 	}
 
-	metadata.LastSyncTime = timestamp
-	metadata.UpdatedAt = time.Now()
+	fn(&doc.SyncMetadata)
+	doc.DocType = "sync_metadata"
+	doc.UpdatedAt = time.Now()
 
-	return r.Upsert(metadata)
+	docID := syncMetadataDocID(userID, deviceID)
+	if _, err := r.client.DB(r.dbName).Put(ctx, docID, doc); err != nil {
+		return fmt.Errorf("failed to update sync metadata: %w", wrapError(err))
+	}
+
+	return nil
 }
 
-func (r *syncMetadataRepo) UpdateNoteVersion(userID, deviceID, noteID string, version int64) error {
-	metadata, err := r.Get(userID, deviceID)
+func (r *syncMetadataRepository) get(ctx context.Context, userID, deviceID string) (*syncMetadataDoc, error) {
+	var doc syncMetadataDoc
+	err := r.client.DB(r.dbName).Get(ctx, syncMetadataDocID(userID, deviceID)).ScanDoc(&doc)
 	if err != nil {
-		return err
+		if kivik.HTTPStatus(err) != 404 {
+			return nil, fmt.Errorf("failed to get sync metadata: %w", err)
+		}
+		doc = syncMetadataDoc{SyncMetadata: domain.SyncMetadata{
+			UserID:           userID,
+			DeviceID:         deviceID,
+			PendingConflicts: []string{},
+			UpdatedAt:        time.Now(),
+		}}
 	}
 
-	if metadata.NoteVersions == nil {
-		metadata.NoteVersions = make(map[string]int64)
+	if doc.NoteVersions == nil {
+		doc.NoteVersions = make(map[string]int64)
 	}
 
-	metadata.NoteVersions[noteID] = version
-	metadata.UpdatedAt = time.Now()
+	return &doc, nil
+}
 
-	return r.Upsert(metadata)
+func syncMetadataDocID(userID, deviceID string) string {
+	return fmt.Sprintf("sync:%s:%s", userID, deviceID)
 }
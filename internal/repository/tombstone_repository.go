@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+type TombstoneRepository interface {
+	Create(ctx context.Context, tombstone *domain.Tombstone) error
+	ListByUser(ctx context.Context, userID string) ([]*domain.Tombstone, error)
+	DeletePurgedBefore(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+type tombstoneRepository struct {
+	client *kivik.Client
+	dbName string
+}
+
+type tombstoneDoc struct {
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.Tombstone
+}
+
+func NewTombstoneRepository(client *kivik.Client, dbName string) TombstoneRepository {
+	return &tombstoneRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *tombstoneRepository) Create(ctx context.Context, tombstone *domain.Tombstone) error {
+	db := r.client.DB(r.dbName)
+
+	docID := fmt.Sprintf("tombstone:%s", tombstone.NoteID)
+	doc := tombstoneDoc{
+		DocType:   "tombstone",
+		Tombstone: *tombstone,
+	}
+
+	rev, err := db.GetRev(ctx, docID)
+	if err != nil && kivik.HTTPStatus(err) != 404 {
+		return fmt.Errorf("failed to get tombstone revision: %w", err)
+	}
+	doc.Rev = rev
+
+	if _, err := db.Put(ctx, docID, doc); err != nil {
+		return fmt.Errorf("failed to create tombstone: %w", err)
+	}
+
+	return nil
+}
+
+func (r *tombstoneRepository) ListByUser(ctx context.Context, userID string) ([]*domain.Tombstone, error) {
+	db := r.client.DB(r.dbName)
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"doc_type": "tombstone",
+			"user_id":  userID,
+		},
+	}
+
+	rows := db.Find(ctx, query)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	var tombstones []*domain.Tombstone
+	for rows.Next() {
+		var doc tombstoneDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			continue
+		}
+		tombstone := doc.Tombstone
+		tombstones = append(tombstones, &tombstone)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tombstones: %w", err)
+	}
+
+	return tombstones, nil
+}
+
+func (r *tombstoneRepository) DeletePurgedBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	db := r.client.DB(r.dbName)
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"doc_type":  "tombstone",
+			"purged_at": map[string]interface{}{"$lt": cutoff.UTC().Format(time.RFC3339Nano)},
+		},
+		"fields": []string{"_id", "_rev"},
+	}
+
+	rows := db.Find(ctx, query)
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query expired tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	deleted := 0
+	for rows.Next() {
+		var doc struct {
+			ID  string `json:"_id"`
+			Rev string `json:"_rev"`
+		}
+		if err := rows.ScanDoc(&doc); err != nil {
+			continue
+		}
+		if _, err := db.Delete(ctx, doc.ID, doc.Rev); err != nil {
+			return deleted, fmt.Errorf("failed to delete tombstone: %w", err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+type UsageRepository interface {
+	Get(ctx context.Context, userID string) (*domain.Usage, error)
+	// Save stores usage if the stored document is still at usage.Rev and
+	// fails with ErrConflict otherwise
+	Save(ctx context.Context, usage *domain.Usage) error
+}
+
+type usageRepository struct {
+	client *kivik.Client
+	dbName string
+}
+
+type usageDoc struct {
+	Rev     string `json:"_rev,omitempty"`
+	DocType string `json:"doc_type"`
+	domain.Usage
+}
+
+func NewUsageRepository(client *kivik.Client, dbName string) UsageRepository {
+	return &usageRepository{
+		client: client,
+		dbName: dbName,
+	}
+}
+
+func (r *usageRepository) Get(ctx context.Context, userID string) (*domain.Usage, error) {
+	db := r.client.DB(r.dbName)
+
+	docID := fmt.Sprintf("usage:%s", userID)
+	row := db.Get(ctx, docID)
+
+	var doc usageDoc
+	if err := row.ScanDoc(&doc); err != nil {
+		if kivik.HTTPStatus(err) == 404 {
+			return &domain.Usage{
+				UserID:     userID,
+				Workspaces: make(map[string]*domain.WorkspaceUsage),
+				UpdatedAt:  time.Now(),
+			}, nil
+		}
+		return nil, fmt.Errorf("failed to get usage: %w", err)
+	}
+
+	if doc.Workspaces == nil {
+		doc.Workspaces = make(map[string]*domain.WorkspaceUsage)
+	}
+
+	usage := doc.Usage
+	usage.Rev = doc.Rev
+	return &usage, nil
+}
+
+func (r *usageRepository) Save(ctx context.Context, usage *domain.Usage) error {
+	db := r.client.DB(r.dbName)
+	docID := fmt.Sprintf("usage:%s", usage.UserID)
+
+	doc := usageDoc{
+		Rev:     usage.Rev,
+		DocType: "usage",
+		Usage:   *usage,
+	}
+
+	rev, err := db.Put(ctx, docID, doc)
+	if err != nil {
+		return fmt.Errorf("failed to save usage: %w", wrapError(err))
+	}
+	usage.Rev = rev
+
+	return nil
+}
@@ -10,13 +10,14 @@ import (
 )
 
 type UserRepository interface {
-	Create(user *domain.User) error
-	FindByEmail(email string) (*domain.User, error)
-	FindByID(id string) (*domain.User, error)
-	FindByUsername(username string) (*domain.User, error)
-	Update(user *domain.User) error
-	EmailExists(email string) (bool, error)
-	UsernameExists(username string) (bool, error)
+	Create(ctx context.Context, user *domain.User) error
+	FindByEmail(ctx context.Context, email string) (*domain.User, error)
+	FindByID(ctx context.Context, id string) (*domain.User, error)
+	FindByUsername(ctx context.Context, username string) (*domain.User, error)
+	Update(ctx context.Context, user *domain.User) error
+	EmailExists(ctx context.Context, email string) (bool, error)
+	UsernameExists(ctx context.Context, username string) (bool, error)
+	List(ctx context.Context) ([]*domain.User, error)
 }
 
 type userRepository struct {
@@ -31,11 +32,11 @@ func NewUserRepository(client *kivik.Client, dbName string) UserRepository {
 	}
 }
 
-func (r *userRepository) Create(user *domain.User) error {
+func (r *userRepository) Create(ctx context.Context, user *domain.User) error {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("user:%s", user.ID)
-	_, err := db.Put(context.Background(), docID, user)
+	_, err := db.Put(ctx, docID, user)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
@@ -43,7 +44,7 @@ func (r *userRepository) Create(user *domain.User) error {
 	return nil
 }
 
-func (r *userRepository) FindByEmail(email string) (*domain.User, error) {
+func (r *userRepository) FindByEmail(ctx context.Context, email string) (*domain.User, error) {
 	db := r.client.DB(r.dbName)
 
 	query := map[string]interface{}{
@@ -53,7 +54,7 @@ func (r *userRepository) FindByEmail(email string) (*domain.User, error) {
 		"limit": 1,
 	}
 
-	rows := db.Find(context.Background(), query)
+	rows := db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to query user by email: %w", err)
 	}
@@ -71,11 +72,11 @@ func (r *userRepository) FindByEmail(email string) (*domain.User, error) {
 	return &user, nil
 }
 
-func (r *userRepository) FindByID(id string) (*domain.User, error) {
+func (r *userRepository) FindByID(ctx context.Context, id string) (*domain.User, error) {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("user:%s", id)
-	row := db.Get(context.Background(), docID)
+	row := db.Get(ctx, docID)
 
 	var user domain.User
 	if err := row.ScanDoc(&user); err != nil {
@@ -85,7 +86,7 @@ func (r *userRepository) FindByID(id string) (*domain.User, error) {
 	return &user, nil
 }
 
-func (r *userRepository) FindByUsername(username string) (*domain.User, error) {
+func (r *userRepository) FindByUsername(ctx context.Context, username string) (*domain.User, error) {
 	db := r.client.DB(r.dbName)
 
 	query := map[string]interface{}{
@@ -95,7 +96,7 @@ func (r *userRepository) FindByUsername(username string) (*domain.User, error) {
 		"limit": 1,
 	}
 
-	rows := db.Find(context.Background(), query)
+	rows := db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to query user by username: %w", err)
 	}
@@ -113,11 +114,11 @@ func (r *userRepository) FindByUsername(username string) (*domain.User, error) {
 	return &user, nil
 }
 
-func (r *userRepository) Update(user *domain.User) error {
+func (r *userRepository) Update(ctx context.Context, user *domain.User) error {
 	db := r.client.DB(r.dbName)
 
 	docID := fmt.Sprintf("user:%s", user.ID)
-	_, err := db.Put(context.Background(), docID, user)
+	_, err := db.Put(ctx, docID, user)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
@@ -125,8 +126,36 @@ func (r *userRepository) Update(user *domain.User) error {
 	return nil
 }
 
-func (r *userRepository) EmailExists(email string) (bool, error) {
-	_, err := r.FindByEmail(email)
+func (r *userRepository) List(ctx context.Context) ([]*domain.User, error) {
+	db := r.client.DB(r.dbName)
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"email":    map[string]interface{}{"$exists": true},
+			"username": map[string]interface{}{"$exists": true},
+		},
+	}
+
+	rows := db.Find(ctx, query)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		var user domain.User
+		if err := rows.ScanDoc(&user); err != nil {
+			continue
+		}
+		users = append(users, &user)
+	}
+
+	return users, nil
+}
+
+func (r *userRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	_, err := r.FindByEmail(ctx, email)
 	if err != nil {
 		if err.Error() == "user not found" {
 			return false, nil
@@ -136,8 +165,8 @@ func (r *userRepository) EmailExists(email string) (bool, error) {
 	return true, nil
 }
 
-func (r *userRepository) UsernameExists(username string) (bool, error) {
-	_, err := r.FindByUsername(username)
+func (r *userRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
+	_, err := r.FindByUsername(ctx, username)
 	if err != nil {
 		if err.Error() == "user not found" {
 			return false, nil
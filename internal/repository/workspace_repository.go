@@ -17,12 +17,13 @@ var (
 )
 
 type WorkspaceRepository interface {
-	Create(workspace *domain.Workspace) error
-	Get(id string) (*domain.Workspace, error)
-	GetByOwner(ownerID string) ([]*domain.Workspace, error)
-	GetDefault(ownerID string) (*domain.Workspace, error)
-	Update(workspace *domain.Workspace) error
-	Delete(id string) error
+	Create(ctx context.Context, workspace *domain.Workspace) error
+	Get(ctx context.Context, id string) (*domain.Workspace, error)
+	GetByOwner(ctx context.Context, ownerID string) ([]*domain.Workspace, error)
+	GetDefault(ctx context.Context, ownerID string) (*domain.Workspace, error)
+	GetPendingTransfers(ctx context.Context, userID string) ([]*domain.Workspace, error)
+	Update(ctx context.Context, workspace *domain.Workspace) error
+	Delete(ctx context.Context, id string) error
 }
 
 type CouchDBWorkspaceRepository struct {
@@ -30,14 +31,19 @@ type CouchDBWorkspaceRepository struct {
 }
 
 type workspaceDoc struct {
-	ID        string `json:"_id"`
-	Rev       string `json:"_rev,omitempty"`
-	DocType   string `json:"doc_type"`
-	OwnerID   string `json:"owner_id"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
-	IsDefault bool   `json:"is_default"`
+	ID                  string `json:"_id"`
+	Rev                 string `json:"_rev,omitempty"`
+	DocType             string `json:"doc_type"`
+	OwnerID             string `json:"owner_id"`
+	Name                string `json:"name"`
+	CreatedAt           string `json:"created_at"`
+	UpdatedAt           string `json:"updated_at"`
+	IsDefault           bool   `json:"is_default"`
+	IsArchived          bool   `json:"is_archived"`
+	ArchivedAt          string `json:"archived_at,omitempty"`
+	PendingOwnerID      string `json:"pending_owner_id,omitempty"`
+	TransferRequestedAt string `json:"transfer_requested_at,omitempty"`
+	ConflictPolicy      string `json:"conflict_policy,omitempty"`
 }
 
 func NewWorkspaceRepository(client *kivik.Client, dbName string) *CouchDBWorkspaceRepository {
@@ -46,30 +52,23 @@ func NewWorkspaceRepository(client *kivik.Client, dbName string) *CouchDBWorkspa
 	}
 }
 
-func (r *CouchDBWorkspaceRepository) Create(workspace *domain.Workspace) error {
-	doc := workspaceDoc{
-		ID:        workspace.ID,
-		DocType:   "workspace",
-		OwnerID:   workspace.OwnerID,
-		Name:      workspace.Name,
-		CreatedAt: workspace.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt: workspace.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		IsDefault: workspace.IsDefault,
-	}
+func (r *CouchDBWorkspaceRepository) Create(ctx context.Context, workspace *domain.Workspace) error {
+	doc := workspaceToDoc(workspace)
 
-	_, err := r.db.Put(context.Background(), doc.ID, doc)
+	rev, err := r.db.Put(ctx, doc.ID, doc)
 	if err != nil {
 		if kivik.HTTPStatus(err) == 409 {
 			return ErrWorkspaceExists
 		}
 		return fmt.Errorf("failed to create workspace: %w", err)
 	}
+	workspace.Rev = rev
 
 	return nil
 }
 
-func (r *CouchDBWorkspaceRepository) Get(id string) (*domain.Workspace, error) {
-	row := r.db.Get(context.Background(), id)
+func (r *CouchDBWorkspaceRepository) Get(ctx context.Context, id string) (*domain.Workspace, error) {
+	row := r.db.Get(ctx, id)
 
 	var doc workspaceDoc
 	if err := row.ScanDoc(&doc); err != nil {
@@ -82,7 +81,7 @@ func (r *CouchDBWorkspaceRepository) Get(id string) (*domain.Workspace, error) {
 	return docToWorkspace(&doc)
 }
 
-func (r *CouchDBWorkspaceRepository) GetByOwner(ownerID string) ([]*domain.Workspace, error) {
+func (r *CouchDBWorkspaceRepository) GetByOwner(ctx context.Context, ownerID string) ([]*domain.Workspace, error) {
 	query := map[string]interface{}{
 		"selector": map[string]interface{}{
 			"doc_type": "workspace",
@@ -90,7 +89,7 @@ func (r *CouchDBWorkspaceRepository) GetByOwner(ownerID string) ([]*domain.Works
 		},
 	}
 
-	rows := r.db.Find(context.Background(), query)
+	rows := r.db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to query workspaces: %w", err)
 	}
@@ -113,7 +112,7 @@ func (r *CouchDBWorkspaceRepository) GetByOwner(ownerID string) ([]*domain.Works
 	return workspaces, nil
 }
 
-func (r *CouchDBWorkspaceRepository) GetDefault(ownerID string) (*domain.Workspace, error) {
+func (r *CouchDBWorkspaceRepository) GetDefault(ctx context.Context, ownerID string) (*domain.Workspace, error) {
 	query := map[string]interface{}{
 		"selector": map[string]interface{}{
 			"doc_type":   "workspace",
@@ -123,7 +122,7 @@ func (r *CouchDBWorkspaceRepository) GetDefault(ownerID string) (*domain.Workspa
 		"limit": 1,
 	}
 
-	rows := r.db.Find(context.Background(), query)
+	rows := r.db.Find(ctx, query)
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to query default workspace: %w", err)
 	}
@@ -141,8 +140,10 @@ func (r *CouchDBWorkspaceRepository) GetDefault(ownerID string) (*domain.Workspa
 	return docToWorkspace(&doc)
 }
 
-func (r *CouchDBWorkspaceRepository) Update(workspace *domain.Workspace) error {
-	row := r.db.Get(context.Background(), workspace.ID)
+// Update stores workspace. If workspace.Rev is set the write fails with
+// ErrConflict when the stored workspace has changed since it was read.
+func (r *CouchDBWorkspaceRepository) Update(ctx context.Context, workspace *domain.Workspace) error {
+	row := r.db.Get(ctx, workspace.ID)
 	var existingDoc workspaceDoc
 	if err := row.ScanDoc(&existingDoc); err != nil {
 		if kivik.HTTPStatus(err) == 404 {
@@ -151,27 +152,24 @@ func (r *CouchDBWorkspaceRepository) Update(workspace *domain.Workspace) error {
 		return fmt.Errorf("failed to get workspace for update: %w", err)
 	}
 
-	doc := workspaceDoc{
-		ID:        workspace.ID,
-		Rev:       existingDoc.Rev,
-		DocType:   "workspace",
-		OwnerID:   workspace.OwnerID,
-		Name:      workspace.Name,
-		CreatedAt: workspace.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt: workspace.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		IsDefault: workspace.IsDefault,
+	if workspace.Rev != "" && existingDoc.Rev != workspace.Rev {
+		return fmt.Errorf("failed to update workspace: %w", ErrConflict)
 	}
 
-	_, err := r.db.Put(context.Background(), doc.ID, doc)
+	doc := workspaceToDoc(workspace)
+	doc.Rev = existingDoc.Rev
+
+	rev, err := r.db.Put(ctx, doc.ID, doc)
 	if err != nil {
-		return fmt.Errorf("failed to update workspace: %w", err)
+		return fmt.Errorf("failed to update workspace: %w", wrapError(err))
 	}
+	workspace.Rev = rev
 
 	return nil
 }
 
-func (r *CouchDBWorkspaceRepository) Delete(id string) error {
-	row := r.db.Get(context.Background(), id)
+func (r *CouchDBWorkspaceRepository) Delete(ctx context.Context, id string) error {
+	row := r.db.Get(ctx, id)
 	var doc workspaceDoc
 	if err := row.ScanDoc(&doc); err != nil {
 		if kivik.HTTPStatus(err) == 404 {
@@ -180,7 +178,7 @@ func (r *CouchDBWorkspaceRepository) Delete(id string) error {
 		return fmt.Errorf("failed to get workspace for delete: %w", err)
 	}
 
-	_, err := r.db.Delete(context.Background(), id, doc.Rev)
+	_, err := r.db.Delete(ctx, id, doc.Rev)
 	if err != nil {
 		return fmt.Errorf("failed to delete workspace: %w", err)
 	}
@@ -188,6 +186,54 @@ func (r *CouchDBWorkspaceRepository) Delete(id string) error {
 	return nil
 }
 
+func (r *CouchDBWorkspaceRepository) GetPendingTransfers(ctx context.Context, userID string) ([]*domain.Workspace, error) {
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"doc_type":         "workspace",
+			"pending_owner_id": userID,
+		},
+	}
+
+	rows := r.db.Find(ctx, query)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query workspace transfers: %w", err)
+	}
+	defer rows.Close()
+
+	var workspaces []*domain.Workspace
+	for rows.Next() {
+		var doc workspaceDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			return nil, fmt.Errorf("failed to scan workspace: %w", err)
+		}
+
+		ws, err := docToWorkspace(&doc)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, ws)
+	}
+
+	return workspaces, nil
+}
+
+func workspaceToDoc(workspace *domain.Workspace) workspaceDoc {
+	return workspaceDoc{
+		ID:                  workspace.ID,
+		DocType:             "workspace",
+		OwnerID:             workspace.OwnerID,
+		Name:                workspace.Name,
+		CreatedAt:           workspace.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		UpdatedAt:           workspace.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		IsDefault:           workspace.IsDefault,
+		IsArchived:          workspace.IsArchived,
+		ArchivedAt:          formatOptionalTime(workspace.ArchivedAt),
+		PendingOwnerID:      workspace.PendingOwnerID,
+		TransferRequestedAt: formatOptionalTime(workspace.TransferRequestedAt),
+		ConflictPolicy:      string(workspace.ConflictPolicy),
+	}
+}
+
 func docToWorkspace(doc *workspaceDoc) (*domain.Workspace, error) {
 	createdAt, err := parseTime(doc.CreatedAt)
 	if err != nil {
@@ -199,16 +245,50 @@ func docToWorkspace(doc *workspaceDoc) (*domain.Workspace, error) {
 		return nil, fmt.Errorf("failed to parse updated_at: %w", err)
 	}
 
+	archivedAt, err := parseOptionalTime(doc.ArchivedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse archived_at: %w", err)
+	}
+
+	transferRequestedAt, err := parseOptionalTime(doc.TransferRequestedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transfer_requested_at: %w", err)
+	}
+
 	return &domain.Workspace{
-		ID:        doc.ID,
-		OwnerID:   doc.OwnerID,
-		Name:      doc.Name,
-		CreatedAt: createdAt,
-		UpdatedAt: updatedAt,
-		IsDefault: doc.IsDefault,
+		ID:                  doc.ID,
+		OwnerID:             doc.OwnerID,
+		Name:                doc.Name,
+		CreatedAt:           createdAt,
+		UpdatedAt:           updatedAt,
+		IsDefault:           doc.IsDefault,
+		IsArchived:          doc.IsArchived,
+		ArchivedAt:          archivedAt,
+		PendingOwnerID:      doc.PendingOwnerID,
+		TransferRequestedAt: transferRequestedAt,
+		ConflictPolicy:      domain.ResolutionStrategy(doc.ConflictPolicy),
+		Rev:                 doc.Rev,
 	}, nil
 }
 
 func parseTime(s string) (time.Time, error) {
 	return time.Parse(time.RFC3339, s)
 }
+
+func parseOptionalTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := parseTime(s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
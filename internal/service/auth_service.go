@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -14,22 +15,24 @@ import (
 
 type AuthService struct {
 	userRepo          repository.UserRepository
+	workspaceService  *WorkspaceService
 	jwtSecret         string
 	jwtExpiration     time.Duration
 	refreshExpiration time.Duration
 }
 
-func NewAuthService(userRepo repository.UserRepository, jwtSecret string, jwtExp, refreshExp time.Duration) *AuthService {
+func NewAuthService(userRepo repository.UserRepository, workspaceService *WorkspaceService, jwtSecret string, jwtExp, refreshExp time.Duration) *AuthService {
 	return &AuthService{
 		userRepo:          userRepo,
+		workspaceService:  workspaceService,
 		jwtSecret:         jwtSecret,
 		jwtExpiration:     jwtExp,
 		refreshExpiration: refreshExp,
 	}
 }
 
-func (s *AuthService) Register(req *domain.RegisterRequest) error {
-	emailExists, err := s.userRepo.EmailExists(req.Email)
+func (s *AuthService) Register(ctx context.Context, req *domain.RegisterRequest) error {
+	emailExists, err := s.userRepo.EmailExists(ctx, req.Email)
 	if err != nil {
 		return fmt.Errorf("failed to check email existence: %w", err)
 	}
@@ -37,7 +40,7 @@ func (s *AuthService) Register(req *domain.RegisterRequest) error {
 		return fmt.Errorf("email already registered")
 	}
 
-	usernameExists, err := s.userRepo.UsernameExists(req.Username)
+	usernameExists, err := s.userRepo.UsernameExists(ctx, req.Username)
 	if err != nil {
 		return fmt.Errorf("failed to check username existence: %w", err)
 	}
@@ -59,15 +62,21 @@ func (s *AuthService) Register(req *domain.RegisterRequest) error {
 		UpdatedAt: time.Now(),
 	}
 
-	if err := s.userRepo.Create(user); err != nil {
+	if err := s.userRepo.Create(ctx, user); err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 
+	if s.workspaceService != nil {
+		if _, err := s.workspaceService.CreateDefaultForUser(ctx, user.ID); err != nil {
+			return fmt.Errorf("failed to create default workspace: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func (s *AuthService) Login(req *domain.LoginRequest) (*domain.LoginResponse, error) {
-	user, err := s.userRepo.FindByEmail(req.Email)
+func (s *AuthService) Login(ctx context.Context, req *domain.LoginRequest) (*domain.LoginResponse, error) {
+	user, err := s.userRepo.FindByEmail(ctx, req.Email)
 	if err != nil {
 		return nil, fmt.Errorf("invalid credentials")
 	}
@@ -96,7 +105,7 @@ func (s *AuthService) Login(req *domain.LoginRequest) (*domain.LoginResponse, er
 	}, nil
 }
 
-func (s *AuthService) RefreshToken(req *domain.RefreshTokenRequest) (*domain.TokenResponse, error) {
+func (s *AuthService) RefreshToken(ctx context.Context, req *domain.RefreshTokenRequest) (*domain.TokenResponse, error) {
 	claims, err := jwt.ValidateToken(req.RefreshToken, s.jwtSecret)
 	if err != nil {
 		return nil, fmt.Errorf("invalid refresh token")
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -19,12 +20,12 @@ func newMockUserRepository() *mockUserRepository {
 	}
 }
 
-func (m *mockUserRepository) Create(user *domain.User) error {
+func (m *mockUserRepository) Create(ctx context.Context, user *domain.User) error {
 	m.users[user.ID] = user
 	return nil
 }
 
-func (m *mockUserRepository) FindByEmail(email string) (*domain.User, error) {
+func (m *mockUserRepository) FindByEmail(ctx context.Context, email string) (*domain.User, error) {
 	for _, user := range m.users {
 		if user.Email == email {
 			return user, nil
@@ -33,14 +34,14 @@ func (m *mockUserRepository) FindByEmail(email string) (*domain.User, error) {
 	return nil, &userNotFoundError{}
 }
 
-func (m *mockUserRepository) FindByID(id string) (*domain.User, error) {
+func (m *mockUserRepository) FindByID(ctx context.Context, id string) (*domain.User, error) {
 	if user, ok := m.users[id]; ok {
 		return user, nil
 	}
 	return nil, &userNotFoundError{}
 }
 
-func (m *mockUserRepository) FindByUsername(username string) (*domain.User, error) {
+func (m *mockUserRepository) FindByUsername(ctx context.Context, username string) (*domain.User, error) {
 	for _, user := range m.users {
 		if user.Username == username {
 			return user, nil
@@ -49,21 +50,29 @@ func (m *mockUserRepository) FindByUsername(username string) (*domain.User, erro
 	return nil, &userNotFoundError{}
 }
 
-func (m *mockUserRepository) Update(user *domain.User) error {
+func (m *mockUserRepository) Update(ctx context.Context, user *domain.User) error {
 	m.users[user.ID] = user
 	return nil
 }
 
-func (m *mockUserRepository) EmailExists(email string) (bool, error) {
-	_, err := m.FindByEmail(email)
+func (m *mockUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	_, err := m.FindByEmail(ctx, email)
 	return err == nil, nil
 }
 
-func (m *mockUserRepository) UsernameExists(username string) (bool, error) {
-	_, err := m.FindByUsername(username)
+func (m *mockUserRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
+	_, err := m.FindByUsername(ctx, username)
 	return err == nil, nil
 }
 
+func (m *mockUserRepository) List(ctx context.Context) ([]*domain.User, error) {
+	var users []*domain.User
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
 type userNotFoundError struct{}
 
 func (e *userNotFoundError) Error() string {
@@ -71,8 +80,9 @@ func (e *userNotFoundError) Error() string {
 }
 
 func TestAuthService_Register(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockUserRepository()
-	service := NewAuthService(repo, "test-secret", 15*time.Minute, 7*24*time.Hour)
+	service := NewAuthService(repo, nil, "test-secret", 15*time.Minute, 7*24*time.Hour)
 
 	tests := []struct {
 		name    string
@@ -100,7 +110,7 @@ func TestAuthService_Register(t *testing.T) {
 			wantErr: true,
 			setup: func() {
 				hashedPw, _ := hash.Hash("ExistingPass123!")
-				repo.Create(&domain.User{
+				repo.Create(ctx, &domain.User{
 					ID:       "existing-id",
 					Username: "existinguser",
 					Email:    "existing@example.com",
@@ -118,7 +128,7 @@ func TestAuthService_Register(t *testing.T) {
 			wantErr: true,
 			setup: func() {
 				hashedPw, _ := hash.Hash("Pass123!")
-				repo.Create(&domain.User{
+				repo.Create(ctx, &domain.User{
 					ID:       "dup-id",
 					Username: "duplicateuser",
 					Email:    "other@example.com",
@@ -143,7 +153,7 @@ func TestAuthService_Register(t *testing.T) {
 			repo.users = make(map[string]*domain.User)
 			tt.setup()
 
-			err := service.Register(tt.req)
+			err := service.Register(ctx, tt.req)
 
 			if tt.wantErr {
 				if err == nil {
@@ -154,7 +164,7 @@ func TestAuthService_Register(t *testing.T) {
 					t.Errorf("Register() unexpected error = %v", err)
 				}
 
-				exists, _ := repo.EmailExists(tt.req.Email)
+				exists, _ := repo.EmailExists(ctx, tt.req.Email)
 				if !exists {
 					t.Error("Register() user not created in repository")
 				}
@@ -164,13 +174,14 @@ func TestAuthService_Register(t *testing.T) {
 }
 
 func TestAuthService_Login(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockUserRepository()
-	service := NewAuthService(repo, "test-secret-key", 15*time.Minute, 7*24*time.Hour)
+	service := NewAuthService(repo, nil, "test-secret-key", 15*time.Minute, 7*24*time.Hour)
 
 	password := "UserPassword123!"
 	hashedPassword, _ := hash.Hash(password)
 
-	repo.Create(&domain.User{
+	repo.Create(ctx, &domain.User{
 		ID:       "test-user-id",
 		Username: "testuser",
 		Email:    "test@example.com",
@@ -218,7 +229,7 @@ func TestAuthService_Login(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp, err := service.Login(tt.req)
+			resp, err := service.Login(ctx, tt.req)
 
 			if tt.wantErr {
 				if err == nil {
@@ -256,11 +267,12 @@ func TestAuthService_Login(t *testing.T) {
 }
 
 func TestAuthService_RefreshToken(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockUserRepository()
 	secret := "refresh-test-secret-key"
-	service := NewAuthService(repo, secret, 15*time.Minute, 7*24*time.Hour)
+	service := NewAuthService(repo, nil, secret, 15*time.Minute, 7*24*time.Hour)
 
-	repo.Create(&domain.User{
+	repo.Create(ctx, &domain.User{
 		ID:       "refresh-user-id",
 		Username: "refreshuser",
 		Email:    "refresh@example.com",
@@ -307,7 +319,7 @@ func TestAuthService_RefreshToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp, err := service.RefreshToken(tt.req)
+			resp, err := service.RefreshToken(ctx, tt.req)
 
 			if tt.wantErr {
 				if err == nil {
@@ -335,7 +347,7 @@ func TestAuthService_RefreshToken(t *testing.T) {
 func TestAuthService_ValidateToken(t *testing.T) {
 	repo := newMockUserRepository()
 	secret := "validation-test-secret"
-	service := NewAuthService(repo, secret, 15*time.Minute, 7*24*time.Hour)
+	service := NewAuthService(repo, nil, secret, 15*time.Minute, 7*24*time.Hour)
 
 	validToken, _ := GenerateToken("user-id", 1*time.Hour, secret)
 
@@ -383,3 +395,25 @@ func TestAuthService_ValidateToken(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthService_RegisterCreatesDefaultWorkspace(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockUserRepository()
+	workspaces := newMockWorkspaceRepo()
+	workspaceService := NewWorkspaceService(workspaces, newMockNoteRepo(), repo, newMockJobRepo(), nil, nil, nil, nil, nil)
+	service := NewAuthService(repo, workspaceService, "test-secret", 15*time.Minute, 7*24*time.Hour)
+
+	err := service.Register(ctx, &domain.RegisterRequest{
+		Username: "newuser",
+		Email:    "new@example.com",
+		Password: "Password123!",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	user, _ := repo.FindByEmail(ctx, "new@example.com")
+	if _, err := workspaces.GetDefault(ctx, user.ID); err != nil {
+		t.Errorf("expected default workspace, got %v", err)
+	}
+}
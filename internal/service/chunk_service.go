@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+var (
+	ErrInvalidChunks = errors.New("invalid chunked content")
+	ErrMissingChunks = errors.New("chunks are missing")
+)
+
+// MissingChunksError lists the chunks of a chunked write that were neither
+// uploaded nor stored for the note
+type MissingChunksError struct {
+	Hashes []string
+}
+
+func (e *MissingChunksError) Error() string {
+	return ErrMissingChunks.Error()
+}
+
+func (e *MissingChunksError) Unwrap() error {
+	return ErrMissingChunks
+}
+
+// ChunkService stores chunked note content. A device splits the content into
+// independently encrypted chunks and writes the ordered list of their hashes;
+// it only has to upload the chunks the note does not have yet.
+type ChunkService struct {
+	repo     repository.ChunkRepository
+	noteRepo repository.NoteRepository
+}
+
+func NewChunkService(repo repository.ChunkRepository, noteRepo repository.NoteRepository) *ChunkService {
+	return &ChunkService{
+		repo:     repo,
+		noteRepo: noteRepo,
+	}
+}
+
+// ChunkHash returns the address of a chunk holding data
+func ChunkHash(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// Missing returns the hashes the note has no chunk for
+func (s *ChunkService) Missing(ctx context.Context, userID, noteID string, hashes []string) ([]string, error) {
+	if err := s.checkOwner(ctx, userID, noteID); err != nil {
+		return nil, err
+	}
+
+	sizes, err := s.repo.Sizes(ctx, noteID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	for _, hash := range hashes {
+		if _, ok := sizes[hash]; !ok {
+			missing = append(missing, hash)
+		}
+	}
+	return missing, nil
+}
+
+// Fetch returns the chunks of the note among hashes. Hashes the note has no
+// chunk for are left out.
+func (s *ChunkService) Fetch(ctx context.Context, userID, noteID string, hashes []string) ([]*domain.ChunkData, error) {
+	if err := s.checkOwner(ctx, userID, noteID); err != nil {
+		return nil, err
+	}
+
+	chunks, err := s.repo.Get(ctx, noteID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.ChunkData, len(chunks))
+	for i, c := range chunks {
+		result[i] = &domain.ChunkData{Hash: c.Hash, Data: c.Data}
+	}
+	return result, nil
+}
+
+// prepare checks a chunked write to noteID and returns the uploaded chunks
+// to store together with the size of the content. Uploads that are not part
+// of manifest are dropped.
+func (s *ChunkService) prepare(ctx context.Context, noteID string, manifest []string, uploads []domain.ChunkData) ([]*domain.Chunk, int64, error) {
+	if len(manifest) == 0 {
+		return nil, 0, ErrInvalidChunks
+	}
+
+	listed := make(map[string]bool, len(manifest))
+	for _, hash := range manifest {
+		listed[hash] = true
+	}
+
+	now := time.Now().UTC()
+	sizes := make(map[string]int64, len(manifest))
+	var chunks []*domain.Chunk
+	for _, upload := range uploads {
+		if upload.Hash != ChunkHash(upload.Data) {
+			return nil, 0, ErrInvalidChunks
+		}
+		if _, seen := sizes[upload.Hash]; seen || !listed[upload.Hash] {
+			continue
+		}
+		sizes[upload.Hash] = int64(len(upload.Data))
+		chunks = append(chunks, &domain.Chunk{
+			NoteID:    noteID,
+			Hash:      upload.Hash,
+			Data:      upload.Data,
+			Size:      int64(len(upload.Data)),
+			CreatedAt: now,
+		})
+	}
+
+	var lookup []string
+	for hash := range listed {
+		if _, ok := sizes[hash]; !ok {
+			lookup = append(lookup, hash)
+		}
+	}
+	if len(lookup) > 0 {
+		stored, err := s.repo.Sizes(ctx, noteID, lookup)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var missing []string
+		for _, hash := range lookup {
+			size, ok := stored[hash]
+			if !ok {
+				missing = append(missing, hash)
+				continue
+			}
+			sizes[hash] = size
+		}
+		if len(missing) > 0 {
+			return nil, 0, &MissingChunksError{Hashes: missing}
+		}
+	}
+
+	var size int64
+	for _, hash := range manifest {
+		size += sizes[hash]
+	}
+	return chunks, size, nil
+}
+
+// prepareUpdate checks the chunked content of an update to noteID, if it has
+// any, and returns the uploaded chunks to store. req.ContentSize is set and
+// the uploads are dropped from req, so a conflict that records it only keeps
+// the list of hashes.
+func (s *ChunkService) prepareUpdate(ctx context.Context, noteID string, req *domain.UpdateNoteRequest) ([]*domain.Chunk, error) {
+	if req.Chunks == nil && len(req.NewChunks) == 0 {
+		req.ContentSize = 0
+		return nil, nil
+	}
+	if s == nil || req.EncryptedContent != nil {
+		return nil, ErrInvalidChunks
+	}
+
+	chunks, size, err := s.prepare(ctx, noteID, req.Chunks, req.NewChunks)
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentSize = size
+	req.NewChunks = nil
+	return chunks, nil
+}
+
+// save stores chunks returned by prepare. Having none to store is fine on a
+// nil service too.
+func (s *ChunkService) save(ctx context.Context, chunks []*domain.Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	return s.repo.Save(ctx, chunks)
+}
+
+// copyChunks stores the chunks in hashes of one note for another
+func (s *ChunkService) copyChunks(ctx context.Context, fromNoteID, toNoteID string, hashes []string) error {
+	chunks, err := s.repo.Get(ctx, fromNoteID, hashes)
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		c.NoteID = toNoteID
+	}
+	return s.save(ctx, chunks)
+}
+
+// store returns the chunk repository, or nil without a service
+func (s *ChunkService) store() repository.ChunkRepository {
+	if s == nil {
+		return nil
+	}
+	return s.repo
+}
+
+// chunkBytes returns the size of chunks
+func chunkBytes(chunks []*domain.Chunk) int64 {
+	var size int64
+	for _, c := range chunks {
+		size += c.Size
+	}
+	return size
+}
+
+func (s *ChunkService) checkOwner(ctx context.Context, userID, noteID string) error {
+	note, err := s.noteRepo.FindByID(ctx, noteID)
+	if err != nil {
+		return err
+	}
+	if note.UserID != userID {
+		return ErrNoteAccessDenied
+	}
+	return nil
+}
+
+// applyContent replaces the content of note with the whole or the chunked
+// content set in req
+func applyContent(note *domain.Note, req *domain.UpdateNoteRequest) {
+	switch {
+	case req.Chunks != nil:
+		note.EncryptedContent = ""
+		note.Chunks = req.Chunks
+		note.ContentSize = req.ContentSize
+	case req.EncryptedContent != nil:
+		note.EncryptedContent = *req.EncryptedContent
+		note.Chunks = nil
+		note.ContentSize = 0
+	}
+}
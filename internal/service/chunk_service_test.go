@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"inkdown-sync-server/internal/domain"
+)
+
+type mockChunkRepo struct {
+	chunks map[string]map[string]*domain.Chunk
+	saved  int
+}
+
+func newMockChunkRepo() *mockChunkRepo {
+	return &mockChunkRepo{chunks: make(map[string]map[string]*domain.Chunk)}
+}
+
+func (m *mockChunkRepo) Save(ctx context.Context, chunks []*domain.Chunk) error {
+	for _, c := range chunks {
+		if m.chunks[c.NoteID] == nil {
+			m.chunks[c.NoteID] = make(map[string]*domain.Chunk)
+		}
+		if _, ok := m.chunks[c.NoteID][c.Hash]; !ok {
+			m.chunks[c.NoteID][c.Hash] = c
+			m.saved++
+		}
+	}
+	return nil
+}
+
+func (m *mockChunkRepo) Get(ctx context.Context, noteID string, hashes []string) ([]*domain.Chunk, error) {
+	var chunks []*domain.Chunk
+	for _, hash := range hashes {
+		if c, ok := m.chunks[noteID][hash]; ok {
+			copied := *c
+			chunks = append(chunks, &copied)
+		}
+	}
+	return chunks, nil
+}
+
+func (m *mockChunkRepo) Sizes(ctx context.Context, noteID string, hashes []string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	for _, hash := range hashes {
+		if c, ok := m.chunks[noteID][hash]; ok {
+			sizes[hash] = c.Size
+		}
+	}
+	return sizes, nil
+}
+
+func (m *mockChunkRepo) TotalSize(ctx context.Context, noteID string) (int64, error) {
+	var total int64
+	for _, c := range m.chunks[noteID] {
+		total += c.Size
+	}
+	return total, nil
+}
+
+func (m *mockChunkRepo) DeleteAll(ctx context.Context, noteID string) error {
+	delete(m.chunks, noteID)
+	return nil
+}
+
+func chunkOf(data string) domain.ChunkData {
+	return domain.ChunkData{Hash: ChunkHash(data), Data: data}
+}
+
+func TestNoteService_ChunkedUpdate(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	chunks := newMockChunkRepo()
+	versions := &mockVersionStore{}
+	usageService := NewUsageService(newMockUsageRepo(), nil, repo, versions, nil, chunks, nil, QuotaLimits{})
+	service := NewNoteService(repo, versions, nil, nil, usageService, nil, NewChunkService(chunks, repo))
+
+	a, b, c := chunkOf("aaaa"), chunkOf("bbbb"), chunkOf("cc")
+	note, err := service.Create(ctx, "user1", &domain.CreateNoteRequest{
+		Type: domain.NoteTypeFile, EncryptedTitle: "t", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1",
+		Chunks:    []string{a.Hash, b.Hash},
+		NewChunks: []domain.ChunkData{a, b},
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if note.ContentSize != 8 || chunks.saved != 2 {
+		t.Fatalf("expected 8 bytes in 2 chunks, got %d bytes in %d", note.ContentSize, chunks.saved)
+	}
+
+	// Only the changed chunk is uploaded
+	updated, err := service.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{
+		DeviceID:  "d1",
+		Chunks:    []string{a.Hash, c.Hash},
+		NewChunks: []domain.ChunkData{c},
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if !slices.Equal(updated.Chunks, []string{a.Hash, c.Hash}) || updated.ContentSize != 6 || updated.EncryptedContent != "" {
+		t.Errorf("unexpected chunked note %+v", updated)
+	}
+	if chunks.saved != 3 {
+		t.Errorf("expected only the new chunk to be stored, got %d chunks", chunks.saved)
+	}
+	if len(versions.versions) != 1 || !slices.Equal(versions.versions[0].Chunks, []string{a.Hash, b.Hash}) {
+		t.Errorf("expected the previous version to keep its chunks, got %+v", versions.versions)
+	}
+
+	d := chunkOf("dd")
+	_, err = service.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{DeviceID: "d1", Chunks: []string{a.Hash, d.Hash}})
+	var missingErr *MissingChunksError
+	if !errors.As(err, &missingErr) || !slices.Equal(missingErr.Hashes, []string{d.Hash}) {
+		t.Errorf("expected %s to be missing, got %v", d.Hash, err)
+	}
+
+	content := "whole"
+	tests := []struct {
+		name string
+		req  *domain.UpdateNoteRequest
+	}{
+		{name: "hash mismatch", req: &domain.UpdateNoteRequest{Chunks: []string{d.Hash}, NewChunks: []domain.ChunkData{{Hash: d.Hash, Data: "other"}}}},
+		{name: "content and chunks", req: &domain.UpdateNoteRequest{EncryptedContent: &content, Chunks: []string{a.Hash}}},
+		{name: "empty list", req: &domain.UpdateNoteRequest{Chunks: []string{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.Update(ctx, "user1", note.ID, tt.req); !errors.Is(err, ErrInvalidChunks) {
+				t.Errorf("expected ErrInvalidChunks, got %v", err)
+			}
+		})
+	}
+
+	// Whole content replaces the chunks again
+	updated, err = service.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{DeviceID: "d1", EncryptedContent: &content})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if updated.Chunks != nil || updated.ContentSize != 0 || updated.EncryptedContent != content {
+		t.Errorf("expected whole content, got %+v", updated)
+	}
+
+	// Each chunk is counted once however many versions share it: the two
+	// version titles and the 10 chunk bytes the content no longer uses
+	recorded, _ := usageService.GetUsage(ctx, "user1")
+	if recorded.ContentBytes != 6 || recorded.VersionBytes != 12 {
+		t.Errorf("expected 6 content and 12 version bytes, got %d and %d", recorded.ContentBytes, recorded.VersionBytes)
+	}
+	reconciled, err := usageService.Reconcile(ctx, "user1")
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if reconciled.ContentBytes != recorded.ContentBytes || reconciled.VersionBytes != recorded.VersionBytes {
+		t.Errorf("expected reconciliation to agree, got %d and %d", reconciled.ContentBytes, reconciled.VersionBytes)
+	}
+}
+
+func TestChunkService_MissingAndFetch(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	chunks := newMockChunkRepo()
+	service := NewChunkService(chunks, repo)
+
+	a, b := chunkOf("aaaa"), chunkOf("bbbb")
+	repo.Create(ctx, &domain.Note{ID: "n1", UserID: "user1"})
+	chunks.Save(ctx, []*domain.Chunk{{NoteID: "n1", Hash: a.Hash, Data: a.Data, Size: 4}})
+
+	missing, err := service.Missing(ctx, "user1", "n1", []string{a.Hash, b.Hash})
+	if err != nil {
+		t.Fatalf("Missing failed: %v", err)
+	}
+	if !slices.Equal(missing, []string{b.Hash}) {
+		t.Errorf("expected only %s to be missing, got %v", b.Hash, missing)
+	}
+
+	fetched, err := service.Fetch(ctx, "user1", "n1", []string{a.Hash, b.Hash})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(fetched) != 1 || fetched[0].Data != a.Data {
+		t.Errorf("expected the stored chunk, got %+v", fetched)
+	}
+
+	if _, err := service.Fetch(ctx, "user2", "n1", []string{a.Hash}); !errors.Is(err, ErrNoteAccessDenied) {
+		t.Errorf("expected ErrNoteAccessDenied, got %v", err)
+	}
+}
+
+func TestArchiveService_ExportImportChunks(t *testing.T) {
+	ctx := context.Background()
+	notes := newMockNoteRepo()
+	chunks := newMockChunkRepo()
+	workspaces := newMockWorkspaceRepo()
+	workspaceService := NewWorkspaceService(workspaces, notes, nil, newMockJobRepo(), nil, nil, nil, nil, nil)
+	service := NewArchiveService(workspaceService, notes, &mockVersionRepo{}, chunks, nil)
+
+	a := chunkOf("aaaa")
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1", Name: "Notes"})
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Type: domain.NoteTypeFile, EncryptedTitle: "t", Chunks: []string{a.Hash}, ContentSize: 4})
+	chunks.Save(ctx, []*domain.Chunk{{NoteID: "n1", Hash: a.Hash, Data: a.Data, Size: 4}})
+
+	workspace, _ := workspaceService.Get(ctx, "user1", "ws1")
+	var archive bytes.Buffer
+	if err := service.Export(ctx, workspace, false, &archive); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	result, err := service.Import(ctx, "user2", "", &archive)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	imported, _ := notes.ListByWorkspace(ctx, result.Workspace.ID)
+	if len(imported) != 1 || !slices.Equal(imported[0].Chunks, []string{a.Hash}) || imported[0].ContentSize != 4 {
+		t.Fatalf("expected the chunked note to be imported, got %+v", imported)
+	}
+	if got, _ := chunks.Get(ctx, imported[0].ID, []string{a.Hash}); len(got) != 1 || got[0].Data != a.Data {
+		t.Errorf("expected the chunk to be stored for the imported note, got %+v", got)
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -43,9 +44,9 @@ func hashToken(token string) string {
 }
 
 // LoginAndCreateToken authenticates a user and creates a new CLI token
-func (s *CLITokenService) LoginAndCreateToken(req *domain.CLILoginRequest) (*domain.CreateCLITokenResponse, error) {
+func (s *CLITokenService) LoginAndCreateToken(ctx context.Context, req *domain.CLILoginRequest) (*domain.CreateCLITokenResponse, error) {
 	// Authenticate user
-	user, err := s.userRepo.FindByEmail(req.Email)
+	user, err := s.userRepo.FindByEmail(ctx, req.Email)
 	if err != nil {
 		return nil, fmt.Errorf("invalid credentials")
 	}
@@ -60,13 +61,13 @@ func (s *CLITokenService) LoginAndCreateToken(req *domain.CLILoginRequest) (*dom
 		Scopes: domain.DefaultCLIScopes(),
 	}
 
-	return s.CreateToken(user.ID, createReq)
+	return s.CreateToken(ctx, user.ID, createReq)
 }
 
 // CreateToken creates a new CLI token for a user (requires authentication)
-func (s *CLITokenService) CreateToken(userID string, req *domain.CreateCLITokenRequest) (*domain.CreateCLITokenResponse, error) {
+func (s *CLITokenService) CreateToken(ctx context.Context, userID string, req *domain.CreateCLITokenRequest) (*domain.CreateCLITokenResponse, error) {
 	// Verify user exists
-	_, err := s.userRepo.FindByID(userID)
+	_, err := s.userRepo.FindByID(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("user not found")
 	}
@@ -98,7 +99,7 @@ func (s *CLITokenService) CreateToken(userID string, req *domain.CreateCLITokenR
 		IsRevoked:   false,
 	}
 
-	if err := s.tokenRepo.Create(token); err != nil {
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
 		return nil, fmt.Errorf("failed to create token: %w", err)
 	}
 
@@ -114,12 +115,12 @@ func (s *CLITokenService) CreateToken(userID string, req *domain.CreateCLITokenR
 }
 
 // ValidateToken validates a CLI token and returns the associated user
-func (s *CLITokenService) ValidateToken(plainToken string) (*domain.User, *domain.CLIToken, error) {
+func (s *CLITokenService) ValidateToken(ctx context.Context, plainToken string) (*domain.User, *domain.CLIToken, error) {
 	// Hash the provided token
 	hashedToken := hashToken(plainToken)
 
 	// Find token in database
-	token, err := s.tokenRepo.FindByToken(hashedToken)
+	token, err := s.tokenRepo.FindByToken(ctx, hashedToken)
 	if err != nil {
 		return nil, nil, fmt.Errorf("invalid or revoked token")
 	}
@@ -130,7 +131,7 @@ func (s *CLITokenService) ValidateToken(plainToken string) (*domain.User, *domai
 	}
 
 	// Get user
-	user, err := s.userRepo.FindByID(token.UserID)
+	user, err := s.userRepo.FindByID(ctx, token.UserID)
 	if err != nil {
 		return nil, nil, fmt.Errorf("user not found")
 	}
@@ -142,8 +143,8 @@ func (s *CLITokenService) ValidateToken(plainToken string) (*domain.User, *domai
 }
 
 // ValidateTokenWithScope validates a token and checks for a specific scope
-func (s *CLITokenService) ValidateTokenWithScope(plainToken string, requiredScope string) (*domain.User, *domain.CLIToken, error) {
-	user, token, err := s.ValidateToken(plainToken)
+func (s *CLITokenService) ValidateTokenWithScope(ctx context.Context, plainToken string, requiredScope string) (*domain.User, *domain.CLIToken, error) {
+	user, token, err := s.ValidateToken(ctx, plainToken)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -165,13 +166,13 @@ func (s *CLITokenService) ValidateTokenWithScope(plainToken string, requiredScop
 }
 
 // UpdateLastUsed updates the last used timestamp and IP
-func (s *CLITokenService) UpdateLastUsed(tokenID string, ip string) error {
-	return s.tokenRepo.UpdateLastUsed(tokenID, ip)
+func (s *CLITokenService) UpdateLastUsed(ctx context.Context, tokenID string, ip string) error {
+	return s.tokenRepo.UpdateLastUsed(ctx, tokenID, ip)
 }
 
 // ListTokens returns all CLI tokens for a user (without the actual token values)
-func (s *CLITokenService) ListTokens(userID string) ([]*domain.CLITokenPublic, error) {
-	tokens, err := s.tokenRepo.FindByUserID(userID)
+func (s *CLITokenService) ListTokens(ctx context.Context, userID string) ([]*domain.CLITokenPublic, error) {
+	tokens, err := s.tokenRepo.FindByUserID(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list tokens: %w", err)
 	}
@@ -185,9 +186,9 @@ func (s *CLITokenService) ListTokens(userID string) ([]*domain.CLITokenPublic, e
 }
 
 // RevokeToken revokes a CLI token
-func (s *CLITokenService) RevokeToken(userID string, tokenID string) error {
+func (s *CLITokenService) RevokeToken(ctx context.Context, userID string, tokenID string) error {
 	// Verify token belongs to user
-	token, err := s.tokenRepo.FindByID(tokenID)
+	token, err := s.tokenRepo.FindByID(ctx, tokenID)
 	if err != nil {
 		return fmt.Errorf("token not found")
 	}
@@ -196,13 +197,13 @@ func (s *CLITokenService) RevokeToken(userID string, tokenID string) error {
 		return fmt.Errorf("token does not belong to user")
 	}
 
-	return s.tokenRepo.Revoke(tokenID)
+	return s.tokenRepo.Revoke(ctx, tokenID)
 }
 
 // DeleteToken permanently deletes a CLI token
-func (s *CLITokenService) DeleteToken(userID string, tokenID string) error {
+func (s *CLITokenService) DeleteToken(ctx context.Context, userID string, tokenID string) error {
 	// Verify token belongs to user
-	token, err := s.tokenRepo.FindByID(tokenID)
+	token, err := s.tokenRepo.FindByID(ctx, tokenID)
 	if err != nil {
 		return fmt.Errorf("token not found")
 	}
@@ -211,12 +212,12 @@ func (s *CLITokenService) DeleteToken(userID string, tokenID string) error {
 		return fmt.Errorf("token does not belong to user")
 	}
 
-	return s.tokenRepo.Delete(tokenID)
+	return s.tokenRepo.Delete(ctx, tokenID)
 }
 
 // GetToken returns a specific token (public info only)
-func (s *CLITokenService) GetToken(userID string, tokenID string) (*domain.CLITokenPublic, error) {
-	token, err := s.tokenRepo.FindByID(tokenID)
+func (s *CLITokenService) GetToken(ctx context.Context, userID string, tokenID string) (*domain.CLITokenPublic, error) {
+	token, err := s.tokenRepo.FindByID(ctx, tokenID)
 	if err != nil {
 		return nil, fmt.Errorf("token not found")
 	}
@@ -1,36 +1,82 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
 	"inkdown-sync-server/internal/repository"
+	"inkdown-sync-server/pkg/hlc"
 
 	"github.com/google/uuid"
 )
 
+// ErrInvalidResolution is returned for a strategy that does not apply to the
+// conflict's type
+var ErrInvalidResolution = errors.New("resolution strategy does not apply to this conflict")
+
+// ErrNoteChanged is returned when resolving a conflict in favour of the
+// losing write after the note was written again, which would drop that write
+var ErrNoteChanged = fmt.Errorf("note changed since the conflict was detected: %w", repository.ErrConflict)
+
+// ErrConflictResolved is returned when resolving a conflict that already was
+var ErrConflictResolved = fmt.Errorf("conflict is already resolved: %w", repository.ErrConflict)
+
+const (
+	DefaultConflictPageSize = 50
+	MaxConflictPageSize     = 200
+
+	// MaxMergeVersions bounds how many versions a conflict detail reads; a
+	// base further back than that is left out.
+	MaxMergeVersions = 500
+)
+
+// ConflictExpiryPolicy controls what happens to conflicts left unresolved for
+// longer than MaxAge: Action is ResolutionExpired to drop them or a
+// resolution strategy to apply. A zero MaxAge keeps them forever.
+type ConflictExpiryPolicy struct {
+	MaxAge time.Duration
+	Action domain.ResolutionStrategy
+}
+
 type ConflictService struct {
-	conflictRepo repository.ConflictRepository
-	versionRepo  repository.NoteVersionRepository
-	noteRepo     repository.NoteRepository
+	conflictRepo  repository.ConflictRepository
+	versionRepo   repository.NoteVersionRepository
+	noteRepo      repository.NoteRepository
+	workspaceRepo repository.WorkspaceRepository
+	syncService   *SyncService
+	chunkService  *ChunkService
+	usageService  *UsageService
+	expiry        ConflictExpiryPolicy
 }
 
 func NewConflictService(
 	conflictRepo repository.ConflictRepository,
 	versionRepo repository.NoteVersionRepository,
 	noteRepo repository.NoteRepository,
+	workspaceRepo repository.WorkspaceRepository,
+	syncService *SyncService,
+	chunkService *ChunkService,
+	usageService *UsageService,
+	expiry ConflictExpiryPolicy,
 ) *ConflictService {
 	return &ConflictService{
-		conflictRepo: conflictRepo,
-		versionRepo:  versionRepo,
-		noteRepo:     noteRepo,
+		conflictRepo:  conflictRepo,
+		versionRepo:   versionRepo,
+		noteRepo:      noteRepo,
+		workspaceRepo: workspaceRepo,
+		syncService:   syncService,
+		chunkService:  chunkService,
+		usageService:  usageService,
+		expiry:        expiry,
 	}
 }
 
-func (s *ConflictService) DetectConflict(noteID, userID, deviceID string, expectedVersion int64, updateReq *domain.UpdateNoteRequest) (*domain.Conflict, error) {
-	note, err := s.noteRepo.FindByID(noteID)
+func (s *ConflictService) DetectConflict(ctx context.Context, noteID, userID, deviceID string, expectedVersion int64, updateReq *domain.UpdateNoteRequest) (*domain.Conflict, error) {
+	note, err := s.noteRepo.FindByID(ctx, noteID)
 	if err != nil {
 		return nil, err
 	}
@@ -47,6 +93,7 @@ func (s *ConflictService) DetectConflict(noteID, userID, deviceID string, expect
 		ID:            uuid.New().String(),
 		NoteID:        noteID,
 		UserID:        userID,
+		WorkspaceID:   note.WorkspaceID,
 		Type:          domain.ConflictTypeUpdate,
 		BaseVersion:   expectedVersion,
 		ServerVersion: note.Version,
@@ -54,46 +101,124 @@ func (s *ConflictService) DetectConflict(noteID, userID, deviceID string, expect
 		ServerNote:    note,
 		ClientData:    updateReq,
 		DeviceID:      deviceID,
-		DetectedAt:    time.Now(),
+		DetectedAt:    time.Now().UTC(),
 	}
 
-	if err := s.conflictRepo.Create(conflict); err != nil {
+	if err := s.record(ctx, conflict); err != nil {
 		return nil, err
 	}
 
 	return conflict, nil
 }
 
-func (s *ConflictService) ResolveWithLWW(conflict *domain.Conflict) (*domain.Note, error) {
-	serverNote := conflict.ServerNote
+// DetectDeleteConflict records a conflict between a deletion and an edit of
+// note, which is the note as currently stored. req is the losing write: the
+// edit when the note was deleted first, or a request with IsDeleted set when
+// the note was edited first.
+func (s *ConflictService) DetectDeleteConflict(ctx context.Context, note *domain.Note, deviceID string, baseVersion int64, req *domain.UpdateNoteRequest) (*domain.Conflict, error) {
+	conflict := &domain.Conflict{
+		ID:            uuid.New().String(),
+		NoteID:        note.ID,
+		UserID:        note.UserID,
+		WorkspaceID:   note.WorkspaceID,
+		Type:          domain.ConflictTypeDelete,
+		BaseVersion:   baseVersion,
+		ServerVersion: note.Version,
+		ClientVersion: baseVersion + 1,
+		ServerNote:    note,
+		ClientData:    req,
+		DeviceID:      deviceID,
+		DetectedAt:    time.Now().UTC(),
+	}
 
-	if conflict.ClientData == nil {
-		return serverNote, nil
+	if err := s.record(ctx, conflict); err != nil {
+		return nil, err
 	}
 
-	clientUpdatedAt := time.Now()
-	if conflict.ClientData.ExpectedVersion != nil {
-		versions, err := s.versionRepo.GetVersions(conflict.NoteID, 10)
-		if err == nil && len(versions) > 0 {
-			for _, v := range versions {
-				if v.Version == *conflict.ClientData.ExpectedVersion {
-					clientUpdatedAt = v.CreatedAt
-					break
-				}
-			}
+	return conflict, nil
+}
+
+// record stores a detected conflict and notifies the user's devices. If the
+// workspace has a conflict policy it is applied first and the conflict is
+// stored already resolved. An open conflict keeps the losing write, so it is
+// charged to the user's quota until it is resolved.
+func (s *ConflictService) record(ctx context.Context, conflict *domain.Conflict) error {
+	note := s.autoResolve(ctx, conflict)
+
+	var size int64
+	if conflict.ResolvedAt == nil && s.usageService != nil {
+		size = conflictSize(conflict)
+		if err := s.usageService.CheckWrite(ctx, conflict.UserID, conflict.NoteWorkspaceID(), 0, size); err != nil {
+			return err
 		}
 	}
 
-	if serverNote.UpdatedAt.After(clientUpdatedAt) {
-		if err := s.conflictRepo.MarkResolved(conflict.ID, domain.ResolutionLWW); err != nil {
-			return nil, err
+	if err := s.conflictRepo.Create(ctx, conflict); err != nil {
+		return err
+	}
+
+	if size > 0 {
+		s.usageService.RecordConflict(ctx, conflict.UserID, size)
+	}
+
+	if s.syncService != nil {
+		s.syncService.BroadcastConflict(conflict.UserID, conflict)
+		if conflict.AutoResolved {
+			s.notifyResolved(conflict, note)
 		}
-		return serverNote, nil
 	}
 
-	if conflict.ClientData.EncryptedContent != nil {
-		serverNote.EncryptedContent = *conflict.ClientData.EncryptedContent
+	return nil
+}
+
+// autoResolve applies the conflict policy of the note's workspace to
+// conflict and returns the resulting note. The conflict stays unresolved
+// when there is no policy or it could not be applied.
+func (s *ConflictService) autoResolve(ctx context.Context, conflict *domain.Conflict) *domain.Note {
+	policy := s.policy(ctx, conflict.NoteWorkspaceID())
+	if policy == "" || policy == domain.ResolutionManual {
+		return nil
+	}
+
+	note, applied, err := s.resolve(ctx, conflict, policy, nil)
+	if err != nil {
+		log.Printf("failed to apply %s policy to conflict %s: %v", policy, conflict.ID, err)
+		return nil
+	}
+
+	now := time.Now()
+	conflict.ResolvedAt = &now
+	conflict.ResolutionChoice = applied
+	conflict.AutoResolved = true
+	if note != nil {
+		conflict.ResolvedVersion = note.Version
+	}
+	return note
+}
+
+// policy returns the conflict policy of a workspace, or "" when it has none
+// or cannot be read
+func (s *ConflictService) policy(ctx context.Context, workspaceID string) domain.ResolutionStrategy {
+	if s.workspaceRepo == nil || workspaceID == "" {
+		return ""
 	}
+
+	workspace, err := s.workspaceRepo.Get(ctx, workspaceID)
+	if err != nil {
+		log.Printf("failed to load conflict policy of workspace %s: %v", workspaceID, err)
+		return ""
+	}
+	return workspace.ConflictPolicy
+}
+
+// resolveWithLWW applies the losing write to serverNote if it is the later
+// edit of the two
+func (s *ConflictService) resolveWithLWW(ctx context.Context, conflict *domain.Conflict, serverNote *domain.Note) (*domain.Note, error) {
+	if conflict.ClientData == nil || !lostWriteNewer(conflict, serverNote) {
+		return serverNote, nil
+	}
+
+	applyContent(serverNote, conflict.ClientData)
 	if conflict.ClientData.EncryptedTitle != nil {
 		serverNote.EncryptedTitle = *conflict.ClientData.EncryptedTitle
 	}
@@ -104,46 +229,166 @@ func (s *ConflictService) ResolveWithLWW(conflict *domain.Conflict) (*domain.Not
 		serverNote.ContentHash = *conflict.ClientData.ContentHash
 	}
 
-	serverNote.UpdatedAt = time.Now()
+	stampEdit(serverNote, conflict.ClientData)
 	serverNote.Version++
 	serverNote.LastEditDevice = conflict.DeviceID
 
-	if err := s.noteRepo.Update(serverNote); err != nil {
+	if err := s.noteRepo.Update(ctx, serverNote); err != nil {
 		return nil, err
 	}
 
-	if err := s.conflictRepo.MarkResolved(conflict.ID, domain.ResolutionLWW); err != nil {
+	return serverNote, nil
+}
+
+// lostWriteNewer reports whether the write that lost conflict came after the
+// last change of note. Devices that send no edit time are ordered by when the
+// write arrived, and a deleted note by when it was deleted.
+func lostWriteNewer(conflict *domain.Conflict, note *domain.Note) bool {
+	clientEdit := editTimestamp(conflict.ClientData)
+	if clientEdit.IsZero() {
+		clientEdit = hlc.FromTime(conflict.DetectedAt)
+	}
+
+	if note.IsDeleted && note.DeletedAt != nil {
+		if deleted := hlc.FromTime(*note.DeletedAt); deleted.Compare(note.HLC) > 0 {
+			deletion := *note
+			deletion.HLC = deleted
+			note = &deletion
+		}
+	}
+
+	return newerEdit(clientEdit, conflict.DeviceID, note)
+}
+
+// ApplyResolution resolves a conflict with strategy and notifies the user's
+// devices of the resolution and of the resulting note. The conflict is
+// claimed before the note is written, so of two concurrent resolutions only
+// one applies; it is reopened if its resolution fails.
+func (s *ConflictService) ApplyResolution(ctx context.Context, conflictID string, strategy domain.ResolutionStrategy, noteData *domain.UpdateNoteRequest) (*domain.Note, error) {
+	conflict, err := s.conflictRepo.Get(ctx, conflictID)
+	if err != nil {
 		return nil, err
 	}
 
-	return serverNote, nil
+	if conflict.ResolvedAt != nil {
+		return nil, ErrConflictResolved
+	}
+
+	if err := s.conflictRepo.MarkResolved(ctx, conflictID, strategy); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, ErrConflictResolved
+		}
+		return nil, err
+	}
+
+	note, applied, err := s.resolve(ctx, conflict, strategy, noteData)
+	if err != nil {
+		if reopenErr := s.conflictRepo.UpdateResolution(ctx, conflictID, ""); reopenErr != nil {
+			log.Printf("failed to reopen conflict %s: %v", conflictID, reopenErr)
+		}
+		return nil, err
+	}
+
+	if applied != strategy {
+		if err := s.conflictRepo.UpdateResolution(ctx, conflictID, applied); err != nil {
+			log.Printf("failed to record %s resolution of conflict %s: %v", applied, conflictID, err)
+		}
+	}
+	s.release(ctx, conflict)
+
+	if s.syncService != nil {
+		conflict.ResolutionChoice = applied
+		s.notifyResolved(conflict, note)
+	}
+
+	return note, nil
+}
+
+// release returns the bytes an open conflict was charged once it is resolved
+func (s *ConflictService) release(ctx context.Context, conflict *domain.Conflict) {
+	if s.usageService != nil {
+		s.usageService.RecordConflict(ctx, conflict.UserID, -conflictSize(conflict))
+	}
 }
 
-func (s *ConflictService) ApplyResolution(conflictID string, strategy domain.ResolutionStrategy, noteData *domain.UpdateNoteRequest) (*domain.Note, error) {
-	conflict, err := s.conflictRepo.Get(conflictID)
+// notifyResolved sends the note a conflict was resolved into, if it changed,
+// and the resolution itself to the user's devices. After a duplicate
+// resolution the note is sent even if unchanged, since the device that lost
+// still shows its own edits under it.
+func (s *ConflictService) notifyResolved(conflict *domain.Conflict, note *domain.Note) {
+	send := note != nil && (note.Version != conflict.ServerVersion || conflict.ResolutionChoice == domain.ResolutionDuplicate)
+	if send {
+		if note.IsDeleted {
+			s.syncService.BroadcastNoteDelete(conflict.UserID, "", note.WorkspaceID, note.ID, note.Version)
+		} else {
+			s.syncService.BroadcastNoteUpdate(conflict.UserID, "", noteToResponse(note))
+		}
+	}
+	s.syncService.BroadcastConflictResolved(conflict.UserID, conflict, note)
+}
+
+// resolve writes the outcome of resolving conflict with strategy to the note
+// and returns the note together with the strategy that was applied. The
+// conflict itself is left for the caller to mark as resolved.
+func (s *ConflictService) resolve(ctx context.Context, conflict *domain.Conflict, strategy domain.ResolutionStrategy, noteData *domain.UpdateNoteRequest) (*domain.Note, domain.ResolutionStrategy, error) {
+	// Chunks uploaded with a manual resolution are stored for the note first
+	if strategy == domain.ResolutionManual && noteData != nil {
+		chunks, err := s.chunkService.prepareUpdate(ctx, conflict.NoteID, noteData)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := s.chunkService.save(ctx, chunks); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if conflict.Type == domain.ConflictTypeDelete {
+		return s.resolveDelete(ctx, conflict, strategy, noteData)
+	}
+
+	note, err := s.resolveUpdate(ctx, conflict, strategy, noteData)
+	return note, strategy, err
+}
+
+// resolveUpdate resolves a conflict between two edits against the note as
+// currently stored, so a write made since the conflict is never overwritten:
+// lww compares it with the losing write, and client and manual fail with
+// ErrNoteChanged once the note moved past the conflict's server version.
+func (s *ConflictService) resolveUpdate(ctx context.Context, conflict *domain.Conflict, strategy domain.ResolutionStrategy, noteData *domain.UpdateNoteRequest) (*domain.Note, error) {
+	if strategy == domain.ResolutionRestore || strategy == domain.ResolutionKeepDeleted {
+		return nil, ErrInvalidResolution
+	}
+
+	note, err := s.noteRepo.FindByID(ctx, conflict.NoteID)
 	if err != nil {
 		return nil, err
 	}
 
 	switch strategy {
 	case domain.ResolutionLWW:
-		return s.ResolveWithLWW(conflict)
+		return s.resolveWithLWW(ctx, conflict, note)
 
 	case domain.ResolutionServer:
-		if err := s.conflictRepo.MarkResolved(conflictID, domain.ResolutionServer); err != nil {
+		return note, nil
+
+	case domain.ResolutionDuplicate:
+		if conflict.ClientData == nil {
+			return nil, errors.New("no client data available")
+		}
+		if _, err := s.createCopy(ctx, conflict, note, conflict.ClientData); err != nil {
 			return nil, err
 		}
-		return conflict.ServerNote, nil
+		return note, nil
 
 	case domain.ResolutionClient:
 		if conflict.ClientData == nil {
 			return nil, errors.New("no client data available")
 		}
-
-		note := conflict.ServerNote
-		if conflict.ClientData.EncryptedContent != nil {
-			note.EncryptedContent = *conflict.ClientData.EncryptedContent
+		if note.Version != conflict.ServerVersion {
+			return nil, ErrNoteChanged
 		}
+
+		applyContent(note, conflict.ClientData)
 		if conflict.ClientData.EncryptedTitle != nil {
 			note.EncryptedTitle = *conflict.ClientData.EncryptedTitle
 		}
@@ -154,15 +399,11 @@ func (s *ConflictService) ApplyResolution(conflictID string, strategy domain.Res
 			note.ContentHash = *conflict.ClientData.ContentHash
 		}
 
-		note.UpdatedAt = time.Now()
+		stampEdit(note, conflict.ClientData)
 		note.Version++
 		note.LastEditDevice = conflict.DeviceID
 
-		if err := s.noteRepo.Update(note); err != nil {
-			return nil, err
-		}
-
-		if err := s.conflictRepo.MarkResolved(conflictID, domain.ResolutionClient); err != nil {
+		if err := s.noteRepo.Update(ctx, note); err != nil {
 			return nil, err
 		}
 
@@ -172,11 +413,11 @@ func (s *ConflictService) ApplyResolution(conflictID string, strategy domain.Res
 		if noteData == nil {
 			return nil, errors.New("manual resolution requires note data")
 		}
-
-		note := conflict.ServerNote
-		if noteData.EncryptedContent != nil {
-			note.EncryptedContent = *noteData.EncryptedContent
+		if note.Version != conflict.ServerVersion {
+			return nil, ErrNoteChanged
 		}
+
+		applyContent(note, noteData)
 		if noteData.EncryptedTitle != nil {
 			note.EncryptedTitle = *noteData.EncryptedTitle
 		}
@@ -187,15 +428,11 @@ func (s *ConflictService) ApplyResolution(conflictID string, strategy domain.Res
 			note.ContentHash = *noteData.ContentHash
 		}
 
-		note.UpdatedAt = time.Now()
+		stampEdit(note, noteData)
 		note.Version++
 		note.LastEditDevice = noteData.DeviceID
 
-		if err := s.noteRepo.Update(note); err != nil {
-			return nil, err
-		}
-
-		if err := s.conflictRepo.MarkResolved(conflictID, domain.ResolutionManual); err != nil {
+		if err := s.noteRepo.Update(ctx, note); err != nil {
 			return nil, err
 		}
 
@@ -206,14 +443,308 @@ func (s *ConflictService) ApplyResolution(conflictID string, strategy domain.Res
 	}
 }
 
-func (s *ConflictService) Get(conflictID string) (*domain.Conflict, error) {
-	return s.conflictRepo.Get(conflictID)
+// resolveDelete resolves a conflict between a deletion and an edit against
+// the note as currently stored. client applies the losing write and lww
+// applies it only if it is the later one; server leaves the note as it is
+// and manual restores the note with noteData. Like in resolveUpdate,
+// strategies other than lww that write the note fail with ErrNoteChanged
+// once the note moved past the conflict's server version.
+func (s *ConflictService) resolveDelete(ctx context.Context, conflict *domain.Conflict, strategy domain.ResolutionStrategy, noteData *domain.UpdateNoteRequest) (*domain.Note, domain.ResolutionStrategy, error) {
+	note, err := s.noteRepo.FindByID(ctx, conflict.NoteID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	edits := conflict.ClientData
+	deviceID := conflict.DeviceID
+	lostDelete := edits != nil && edits.IsDeleted != nil && *edits.IsDeleted
+	// lww compares against the stored note, so it never drops a newer write
+	changed := note.Version != conflict.ServerVersion && strategy != domain.ResolutionLWW
+	switch strategy {
+	case domain.ResolutionLWW:
+		strategy = domain.ResolutionServer
+		if lostWriteNewer(conflict, note) {
+			strategy = domain.ResolutionRestore
+			if lostDelete {
+				strategy = domain.ResolutionKeepDeleted
+			}
+		}
+	case domain.ResolutionClient:
+		strategy = domain.ResolutionRestore
+		if lostDelete {
+			strategy = domain.ResolutionKeepDeleted
+		}
+	case domain.ResolutionManual:
+		if noteData == nil {
+			return nil, "", errors.New("manual resolution requires note data")
+		}
+		edits = noteData
+		deviceID = noteData.DeviceID
+	case domain.ResolutionDuplicate:
+		// A deletion that lost leaves no edits to copy
+		if edits == nil || lostDelete {
+			strategy = domain.ResolutionServer
+		}
+	}
+
+	switch strategy {
+	case domain.ResolutionServer:
+		// The stored note already is the server side.
+
+	case domain.ResolutionDuplicate:
+		// The note stays in the trash and the edits move to a copy
+		if _, err := s.createCopy(ctx, conflict, note, edits); err != nil {
+			return nil, "", err
+		}
+
+	case domain.ResolutionRestore, domain.ResolutionManual:
+		if changed {
+			return nil, "", ErrNoteChanged
+		}
+
+		note.IsDeleted = false
+		note.DeletedAt = nil
+		if edits != nil {
+			applyEdits(note, edits)
+		}
+		stampEdit(note, edits)
+		note.Version++
+		note.LastEditDevice = deviceID
+
+		if err := s.noteRepo.Update(ctx, note); err != nil {
+			return nil, "", err
+		}
+
+	case domain.ResolutionKeepDeleted:
+		if changed {
+			return nil, "", ErrNoteChanged
+		}
+
+		if !note.IsDeleted {
+			now := time.Now()
+			deletedAt := now.UTC()
+			note.IsDeleted = true
+			note.DeletedAt = &deletedAt
+			note.UpdatedAt = now
+			note.Version++
+			note.LastEditDevice = deviceID
+
+			if err := s.noteRepo.Update(ctx, note); err != nil {
+				return nil, "", err
+			}
+		}
+
+	default:
+		return nil, "", ErrInvalidResolution
+	}
+
+	return note, strategy, nil
+}
+
+// applyEdits copies the content fields set in req onto note
+func applyEdits(note *domain.Note, req *domain.UpdateNoteRequest) {
+	applyContent(note, req)
+	if req.EncryptedTitle != nil {
+		note.EncryptedTitle = *req.EncryptedTitle
+	}
+	if req.EncryptionAlgo != nil {
+		note.EncryptionAlgo = *req.EncryptionAlgo
+	}
+	if req.Nonce != nil {
+		note.Nonce = *req.Nonce
+	}
+	if req.ContentHash != nil {
+		note.ContentHash = *req.ContentHash
+	}
+}
+
+// createCopy stores the edits of the device whose write lost conflict as a
+// new note next to source, named and placed like it, and sends it to the
+// user's devices
+func (s *ConflictService) createCopy(ctx context.Context, conflict *domain.Conflict, source *domain.Note, edits *domain.UpdateNoteRequest) (*domain.Note, error) {
+	now := time.Now()
+	copied := &domain.Note{
+		ID:               uuid.New().String(),
+		UserID:           source.UserID,
+		WorkspaceID:      source.WorkspaceID,
+		ParentID:         source.ParentID,
+		Type:             source.Type,
+		EncryptedTitle:   source.EncryptedTitle,
+		EncryptedContent: source.EncryptedContent,
+		EncryptionAlgo:   source.EncryptionAlgo,
+		Nonce:            source.Nonce,
+		Chunks:           source.Chunks,
+		ContentSize:      source.ContentSize,
+		ContentHash:      source.ContentHash,
+		CreatedAt:        now,
+		Version:          1,
+		LastEditDevice:   conflict.DeviceID,
+		ConflictID:       conflict.ID,
+	}
+	applyEdits(copied, edits)
+	stampEdit(copied, edits)
+
+	// Chunks belong to a note, so the copy gets its own
+	if len(copied.Chunks) > 0 {
+		if s.chunkService == nil {
+			return nil, ErrInvalidChunks
+		}
+		if err := s.chunkService.copyChunks(ctx, conflict.NoteID, copied.ID, copied.Chunks); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.noteRepo.Create(ctx, copied); err != nil {
+		return nil, err
+	}
+
+	if s.syncService != nil {
+		s.syncService.BroadcastNoteUpdate(conflict.UserID, "", noteToResponse(copied))
+	}
+
+	return copied, nil
 }
 
-func (s *ConflictService) ListByUser(userID string) ([]*domain.Conflict, error) {
-	return s.conflictRepo.ListByUser(userID)
+func (s *ConflictService) Get(ctx context.Context, conflictID string) (*domain.Conflict, error) {
+	return s.conflictRepo.Get(ctx, conflictID)
+}
+
+// Detail returns a conflict together with its base version and the versions
+// the server stored between the base and the server side of the conflict
+func (s *ConflictService) Detail(ctx context.Context, conflictID string) (*domain.ConflictDetail, error) {
+	conflict, err := s.conflictRepo.Get(ctx, conflictID)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &domain.ConflictDetail{Conflict: conflict, Intermediate: []*domain.NoteVersion{}}
+	if s.versionRepo == nil || conflict.ServerVersion <= conflict.BaseVersion {
+		return detail, nil
+	}
+
+	// Versions are listed newest first and the note may have moved on since
+	// the conflict was detected, so the window starts at its current version.
+	latest := conflict.ServerVersion
+	if note, err := s.noteRepo.FindByID(ctx, conflict.NoteID); err == nil && note.Version > latest {
+		latest = note.Version
+	}
+	limit := min(latest-conflict.BaseVersion, MaxMergeVersions)
+
+	versions, err := s.versionRepo.GetVersions(ctx, conflict.NoteID, int(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		switch {
+		case v.Version == conflict.BaseVersion:
+			detail.Base = v
+		case v.Version > conflict.BaseVersion && v.Version < conflict.ServerVersion:
+			detail.Intermediate = append(detail.Intermediate, v)
+		}
+	}
+
+	return detail, nil
+}
+
+func (s *ConflictService) ListByUser(ctx context.Context, userID string) ([]*domain.Conflict, error) {
+	return s.conflictRepo.ListByUser(ctx, userID)
+}
+
+// List returns a page of the user's conflicts matching filter, newest first,
+// and whether more follow it
+func (s *ConflictService) List(ctx context.Context, userID string, filter domain.ConflictFilter) ([]*domain.Conflict, bool, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultConflictPageSize
+	}
+	if filter.Limit > MaxConflictPageSize {
+		filter.Limit = MaxConflictPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	conflicts, err := s.conflictRepo.List(ctx, userID, filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(conflicts) > limit {
+		return conflicts[:limit], true, nil
+	}
+	return conflicts, false, nil
+}
+
+// ExpireStale applies the expiry policy to every conflict that has been
+// unresolved for longer than its MaxAge and returns how many it handled
+func (s *ConflictService) ExpireStale(ctx context.Context) (int, error) {
+	if s.expiry.MaxAge <= 0 {
+		return 0, nil
+	}
+
+	conflicts, err := s.conflictRepo.ListUnresolvedBefore(ctx, time.Now().Add(-s.expiry.MaxAge))
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, conflict := range conflicts {
+		if err := ctx.Err(); err != nil {
+			return expired, err
+		}
+		if err := s.expire(ctx, conflict); err != nil {
+			log.Printf("failed to expire conflict %s: %v", conflict.ID, err)
+			continue
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+// expire applies the expiry action to conflict. A conflict whose note was
+// written again since is only marked expired, as the action would overwrite
+// the newer write.
+func (s *ConflictService) expire(ctx context.Context, conflict *domain.Conflict) error {
+	if s.expiry.Action != domain.ResolutionExpired {
+		_, err := s.ApplyResolution(ctx, conflict.ID, s.expiry.Action, nil)
+		if !errors.Is(err, ErrNoteChanged) {
+			return err
+		}
+	}
+
+	if err := s.conflictRepo.MarkResolved(ctx, conflict.ID, domain.ResolutionExpired); err != nil {
+		return err
+	}
+	s.release(ctx, conflict)
+
+	if s.syncService != nil {
+		conflict.ResolutionChoice = domain.ResolutionExpired
+		s.syncService.BroadcastConflictResolved(conflict.UserID, conflict, nil)
+	}
+	return nil
+}
+
+// RunExpirer periodically expires stale conflicts until ctx is cancelled
+func (s *ConflictService) RunExpirer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.ExpireStale(ctx); err != nil {
+				log.Printf("conflict expiry failed: %v", err)
+			}
+		}
+	}
 }
 
-func (s *ConflictService) ListByNote(noteID string) ([]*domain.Conflict, error) {
-	return s.conflictRepo.ListByNote(noteID)
+func (s *ConflictService) ListByNote(ctx context.Context, noteID string) ([]*domain.Conflict, error) {
+	return s.conflictRepo.ListByNote(ctx, noteID)
 }
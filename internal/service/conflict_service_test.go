@@ -0,0 +1,731 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+	"inkdown-sync-server/pkg/hlc"
+)
+
+type mockConflictRepo struct {
+	mu        sync.Mutex
+	conflicts map[string]*domain.Conflict
+}
+
+func newMockConflictRepo() *mockConflictRepo {
+	return &mockConflictRepo{conflicts: make(map[string]*domain.Conflict)}
+}
+
+func (m *mockConflictRepo) Create(ctx context.Context, conflict *domain.Conflict) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.conflicts[conflict.ID] = conflict
+	return nil
+}
+
+func (m *mockConflictRepo) Get(ctx context.Context, conflictID string) (*domain.Conflict, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.conflicts[conflictID]; ok {
+		found := *c
+		return &found, nil
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *mockConflictRepo) ListByUser(ctx context.Context, userID string) ([]*domain.Conflict, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var conflicts []*domain.Conflict
+	for _, c := range m.conflicts {
+		if c.UserID == userID {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts, nil
+}
+
+func (m *mockConflictRepo) ListByNote(ctx context.Context, noteID string) ([]*domain.Conflict, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var conflicts []*domain.Conflict
+	for _, c := range m.conflicts {
+		if c.NoteID == noteID {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts, nil
+}
+
+func (m *mockConflictRepo) List(ctx context.Context, userID string, filter domain.ConflictFilter) ([]*domain.Conflict, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matched []*domain.Conflict
+	for _, c := range m.conflicts {
+		if c.UserID == userID && filter.Matches(c) {
+			matched = append(matched, c)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].DetectedAt.After(matched[j].DetectedAt) })
+
+	if filter.Offset >= len(matched) {
+		return nil, nil
+	}
+	matched = matched[filter.Offset:]
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched, nil
+}
+
+func (m *mockConflictRepo) ListUnresolvedBefore(ctx context.Context, cutoff time.Time) ([]*domain.Conflict, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var conflicts []*domain.Conflict
+	for _, c := range m.conflicts {
+		if c.ResolvedAt == nil && c.DetectedAt.Before(cutoff) {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts, nil
+}
+
+func (m *mockConflictRepo) MarkResolved(ctx context.Context, conflictID string, choice domain.ResolutionStrategy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.conflicts[conflictID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if c.ResolvedAt != nil {
+		return repository.ErrConflict
+	}
+	now := time.Now()
+	c.ResolvedAt = &now
+	c.ResolutionChoice = choice
+	return nil
+}
+
+func (m *mockConflictRepo) UpdateResolution(ctx context.Context, conflictID string, choice domain.ResolutionStrategy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.conflicts[conflictID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if choice == "" {
+		c.ResolvedAt = nil
+	}
+	c.ResolutionChoice = choice
+	return nil
+}
+
+func (m *mockConflictRepo) Reassign(ctx context.Context, noteID, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.conflicts {
+		if c.NoteID == noteID {
+			c.UserID = userID
+		}
+	}
+	return nil
+}
+
+func (m *mockConflictRepo) Delete(ctx context.Context, conflictID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.conflicts[conflictID]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(m.conflicts, conflictID)
+	return nil
+}
+
+func newTestConflictServices() (*mockNoteRepo, *mockConflictRepo, *ConflictService, *NoteService) {
+	repo := newMockNoteRepo()
+	versionRepo := &mockVersionRepo{}
+	conflicts := newMockConflictRepo()
+	conflictService := NewConflictService(conflicts, versionRepo, repo, nil, nil, nil, nil, ConflictExpiryPolicy{})
+	return repo, conflicts, conflictService, NewNoteService(repo, versionRepo, conflictService, nil, nil, nil, nil)
+}
+
+func TestConflictService_EditAfterDelete(t *testing.T) {
+	ctx := context.Background()
+	repo, conflicts, conflictService, noteService := newTestConflictServices()
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	if err := noteService.Delete(ctx, "user1", note.ID, "d1", nil); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// d2 edits the note based on the version before the deletion
+	newTitle := "edited"
+	baseVersion := note.Version
+	_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &newTitle, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if conflictErr.Conflict.Type != domain.ConflictTypeDelete {
+		t.Fatalf("expected delete conflict, got %s", conflictErr.Conflict.Type)
+	}
+
+	if _, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionRestore, nil); err != nil {
+		t.Fatalf("ApplyResolution failed: %v", err)
+	}
+
+	restored, _ := repo.FindByID(ctx, note.ID)
+	if restored.IsDeleted || restored.EncryptedTitle != newTitle || restored.Version != 3 {
+		t.Errorf("expected restored note with edits at version 3, got %+v", restored)
+	}
+	if conflicts.conflicts[conflictErr.Conflict.ID].ResolutionChoice != domain.ResolutionRestore {
+		t.Error("expected conflict to be marked resolved")
+	}
+}
+
+func TestConflictService_DeleteAfterEdit(t *testing.T) {
+	ctx := context.Background()
+	repo, _, conflictService, noteService := newTestConflictServices()
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	newTitle := "edited"
+	if _, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &newTitle, DeviceID: "d1"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// d2 deletes the note without having seen the edit
+	baseVersion := note.Version
+	err := noteService.Delete(ctx, "user1", note.ID, "d2", &baseVersion)
+
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if conflictErr.Conflict.Type != domain.ConflictTypeDelete {
+		t.Fatalf("expected delete conflict, got %s", conflictErr.Conflict.Type)
+	}
+	if current, _ := repo.FindByID(ctx, note.ID); current.IsDeleted {
+		t.Fatal("expected note to stay until the conflict is resolved")
+	}
+
+	if _, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionManual, nil); err == nil {
+		t.Error("expected manual resolution without note data to fail")
+	}
+
+	resolved, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionClient, nil)
+	if err != nil {
+		t.Fatalf("ApplyResolution failed: %v", err)
+	}
+	if !resolved.IsDeleted || resolved.Version != 3 {
+		t.Errorf("expected client resolution to delete the note at version 3, got %+v", resolved)
+	}
+}
+
+func TestConflictService_ResolveAfterNoteChanged(t *testing.T) {
+	ctx := context.Background()
+	repo, _, conflictService, noteService := newTestConflictServices()
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	edited := "edited"
+	if _, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &edited, DeviceID: "d1"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	lost := "lost"
+	baseVersion := note.Version
+	_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &lost, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+
+	// The note is written again before the conflict is resolved
+	newest := "newest"
+	if _, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &newest, DeviceID: "d1"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	_, err = conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionClient, nil)
+	if !errors.Is(err, ErrNoteChanged) || !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrNoteChanged, got %v", err)
+	}
+
+	resolved, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionLWW, nil)
+	if err != nil {
+		t.Fatalf("ApplyResolution failed: %v", err)
+	}
+	if resolved.EncryptedTitle != newest || resolved.Version != 3 {
+		t.Errorf("expected the newest write to win, got %+v", resolved)
+	}
+	if current, _ := repo.FindByID(ctx, note.ID); current.EncryptedTitle != newest {
+		t.Errorf("expected the newest write to be kept, got %q", current.EncryptedTitle)
+	}
+}
+
+func TestConflictService_ResolveDeleteAfterNoteChanged(t *testing.T) {
+	ctx := context.Background()
+	repo, _, conflictService, noteService := newTestConflictServices()
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	if err := noteService.Delete(ctx, "user1", note.ID, "d1", nil); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	lost := "lost"
+	baseVersion := note.Version
+	_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &lost, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+
+	// d1 restores and edits the note before the conflict is resolved
+	newest := "newest"
+	restore := false
+	if _, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &newest, IsDeleted: &restore, DeviceID: "d1"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	for _, strategy := range []domain.ResolutionStrategy{domain.ResolutionRestore, domain.ResolutionKeepDeleted, domain.ResolutionClient} {
+		if _, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, strategy, nil); !errors.Is(err, ErrNoteChanged) {
+			t.Errorf("expected ErrNoteChanged for %s, got %v", strategy, err)
+		}
+	}
+	if _, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionManual, &domain.UpdateNoteRequest{EncryptedTitle: &lost, DeviceID: "d2"}); !errors.Is(err, ErrNoteChanged) {
+		t.Errorf("expected ErrNoteChanged for manual, got %v", err)
+	}
+
+	if current, _ := repo.FindByID(ctx, note.ID); current.IsDeleted || current.EncryptedTitle != newest {
+		t.Errorf("expected the newest write to be kept, got %+v", current)
+	}
+}
+
+func TestConflictService_ConcurrentResolutions(t *testing.T) {
+	ctx := context.Background()
+	repo, _, conflictService, noteService := newTestConflictServices()
+
+	parentID := "dir1"
+	repo.Create(ctx, &domain.Note{ID: parentID, UserID: "user1", Type: domain.NoteTypeDirectory, Version: 1})
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{ParentID: &parentID, Type: domain.NoteTypeFile, EncryptedTitle: "title", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	serverContent := "server"
+	noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedContent: &serverContent, DeviceID: "d1"})
+
+	clientContent := "client"
+	baseVersion := note.Version
+	_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedContent: &clientContent, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionDuplicate, nil)
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrConflictResolved):
+			t.Errorf("expected ErrConflictResolved, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected exactly one resolution to apply, got %d", succeeded)
+	}
+
+	if children, _ := repo.ListChildren(ctx, parentID); len(children) != 2 {
+		t.Errorf("expected the note and a single conflicted copy, got %d notes", len(children))
+	}
+}
+
+func TestConflictService_DeleteStrategyOnUpdateConflict(t *testing.T) {
+	ctx := context.Background()
+	_, conflicts, conflictService, _ := newTestConflictServices()
+
+	conflicts.Create(ctx, &domain.Conflict{ID: "c1", NoteID: "n1", UserID: "user1", Type: domain.ConflictTypeUpdate})
+
+	if _, err := conflictService.ApplyResolution(ctx, "c1", domain.ResolutionKeepDeleted, nil); !errors.Is(err, ErrInvalidResolution) {
+		t.Errorf("expected ErrInvalidResolution, got %v", err)
+	}
+}
+
+func TestConflictService_List(t *testing.T) {
+	ctx := context.Background()
+	_, conflicts, conflictService, _ := newTestConflictServices()
+
+	now := time.Now()
+	resolvedAt := now
+	for i := 0; i < 5; i++ {
+		c := &domain.Conflict{
+			ID:          fmt.Sprintf("c%d", i),
+			NoteID:      "n1",
+			UserID:      "user1",
+			WorkspaceID: "ws1",
+			DeviceID:    "d1",
+			DetectedAt:  now.Add(time.Duration(i) * time.Minute),
+		}
+		if i == 0 {
+			c.ResolvedAt = &resolvedAt
+		}
+		conflicts.Create(ctx, c)
+	}
+	conflicts.Create(ctx, &domain.Conflict{ID: "other-ws", UserID: "user1", WorkspaceID: "ws2", DetectedAt: now})
+	conflicts.Create(ctx, &domain.Conflict{ID: "other-user", UserID: "user2", WorkspaceID: "ws1", DetectedAt: now})
+
+	page, hasMore, err := conflictService.List(ctx, "user1", domain.ConflictFilter{UnresolvedOnly: true, WorkspaceID: "ws1", Limit: 3})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page) != 3 || !hasMore || page[0].ID != "c4" {
+		t.Fatalf("expected newest 3 of 4 unresolved conflicts, got %d (has_more %v)", len(page), hasMore)
+	}
+
+	page, hasMore, _ = conflictService.List(ctx, "user1", domain.ConflictFilter{UnresolvedOnly: true, WorkspaceID: "ws1", Limit: 3, Offset: 3})
+	if len(page) != 1 || hasMore || page[0].ID != "c1" {
+		t.Errorf("expected last unresolved conflict c1, got %d (has_more %v)", len(page), hasMore)
+	}
+}
+
+func TestConflictService_ExpireStale(t *testing.T) {
+	ctx := context.Background()
+	repo, conflicts, _, noteService := newTestConflictServices()
+	conflictService := NewConflictService(conflicts, &mockVersionRepo{}, repo, nil, nil, nil, nil, ConflictExpiryPolicy{
+		MaxAge: time.Hour,
+		Action: domain.ResolutionExpired,
+	})
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	stored, _ := repo.FindByID(ctx, note.ID)
+
+	conflicts.Create(ctx, &domain.Conflict{ID: "stale", NoteID: note.ID, UserID: "user1", ServerNote: stored, DetectedAt: time.Now().Add(-2 * time.Hour)})
+	conflicts.Create(ctx, &domain.Conflict{ID: "fresh", NoteID: note.ID, UserID: "user1", ServerNote: stored, DetectedAt: time.Now()})
+
+	expired, err := conflictService.ExpireStale(ctx)
+	if err != nil {
+		t.Fatalf("ExpireStale failed: %v", err)
+	}
+	if expired != 1 {
+		t.Fatalf("expected 1 expired conflict, got %d", expired)
+	}
+	if conflicts.conflicts["stale"].ResolutionChoice != domain.ResolutionExpired {
+		t.Error("expected stale conflict to be marked expired")
+	}
+	if conflicts.conflicts["fresh"].ResolvedAt != nil {
+		t.Error("expected fresh conflict to stay unresolved")
+	}
+	if current, _ := repo.FindByID(ctx, note.ID); current.Version != 1 {
+		t.Errorf("expected note to be left alone, got version %d", current.Version)
+	}
+}
+
+func TestConflictService_ExpireAfterNoteChanged(t *testing.T) {
+	ctx := context.Background()
+	repo, conflicts, _, noteService := newTestConflictServices()
+	conflictService := NewConflictService(conflicts, &mockVersionRepo{}, repo, nil, nil, nil, nil, ConflictExpiryPolicy{
+		MaxAge: time.Hour,
+		Action: domain.ResolutionClient,
+	})
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	stored, _ := repo.FindByID(ctx, note.ID)
+	lost := "lost"
+	conflicts.Create(ctx, &domain.Conflict{ID: "stale", NoteID: note.ID, UserID: "user1", Type: domain.ConflictTypeUpdate, ServerVersion: stored.Version, ServerNote: stored, ClientData: &domain.UpdateNoteRequest{EncryptedTitle: &lost}, DetectedAt: time.Now().Add(-2 * time.Hour)})
+
+	newest := "newest"
+	if _, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &newest, DeviceID: "d1"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	if expired, err := conflictService.ExpireStale(ctx); err != nil || expired != 1 {
+		t.Fatalf("expected 1 expired conflict, got %d (%v)", expired, err)
+	}
+	if conflicts.conflicts["stale"].ResolutionChoice != domain.ResolutionExpired {
+		t.Errorf("expected the conflict to only be marked expired, got %q", conflicts.conflicts["stale"].ResolutionChoice)
+	}
+	if current, _ := repo.FindByID(ctx, note.ID); current.EncryptedTitle != newest || current.Version != 2 {
+		t.Errorf("expected the newest write to be kept, got %+v", current)
+	}
+
+	if _, err := conflictService.ApplyResolution(ctx, "stale", domain.ResolutionServer, nil); !errors.Is(err, ErrConflictResolved) {
+		t.Errorf("expected ErrConflictResolved, got %v", err)
+	}
+}
+
+func TestConflictService_WorkspacePolicy(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	versionRepo := &mockVersionRepo{}
+	conflicts := newMockConflictRepo()
+	workspaces := newMockWorkspaceRepo()
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1", ConflictPolicy: domain.ResolutionClient})
+	conflictService := NewConflictService(conflicts, versionRepo, repo, workspaces, nil, nil, nil, ConflictExpiryPolicy{})
+	noteService := NewNoteService(repo, versionRepo, conflictService, nil, nil, nil, nil)
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{WorkspaceID: "ws1", Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	serverTitle := "server"
+	noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &serverTitle, DeviceID: "d1"})
+
+	// d2 edits the first version; the workspace policy lets it win
+	clientTitle := "client"
+	baseVersion := note.Version
+	resolved, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &clientTitle, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+	if err != nil {
+		t.Fatalf("expected conflict to be resolved by policy, got %v", err)
+	}
+	if resolved.EncryptedTitle != clientTitle || resolved.Version != 3 {
+		t.Errorf("expected client title at version 3, got %q at version %d", resolved.EncryptedTitle, resolved.Version)
+	}
+	if resolved.ResolvedConflict == nil || !resolved.ResolvedConflict.AutoResolved {
+		t.Fatal("expected response to carry the auto-resolved conflict")
+	}
+
+	conflict := conflicts.conflicts[resolved.ResolvedConflict.ID]
+	if conflict.ResolvedAt == nil || conflict.ResolutionChoice != domain.ResolutionClient || conflict.ResolvedVersion != 3 {
+		t.Errorf("expected conflict recorded as resolved with client at version 3, got %+v", conflict)
+	}
+	if conflict.ServerNote.EncryptedTitle != serverTitle {
+		t.Errorf("expected audit copy of server note to be kept, got %q", conflict.ServerNote.EncryptedTitle)
+	}
+
+	// Without a policy the conflict is left to the user
+	workspaces.workspaces["ws1"].ConflictPolicy = domain.ResolutionManual
+	stale := int64(1)
+	_, err = noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &clientTitle, ExpectedVersion: &stale, DeviceID: "d2"})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError under manual policy, got %v", err)
+	}
+}
+
+func TestConflictService_ResolveWithLWW(t *testing.T) {
+	ctx := context.Background()
+	serverEdit := hlc.Timestamp{Wall: 1718000000000, Logical: 2}
+
+	tests := []struct {
+		name       string
+		clientEdit hlc.Timestamp
+		deviceID   string
+		wantTitle  string
+	}{
+		{name: "older client edit", clientEdit: hlc.Timestamp{Wall: 1717000000000}, deviceID: "d2", wantTitle: "server"},
+		{name: "newer client edit", clientEdit: hlc.Timestamp{Wall: 1718000000000, Logical: 3}, deviceID: "d0", wantTitle: "client"},
+		{name: "tie won by higher device ID", clientEdit: serverEdit, deviceID: "d2", wantTitle: "client"},
+		{name: "tie lost by lower device ID", clientEdit: serverEdit, deviceID: "d0", wantTitle: "server"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, conflicts, conflictService, noteService := newTestConflictServices()
+
+			note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+			serverTitle := "server"
+			noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &serverTitle, HLC: serverEdit, DeviceID: "d1"})
+
+			clientTitle := "client"
+			baseVersion := note.Version
+			_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &clientTitle, ExpectedVersion: &baseVersion, HLC: tt.clientEdit, DeviceID: tt.deviceID})
+			var conflictErr *ConflictError
+			if !errors.As(err, &conflictErr) {
+				t.Fatalf("expected ConflictError, got %v", err)
+			}
+
+			resolved, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionLWW, nil)
+			if err != nil {
+				t.Fatalf("ApplyResolution failed: %v", err)
+			}
+			if resolved.EncryptedTitle != tt.wantTitle {
+				t.Errorf("expected %s to win, got %q", tt.wantTitle, resolved.EncryptedTitle)
+			}
+			if tt.wantTitle == "client" && resolved.HLC != tt.clientEdit {
+				t.Errorf("expected note to carry the client edit's HLC, got %v", resolved.HLC)
+			}
+			if conflicts.conflicts[conflictErr.Conflict.ID].ResolutionChoice != domain.ResolutionLWW {
+				t.Error("expected conflict to be marked resolved with lww")
+			}
+			if stored, _ := repo.FindByID(ctx, note.ID); stored.EncryptedTitle != tt.wantTitle {
+				t.Errorf("expected stored title %q, got %q", tt.wantTitle, stored.EncryptedTitle)
+			}
+		})
+	}
+}
+
+func TestConflictService_ResolveDeleteWithLWW(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		clientEdit  time.Duration // relative to the deletion
+		wantDeleted bool
+		wantChoice  domain.ResolutionStrategy
+	}{
+		{name: "edit made before the deletion", clientEdit: -time.Hour, wantDeleted: true, wantChoice: domain.ResolutionServer},
+		{name: "edit made after the deletion", clientEdit: time.Hour, wantDeleted: false, wantChoice: domain.ResolutionRestore},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, conflicts, conflictService, noteService := newTestConflictServices()
+
+			note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+			if err := noteService.Delete(ctx, "user1", note.ID, "d1", nil); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			deleted, _ := repo.FindByID(ctx, note.ID)
+
+			clientTitle := "client"
+			editedAt := deleted.DeletedAt.Add(tt.clientEdit)
+			baseVersion := note.Version
+			_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &clientTitle, ExpectedVersion: &baseVersion, EditedAt: &editedAt, DeviceID: "d2"})
+			var conflictErr *ConflictError
+			if !errors.As(err, &conflictErr) {
+				t.Fatalf("expected ConflictError, got %v", err)
+			}
+
+			resolved, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionLWW, nil)
+			if err != nil {
+				t.Fatalf("ApplyResolution failed: %v", err)
+			}
+			if resolved.IsDeleted != tt.wantDeleted {
+				t.Errorf("expected deleted=%v, got %+v", tt.wantDeleted, resolved)
+			}
+			if choice := conflicts.conflicts[conflictErr.Conflict.ID].ResolutionChoice; choice != tt.wantChoice {
+				t.Errorf("expected conflict resolved with %s, got %s", tt.wantChoice, choice)
+			}
+		})
+	}
+}
+
+func TestConflictService_ResolveDuplicate(t *testing.T) {
+	ctx := context.Background()
+	repo, conflicts, conflictService, noteService := newTestConflictServices()
+
+	parentID := "dir1"
+	repo.Create(ctx, &domain.Note{ID: parentID, UserID: "user1", WorkspaceID: "ws1", Type: domain.NoteTypeDirectory, Version: 1})
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{WorkspaceID: "ws1", ParentID: &parentID, Type: domain.NoteTypeFile, EncryptedTitle: "title", EncryptedContent: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	serverContent := "server"
+	noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedContent: &serverContent, DeviceID: "d1"})
+
+	clientContent := "client"
+	baseVersion := note.Version
+	_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedContent: &clientContent, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+
+	resolved, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionDuplicate, nil)
+	if err != nil {
+		t.Fatalf("ApplyResolution failed: %v", err)
+	}
+	if resolved.EncryptedContent != serverContent || resolved.Version != 2 {
+		t.Errorf("expected server note to be kept at version 2, got %q at version %d", resolved.EncryptedContent, resolved.Version)
+	}
+	if conflicts.conflicts[conflictErr.Conflict.ID].ResolutionChoice != domain.ResolutionDuplicate {
+		t.Error("expected conflict to be marked resolved with duplicate")
+	}
+
+	siblings, _ := repo.ListChildren(ctx, parentID)
+	var copied *domain.Note
+	for _, n := range siblings {
+		if n.ID != note.ID {
+			copied = n
+		}
+	}
+	if copied == nil {
+		t.Fatal("expected a conflicted copy next to the note")
+	}
+	if copied.EncryptedContent != clientContent || copied.EncryptedTitle != "title" || copied.LastEditDevice != "d2" {
+		t.Errorf("expected copy with the client's edits, got %+v", copied)
+	}
+	if copied.ConflictID != conflictErr.Conflict.ID || copied.Version != 1 {
+		t.Errorf("expected new copy linked to conflict, got conflict %q at version %d", copied.ConflictID, copied.Version)
+	}
+}
+
+// mockVersionStore keeps the versions saved through it, newest first
+type mockVersionStore struct {
+	mockVersionRepo
+	versions []*domain.NoteVersion
+}
+
+func (m *mockVersionStore) SaveVersion(ctx context.Context, note *domain.Note) error {
+	m.versions = append([]*domain.NoteVersion{{NoteID: note.ID, Version: note.Version, EncryptedTitle: note.EncryptedTitle, EncryptedContent: note.EncryptedContent, Chunks: note.Chunks}}, m.versions...)
+	return nil
+}
+
+func (m *mockVersionStore) GetVersions(ctx context.Context, noteID string, limit int) ([]*domain.NoteVersion, error) {
+	var versions []*domain.NoteVersion
+	for _, v := range m.versions {
+		if v.NoteID == noteID && len(versions) < limit {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func TestConflictService_Detail(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	versionRepo := &mockVersionStore{}
+	conflictService := NewConflictService(newMockConflictRepo(), versionRepo, repo, nil, nil, nil, nil, ConflictExpiryPolicy{})
+	noteService := NewNoteService(repo, versionRepo, conflictService, nil, nil, nil, nil)
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "title", EncryptedContent: "v1", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	for _, content := range []string{"v2", "v3", "v4"} {
+		noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedContent: &content, DeviceID: "d1"})
+	}
+
+	// d2 edits version 2 while the server is at version 4
+	clientContent := "client"
+	baseVersion := int64(2)
+	_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedContent: &clientContent, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+
+	// The note moving on after detection must not change the detail
+	v5 := "v5"
+	noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedContent: &v5, DeviceID: "d1"})
+
+	detail, err := conflictService.Detail(ctx, conflictErr.Conflict.ID)
+	if err != nil {
+		t.Fatalf("Detail failed: %v", err)
+	}
+	if detail.Base == nil || detail.Base.EncryptedContent != "v2" {
+		t.Fatalf("expected base version 2, got %+v", detail.Base)
+	}
+	if len(detail.Intermediate) != 1 || detail.Intermediate[0].EncryptedContent != "v3" {
+		t.Errorf("expected version 3 as the only intermediate version, got %d versions", len(detail.Intermediate))
+	}
+	if detail.ServerVersion != 4 || *detail.ClientData.EncryptedContent != clientContent {
+		t.Error("expected detail to carry both sides of the conflict")
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+var (
+	ErrCRDTUpdateTooLarge = errors.New("crdt update exceeds maximum size")
+	ErrInvalidSnapshot    = errors.New("snapshot does not match the update log")
+)
+
+// crdtPageSize bounds how many updates State returns at once
+const crdtPageSize = 500
+
+// CRDTPolicy controls the CRDT update log. A device is asked for a snapshot
+// every SnapshotEvery updates; zero disables the requests. Updates larger
+// than MaxUpdateBytes are rejected unless it is zero.
+type CRDTPolicy struct {
+	SnapshotEvery  int
+	MaxUpdateBytes int64
+}
+
+// CRDTService relays encrypted CRDT updates between the devices editing a
+// note and keeps them in a log that snapshots uploaded by devices compact.
+type CRDTService struct {
+	repo             repository.CRDTRepository
+	noteRepo         repository.NoteRepository
+	workspaceService *WorkspaceService
+	syncService      *SyncService
+	usageService     *UsageService
+	policy           CRDTPolicy
+}
+
+func NewCRDTService(
+	repo repository.CRDTRepository,
+	noteRepo repository.NoteRepository,
+	workspaceService *WorkspaceService,
+	syncService *SyncService,
+	usageService *UsageService,
+	policy CRDTPolicy,
+) *CRDTService {
+	return &CRDTService{
+		repo:             repo,
+		noteRepo:         noteRepo,
+		workspaceService: workspaceService,
+		syncService:      syncService,
+		usageService:     usageService,
+		policy:           policy,
+	}
+}
+
+// Publish appends an update made on deviceID to the note's log and relays it
+// to the user's other devices. The log is charged to the note's owner as
+// version bytes until a snapshot compacts it.
+func (s *CRDTService) Publish(ctx context.Context, userID, deviceID, noteID, data string) (*domain.CRDTUpdate, error) {
+	size := int64(len(data))
+	if s.policy.MaxUpdateBytes > 0 && size > s.policy.MaxUpdateBytes {
+		return nil, ErrCRDTUpdateTooLarge
+	}
+
+	note, err := s.writableNote(ctx, userID, noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.usageService != nil {
+		if err := s.usageService.CheckWrite(ctx, userID, note.WorkspaceID, 0, size); err != nil {
+			return nil, err
+		}
+	}
+
+	update := &domain.CRDTUpdate{
+		NoteID:    noteID,
+		UserID:    userID,
+		DeviceID:  deviceID,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.repo.AppendUpdate(ctx, update); err != nil {
+		return nil, err
+	}
+
+	if s.usageService != nil {
+		s.usageService.RecordWrite(ctx, userID, note.WorkspaceID, 0, size)
+	}
+
+	if s.syncService != nil {
+		s.syncService.BroadcastCRDTUpdate(userID, deviceID, note.WorkspaceID, update)
+
+		if s.policy.SnapshotEvery > 0 && update.Seq%int64(s.policy.SnapshotEvery) == 0 {
+			s.syncService.RequestCRDTSnapshot(userID, deviceID, note.WorkspaceID, noteID, update.Seq)
+		}
+	}
+
+	return update, nil
+}
+
+// State returns what a device that has applied every update up to afterSeq
+// is missing. Updates already compacted come as the snapshot that replaced
+// them.
+func (s *CRDTService) State(ctx context.Context, userID, noteID string, afterSeq int64) (*domain.CRDTState, error) {
+	if _, err := s.ownedNote(ctx, userID, noteID); err != nil {
+		return nil, err
+	}
+
+	latest, err := s.repo.LatestSeq(ctx, noteID)
+	if err != nil {
+		return nil, err
+	}
+	state := &domain.CRDTState{NoteID: noteID, Updates: []*domain.CRDTUpdate{}, LatestSeq: latest}
+
+	snapshot, err := s.repo.GetSnapshot(ctx, noteID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if snapshot != nil && afterSeq < snapshot.Seq {
+		state.Snapshot = snapshot
+		afterSeq = snapshot.Seq
+	}
+
+	updates, err := s.repo.ListUpdates(ctx, noteID, afterSeq, crdtPageSize+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(updates) > crdtPageSize {
+		updates = updates[:crdtPageSize]
+		state.HasMore = true
+	}
+	state.Updates = append(state.Updates, updates...)
+
+	return state, nil
+}
+
+// SaveSnapshot stores the state of a note after every update up to seq and
+// drops those updates, releasing their bytes. A snapshot older than the
+// stored one is ignored.
+func (s *CRDTService) SaveSnapshot(ctx context.Context, userID, deviceID, noteID string, seq int64, data string) error {
+	note, err := s.writableNote(ctx, userID, noteID)
+	if err != nil {
+		return err
+	}
+
+	latest, err := s.repo.LatestSeq(ctx, noteID)
+	if err != nil {
+		return err
+	}
+	if seq <= 0 || seq > latest {
+		return ErrInvalidSnapshot
+	}
+
+	current, err := s.repo.GetSnapshot(ctx, noteID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	if current != nil && current.Seq >= seq {
+		return nil
+	}
+
+	delta, err := s.snapshotDelta(ctx, current, noteID, seq, data)
+	if err != nil {
+		return err
+	}
+	if s.usageService != nil {
+		if err := s.usageService.CheckWrite(ctx, userID, note.WorkspaceID, int64(len(data)), delta); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repo.SaveSnapshot(ctx, &domain.CRDTSnapshot{
+		NoteID:    noteID,
+		Seq:       seq,
+		DeviceID:  deviceID,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		return err
+	}
+
+	if s.usageService != nil {
+		s.usageService.RecordWrite(ctx, userID, note.WorkspaceID, 0, delta)
+	}
+	return nil
+}
+
+// snapshotDelta returns how many bytes replacing current and the updates up
+// to seq with a snapshot of data adds to the note's log
+func (s *CRDTService) snapshotDelta(ctx context.Context, current *domain.CRDTSnapshot, noteID string, seq int64, data string) (int64, error) {
+	delta := int64(len(data))
+
+	var afterSeq int64
+	if current != nil {
+		afterSeq = current.Seq
+		delta -= int64(len(current.Data))
+	}
+
+	compacted, err := s.repo.ListUpdates(ctx, noteID, afterSeq, int(seq-afterSeq))
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range compacted {
+		if u.Seq <= seq {
+			delta -= int64(len(u.Data))
+		}
+	}
+	return delta, nil
+}
+
+func (s *CRDTService) ownedNote(ctx context.Context, userID, noteID string) (*domain.Note, error) {
+	note, err := s.noteRepo.FindByID(ctx, noteID)
+	if err != nil {
+		return nil, err
+	}
+	if note.UserID != userID {
+		return nil, ErrNoteAccessDenied
+	}
+	return note, nil
+}
+
+// writableNote returns the note if the user may edit it
+func (s *CRDTService) writableNote(ctx context.Context, userID, noteID string) (*domain.Note, error) {
+	note, err := s.ownedNote(ctx, userID, noteID)
+	if err != nil {
+		return nil, err
+	}
+	if note.IsDeleted {
+		return nil, ErrNoteDeleted
+	}
+
+	if s.workspaceService != nil {
+		if err := s.workspaceService.EnsureWritable(ctx, note.WorkspaceID); err != nil {
+			return nil, err
+		}
+	}
+
+	return note, nil
+}
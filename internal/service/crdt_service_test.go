@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type mockCRDTRepo struct {
+	updates   map[string][]*domain.CRDTUpdate
+	snapshots map[string]*domain.CRDTSnapshot
+}
+
+func newMockCRDTRepo() *mockCRDTRepo {
+	return &mockCRDTRepo{
+		updates:   make(map[string][]*domain.CRDTUpdate),
+		snapshots: make(map[string]*domain.CRDTSnapshot),
+	}
+}
+
+func (m *mockCRDTRepo) AppendUpdate(ctx context.Context, update *domain.CRDTUpdate) error {
+	latest, _ := m.LatestSeq(ctx, update.NoteID)
+	update.Seq = latest + 1
+	stored := *update
+	m.updates[update.NoteID] = append(m.updates[update.NoteID], &stored)
+	return nil
+}
+
+func (m *mockCRDTRepo) ListUpdates(ctx context.Context, noteID string, afterSeq int64, limit int) ([]*domain.CRDTUpdate, error) {
+	var updates []*domain.CRDTUpdate
+	for _, u := range m.updates[noteID] {
+		if u.Seq > afterSeq && len(updates) < limit {
+			updates = append(updates, u)
+		}
+	}
+	return updates, nil
+}
+
+func (m *mockCRDTRepo) LatestSeq(ctx context.Context, noteID string) (int64, error) {
+	var latest int64
+	if s, ok := m.snapshots[noteID]; ok {
+		latest = s.Seq
+	}
+	if updates := m.updates[noteID]; len(updates) > 0 {
+		latest = max(latest, updates[len(updates)-1].Seq)
+	}
+	return latest, nil
+}
+
+func (m *mockCRDTRepo) GetSnapshot(ctx context.Context, noteID string) (*domain.CRDTSnapshot, error) {
+	if s, ok := m.snapshots[noteID]; ok {
+		return s, nil
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *mockCRDTRepo) SaveSnapshot(ctx context.Context, snapshot *domain.CRDTSnapshot) error {
+	m.snapshots[snapshot.NoteID] = snapshot
+	var kept []*domain.CRDTUpdate
+	for _, u := range m.updates[snapshot.NoteID] {
+		if u.Seq > snapshot.Seq {
+			kept = append(kept, u)
+		}
+	}
+	m.updates[snapshot.NoteID] = kept
+	return nil
+}
+
+func (m *mockCRDTRepo) Reassign(ctx context.Context, noteID, userID string) error {
+	for _, u := range m.updates[noteID] {
+		u.UserID = userID
+	}
+	return nil
+}
+
+func (m *mockCRDTRepo) TotalSize(ctx context.Context, noteID string) (int64, error) {
+	var total int64
+	for _, u := range m.updates[noteID] {
+		total += int64(len(u.Data))
+	}
+	if s, ok := m.snapshots[noteID]; ok {
+		total += int64(len(s.Data))
+	}
+	return total, nil
+}
+
+func (m *mockCRDTRepo) DeleteAll(ctx context.Context, noteID string) error {
+	delete(m.updates, noteID)
+	delete(m.snapshots, noteID)
+	return nil
+}
+
+func TestCRDTService_Publish(t *testing.T) {
+	ctx := context.Background()
+	notes := newMockNoteRepo()
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	notes.Create(ctx, &domain.Note{ID: "trashed", UserID: "user1", WorkspaceID: "ws1", IsDeleted: true})
+	service := NewCRDTService(newMockCRDTRepo(), notes, nil, nil, nil, CRDTPolicy{MaxUpdateBytes: 8})
+
+	for i := int64(1); i <= 2; i++ {
+		update, err := service.Publish(ctx, "user1", "d1", "n1", "delta")
+		if err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if update.Seq != i {
+			t.Errorf("expected seq %d, got %d", i, update.Seq)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		noteID  string
+		data    string
+		wantErr error
+	}{
+		{name: "other user", userID: "user2", noteID: "n1", data: "delta", wantErr: ErrNoteAccessDenied},
+		{name: "deleted note", userID: "user1", noteID: "trashed", data: "delta", wantErr: ErrNoteDeleted},
+		{name: "too large", userID: "user1", noteID: "n1", data: "a much larger delta", wantErr: ErrCRDTUpdateTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.Publish(ctx, tt.userID, "d1", tt.noteID, tt.data); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCRDTService_SnapshotCompaction(t *testing.T) {
+	ctx := context.Background()
+	notes := newMockNoteRepo()
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	service := NewCRDTService(newMockCRDTRepo(), notes, nil, nil, nil, CRDTPolicy{})
+
+	for i := 0; i < 5; i++ {
+		service.Publish(ctx, "user1", "d1", "n1", "delta")
+	}
+
+	if err := service.SaveSnapshot(ctx, "user1", "d1", "n1", 6, "state"); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("expected ErrInvalidSnapshot beyond the log, got %v", err)
+	}
+	if err := service.SaveSnapshot(ctx, "user1", "d1", "n1", 3, "state"); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+
+	// A device that stopped at update 1 gets the snapshot and what follows it
+	state, err := service.State(ctx, "user1", "n1", 1)
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	if state.Snapshot == nil || state.Snapshot.Seq != 3 {
+		t.Fatalf("expected snapshot at 3, got %+v", state.Snapshot)
+	}
+	if len(state.Updates) != 2 || state.Updates[0].Seq != 4 || state.LatestSeq != 5 {
+		t.Errorf("expected updates 4 and 5, got %d updates up to %d", len(state.Updates), state.LatestSeq)
+	}
+
+	// A device past the snapshot only gets the updates it is missing
+	state, _ = service.State(ctx, "user1", "n1", 4)
+	if state.Snapshot != nil || len(state.Updates) != 1 || state.Updates[0].Seq != 5 {
+		t.Errorf("expected only update 5, got snapshot %v and %d updates", state.Snapshot != nil, len(state.Updates))
+	}
+
+	if _, err := service.State(ctx, "user2", "n1", 0); !errors.Is(err, ErrNoteAccessDenied) {
+		t.Errorf("expected ErrNoteAccessDenied, got %v", err)
+	}
+}
+
+func TestCRDTService_ChargesUsage(t *testing.T) {
+	ctx := context.Background()
+	notes := newMockNoteRepo()
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	crdt := newMockCRDTRepo()
+	usageRepo := newMockUsageRepo()
+	usageService := NewUsageService(usageRepo, nil, notes, nil, nil, nil, crdt, QuotaLimits{PerUser: 20})
+	service := NewCRDTService(crdt, notes, nil, nil, usageService, CRDTPolicy{})
+
+	for i := 0; i < 3; i++ {
+		if _, err := service.Publish(ctx, "user1", "d1", "n1", "delta"); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	if got := usageRepo.usage["user1"].Workspaces["ws1"].VersionBytes; got != 15 {
+		t.Errorf("expected 15 bytes for the update log, got %d", got)
+	}
+
+	// The snapshot replaces the two updates it covers
+	if err := service.SaveSnapshot(ctx, "user1", "d1", "n1", 2, "state!"); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	if got := usageRepo.usage["user1"].VersionBytes; got != 11 {
+		t.Errorf("expected 11 bytes after compaction, got %d", got)
+	}
+
+	var quotaErr *QuotaExceededError
+	if _, err := service.Publish(ctx, "user1", "d1", "n1", "over-the-quota"); !errors.As(err, &quotaErr) {
+		t.Errorf("expected QuotaExceededError, got %v", err)
+	}
+
+	usage, err := usageService.Reconcile(ctx, "user1")
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if usage.VersionBytes != 11 {
+		t.Errorf("expected reconcile to count 11 bytes of CRDT log, got %d", usage.VersionBytes)
+	}
+}
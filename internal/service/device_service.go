@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -20,7 +21,7 @@ func NewDeviceService(repo repository.DeviceRepository) *DeviceService {
 	}
 }
 
-func (s *DeviceService) Register(userID string, req *domain.RegisterDeviceRequest) (*domain.DeviceResponse, error) {
+func (s *DeviceService) Register(ctx context.Context, userID string, req *domain.RegisterDeviceRequest) (*domain.DeviceResponse, error) {
 	// TODO: Check if device limit is reached (optional future feature)
 
 	deviceID := uuid.New().String()
@@ -38,7 +39,7 @@ func (s *DeviceService) Register(userID string, req *domain.RegisterDeviceReques
 		IsRevoked:  false,
 	}
 
-	if err := s.repo.Create(device); err != nil {
+	if err := s.repo.Create(ctx, device); err != nil {
 		return nil, err
 	}
 
@@ -52,8 +53,8 @@ func (s *DeviceService) Register(userID string, req *domain.RegisterDeviceReques
 	}, nil
 }
 
-func (s *DeviceService) List(userID string) ([]*domain.DeviceResponse, error) {
-	devices, err := s.repo.List(userID)
+func (s *DeviceService) List(ctx context.Context, userID string) ([]*domain.DeviceResponse, error) {
+	devices, err := s.repo.List(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -73,9 +74,9 @@ func (s *DeviceService) List(userID string) ([]*domain.DeviceResponse, error) {
 	return responses, nil
 }
 
-func (s *DeviceService) Revoke(userID, deviceID string) error {
+func (s *DeviceService) Revoke(ctx context.Context, userID, deviceID string) error {
 	// Verify device belongs to user
-	device, err := s.repo.FindByID(deviceID)
+	device, err := s.repo.FindByID(ctx, deviceID)
 	if err != nil {
 		return err
 	}
@@ -84,9 +85,9 @@ func (s *DeviceService) Revoke(userID, deviceID string) error {
 		return errors.New("unauthorized: device does not belong to user")
 	}
 
-	return s.repo.Revoke(deviceID)
+	return s.repo.Revoke(ctx, deviceID)
 }
 
-func (s *DeviceService) UpdateLastActive(deviceID string) error {
-	return s.repo.UpdateLastActive(deviceID)
+func (s *DeviceService) UpdateLastActive(ctx context.Context, deviceID string) error {
+	return s.repo.UpdateLastActive(ctx, deviceID)
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -18,7 +19,7 @@ func newMockDeviceRepo() *mockDeviceRepo {
 	}
 }
 
-func (m *mockDeviceRepo) Create(device *domain.Device) error {
+func (m *mockDeviceRepo) Create(ctx context.Context, device *domain.Device) error {
 	if _, exists := m.devices[device.ID]; exists {
 		return errors.New("device already exists")
 	}
@@ -26,7 +27,7 @@ func (m *mockDeviceRepo) Create(device *domain.Device) error {
 	return nil
 }
 
-func (m *mockDeviceRepo) List(userID string) ([]*domain.Device, error) {
+func (m *mockDeviceRepo) List(ctx context.Context, userID string) ([]*domain.Device, error) {
 	var devices []*domain.Device
 	for _, d := range m.devices {
 		if d.UserID == userID {
@@ -36,14 +37,14 @@ func (m *mockDeviceRepo) List(userID string) ([]*domain.Device, error) {
 	return devices, nil
 }
 
-func (m *mockDeviceRepo) FindByID(deviceID string) (*domain.Device, error) {
+func (m *mockDeviceRepo) FindByID(ctx context.Context, deviceID string) (*domain.Device, error) {
 	if d, exists := m.devices[deviceID]; exists {
 		return d, nil
 	}
 	return nil, errors.New("device not found")
 }
 
-func (m *mockDeviceRepo) Revoke(deviceID string) error {
+func (m *mockDeviceRepo) Revoke(ctx context.Context, deviceID string) error {
 	if d, exists := m.devices[deviceID]; exists {
 		d.IsRevoked = true
 		return nil
@@ -51,7 +52,7 @@ func (m *mockDeviceRepo) Revoke(deviceID string) error {
 	return errors.New("device not found")
 }
 
-func (m *mockDeviceRepo) UpdateLastActive(deviceID string) error {
+func (m *mockDeviceRepo) UpdateLastActive(ctx context.Context, deviceID string) error {
 	if d, exists := m.devices[deviceID]; exists {
 		d.LastActive = time.Now()
 		return nil
@@ -60,6 +61,7 @@ func (m *mockDeviceRepo) UpdateLastActive(deviceID string) error {
 }
 
 func TestDeviceService_Register(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockDeviceRepo()
 	service := NewDeviceService(repo)
 
@@ -70,7 +72,7 @@ func TestDeviceService_Register(t *testing.T) {
 		AppVersion: "1.0.0",
 	}
 
-	resp, err := service.Register("user1", req)
+	resp, err := service.Register(ctx, "user1", req)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -84,14 +86,15 @@ func TestDeviceService_Register(t *testing.T) {
 }
 
 func TestDeviceService_List(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockDeviceRepo()
 	service := NewDeviceService(repo)
 
-	repo.Create(&domain.Device{ID: "d1", UserID: "user1", Name: "D1"})
-	repo.Create(&domain.Device{ID: "d2", UserID: "user1", Name: "D2"})
-	repo.Create(&domain.Device{ID: "d3", UserID: "user2", Name: "D3"})
+	repo.Create(ctx, &domain.Device{ID: "d1", UserID: "user1", Name: "D1"})
+	repo.Create(ctx, &domain.Device{ID: "d2", UserID: "user1", Name: "D2"})
+	repo.Create(ctx, &domain.Device{ID: "d3", UserID: "user2", Name: "D3"})
 
-	list, err := service.List("user1")
+	list, err := service.List(ctx, "user1")
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -102,22 +105,23 @@ func TestDeviceService_List(t *testing.T) {
 }
 
 func TestDeviceService_Revoke(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockDeviceRepo()
 	service := NewDeviceService(repo)
 
-	repo.Create(&domain.Device{ID: "d1", UserID: "user1", Name: "D1"})
+	repo.Create(ctx, &domain.Device{ID: "d1", UserID: "user1", Name: "D1"})
 
-	err := service.Revoke("user1", "d1")
+	err := service.Revoke(ctx, "user1", "d1")
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
 
-	d, _ := repo.FindByID("d1")
+	d, _ := repo.FindByID(ctx, "d1")
 	if !d.IsRevoked {
 		t.Error("expected device to be revoked")
 	}
 
-	err = service.Revoke("user2", "d1")
+	err = service.Revoke(ctx, "user2", "d1")
 	if err == nil {
 		t.Error("expected unauthorized error")
 	}
@@ -1,6 +1,13 @@
 package service
 
-import "inkdown-sync-server/internal/domain"
+import (
+	"errors"
+	"fmt"
+
+	"inkdown-sync-server/internal/domain"
+)
+
+var ErrNoteTooLarge = errors.New("note exceeds maximum size")
 
 type ConflictError struct {
 	Conflict *domain.Conflict
@@ -9,3 +16,14 @@ type ConflictError struct {
 func (e *ConflictError) Error() string {
 	return "conflict detected"
 }
+
+type QuotaExceededError struct {
+	Scope     string `json:"scope"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Requested int64  `json:"requested"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s storage quota exceeded: %d of %d bytes used, %d requested", e.Scope, e.Used, e.Limit, e.Requested)
+}
@@ -1,20 +1,30 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
 	"inkdown-sync-server/internal/repository"
+	"inkdown-sync-server/pkg/hlc"
 
 	"github.com/google/uuid"
 )
 
+var (
+	ErrNoteAccessDenied = errors.New("unauthorized: note does not belong to user")
+	ErrNoteDeleted      = errors.New("note is deleted")
+)
+
 type NoteService struct {
-	repo            repository.NoteRepository
-	versionRepo     repository.NoteVersionRepository
-	conflictService *ConflictService
-	syncService     *SyncService
+	repo             repository.NoteRepository
+	versionRepo      repository.NoteVersionRepository
+	conflictService  *ConflictService
+	syncService      *SyncService
+	usageService     *UsageService
+	workspaceService *WorkspaceService
+	chunkService     *ChunkService
 }
 
 func NewNoteService(
@@ -22,23 +32,46 @@ func NewNoteService(
 	versionRepo repository.NoteVersionRepository,
 	conflictService *ConflictService,
 	syncService *SyncService,
+	usageService *UsageService,
+	workspaceService *WorkspaceService,
+	chunkService *ChunkService,
 ) *NoteService {
 	return &NoteService{
-		repo:            repo,
-		versionRepo:     versionRepo,
-		conflictService: conflictService,
-		syncService:     syncService,
+		repo:             repo,
+		versionRepo:      versionRepo,
+		conflictService:  conflictService,
+		syncService:      syncService,
+		usageService:     usageService,
+		workspaceService: workspaceService,
+		chunkService:     chunkService,
 	}
 }
 
-func (s *NoteService) Create(userID string, req *domain.CreateNoteRequest) (*domain.NoteResponse, error) {
+func (s *NoteService) Create(ctx context.Context, userID string, req *domain.CreateNoteRequest) (*domain.NoteResponse, error) {
+	if s.workspaceService != nil {
+		if err := s.workspaceService.ValidateWriteAccess(ctx, userID, req.WorkspaceID); err != nil {
+			return nil, err
+		}
+	}
+
+	parentID := req.ParentID
+	if parentID != nil && *parentID == "" {
+		parentID = nil
+	}
+
+	if parentID != nil {
+		if err := validateParent(ctx, s.repo, userID, req.WorkspaceID, nil, *parentID); err != nil {
+			return nil, err
+		}
+	}
+
 	noteID := uuid.New().String()
 	now := time.Now()
 
 	note := &domain.Note{
 		ID:               noteID,
 		UserID:           userID,
-		ParentID:         req.ParentID,
+		ParentID:         parentID,
 		Type:             req.Type,
 		EncryptedTitle:   req.EncryptedTitle,
 		EncryptedContent: req.EncryptedContent,
@@ -51,29 +84,43 @@ func (s *NoteService) Create(userID string, req *domain.CreateNoteRequest) (*dom
 		ContentHash:      req.ContentHash,
 		LastEditDevice:   req.DeviceID,
 		WorkspaceID:      req.WorkspaceID,
+		EditedAt:         now,
+		HLC:              clock.Now(),
+	}
+
+	var chunks []*domain.Chunk
+	if req.Chunks != nil || len(req.NewChunks) > 0 {
+		if s.chunkService == nil || req.EncryptedContent != "" {
+			return nil, ErrInvalidChunks
+		}
+		var err error
+		if chunks, note.ContentSize, err = s.chunkService.prepare(ctx, noteID, req.Chunks, req.NewChunks); err != nil {
+			return nil, err
+		}
+		note.Chunks = req.Chunks
 	}
 
-	if err := s.repo.Create(note); err != nil {
+	size := NoteSize(note)
+	if s.usageService != nil {
+		if err := s.usageService.CheckWrite(ctx, userID, note.WorkspaceID, size, size); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.chunkService.save(ctx, chunks); err != nil {
 		return nil, err
 	}
 
-	response := &domain.NoteResponse{
-		ID:               note.ID,
-		ParentID:         note.ParentID,
-		Type:             note.Type,
-		EncryptedTitle:   note.EncryptedTitle,
-		EncryptedContent: note.EncryptedContent,
-		EncryptionAlgo:   note.EncryptionAlgo,
-		Nonce:            note.Nonce,
-		CreatedAt:        note.CreatedAt,
-		UpdatedAt:        note.UpdatedAt,
-		IsDeleted:        note.IsDeleted,
-		Version:          note.Version,
-		ContentHash:      note.ContentHash,
-		LastEditDevice:   note.LastEditDevice,
-		WorkspaceID:      note.WorkspaceID,
+	if err := s.repo.Create(ctx, note); err != nil {
+		return nil, err
 	}
 
+	if s.usageService != nil {
+		s.usageService.RecordWrite(ctx, userID, note.WorkspaceID, size, 0)
+	}
+
+	response := noteToResponse(note)
+
 	if s.syncService != nil {
 		s.syncService.BroadcastNoteUpdate(userID, req.DeviceID, response)
 	}
@@ -81,148 +128,570 @@ func (s *NoteService) Create(userID string, req *domain.CreateNoteRequest) (*dom
 	return response, nil
 }
 
-func (s *NoteService) List(userID string) ([]*domain.NoteResponse, error) {
-	notes, err := s.repo.List(userID)
+func (s *NoteService) List(ctx context.Context, userID string) ([]*domain.NoteResponse, error) {
+	notes, err := s.repo.List(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
 	var responses []*domain.NoteResponse
 	for _, n := range notes {
-		responses = append(responses, &domain.NoteResponse{
-			ID:               n.ID,
-			ParentID:         n.ParentID,
-			Type:             n.Type,
-			EncryptedTitle:   n.EncryptedTitle,
-			EncryptedContent: n.EncryptedContent,
-			EncryptionAlgo:   n.EncryptionAlgo,
-			Nonce:            n.Nonce,
-			CreatedAt:        n.CreatedAt,
-			UpdatedAt:        n.UpdatedAt,
-			IsDeleted:        n.IsDeleted,
-			Version:          n.Version,
-			ContentHash:      n.ContentHash,
-			LastEditDevice:   n.LastEditDevice,
-			WorkspaceID:      n.WorkspaceID,
-		})
+		responses = append(responses, noteToResponse(n))
 	}
 
 	return responses, nil
 }
 
-func (s *NoteService) GetByID(userID, noteID string) (*domain.NoteResponse, error) {
-	note, err := s.repo.FindByID(noteID)
+func (s *NoteService) GetByID(ctx context.Context, userID, noteID string) (*domain.NoteResponse, error) {
+	note, err := s.repo.FindByID(ctx, noteID)
 	if err != nil {
 		return nil, err
 	}
 
 	if note.UserID != userID {
-		return nil, errors.New("unauthorized: note does not belong to user")
+		return nil, ErrNoteAccessDenied
 	}
 
-	return &domain.NoteResponse{
-		ID:               note.ID,
-		ParentID:         note.ParentID,
-		Type:             note.Type,
-		EncryptedTitle:   note.EncryptedTitle,
-		EncryptedContent: note.EncryptedContent,
-		EncryptionAlgo:   note.EncryptionAlgo,
-		Nonce:            note.Nonce,
-		CreatedAt:        note.CreatedAt,
-		UpdatedAt:        note.UpdatedAt,
-		IsDeleted:        note.IsDeleted,
-		Version:          note.Version,
-		ContentHash:      note.ContentHash,
-		LastEditDevice:   note.LastEditDevice,
-		WorkspaceID:      note.WorkspaceID,
-	}, nil
+	return noteToResponse(note), nil
 }
 
-func (s *NoteService) Update(userID, noteID string, req *domain.UpdateNoteRequest) (*domain.NoteResponse, error) {
-	note, err := s.repo.FindByID(noteID)
+func (s *NoteService) Update(ctx context.Context, userID, noteID string, req *domain.UpdateNoteRequest) (*domain.NoteResponse, error) {
+	note, err := s.repo.FindByID(ctx, noteID)
 	if err != nil {
 		return nil, err
 	}
 
 	if note.UserID != userID {
-		return nil, errors.New("unauthorized: note does not belong to user")
+		return nil, ErrNoteAccessDenied
+	}
+
+	if s.workspaceService != nil {
+		if err := s.workspaceService.EnsureWritable(ctx, note.WorkspaceID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Uploaded chunks are stored before a conflict is recorded, since it
+	// refers to them
+	chunks, err := s.chunkService.prepareUpdate(ctx, noteID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// An edit that was not based on the deleted version raced with the
+	// deletion. Updates that set is_deleted restore or trash the note and are
+	// applied as usual.
+	if note.IsDeleted && req.IsDeleted == nil && (req.ExpectedVersion == nil || *req.ExpectedVersion != note.Version) {
+		baseVersion := note.Version
+		if req.ExpectedVersion != nil {
+			baseVersion = *req.ExpectedVersion
+		}
+		if err := s.chunkService.save(ctx, chunks); err != nil {
+			return nil, err
+		}
+		conflict, err := s.conflictService.DetectDeleteConflict(ctx, note, req.DeviceID, baseVersion, req)
+		if err != nil {
+			return nil, err
+		}
+		return s.conflictResult(ctx, conflict)
 	}
 
 	if req.ExpectedVersion != nil && *req.ExpectedVersion != note.Version {
-		conflict, err := s.conflictService.DetectConflict(noteID, userID, req.DeviceID, *req.ExpectedVersion, req)
+		if err := s.chunkService.save(ctx, chunks); err != nil {
+			return nil, err
+		}
+		conflict, err := s.conflictService.DetectConflict(ctx, noteID, userID, req.DeviceID, *req.ExpectedVersion, req)
 		if err != nil {
 			return nil, err
 		}
-		return nil, &ConflictError{Conflict: conflict}
+		return s.conflictResult(ctx, conflict)
 	}
 
-	if s.versionRepo != nil {
-		s.versionRepo.SaveVersion(note)
+	moved := parentChanged(note.ParentID, req.ParentID)
+	if moved && *req.ParentID != "" {
+		if err := validateParent(ctx, s.repo, userID, note.WorkspaceID, note, *req.ParentID); err != nil {
+			return nil, err
+		}
 	}
 
+	oldSize := NoteSize(note)
+	newSize := oldSize
 	if req.EncryptedTitle != nil {
-		note.EncryptedTitle = *req.EncryptedTitle
+		newSize += int64(len(*req.EncryptedTitle) - len(note.EncryptedTitle))
+	}
+	if req.EncryptedContent != nil {
+		newSize += int64(len(*req.EncryptedContent)) - contentSize(note)
 	}
+	newContentSize := note.ContentSize
 	if req.EncryptedContent != nil {
-		note.EncryptedContent = *req.EncryptedContent
+		newContentSize = 0
+	}
+	if req.Chunks != nil {
+		newSize += req.ContentSize - contentSize(note)
+		newContentSize = req.ContentSize
+	}
+
+	// The previous content is kept as a version. Its chunks stay stored once
+	// for the note, so those the new content drops move to the version side
+	// and only the uploaded ones add to it.
+	versionDelta := chunkBytes(chunks) - (newContentSize - note.ContentSize)
+	if s.versionRepo != nil {
+		versionDelta += oldSize - note.ContentSize
 	}
+	if s.usageService != nil {
+		if err := s.usageService.CheckWrite(ctx, userID, note.WorkspaceID, newSize, newSize-oldSize+versionDelta); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.chunkService.save(ctx, chunks); err != nil {
+		return nil, err
+	}
+
+	if s.versionRepo != nil {
+		s.versionRepo.SaveVersion(ctx, note)
+	}
+
+	if req.EncryptedTitle != nil {
+		note.EncryptedTitle = *req.EncryptedTitle
+	}
+	applyContent(note, req)
 	if req.EncryptionAlgo != nil {
 		note.EncryptionAlgo = *req.EncryptionAlgo
 	}
 	if req.Nonce != nil {
 		note.Nonce = *req.Nonce
 	}
-	if req.ParentID != nil {
-		note.ParentID = req.ParentID
+	if moved {
+		if *req.ParentID == "" {
+			note.ParentID = nil
+		} else {
+			note.ParentID = req.ParentID
+		}
 	}
 	if req.IsDeleted != nil {
 		note.IsDeleted = *req.IsDeleted
+		if !note.IsDeleted {
+			note.DeletedAt = nil
+		} else if note.DeletedAt == nil {
+			deletedAt := time.Now().UTC()
+			note.DeletedAt = &deletedAt
+		}
 	}
 	if req.ContentHash != nil {
 		note.ContentHash = *req.ContentHash
 	}
 
-	note.UpdatedAt = time.Now()
+	baseVersion := note.Version
+	stampEdit(note, req)
 	note.Version++
 	note.LastEditDevice = req.DeviceID
 
-	if err := s.repo.Update(note); err != nil {
+	if err := s.repo.Update(ctx, note); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return s.lostUpdate(ctx, userID, noteID, baseVersion, req, err)
+		}
 		return nil, err
 	}
 
-	response := &domain.NoteResponse{
-		ID:               note.ID,
-		ParentID:         note.ParentID,
-		Type:             note.Type,
-		EncryptedTitle:   note.EncryptedTitle,
-		EncryptedContent: note.EncryptedContent,
-		EncryptionAlgo:   note.EncryptionAlgo,
-		Nonce:            note.Nonce,
-		CreatedAt:        note.CreatedAt,
-		UpdatedAt:        note.UpdatedAt,
-		IsDeleted:        note.IsDeleted,
-		Version:          note.Version,
-		ContentHash:      note.ContentHash,
-		LastEditDevice:   note.LastEditDevice,
-		WorkspaceID:      note.WorkspaceID,
+	if s.usageService != nil {
+		s.usageService.RecordWrite(ctx, userID, note.WorkspaceID, newSize-oldSize, versionDelta)
 	}
 
+	response := noteToResponse(note)
+
 	if s.syncService != nil {
 		s.syncService.BroadcastNoteUpdate(userID, req.DeviceID, response)
+
+		if moved && note.Type == domain.NoteTypeDirectory {
+			if subtree, err := collectSubtree(ctx, s.repo, note); err == nil {
+				s.syncService.BroadcastTreeChange(userID, req.DeviceID, "move", note, subtree)
+			}
+		}
+	}
+
+	return response, nil
+}
+
+// lostUpdate turns a lost write race into a conflict against the version the
+// update was based on. err is returned as is when the stored note has not
+// moved to a different version.
+func (s *NoteService) lostUpdate(ctx context.Context, userID, noteID string, baseVersion int64, req *domain.UpdateNoteRequest, err error) (*domain.NoteResponse, error) {
+	if req.ExpectedVersion != nil {
+		baseVersion = *req.ExpectedVersion
+	}
+
+	current, findErr := s.repo.FindByID(ctx, noteID)
+	if findErr != nil {
+		return nil, findErr
+	}
+	if current.IsDeleted && req.IsDeleted == nil {
+		conflict, detectErr := s.conflictService.DetectDeleteConflict(ctx, current, req.DeviceID, baseVersion, req)
+		if detectErr != nil {
+			return nil, detectErr
+		}
+		return s.conflictResult(ctx, conflict)
+	}
+
+	conflict, detectErr := s.conflictService.DetectConflict(ctx, noteID, userID, req.DeviceID, baseVersion, req)
+	if detectErr != nil {
+		return nil, detectErr
+	}
+	if conflict == nil {
+		return nil, err
+	}
+	return s.conflictResult(ctx, conflict)
+}
+
+// conflictResult returns the note a conflict was resolved into when the
+// workspace policy resolved it on detection, and a ConflictError otherwise
+func (s *NoteService) conflictResult(ctx context.Context, conflict *domain.Conflict) (*domain.NoteResponse, error) {
+	if !conflict.AutoResolved {
+		return nil, &ConflictError{Conflict: conflict}
+	}
+
+	note, err := s.repo.FindByID(ctx, conflict.NoteID)
+	if err != nil {
+		return nil, err
 	}
 
+	response := noteToResponse(note)
+	response.ResolvedConflict = conflict.Summary()
 	return response, nil
 }
 
-func (s *NoteService) Delete(userID, noteID string) error {
-	note, err := s.repo.FindByID(noteID)
+// Delete moves a note to the trash. Deleting a directory also deletes every
+// note below it and notifies devices with a single tree change. When
+// expectedVersion is set and the note was edited since, or an edit lands
+// while it is being deleted, a delete conflict is returned unless the
+// workspace policy resolves it by keeping the deletion.
+func (s *NoteService) Delete(ctx context.Context, userID, noteID, deviceID string, expectedVersion *int64) error {
+	note, err := s.repo.FindByID(ctx, noteID)
 	if err != nil {
 		return err
 	}
 
 	if note.UserID != userID {
-		return errors.New("unauthorized: note does not belong to user")
+		return ErrNoteAccessDenied
+	}
+
+	if s.workspaceService != nil {
+		if err := s.workspaceService.EnsureWritable(ctx, note.WorkspaceID); err != nil {
+			return err
+		}
+	}
+
+	subtree, err := collectSubtree(ctx, s.repo, note)
+	if err != nil {
+		return err
+	}
+
+	// The root is deleted first so every descendant's deleted_at is not
+	// earlier than the directory's, which is what restore relies on. It is
+	// written at the revision it was read at so a concurrent edit is not lost.
+	var deleted []*domain.Note
+	if !note.IsDeleted {
+		baseVersion := note.Version
+		if expectedVersion != nil {
+			baseVersion = *expectedVersion
+		}
+		if baseVersion != note.Version {
+			if err := s.deleteConflict(ctx, note, deviceID, baseVersion); err != nil {
+				return err
+			}
+		} else if trashed, err := s.trash(ctx, note, deviceID, baseVersion); err != nil {
+			return err
+		} else if trashed != nil {
+			deleted = append(deleted, trashed)
+		}
+	}
+
+	for _, n := range subtree {
+		if n.ID == note.ID || n.IsDeleted {
+			continue
+		}
+		if err := s.repo.Delete(ctx, n.ID); err != nil {
+			return err
+		}
+		deletedNote := *n
+		deletedNote.IsDeleted = true
+		deletedNote.Version++
+		deleted = append(deleted, &deletedNote)
+	}
+
+	if s.syncService != nil && len(deleted) > 0 {
+		if note.Type == domain.NoteTypeDirectory {
+			s.syncService.BroadcastTreeChange(userID, deviceID, "delete", note, deleted)
+		} else {
+			s.syncService.BroadcastNoteDelete(userID, deviceID, note.WorkspaceID, noteID, deleted[0].Version)
+		}
+	}
+
+	return nil
+}
+
+// trash moves note to the trash at the revision it was read at and returns
+// the trashed note. When an edit landed in between, the delete conflict is
+// recorded instead and the note is returned as nil if the workspace policy
+// still kept the deletion.
+func (s *NoteService) trash(ctx context.Context, note *domain.Note, deviceID string, baseVersion int64) (*domain.Note, error) {
+	trashed := *note
+	now := time.Now()
+	deletedAt := now.UTC()
+	trashed.IsDeleted = true
+	trashed.DeletedAt = &deletedAt
+	trashed.UpdatedAt = now
+	trashed.Version++
+
+	if err := s.repo.Update(ctx, &trashed); err != nil {
+		if !errors.Is(err, repository.ErrConflict) {
+			return nil, err
+		}
+		current, err := s.repo.FindByID(ctx, note.ID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.deleteConflict(ctx, current, deviceID, baseVersion)
+	}
+	return &trashed, nil
+}
+
+// deleteConflict records that deleting note, as currently stored, lost to an
+// edit made after baseVersion. It returns nil when the workspace policy
+// resolved the conflict by deleting the note anyway.
+func (s *NoteService) deleteConflict(ctx context.Context, note *domain.Note, deviceID string, baseVersion int64) error {
+	isDeleted := true
+	conflict, err := s.conflictService.DetectDeleteConflict(ctx, note, deviceID, baseVersion, &domain.UpdateNoteRequest{
+		IsDeleted: &isDeleted,
+		DeviceID:  deviceID,
+	})
+	if err != nil {
+		return err
+	}
+	if conflict.AutoResolved && conflict.ResolutionChoice == domain.ResolutionKeepDeleted {
+		return nil
+	}
+	return &ConflictError{Conflict: conflict}
+}
+
+// Move relocates a note, together with everything below it, to another
+// workspace the user can access. Every moved note gets a new version; the
+// version history stays attached to the note.
+func (s *NoteService) Move(ctx context.Context, userID, noteID string, req *domain.MoveNoteRequest) (*domain.NoteResponse, error) {
+	note, err := s.repo.FindByID(ctx, noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if note.UserID != userID {
+		return nil, ErrNoteAccessDenied
+	}
+
+	if note.IsDeleted {
+		return nil, ErrNoteDeleted
+	}
+
+	if s.workspaceService != nil {
+		if err := s.workspaceService.EnsureWritable(ctx, note.WorkspaceID); err != nil {
+			return nil, err
+		}
+		if err := s.workspaceService.ValidateWriteAccess(ctx, userID, req.WorkspaceID); err != nil {
+			return nil, err
+		}
+	}
+
+	var parentID *string
+	if req.ParentID != nil && *req.ParentID != "" {
+		if err := validateParent(ctx, s.repo, userID, req.WorkspaceID, note, *req.ParentID); err != nil {
+			return nil, err
+		}
+		parentID = req.ParentID
+	}
+
+	fromWorkspaceID := note.WorkspaceID
+	if fromWorkspaceID == req.WorkspaceID {
+		return s.Update(ctx, userID, noteID, &domain.UpdateNoteRequest{
+			ParentID: parentIDOrRoot(parentID),
+			DeviceID: req.DeviceID,
+		})
+	}
+
+	subtree, err := collectSubtree(ctx, s.repo, note)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.repo.Delete(noteID)
+	// Check every note before moving any. Notes already in the target
+	// workspace were moved by an earlier attempt that failed partway.
+	var pending []*domain.Note
+	transfers := make(map[string]*domain.WorkspaceUsage)
+	sizes := make(map[string]*domain.WorkspaceUsage, len(subtree))
+	var transferBytes int64
+	for _, n := range subtree {
+		if n.WorkspaceID == req.WorkspaceID {
+			continue
+		}
+		if n.WorkspaceID != fromWorkspaceID && s.workspaceService != nil {
+			if err := s.workspaceService.EnsureWritable(ctx, n.WorkspaceID); err != nil {
+				return nil, err
+			}
+		}
+
+		size := &domain.WorkspaceUsage{ContentBytes: NoteSize(n)}
+		if size.VersionBytes, err = storedVersionBytes(ctx, s.versionRepo, s.chunkService.store(), n); err != nil {
+			return nil, err
+		}
+		sizes[n.ID] = size
+		transferBytes += size.TotalBytes()
+		pending = append(pending, n)
+	}
+
+	if s.usageService != nil {
+		if err := s.usageService.CheckTransfer(ctx, userID, req.WorkspaceID, transferBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	// The root is moved last, so a failed move leaves it in place and
+	// retrying picks up the notes that were not moved yet
+	movedNotes := make(map[string]*domain.Note, len(pending))
+	var moveErr error
+	for i := len(pending) - 1; i >= 0; i-- {
+		movedNote := *pending[i]
+		if i == 0 {
+			movedNote.ParentID = parentID
+		}
+		movedNote.WorkspaceID = req.WorkspaceID
+		movedNote.Version++
+		movedNote.LastEditDevice = req.DeviceID
+		stampEdit(&movedNote, nil)
+
+		if moveErr = s.repo.Update(ctx, &movedNote); moveErr != nil {
+			break
+		}
+		movedNotes[movedNote.ID] = &movedNote
+
+		transfer, ok := transfers[pending[i].WorkspaceID]
+		if !ok {
+			transfer = &domain.WorkspaceUsage{}
+			transfers[pending[i].WorkspaceID] = transfer
+		}
+		transfer.ContentBytes += sizes[movedNote.ID].ContentBytes
+		transfer.VersionBytes += sizes[movedNote.ID].VersionBytes
+	}
+
+	if s.usageService != nil {
+		for fromID, transfer := range transfers {
+			s.usageService.RecordTransfer(ctx, userID, fromID, req.WorkspaceID, transfer.ContentBytes, transfer.VersionBytes)
+		}
+	}
+	if moveErr != nil {
+		return nil, moveErr
+	}
+
+	// Devices learn about the whole subtree, including notes moved by an
+	// earlier attempt
+	moved := make([]*domain.Note, len(subtree))
+	for i, n := range subtree {
+		if movedNote, ok := movedNotes[n.ID]; ok {
+			n = movedNote
+		}
+		moved[i] = n
+	}
+
+	root := moved[0]
+	response := noteToResponse(root)
+
+	if s.syncService != nil {
+		s.syncService.BroadcastWorkspaceMove(userID, req.DeviceID, fromWorkspaceID, root, moved)
+	}
+
+	return response, nil
+}
+
+// parentIDOrRoot converts a nil parent into the empty ID Update uses for
+// the workspace root.
+func parentIDOrRoot(parentID *string) *string {
+	if parentID == nil {
+		root := ""
+		return &root
+	}
+	return parentID
+}
+
+// clock orders edits made on the server with the HLC timestamps devices send
+var clock = hlc.NewClock()
+
+// stampEdit records when note was edited. Times sent by the device in req are
+// kept, and the server clock fills in the ones that are missing.
+func stampEdit(note *domain.Note, req *domain.UpdateNoteRequest) {
+	now := time.Now()
+	note.UpdatedAt = now
+	note.EditedAt = now
+	if req != nil && req.EditedAt != nil {
+		note.EditedAt = *req.EditedAt
+	}
+
+	if ts := editTimestamp(req); !ts.IsZero() {
+		clock.Update(ts)
+		note.HLC = ts
+	} else {
+		note.HLC = clock.Now()
+	}
+}
+
+// editTimestamp returns the HLC timestamp of the edit in req, derived from
+// edited_at for devices that do not keep an HLC. It is zero if the device
+// sent neither.
+func editTimestamp(req *domain.UpdateNoteRequest) hlc.Timestamp {
+	switch {
+	case req == nil:
+		return hlc.Timestamp{}
+	case !req.HLC.IsZero():
+		return req.HLC
+	case req.EditedAt != nil:
+		return hlc.FromTime(*req.EditedAt)
+	}
+	return hlc.Timestamp{}
+}
+
+// newerEdit reports whether an edit at ts made on deviceID comes after the
+// last edit of note. Edits with the same timestamp are ordered by device ID
+// so every server and device picks the same winner.
+func newerEdit(ts hlc.Timestamp, deviceID string, note *domain.Note) bool {
+	last := note.HLC
+	if last.IsZero() {
+		// Stored before notes carried an HLC
+		last = hlc.FromTime(note.UpdatedAt)
+	}
+
+	if c := ts.Compare(last); c != 0 {
+		return c > 0
+	}
+	return deviceID > note.LastEditDevice
+}
+
+func noteToResponse(note *domain.Note) *domain.NoteResponse {
+	return &domain.NoteResponse{
+		ID:               note.ID,
+		WorkspaceID:      note.WorkspaceID,
+		ParentID:         note.ParentID,
+		Type:             note.Type,
+		EncryptedTitle:   note.EncryptedTitle,
+		EncryptedContent: note.EncryptedContent,
+		EncryptionAlgo:   note.EncryptionAlgo,
+		Nonce:            note.Nonce,
+		Chunks:           note.Chunks,
+		ContentSize:      note.ContentSize,
+		CreatedAt:        note.CreatedAt,
+		UpdatedAt:        note.UpdatedAt,
+		IsDeleted:        note.IsDeleted,
+		DeletedAt:        note.DeletedAt,
+		Version:          note.Version,
+		ContentHash:      note.ContentHash,
+		LastEditDevice:   note.LastEditDevice,
+		EditedAt:         note.EditedAt,
+		HLC:              note.HLC,
+		ConflictID:       note.ConflictID,
+	}
 }
@@ -1,14 +1,21 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
 )
 
 type mockNoteRepo struct {
+	mu    sync.Mutex
 	notes map[string]*domain.Note
+	// failUpdates makes updates of these notes fail
+	failUpdates map[string]bool
 }
 
 func newMockNoteRepo() *mockNoteRepo {
@@ -17,19 +24,29 @@ func newMockNoteRepo() *mockNoteRepo {
 	}
 }
 
-func (m *mockNoteRepo) Create(note *domain.Note) error {
+func (m *mockNoteRepo) Create(ctx context.Context, note *domain.Note) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.notes[note.ID] = note
 	return nil
 }
 
-func (m *mockNoteRepo) FindByID(id string) (*domain.Note, error) {
+func (m *mockNoteRepo) FindByID(ctx context.Context, id string) (*domain.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if n, exists := m.notes[id]; exists {
-		return n, nil
+		found := *n
+		return &found, nil
 	}
 	return nil, errors.New("note not found")
 }
 
-func (m *mockNoteRepo) List(userID string) ([]*domain.Note, error) {
+func (m *mockNoteRepo) List(ctx context.Context, userID string) ([]*domain.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	var notes []*domain.Note
 	for _, n := range m.notes {
 		if n.UserID == userID && !n.IsDeleted {
@@ -39,7 +56,13 @@ func (m *mockNoteRepo) List(userID string) ([]*domain.Note, error) {
 	return notes, nil
 }
 
-func (m *mockNoteRepo) Update(note *domain.Note) error {
+func (m *mockNoteRepo) Update(ctx context.Context, note *domain.Note) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failUpdates[note.ID] {
+		return errors.New("update failed")
+	}
 	if _, exists := m.notes[note.ID]; exists {
 		m.notes[note.ID] = note
 		return nil
@@ -47,39 +70,162 @@ func (m *mockNoteRepo) Update(note *domain.Note) error {
 	return errors.New("note not found")
 }
 
-func (m *mockNoteRepo) Delete(id string) error {
+func (m *mockNoteRepo) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if n, exists := m.notes[id]; exists {
+		now := time.Now()
 		n.IsDeleted = true
+		n.DeletedAt = &now
+		n.Version++
 		return nil
 	}
 	return errors.New("note not found")
 }
 
-func (m *mockNoteRepo) ListByWorkspace(workspaceID string) ([]*domain.Note, error) {
+func (m *mockNoteRepo) ListByWorkspace(ctx context.Context, workspaceID string) ([]*domain.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var notes []*domain.Note
+	for _, n := range m.notes {
+		if n.WorkspaceID == workspaceID {
+			notes = append(notes, n)
+		}
+	}
+	return notes, nil
+}
+
+func (m *mockNoteRepo) ListChildren(ctx context.Context, parentID string) ([]*domain.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var notes []*domain.Note
+	for _, n := range m.notes {
+		if n.ParentID != nil && *n.ParentID == parentID {
+			notes = append(notes, n)
+		}
+	}
+	return notes, nil
+}
+
+func (m *mockNoteRepo) ListDeleted(ctx context.Context, userID string) ([]*domain.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var notes []*domain.Note
+	for _, n := range m.notes {
+		if n.UserID == userID && n.IsDeleted {
+			notes = append(notes, n)
+		}
+	}
+	return notes, nil
+}
+
+func (m *mockNoteRepo) ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]*domain.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	var notes []*domain.Note
 	for _, n := range m.notes {
-		if n.WorkspaceID == workspaceID && !n.IsDeleted {
+		deletedAt := n.UpdatedAt
+		if n.DeletedAt != nil {
+			deletedAt = *n.DeletedAt
+		}
+		if n.IsDeleted && deletedAt.Before(cutoff) {
 			notes = append(notes, n)
 		}
 	}
 	return notes, nil
 }
 
+func (m *mockNoteRepo) Restore(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n, exists := m.notes[id]; exists {
+		n.IsDeleted = false
+		n.DeletedAt = nil
+		n.Version++
+		return nil
+	}
+	return errors.New("note not found")
+}
+
+func (m *mockNoteRepo) Purge(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.notes[id]; exists {
+		delete(m.notes, id)
+		return nil
+	}
+	return errors.New("note not found")
+}
+
+func (m *mockNoteRepo) WorkspaceStats(ctx context.Context, userID string) (map[string]*domain.WorkspaceStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[string]*domain.WorkspaceStats)
+	for _, n := range m.notes {
+		if n.UserID != userID || n.IsDeleted {
+			continue
+		}
+		ws, ok := stats[n.WorkspaceID]
+		if !ok {
+			ws = &domain.WorkspaceStats{}
+			stats[n.WorkspaceID] = ws
+		}
+		ws.NoteCount++
+		ws.TotalBytes += NoteSize(n)
+		if ws.LastModifiedAt == nil || n.UpdatedAt.After(*ws.LastModifiedAt) {
+			updatedAt := n.UpdatedAt
+			ws.LastModifiedAt = &updatedAt
+		}
+	}
+	return stats, nil
+}
+
+// racingNoteRepo lets another device write the note between the service
+// reading it and writing it back.
+type racingNoteRepo struct {
+	*mockNoteRepo
+	raced bool
+}
+
+func (m *racingNoteRepo) Update(ctx context.Context, note *domain.Note) error {
+	if !m.raced {
+		m.raced = true
+		other := *note
+		other.EncryptedTitle = "other-device-title"
+		other.LastEditDevice = "d2"
+		m.notes[note.ID] = &other
+		return repository.ErrConflict
+	}
+	return m.mockNoteRepo.Update(ctx, note)
+}
+
 type mockVersionRepo struct{}
 
-func (m *mockVersionRepo) SaveVersion(note *domain.Note) error { return nil }
-func (m *mockVersionRepo) GetVersions(noteID string, limit int) ([]*domain.NoteVersion, error) {
+func (m *mockVersionRepo) SaveVersion(ctx context.Context, note *domain.Note) error { return nil }
+func (m *mockVersionRepo) GetVersions(ctx context.Context, noteID string, limit int) ([]*domain.NoteVersion, error) {
 	return nil, nil
 }
-func (m *mockVersionRepo) GetVersion(noteID string, version int64) (*domain.NoteVersion, error) {
+func (m *mockVersionRepo) GetVersion(ctx context.Context, noteID string, version int64) (*domain.NoteVersion, error) {
 	return nil, nil
 }
-func (m *mockVersionRepo) DeleteOldVersions(noteID string, keepLast int) error { return nil }
+func (m *mockVersionRepo) DeleteOldVersions(ctx context.Context, noteID string, keepLast int) error {
+	return nil
+}
+func (m *mockVersionRepo) DeleteAll(ctx context.Context, noteID string) error { return nil }
 
 func TestNoteService_Create(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockNoteRepo()
 	versionRepo := &mockVersionRepo{}
-	service := NewNoteService(repo, versionRepo, nil, nil)
+	service := NewNoteService(repo, versionRepo, nil, nil, nil, nil, nil)
 
 	req := &domain.CreateNoteRequest{
 		Type:             domain.NoteTypeFile,
@@ -90,7 +236,7 @@ func TestNoteService_Create(t *testing.T) {
 		DeviceID:         "device1",
 	}
 
-	note, err := service.Create("user1", req)
+	note, err := service.Create(ctx, "user1", req)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -104,15 +250,16 @@ func TestNoteService_Create(t *testing.T) {
 }
 
 func TestNoteService_List(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockNoteRepo()
 	versionRepo := &mockVersionRepo{}
-	service := NewNoteService(repo, versionRepo, nil, nil)
+	service := NewNoteService(repo, versionRepo, nil, nil, nil, nil, nil)
 
-	service.Create("user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "n1", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
-	service.Create("user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "n2", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
-	service.Create("user2", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "n3", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d2"})
+	service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "n1", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "n2", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	service.Create(ctx, "user2", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "n3", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d2"})
 
-	list, err := service.List("user1")
+	list, err := service.List(ctx, "user1")
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -123,11 +270,12 @@ func TestNoteService_List(t *testing.T) {
 }
 
 func TestNoteService_Update(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockNoteRepo()
 	versionRepo := &mockVersionRepo{}
-	service := NewNoteService(repo, versionRepo, nil, nil)
+	service := NewNoteService(repo, versionRepo, nil, nil, nil, nil, nil)
 
-	note, _ := service.Create("user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	note, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
 
 	newTitle := "new-enc-title"
 	req := &domain.UpdateNoteRequest{
@@ -135,7 +283,7 @@ func TestNoteService_Update(t *testing.T) {
 		DeviceID:       "d1",
 	}
 
-	updated, err := service.Update("user1", note.ID, req)
+	updated, err := service.Update(ctx, "user1", note.ID, req)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -147,26 +295,215 @@ func TestNoteService_Update(t *testing.T) {
 		t.Errorf("expected version 2, got %d", updated.Version)
 	}
 
-	_, err = service.Update("user2", note.ID, req)
+	_, err = service.Update(ctx, "user2", note.ID, req)
 	if err == nil {
 		t.Error("expected unauthorized error")
 	}
 }
 
+func TestNoteService_UpdateConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	repo := &racingNoteRepo{mockNoteRepo: newMockNoteRepo()}
+	versionRepo := &mockVersionRepo{}
+	conflicts := newMockConflictRepo()
+	conflictService := NewConflictService(conflicts, versionRepo, repo, nil, nil, nil, nil, ConflictExpiryPolicy{})
+	service := NewNoteService(repo, versionRepo, conflictService, nil, nil, nil, nil)
+
+	note, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+
+	newTitle := "new-enc-title"
+	_, err := service.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &newTitle, DeviceID: "d1"})
+
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if conflictErr.Conflict.BaseVersion != 1 || conflictErr.Conflict.ServerVersion != 2 {
+		t.Errorf("expected base 1 and server 2, got %d and %d", conflictErr.Conflict.BaseVersion, conflictErr.Conflict.ServerVersion)
+	}
+	if len(conflicts.conflicts) != 1 {
+		t.Errorf("expected 1 stored conflict, got %d", len(conflicts.conflicts))
+	}
+	if stored, _ := repo.FindByID(ctx, note.ID); stored.EncryptedTitle != "other-device-title" {
+		t.Errorf("expected the other device's write to be kept, got %s", stored.EncryptedTitle)
+	}
+}
+
 func TestNoteService_Delete(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockNoteRepo()
 	versionRepo := &mockVersionRepo{}
-	service := NewNoteService(repo, versionRepo, nil, nil)
+	service := NewNoteService(repo, versionRepo, nil, nil, nil, nil, nil)
 
-	note, _ := service.Create("user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "del", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	note, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "del", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
 
-	err := service.Delete("user1", note.ID)
+	err := service.Delete(ctx, "user1", note.ID, "d1", nil)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	n, _ := repo.FindByID(note.ID)
+	n, _ := repo.FindByID(ctx, note.ID)
 	if !n.IsDeleted {
 		t.Error("expected note to be marked deleted")
 	}
 }
+
+func TestNoteService_DeleteDirectory(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	service := NewNoteService(repo, &mockVersionRepo{}, nil, nil, nil, nil, nil)
+
+	dir, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeDirectory, EncryptedTitle: "dir", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	sub, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{ParentID: &dir.ID, Type: domain.NoteTypeDirectory, EncryptedTitle: "sub", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	file, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{ParentID: &sub.ID, Type: domain.NoteTypeFile, EncryptedTitle: "file", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+
+	if err := service.Delete(ctx, "user1", dir.ID, "d1", nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, id := range []string{dir.ID, sub.ID, file.ID} {
+		n, _ := repo.FindByID(ctx, id)
+		if !n.IsDeleted {
+			t.Errorf("expected note %s to be deleted", id)
+		}
+	}
+
+	trash := NewTrashService(repo, nil, &mockVersionRepo{}, nil, nil, nil, newMockTombstoneRepo(), nil, nil, time.Hour, time.Hour)
+	if _, err := trash.Restore(ctx, "user1", file.ID, "d1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, id := range []string{dir.ID, sub.ID, file.ID} {
+		n, _ := repo.FindByID(ctx, id)
+		if n.IsDeleted {
+			t.Errorf("expected note %s to be restored", id)
+		}
+	}
+}
+
+func TestNoteService_MoveValidation(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	service := NewNoteService(repo, &mockVersionRepo{}, nil, nil, nil, nil, nil)
+
+	dir, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeDirectory, EncryptedTitle: "dir", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	sub, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{ParentID: &dir.ID, Type: domain.NoteTypeDirectory, EncryptedTitle: "sub", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	file, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "file", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+
+	if _, err := service.Update(ctx, "user1", dir.ID, &domain.UpdateNoteRequest{ParentID: &sub.ID, DeviceID: "d1"}); !errors.Is(err, ErrMoveCycle) {
+		t.Errorf("expected ErrMoveCycle, got %v", err)
+	}
+
+	if _, err := service.Update(ctx, "user1", sub.ID, &domain.UpdateNoteRequest{ParentID: &file.ID, DeviceID: "d1"}); !errors.Is(err, ErrInvalidParent) {
+		t.Errorf("expected ErrInvalidParent, got %v", err)
+	}
+
+	if _, err := service.Create(ctx, "user1", &domain.CreateNoteRequest{WorkspaceID: "other", ParentID: &dir.ID, Type: domain.NoteTypeFile, EncryptedTitle: "x", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"}); !errors.Is(err, ErrInvalidParent) {
+		t.Errorf("expected ErrInvalidParent for other workspace, got %v", err)
+	}
+
+	root := ""
+	moved, err := service.Update(ctx, "user1", sub.ID, &domain.UpdateNoteRequest{ParentID: &root, DeviceID: "d1"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if moved.ParentID != nil {
+		t.Errorf("expected note to be moved to root, got parent %v", *moved.ParentID)
+	}
+}
+
+func TestNoteService_MoveToWorkspace(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	workspaces := newMockWorkspaceRepo()
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws2", OwnerID: "user1"})
+	workspaces.Create(ctx, &domain.Workspace{ID: "foreign", OwnerID: "user2"})
+	service := NewNoteService(repo, &mockVersionRepo{}, nil, nil, nil, NewWorkspaceService(workspaces, repo, nil, newMockJobRepo(), nil, nil, nil, nil, nil), nil)
+
+	dir, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{WorkspaceID: "ws1", Type: domain.NoteTypeDirectory, EncryptedTitle: "dir", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	file, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{WorkspaceID: "ws1", ParentID: &dir.ID, Type: domain.NoteTypeFile, EncryptedTitle: "file", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+
+	if _, err := service.Move(ctx, "user1", dir.ID, &domain.MoveNoteRequest{WorkspaceID: "foreign", DeviceID: "d1"}); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("expected ErrAccessDenied, got %v", err)
+	}
+
+	moved, err := service.Move(ctx, "user1", dir.ID, &domain.MoveNoteRequest{WorkspaceID: "ws2", DeviceID: "d2"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if moved.WorkspaceID != "ws2" || moved.Version != 2 {
+		t.Errorf("unexpected moved note %+v", moved)
+	}
+
+	child, _ := repo.FindByID(ctx, file.ID)
+	if child.WorkspaceID != "ws2" || child.Version != 2 {
+		t.Errorf("expected child to follow the directory, got %+v", child)
+	}
+	if child.ParentID == nil || *child.ParentID != dir.ID {
+		t.Error("expected child to keep its parent")
+	}
+}
+
+func TestNoteService_MoveToWorkspaceRetry(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	workspaces := newMockWorkspaceRepo()
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws2", OwnerID: "user1"})
+	service := NewNoteService(repo, &mockVersionRepo{}, nil, nil, nil, NewWorkspaceService(workspaces, repo, nil, newMockJobRepo(), nil, nil, nil, nil, nil), nil)
+
+	dir, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{WorkspaceID: "ws1", Type: domain.NoteTypeDirectory, EncryptedTitle: "dir", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	a, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{WorkspaceID: "ws1", ParentID: &dir.ID, Type: domain.NoteTypeFile, EncryptedTitle: "a", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	b, _ := service.Create(ctx, "user1", &domain.CreateNoteRequest{WorkspaceID: "ws1", ParentID: &dir.ID, Type: domain.NoteTypeFile, EncryptedTitle: "b", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	before, _ := repo.FindByID(ctx, a.ID)
+	editedAt, stamp := before.EditedAt, before.HLC
+
+	repo.failUpdates = map[string]bool{b.ID: true}
+	if _, err := service.Move(ctx, "user1", dir.ID, &domain.MoveNoteRequest{WorkspaceID: "ws2", DeviceID: "d2"}); err == nil {
+		t.Fatal("expected the move to fail")
+	}
+	if root, _ := repo.FindByID(ctx, dir.ID); root.WorkspaceID != "ws1" {
+		t.Fatalf("expected the directory to stay in ws1 after a failed move, got %s", root.WorkspaceID)
+	}
+
+	repo.failUpdates = nil
+	if _, err := service.Move(ctx, "user1", dir.ID, &domain.MoveNoteRequest{WorkspaceID: "ws2", DeviceID: "d2"}); err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+
+	for _, id := range []string{dir.ID, a.ID, b.ID} {
+		n, _ := repo.FindByID(ctx, id)
+		if n.WorkspaceID != "ws2" || n.Version != 2 {
+			t.Errorf("expected %s in ws2 at version 2, got %s at %d", id, n.WorkspaceID, n.Version)
+		}
+	}
+
+	moved, _ := repo.FindByID(ctx, a.ID)
+	if moved.HLC.Compare(stamp) <= 0 || moved.EditedAt.Before(editedAt) || moved.LastEditDevice != "d2" {
+		t.Errorf("expected the move to be stamped as an edit, got %+v", moved)
+	}
+}
+
+func TestNoteService_CreateValidatesWorkspace(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	workspaces := newMockWorkspaceRepo()
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+	service := NewNoteService(repo, &mockVersionRepo{}, nil, nil, nil, NewWorkspaceService(workspaces, repo, nil, newMockJobRepo(), nil, nil, nil, nil, nil), nil)
+
+	req := &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "t", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"}
+
+	req.WorkspaceID = "missing"
+	if _, err := service.Create(ctx, "user1", req); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Errorf("expected ErrWorkspaceNotFound, got %v", err)
+	}
+
+	req.WorkspaceID = "ws1"
+	if _, err := service.Create(ctx, "user2", req); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("expected ErrAccessDenied, got %v", err)
+	}
+	if _, err := service.Create(ctx, "user1", req); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+var (
+	ErrInvalidParent = errors.New("parent must be a directory in the same workspace")
+	ErrMoveCycle     = errors.New("cannot move a directory into itself or one of its descendants")
+)
+
+// collectSubtree returns root followed by all of its descendants in
+// breadth-first order. Already visited notes are skipped so a corrupted tree
+// can't loop forever.
+func collectSubtree(ctx context.Context, repo repository.NoteRepository, root *domain.Note) ([]*domain.Note, error) {
+	subtree := []*domain.Note{root}
+	if root.Type != domain.NoteTypeDirectory {
+		return subtree, nil
+	}
+
+	visited := map[string]bool{root.ID: true}
+	for i := 0; i < len(subtree); i++ {
+		if subtree[i].Type != domain.NoteTypeDirectory {
+			continue
+		}
+
+		children, err := repo.ListChildren(ctx, subtree[i].ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range children {
+			if visited[child.ID] || child.UserID != root.UserID {
+				continue
+			}
+			visited[child.ID] = true
+			subtree = append(subtree, child)
+		}
+	}
+
+	return subtree, nil
+}
+
+// validateParent checks that parentID can hold note: it must be an existing,
+// non-deleted directory of the same user and workspace that is not note itself
+// or one of its descendants. A nil note validates a parent for a new note.
+func validateParent(ctx context.Context, repo repository.NoteRepository, userID, workspaceID string, note *domain.Note, parentID string) error {
+	parent, err := repo.FindByID(ctx, parentID)
+	if err != nil {
+		return ErrInvalidParent
+	}
+
+	if parent.UserID != userID || parent.IsDeleted ||
+		parent.Type != domain.NoteTypeDirectory || parent.WorkspaceID != workspaceID {
+		return ErrInvalidParent
+	}
+
+	if note == nil {
+		return nil
+	}
+
+	visited := make(map[string]bool)
+	for current := parent; ; {
+		if current.ID == note.ID {
+			return ErrMoveCycle
+		}
+		visited[current.ID] = true
+
+		if current.ParentID == nil || *current.ParentID == "" || visited[*current.ParentID] {
+			return nil
+		}
+
+		current, err = repo.FindByID(ctx, *current.ParentID)
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// parentChanged reports whether requested differs from the current parent.
+// An empty requested ID means the root of the workspace.
+func parentChanged(current, requested *string) bool {
+	if requested == nil {
+		return false
+	}
+	if current == nil || *current == "" {
+		return *requested != ""
+	}
+	return *current != *requested
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
@@ -17,7 +18,7 @@ func NewSecurityService(repo repository.KeyStoreRepository) *SecurityService {
 	}
 }
 
-func (s *SecurityService) UploadKey(userID string, req *domain.UploadKeyRequest) error {
+func (s *SecurityService) UploadKey(ctx context.Context, userID string, req *domain.UploadKeyRequest) error {
 	now := time.Now()
 
 	key := &domain.EncryptedMasterKey{
@@ -30,11 +31,11 @@ func (s *SecurityService) UploadKey(userID string, req *domain.UploadKeyRequest)
 		UpdatedAt:      now,
 	}
 
-	return s.repo.Save(key)
+	return s.repo.Save(ctx, key)
 }
 
-func (s *SecurityService) GetKey(userID string) (*domain.KeyResponse, error) {
-	key, err := s.repo.Get(userID)
+func (s *SecurityService) GetKey(ctx context.Context, userID string) (*domain.KeyResponse, error) {
+	key, err := s.repo.Get(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -18,12 +19,12 @@ func newMockKeyStoreRepo() *mockKeyStoreRepo {
 	}
 }
 
-func (m *mockKeyStoreRepo) Save(key *domain.EncryptedMasterKey) error {
+func (m *mockKeyStoreRepo) Save(ctx context.Context, key *domain.EncryptedMasterKey) error {
 	m.keys[key.UserID] = key
 	return nil
 }
 
-func (m *mockKeyStoreRepo) Get(userID string) (*domain.EncryptedMasterKey, error) {
+func (m *mockKeyStoreRepo) Get(ctx context.Context, userID string) (*domain.EncryptedMasterKey, error) {
 	if key, exists := m.keys[userID]; exists {
 		return key, nil
 	}
@@ -31,6 +32,7 @@ func (m *mockKeyStoreRepo) Get(userID string) (*domain.EncryptedMasterKey, error
 }
 
 func TestSecurityService_UploadKey(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockKeyStoreRepo()
 	service := NewSecurityService(repo)
 
@@ -41,28 +43,29 @@ func TestSecurityService_UploadKey(t *testing.T) {
 		EncryptionAlgo: "AES-256-GCM",
 	}
 
-	err := service.UploadKey("user1", req)
+	err := service.UploadKey(ctx, "user1", req)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	key, _ := repo.Get("user1")
+	key, _ := repo.Get(ctx, "user1")
 	if key.EncryptedKey != req.EncryptedKey {
 		t.Errorf("expected key %s, got %s", req.EncryptedKey, key.EncryptedKey)
 	}
 }
 
 func TestSecurityService_GetKey(t *testing.T) {
+	ctx := context.Background()
 	repo := newMockKeyStoreRepo()
 	service := NewSecurityService(repo)
 
-	repo.Save(&domain.EncryptedMasterKey{
+	repo.Save(ctx, &domain.EncryptedMasterKey{
 		UserID:       "user1",
 		EncryptedKey: "existing-key",
 		UpdatedAt:    time.Now(),
 	})
 
-	resp, err := service.GetKey("user1")
+	resp, err := service.GetKey(ctx, "user1")
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -71,7 +74,7 @@ func TestSecurityService_GetKey(t *testing.T) {
 		t.Errorf("expected key existing-key, got %s", resp.EncryptedKey)
 	}
 
-	_, err = service.GetKey("user2")
+	_, err = service.GetKey(ctx, "user2")
 	if err == nil {
 		t.Error("expected error for non-existent user")
 	}
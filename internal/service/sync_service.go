@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
@@ -9,37 +11,72 @@ import (
 )
 
 type SyncService struct {
-	noteRepo     repository.NoteRepository
-	versionRepo  repository.NoteVersionRepository
-	metadataRepo repository.SyncMetadataRepository
-	wsManager    *websocket.Manager
+	noteRepo      repository.NoteRepository
+	versionRepo   repository.NoteVersionRepository
+	metadataRepo  repository.SyncMetadataRepository
+	tombstoneRepo repository.TombstoneRepository
+	workspaceRepo repository.WorkspaceRepository
+	wsManager     *websocket.Manager
 }
 
 func NewSyncService(
 	noteRepo repository.NoteRepository,
 	versionRepo repository.NoteVersionRepository,
 	metadataRepo repository.SyncMetadataRepository,
+	tombstoneRepo repository.TombstoneRepository,
+	workspaceRepo repository.WorkspaceRepository,
 	wsManager *websocket.Manager,
 ) *SyncService {
 	return &SyncService{
-		noteRepo:     noteRepo,
-		versionRepo:  versionRepo,
-		metadataRepo: metadataRepo,
-		wsManager:    wsManager,
+		noteRepo:      noteRepo,
+		versionRepo:   versionRepo,
+		metadataRepo:  metadataRepo,
+		tombstoneRepo: tombstoneRepo,
+		workspaceRepo: workspaceRepo,
+		wsManager:     wsManager,
 	}
 }
 
-func (s *SyncService) ProcessSyncRequest(userID, deviceID string, req *domain.SyncRequest) (*domain.SyncResponse, error) {
-	notes, err := s.noteRepo.List(userID)
+// ProcessSyncRequest returns the changes a device is missing. Only the
+// workspaces the device syncs are considered: the ones declared on the request,
+// which are remembered for later requests, or else the ones stored for it.
+// Without any, every workspace that is not archived is synced.
+func (s *SyncService) ProcessSyncRequest(ctx context.Context, userID, deviceID string, req *domain.SyncRequest) (*domain.SyncResponse, error) {
+	workspaceIDs, err := s.deviceWorkspaces(ctx, userID, deviceID, req.WorkspaceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	inScope, err := s.workspaceFilter(ctx, userID, workspaceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.noteRepo.List(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
 	var changes []*domain.NoteChange
+	owned := make(map[string]bool, len(notes))
 
 	for _, note := range notes {
+		owned[note.ID] = true
 		clientVersion, exists := req.NoteVersions[note.ID]
 
+		if !inScope(note.WorkspaceID) {
+			// The device still holds a note that left the workspaces it
+			// subscribed to. Archived workspaces are only hidden.
+			if exists && len(workspaceIDs) > 0 {
+				changes = append(changes, &domain.NoteChange{
+					NoteID:    note.ID,
+					Operation: "remove",
+					Version:   note.Version,
+				})
+			}
+			continue
+		}
+
 		if !exists || clientVersion < note.Version {
 			operation := "update"
 			if note.IsDeleted {
@@ -50,32 +87,52 @@ func (s *SyncService) ProcessSyncRequest(userID, deviceID string, req *domain.Sy
 				NoteID:    note.ID,
 				Operation: operation,
 				Version:   note.Version,
-				Note: &domain.NoteResponse{
-					ID:               note.ID,
-					ParentID:         note.ParentID,
-					Type:             note.Type,
-					EncryptedTitle:   note.EncryptedTitle,
-					EncryptedContent: note.EncryptedContent,
-					EncryptionAlgo:   note.EncryptionAlgo,
-					Nonce:            note.Nonce,
-					CreatedAt:        note.CreatedAt,
-					UpdatedAt:        note.UpdatedAt,
-					IsDeleted:        note.IsDeleted,
-					Version:          note.Version,
-					ContentHash:      note.ContentHash,
-					LastEditDevice:   note.LastEditDevice,
-				},
+				Note:      noteToResponse(note),
 			})
 		}
 	}
 
+	tombstones, err := s.listTombstones(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Purged notes the device still holds are deleted whatever their workspace
+	purged := make(map[string]bool, len(tombstones))
+	for _, t := range tombstones {
+		purged[t.NoteID] = true
+		clientVersion, exists := req.NoteVersions[t.NoteID]
+		if exists && clientVersion < t.Version {
+			changes = append(changes, tombstoneChange(t))
+		}
+	}
+
+	// Notes the device holds that now belong to someone else, after a
+	// workspace transfer, are removed. Unknown notes may not be uploaded yet.
+	for noteID := range req.NoteVersions {
+		if owned[noteID] || purged[noteID] {
+			continue
+		}
+		note, err := s.noteRepo.FindByID(ctx, noteID)
+		if err != nil || note.UserID == userID {
+			continue
+		}
+		changes = append(changes, &domain.NoteChange{
+			NoteID:    noteID,
+			Operation: "remove",
+		})
+	}
+
 	syncTime := time.Now()
-	if err := s.metadataRepo.UpdateLastSync(userID, deviceID, syncTime); err != nil {
+	if err := s.metadataRepo.UpdateLastSync(ctx, userID, deviceID, syncTime); err != nil {
 		return nil, err
 	}
 
 	for noteID, version := range req.NoteVersions {
-		if err := s.metadataRepo.UpdateNoteVersion(userID, deviceID, noteID, version); err != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := s.metadataRepo.UpdateNoteVersion(ctx, userID, deviceID, noteID, version); err != nil {
 			continue
 		}
 	}
@@ -87,8 +144,68 @@ func (s *SyncService) ProcessSyncRequest(userID, deviceID string, req *domain.Sy
 	}, nil
 }
 
-func (s *SyncService) GetChangesSince(userID string, since time.Time) ([]*domain.NoteChange, error) {
-	notes, err := s.noteRepo.List(userID)
+// deviceWorkspaces resolves the workspaces a device syncs. Declared
+// workspaces replace the stored subscription and are applied to the device's
+// WebSocket connections; a nil list keeps the stored one and an empty list
+// resets it to every workspace.
+func (s *SyncService) deviceWorkspaces(ctx context.Context, userID, deviceID string, declared []string) ([]string, error) {
+	if declared == nil {
+		metadata, err := s.metadataRepo.Get(ctx, userID, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		return metadata.WorkspaceIDs, nil
+	}
+
+	if err := s.SetDeviceWorkspaces(ctx, userID, deviceID, declared); err != nil {
+		return nil, err
+	}
+
+	return declared, nil
+}
+
+// SetDeviceWorkspaces stores the workspaces a device syncs and routes its
+// WebSocket broadcasts accordingly. An empty list means every workspace.
+func (s *SyncService) SetDeviceWorkspaces(ctx context.Context, userID, deviceID string, workspaceIDs []string) error {
+	if err := s.metadataRepo.UpdateWorkspaces(ctx, userID, deviceID, workspaceIDs); err != nil {
+		return err
+	}
+
+	if s.wsManager != nil {
+		s.wsManager.SubscribeDevice(userID, deviceID, workspaceIDs)
+	}
+
+	return nil
+}
+
+// workspaceFilter returns a predicate matching workspaceIDs. An empty list
+// matches everything except the user's archived workspaces.
+func (s *SyncService) workspaceFilter(ctx context.Context, userID string, workspaceIDs []string) (func(string) bool, error) {
+	if len(workspaceIDs) > 0 {
+		set := make(map[string]bool, len(workspaceIDs))
+		for _, id := range workspaceIDs {
+			set[id] = true
+		}
+		return func(workspaceID string) bool { return set[workspaceID] }, nil
+	}
+
+	archived := make(map[string]bool)
+	if s.workspaceRepo != nil {
+		workspaces, err := s.workspaceRepo.GetByOwner(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ws := range workspaces {
+			if ws.IsArchived {
+				archived[ws.ID] = true
+			}
+		}
+	}
+	return func(workspaceID string) bool { return !archived[workspaceID] }, nil
+}
+
+func (s *SyncService) GetChangesSince(ctx context.Context, userID string, since time.Time) ([]*domain.NoteChange, error) {
+	notes, err := s.noteRepo.List(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -106,34 +223,33 @@ func (s *SyncService) GetChangesSince(userID string, since time.Time) ([]*domain
 				NoteID:    note.ID,
 				Operation: operation,
 				Version:   note.Version,
-				Note: &domain.NoteResponse{
-					ID:               note.ID,
-					ParentID:         note.ParentID,
-					Type:             note.Type,
-					EncryptedTitle:   note.EncryptedTitle,
-					EncryptedContent: note.EncryptedContent,
-					EncryptionAlgo:   note.EncryptionAlgo,
-					Nonce:            note.Nonce,
-					CreatedAt:        note.CreatedAt,
-					UpdatedAt:        note.UpdatedAt,
-					IsDeleted:        note.IsDeleted,
-					Version:          note.Version,
-					ContentHash:      note.ContentHash,
-					LastEditDevice:   note.LastEditDevice,
-				},
+				Note:      noteToResponse(note),
 			})
 		}
 	}
 
+	tombstones, err := s.listTombstones(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tombstones {
+		if t.DeletedAt.After(since) {
+			changes = append(changes, tombstoneChange(t))
+		}
+	}
+
 	return changes, nil
 }
 
 func (s *SyncService) BroadcastNoteUpdate(userID, deviceID string, note *domain.NoteResponse) error {
 	msg, err := websocket.NewMessage(websocket.TypeNoteUpdate, &websocket.NoteUpdatePayload{
 		NoteID:           note.ID,
+		WorkspaceID:      note.WorkspaceID,
 		Version:          note.Version,
 		EncryptedTitle:   note.EncryptedTitle,
 		EncryptedContent: note.EncryptedContent,
+		Chunks:           note.Chunks,
 		UpdatedAt:        note.UpdatedAt,
 		DeviceID:         deviceID,
 	})
@@ -141,39 +257,201 @@ func (s *SyncService) BroadcastNoteUpdate(userID, deviceID string, note *domain.
 		return err
 	}
 
-	return s.wsManager.BroadcastToUser(userID, msg, deviceID)
+	return s.wsManager.BroadcastToWorkspace(userID, msg, deviceID, note.WorkspaceID)
 }
 
-func (s *SyncService) BroadcastNoteDelete(userID, deviceID, noteID string, version int64) error {
+func (s *SyncService) BroadcastNoteDelete(userID, deviceID, workspaceID, noteID string, version int64) error {
 	msg, err := websocket.NewMessage(websocket.TypeNoteDelete, &websocket.NoteDeletePayload{
-		NoteID:   noteID,
-		Version:  version,
-		DeviceID: deviceID,
+		NoteID:      noteID,
+		WorkspaceID: workspaceID,
+		Version:     version,
+		DeviceID:    deviceID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.wsManager.BroadcastToWorkspace(userID, msg, deviceID, workspaceID)
+}
+
+// BroadcastConflict notifies every device of the user, including the one
+// whose write conflicted, that a conflict was recorded
+func (s *SyncService) BroadcastConflict(userID string, conflict *domain.Conflict) error {
+	payload := &websocket.ConflictPayload{
+		ConflictID:    conflict.ID,
+		NoteID:        conflict.NoteID,
+		WorkspaceID:   conflict.NoteWorkspaceID(),
+		ConflictType:  string(conflict.Type),
+		BaseVersion:   conflict.BaseVersion,
+		ServerVersion: conflict.ServerVersion,
+		ClientVersion: conflict.ClientVersion,
+		DeviceID:      conflict.DeviceID,
+	}
+	if conflict.ServerNote != nil {
+		data, err := json.Marshal(noteToResponse(conflict.ServerNote))
+		if err != nil {
+			return err
+		}
+		payload.ServerData = data
+	}
+
+	msg, err := websocket.NewMessage(websocket.TypeConflict, payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.WorkspaceID == "" {
+		return s.wsManager.BroadcastToUser(userID, msg, "")
+	}
+	return s.wsManager.BroadcastToWorkspace(userID, msg, "", payload.WorkspaceID)
+}
+
+// BroadcastConflictResolved notifies every device of the user that a
+// conflict was resolved into note, which is nil when the note was left alone
+func (s *SyncService) BroadcastConflictResolved(userID string, conflict *domain.Conflict, note *domain.Note) error {
+	payload := &websocket.ConflictResolvedPayload{
+		ConflictID:  conflict.ID,
+		NoteID:      conflict.NoteID,
+		WorkspaceID: conflict.NoteWorkspaceID(),
+		Strategy:    string(conflict.ResolutionChoice),
+	}
+	if note != nil {
+		payload.WorkspaceID = note.WorkspaceID
+		payload.Version = note.Version
+	}
+
+	msg, err := websocket.NewMessage(websocket.TypeConflictResolved, payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.WorkspaceID == "" {
+		return s.wsManager.BroadcastToUser(userID, msg, "")
+	}
+	return s.wsManager.BroadcastToWorkspace(userID, msg, "", payload.WorkspaceID)
+}
+
+// BroadcastCRDTUpdate relays a CRDT update to the user's other devices that
+// follow the note's workspace
+func (s *SyncService) BroadcastCRDTUpdate(userID, deviceID, workspaceID string, update *domain.CRDTUpdate) error {
+	msg, err := websocket.NewMessage(websocket.TypeCRDTUpdate, &websocket.CRDTUpdatePayload{
+		NoteID:      update.NoteID,
+		WorkspaceID: workspaceID,
+		Seq:         update.Seq,
+		Data:        update.Data,
+		DeviceID:    update.DeviceID,
+		CreatedAt:   update.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.wsManager.BroadcastToWorkspace(userID, msg, deviceID, workspaceID)
+}
+
+// RequestCRDTSnapshot asks a device to upload the state of a note after
+// update seq so the log can be compacted
+func (s *SyncService) RequestCRDTSnapshot(userID, deviceID, workspaceID, noteID string, seq int64) error {
+	msg, err := websocket.NewMessage(websocket.TypeCRDTSnapshotRequest, &websocket.CRDTSnapshotPayload{
+		NoteID:      noteID,
+		WorkspaceID: workspaceID,
+		Seq:         seq,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.wsManager.SendToDevice(userID, deviceID, msg)
+}
+
+// BroadcastWorkspaceTransfer tells the devices of both owners that a
+// workspace and its notes changed hands, so they sync it or drop it
+func (s *SyncService) BroadcastWorkspaceTransfer(workspaceID, fromUserID, toUserID string) error {
+	msg, err := websocket.NewMessage(websocket.TypeWorkspaceTransfer, &websocket.WorkspaceTransferPayload{
+		WorkspaceID: workspaceID,
+		FromUserID:  fromUserID,
+		ToUserID:    toUserID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.wsManager.BroadcastToUser(fromUserID, msg, ""); err != nil {
+		return err
+	}
+	return s.wsManager.BroadcastToUser(toUserID, msg, "")
+}
+
+// BroadcastTreeChange notifies devices of an operation applied to root and
+// all of its descendants in a single message
+func (s *SyncService) BroadcastTreeChange(userID, deviceID, operation string, root *domain.Note, subtree []*domain.Note) error {
+	return s.broadcastTreeChange(userID, deviceID, operation, "", root, subtree)
+}
+
+// BroadcastWorkspaceMove notifies devices that a subtree moved from one
+// workspace to another
+func (s *SyncService) BroadcastWorkspaceMove(userID, deviceID, fromWorkspaceID string, root *domain.Note, subtree []*domain.Note) error {
+	return s.broadcastTreeChange(userID, deviceID, "move", fromWorkspaceID, root, subtree)
+}
+
+func (s *SyncService) broadcastTreeChange(userID, deviceID, operation, fromWorkspaceID string, root *domain.Note, subtree []*domain.Note) error {
+	entries := make([]websocket.TreeNoteEntry, 0, len(subtree))
+	for _, n := range subtree {
+		entries = append(entries, websocket.TreeNoteEntry{
+			NoteID:    n.ID,
+			Version:   n.Version,
+			IsDeleted: n.IsDeleted,
+		})
+	}
+
+	msg, err := websocket.NewMessage(websocket.TypeTreeChange, &websocket.TreeChangePayload{
+		Operation:       operation,
+		RootID:          root.ID,
+		ParentID:        root.ParentID,
+		WorkspaceID:     root.WorkspaceID,
+		FromWorkspaceID: fromWorkspaceID,
+		Notes:           entries,
+		DeviceID:        deviceID,
 	})
 	if err != nil {
 		return err
 	}
 
-	return s.wsManager.BroadcastToUser(userID, msg, deviceID)
+	workspaceIDs := []string{root.WorkspaceID}
+	if fromWorkspaceID != "" {
+		workspaceIDs = append(workspaceIDs, fromWorkspaceID)
+	}
+
+	return s.wsManager.BroadcastToWorkspace(userID, msg, deviceID, workspaceIDs...)
 }
 
 // GetManifest returns a compact list of all notes for efficient sync comparison
 // If workspaceID is provided, returns notes for that workspace only
-func (s *SyncService) GetManifest(userID, workspaceID string) (*domain.ManifestResponse, error) {
+func (s *SyncService) GetManifest(ctx context.Context, userID, workspaceID string) (*domain.ManifestResponse, error) {
 	var notes []*domain.Note
 	var err error
 
 	if workspaceID != "" {
-		notes, err = s.noteRepo.ListByWorkspace(workspaceID)
+		notes, err = s.noteRepo.ListByWorkspace(ctx, workspaceID)
 	} else {
-		notes, err = s.noteRepo.List(userID)
+		notes, err = s.noteRepo.List(ctx, userID)
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	inScope := func(string) bool { return true }
+	if workspaceID == "" {
+		if inScope, err = s.workspaceFilter(ctx, userID, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	entries := make([]domain.ManifestEntry, 0, len(notes))
 	for _, note := range notes {
+		if !inScope(note.WorkspaceID) {
+			continue
+		}
 		entries = append(entries, domain.ManifestEntry{
 			ID:          note.ID,
 			ContentHash: note.ContentHash,
@@ -183,6 +461,23 @@ func (s *SyncService) GetManifest(userID, workspaceID string) (*domain.ManifestR
 		})
 	}
 
+	tombstones, err := s.listTombstones(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tombstones {
+		if (workspaceID != "" && t.WorkspaceID != workspaceID) || !inScope(t.WorkspaceID) {
+			continue
+		}
+		entries = append(entries, domain.ManifestEntry{
+			ID:        t.NoteID,
+			Version:   t.Version,
+			UpdatedAt: t.DeletedAt,
+			IsDeleted: true,
+		})
+	}
+
 	return &domain.ManifestResponse{
 		Notes:    entries,
 		SyncTime: time.Now(),
@@ -190,15 +485,15 @@ func (s *SyncService) GetManifest(userID, workspaceID string) (*domain.ManifestR
 }
 
 // ProcessBatchDiff compares client state with server and returns needed actions
-func (s *SyncService) ProcessBatchDiff(userID string, req *domain.BatchDiffRequest) (*domain.BatchDiffResponse, error) {
+func (s *SyncService) ProcessBatchDiff(ctx context.Context, userID string, req *domain.BatchDiffRequest) (*domain.BatchDiffResponse, error) {
 	// Get server notes - use workspace if provided
 	var serverNotes []*domain.Note
 	var err error
 
 	if req.WorkspaceID != "" {
-		serverNotes, err = s.noteRepo.ListByWorkspace(req.WorkspaceID)
+		serverNotes, err = s.noteRepo.ListByWorkspace(ctx, req.WorkspaceID)
 	} else {
-		serverNotes, err = s.noteRepo.List(userID)
+		serverNotes, err = s.noteRepo.List(ctx, userID)
 	}
 	if err != nil {
 		return nil, err
@@ -238,22 +533,7 @@ func (s *SyncService) ProcessBatchDiff(userID string, req *domain.BatchDiffReque
 
 		// Client doesn't have this note - download
 		if !existsOnClient {
-			response.ToDownload = append(response.ToDownload, domain.NoteResponse{
-				ID:               serverNote.ID,
-				WorkspaceID:      serverNote.WorkspaceID,
-				ParentID:         serverNote.ParentID,
-				Type:             serverNote.Type,
-				EncryptedTitle:   serverNote.EncryptedTitle,
-				EncryptedContent: serverNote.EncryptedContent,
-				EncryptionAlgo:   serverNote.EncryptionAlgo,
-				Nonce:            serverNote.Nonce,
-				CreatedAt:        serverNote.CreatedAt,
-				UpdatedAt:        serverNote.UpdatedAt,
-				IsDeleted:        serverNote.IsDeleted,
-				Version:          serverNote.Version,
-				ContentHash:      serverNote.ContentHash,
-				LastEditDevice:   serverNote.LastEditDevice,
-			})
+			response.ToDownload = append(response.ToDownload, *noteToResponse(serverNote))
 			continue
 		}
 
@@ -283,9 +563,38 @@ func (s *SyncService) ProcessBatchDiff(userID string, req *domain.BatchDiffReque
 		}
 	}
 
+	// Notes purged from the trash only survive as tombstones
+	tombstones, err := s.listTombstones(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tombstones {
+		if _, existsOnClient := clientMap[t.NoteID]; existsOnClient {
+			if _, existsOnServer := serverMap[t.NoteID]; !existsOnServer {
+				response.ToDelete = append(response.ToDelete, t.NoteID)
+			}
+		}
+	}
+
 	// Notes that exist only on client (not in serverMap) should be uploaded
 	// But we don't know about them here since they don't have server IDs yet
 	// Those are handled by the client's "unmapped files" logic
 
 	return response, nil
 }
+
+func (s *SyncService) listTombstones(ctx context.Context, userID string) ([]*domain.Tombstone, error) {
+	if s.tombstoneRepo == nil {
+		return nil, nil
+	}
+	return s.tombstoneRepo.ListByUser(ctx, userID)
+}
+
+func tombstoneChange(t *domain.Tombstone) *domain.NoteChange {
+	return &domain.NoteChange{
+		NoteID:    t.NoteID,
+		Operation: "delete",
+		Version:   t.Version,
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+)
+
+type mockSyncMetadataRepo struct {
+	metadata map[string]*domain.SyncMetadata
+}
+
+func newMockSyncMetadataRepo() *mockSyncMetadataRepo {
+	return &mockSyncMetadataRepo{
+		metadata: make(map[string]*domain.SyncMetadata),
+	}
+}
+
+func (m *mockSyncMetadataRepo) Get(ctx context.Context, userID, deviceID string) (*domain.SyncMetadata, error) {
+	if md, exists := m.metadata[userID+":"+deviceID]; exists {
+		return md, nil
+	}
+	return &domain.SyncMetadata{
+		UserID:       userID,
+		DeviceID:     deviceID,
+		NoteVersions: make(map[string]int64),
+	}, nil
+}
+
+func (m *mockSyncMetadataRepo) Upsert(ctx context.Context, metadata *domain.SyncMetadata) error {
+	m.metadata[metadata.UserID+":"+metadata.DeviceID] = metadata
+	return nil
+}
+
+func (m *mockSyncMetadataRepo) UpdateLastSync(ctx context.Context, userID, deviceID string, timestamp time.Time) error {
+	md, _ := m.Get(ctx, userID, deviceID)
+	md.LastSyncTime = timestamp
+	return m.Upsert(ctx, md)
+}
+
+func (m *mockSyncMetadataRepo) UpdateNoteVersion(ctx context.Context, userID, deviceID, noteID string, version int64) error {
+	md, _ := m.Get(ctx, userID, deviceID)
+	md.NoteVersions[noteID] = version
+	return m.Upsert(ctx, md)
+}
+
+func (m *mockSyncMetadataRepo) UpdateWorkspaces(ctx context.Context, userID, deviceID string, workspaceIDs []string) error {
+	md, _ := m.Get(ctx, userID, deviceID)
+	md.WorkspaceIDs = workspaceIDs
+	return m.Upsert(ctx, md)
+}
+
+func TestSyncService_ProcessSyncRequestScopedToWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	notes := newMockNoteRepo()
+	metadata := newMockSyncMetadataRepo()
+	service := NewSyncService(notes, &mockVersionRepo{}, metadata, nil, nil, nil)
+
+	notes.Create(ctx, &domain.Note{ID: "a", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	notes.Create(ctx, &domain.Note{ID: "b", UserID: "user1", WorkspaceID: "ws2", Version: 1})
+
+	res, err := service.ProcessSyncRequest(ctx, "user1", "d1", &domain.SyncRequest{DeviceID: "d1", WorkspaceIDs: []string{"ws1"}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(res.Changes) != 1 || res.Changes[0].NoteID != "a" {
+		t.Errorf("expected only note a, got %+v", res.Changes)
+	}
+
+	// The subscription is remembered for requests that don't declare one
+	res, _ = service.ProcessSyncRequest(ctx, "user1", "d1", &domain.SyncRequest{DeviceID: "d1"})
+	if len(res.Changes) != 1 || res.Changes[0].NoteID != "a" {
+		t.Errorf("expected stored subscription to apply, got %+v", res.Changes)
+	}
+
+	res, _ = service.ProcessSyncRequest(ctx, "user1", "d2", &domain.SyncRequest{DeviceID: "d2"})
+	if len(res.Changes) != 2 {
+		t.Errorf("expected all notes for a device without subscription, got %d", len(res.Changes))
+	}
+
+	// Notes that left the subscription are removed from the device
+	notes.notes["a"].WorkspaceID = "ws2"
+	notes.notes["a"].Version = 2
+	res, _ = service.ProcessSyncRequest(ctx, "user1", "d1", &domain.SyncRequest{DeviceID: "d1", NoteVersions: map[string]int64{"a": 1}})
+	if len(res.Changes) != 1 || res.Changes[0].Operation != "remove" || res.Changes[0].Note != nil {
+		t.Errorf("expected note a to be removed, got %+v", res.Changes)
+	}
+
+	// An empty list resets the subscription to every workspace
+	res, _ = service.ProcessSyncRequest(ctx, "user1", "d1", &domain.SyncRequest{DeviceID: "d1", WorkspaceIDs: []string{}})
+	if len(res.Changes) != 2 {
+		t.Errorf("expected all notes after resetting the subscription, got %d", len(res.Changes))
+	}
+	if md, _ := metadata.Get(ctx, "user1", "d1"); len(md.WorkspaceIDs) != 0 {
+		t.Errorf("expected the stored subscription to be reset, got %v", md.WorkspaceIDs)
+	}
+
+	// Notes transferred to another user are removed, unknown ones are kept
+	notes.notes["b"].UserID = "user2"
+	res, _ = service.ProcessSyncRequest(ctx, "user1", "d2", &domain.SyncRequest{DeviceID: "d2", NoteVersions: map[string]int64{"a": 2, "b": 1, "local": 1}})
+	if len(res.Changes) != 1 || res.Changes[0].NoteID != "b" || res.Changes[0].Operation != "remove" {
+		t.Errorf("expected transferred note b to be removed, got %+v", res.Changes)
+	}
+}
+
+func TestSyncService_ProcessSyncRequestCanceled(t *testing.T) {
+	notes := newMockNoteRepo()
+	service := NewSyncService(notes, &mockVersionRepo{}, newMockSyncMetadataRepo(), nil, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := service.ProcessSyncRequest(ctx, "user1", "d1", &domain.SyncRequest{
+		DeviceID:     "d1",
+		NoteVersions: map[string]int64{"a": 1},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
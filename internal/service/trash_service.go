@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+var ErrNoteNotInTrash = errors.New("note is not in trash")
+
+type TrashService struct {
+	noteRepo           repository.NoteRepository
+	workspaceRepo      repository.WorkspaceRepository
+	versionRepo        repository.NoteVersionRepository
+	conflictRepo       repository.ConflictRepository
+	crdtRepo           repository.CRDTRepository
+	chunkRepo          repository.ChunkRepository
+	tombstoneRepo      repository.TombstoneRepository
+	syncService        *SyncService
+	usageService       *UsageService
+	retention          time.Duration
+	tombstoneRetention time.Duration
+}
+
+func NewTrashService(
+	noteRepo repository.NoteRepository,
+	workspaceRepo repository.WorkspaceRepository,
+	versionRepo repository.NoteVersionRepository,
+	conflictRepo repository.ConflictRepository,
+	crdtRepo repository.CRDTRepository,
+	chunkRepo repository.ChunkRepository,
+	tombstoneRepo repository.TombstoneRepository,
+	syncService *SyncService,
+	usageService *UsageService,
+	retention, tombstoneRetention time.Duration,
+) *TrashService {
+	return &TrashService{
+		noteRepo:           noteRepo,
+		workspaceRepo:      workspaceRepo,
+		versionRepo:        versionRepo,
+		conflictRepo:       conflictRepo,
+		crdtRepo:           crdtRepo,
+		chunkRepo:          chunkRepo,
+		tombstoneRepo:      tombstoneRepo,
+		syncService:        syncService,
+		usageService:       usageService,
+		retention:          retention,
+		tombstoneRetention: tombstoneRetention,
+	}
+}
+
+// List returns the soft-deleted notes of a user
+func (s *TrashService) List(ctx context.Context, userID string) ([]*domain.NoteResponse, error) {
+	notes, err := s.noteRepo.ListDeleted(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*domain.NoteResponse, 0, len(notes))
+	for _, n := range notes {
+		responses = append(responses, noteToResponse(n))
+	}
+
+	return responses, nil
+}
+
+// Restore moves a note out of the trash together with the descendants that
+// were deleted along with it. Deleted ancestors are restored as well so the
+// note is reachable again.
+func (s *TrashService) Restore(ctx context.Context, userID, noteID, deviceID string) (*domain.NoteResponse, error) {
+	note, err := s.findDeleted(ctx, userID, noteID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureWritable(ctx, s.workspaceRepo, note.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	if err := s.restoreAncestors(ctx, userID, note, deviceID); err != nil {
+		return nil, err
+	}
+
+	subtree, err := collectSubtree(ctx, s.noteRepo, note)
+	if err != nil {
+		return nil, err
+	}
+
+	var restored []*domain.Note
+	for _, n := range subtree {
+		if !n.IsDeleted || (n.ID != note.ID && !deletedWith(n, note)) {
+			continue
+		}
+
+		if err := s.noteRepo.Restore(ctx, n.ID); err != nil {
+			return nil, err
+		}
+
+		restoredNote, err := s.noteRepo.FindByID(ctx, n.ID)
+		if err != nil {
+			return nil, err
+		}
+		restored = append(restored, restoredNote)
+	}
+
+	root := restored[0]
+	response := noteToResponse(root)
+
+	if s.syncService != nil {
+		if root.Type == domain.NoteTypeDirectory {
+			s.syncService.BroadcastTreeChange(userID, deviceID, "restore", root, restored)
+		} else {
+			s.syncService.BroadcastNoteUpdate(userID, deviceID, response)
+		}
+	}
+
+	return response, nil
+}
+
+// Purge permanently deletes a note that is in the trash. Purging a directory
+// also purges the deleted notes below it.
+func (s *TrashService) Purge(ctx context.Context, userID, noteID string) error {
+	note, err := s.findDeleted(ctx, userID, noteID)
+	if err != nil {
+		return err
+	}
+	if err := ensureWritable(ctx, s.workspaceRepo, note.WorkspaceID); err != nil {
+		return err
+	}
+
+	subtree, err := collectSubtree(ctx, s.noteRepo, note)
+	if err != nil {
+		return err
+	}
+
+	for i := len(subtree) - 1; i >= 0; i-- {
+		if !subtree[i].IsDeleted {
+			continue
+		}
+		if err := s.purgeNote(ctx, subtree[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PurgeExpired permanently deletes notes that have been in the trash longer
+// than the retention window and drops tombstones older than their own window.
+func (s *TrashService) PurgeExpired(ctx context.Context) error {
+	now := time.Now()
+
+	notes, err := s.noteRepo.ListDeletedBefore(ctx, now.Add(-s.retention))
+	if err != nil {
+		return err
+	}
+
+	for _, note := range notes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.purgeNote(ctx, note); err != nil {
+			log.Printf("failed to purge note %s: %v", note.ID, err)
+		}
+	}
+
+	if _, err := s.tombstoneRepo.DeletePurgedBefore(ctx, now.Add(-s.tombstoneRetention)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RunPurger periodically purges expired trash until ctx is cancelled
+func (s *TrashService) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.PurgeExpired(ctx); err != nil {
+				log.Printf("trash purge failed: %v", err)
+			}
+		}
+	}
+}
+
+func (s *TrashService) findDeleted(ctx context.Context, userID, noteID string) (*domain.Note, error) {
+	note, err := s.noteRepo.FindByID(ctx, noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if note.UserID != userID {
+		return nil, ErrNoteAccessDenied
+	}
+
+	if !note.IsDeleted {
+		return nil, ErrNoteNotInTrash
+	}
+
+	return note, nil
+}
+
+func (s *TrashService) restoreAncestors(ctx context.Context, userID string, note *domain.Note, deviceID string) error {
+	visited := map[string]bool{note.ID: true}
+	for parentID := note.ParentID; parentID != nil && *parentID != "" && !visited[*parentID]; {
+		visited[*parentID] = true
+
+		parent, err := s.noteRepo.FindByID(ctx, *parentID)
+		if err != nil || parent.UserID != userID {
+			return nil
+		}
+
+		if parent.IsDeleted {
+			if err := s.noteRepo.Restore(ctx, parent.ID); err != nil {
+				return err
+			}
+
+			if s.syncService != nil {
+				if restored, err := s.noteRepo.FindByID(ctx, parent.ID); err == nil {
+					s.syncService.BroadcastNoteUpdate(userID, deviceID, noteToResponse(restored))
+				}
+			}
+		}
+
+		parentID = parent.ParentID
+	}
+
+	return nil
+}
+
+// deletedWith reports whether n was deleted as part of deleting root, i.e. not
+// before root itself went to the trash.
+func deletedWith(n, root *domain.Note) bool {
+	if n.DeletedAt == nil || root.DeletedAt == nil {
+		return false
+	}
+	return !n.DeletedAt.Before(*root.DeletedAt)
+}
+
+func (s *TrashService) purgeNote(ctx context.Context, note *domain.Note) error {
+	versionBytes, err := storedVersionBytes(ctx, s.versionRepo, s.chunkRepo, note)
+	if err != nil {
+		return err
+	}
+
+	if s.versionRepo != nil {
+		if err := s.versionRepo.DeleteAll(ctx, note.ID); err != nil {
+			return err
+		}
+	}
+
+	// Open conflicts are charged until they are resolved or deleted
+	var conflictBytes int64
+	if s.conflictRepo != nil {
+		conflicts, err := s.conflictRepo.ListByNote(ctx, note.ID)
+		if err != nil {
+			return err
+		}
+		for _, c := range conflicts {
+			if err := s.conflictRepo.Delete(ctx, c.ID); err != nil {
+				return err
+			}
+			if c.ResolvedAt == nil {
+				conflictBytes += conflictSize(c)
+			}
+		}
+	}
+
+	if s.crdtRepo != nil {
+		crdtBytes, err := s.crdtRepo.TotalSize(ctx, note.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.crdtRepo.DeleteAll(ctx, note.ID); err != nil {
+			return err
+		}
+		versionBytes += crdtBytes
+	}
+
+	if s.chunkRepo != nil {
+		if err := s.chunkRepo.DeleteAll(ctx, note.ID); err != nil {
+			return err
+		}
+	}
+
+	deletedAt := note.UpdatedAt
+	if note.DeletedAt != nil {
+		deletedAt = *note.DeletedAt
+	}
+
+	// The tombstone is written before the note disappears so a failure can
+	// never leave devices without a delete operation.
+	if err := s.tombstoneRepo.Create(ctx, &domain.Tombstone{
+		NoteID:      note.ID,
+		UserID:      note.UserID,
+		WorkspaceID: note.WorkspaceID,
+		Version:     note.Version + 1,
+		DeletedAt:   deletedAt,
+		PurgedAt:    time.Now().UTC(),
+	}); err != nil {
+		return err
+	}
+
+	if err := s.noteRepo.Purge(ctx, note.ID); err != nil {
+		return err
+	}
+
+	if s.usageService != nil {
+		s.usageService.RecordWrite(ctx, note.UserID, note.WorkspaceID, -NoteSize(note), -versionBytes)
+		s.usageService.RecordConflict(ctx, note.UserID, -conflictBytes)
+	}
+
+	return nil
+}
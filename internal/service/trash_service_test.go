@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+)
+
+type mockTombstoneRepo struct {
+	tombstones map[string]*domain.Tombstone
+}
+
+func newMockTombstoneRepo() *mockTombstoneRepo {
+	return &mockTombstoneRepo{
+		tombstones: make(map[string]*domain.Tombstone),
+	}
+}
+
+func (m *mockTombstoneRepo) Create(ctx context.Context, tombstone *domain.Tombstone) error {
+	m.tombstones[tombstone.NoteID] = tombstone
+	return nil
+}
+
+func (m *mockTombstoneRepo) ListByUser(ctx context.Context, userID string) ([]*domain.Tombstone, error) {
+	var tombstones []*domain.Tombstone
+	for _, t := range m.tombstones {
+		if t.UserID == userID {
+			tombstones = append(tombstones, t)
+		}
+	}
+	return tombstones, nil
+}
+
+func (m *mockTombstoneRepo) DeletePurgedBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	deleted := 0
+	for id, t := range m.tombstones {
+		if t.PurgedAt.Before(cutoff) {
+			delete(m.tombstones, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func newTestTrashService(repo *mockNoteRepo, tombstones *mockTombstoneRepo) *TrashService {
+	return NewTrashService(repo, nil, &mockVersionRepo{}, nil, nil, nil, tombstones, nil, nil, 24*time.Hour, 48*time.Hour)
+}
+
+func TestTrashService_Restore(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	service := newTestTrashService(repo, newMockTombstoneRepo())
+
+	repo.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", Version: 1})
+
+	if _, err := service.Restore(ctx, "user1", "n1", "d1"); !errors.Is(err, ErrNoteNotInTrash) {
+		t.Errorf("expected ErrNoteNotInTrash, got %v", err)
+	}
+
+	repo.Delete(ctx, "n1")
+
+	if _, err := service.Restore(ctx, "user2", "n1", "d1"); !errors.Is(err, ErrNoteAccessDenied) {
+		t.Errorf("expected ErrNoteAccessDenied, got %v", err)
+	}
+
+	restored, err := service.Restore(ctx, "user1", "n1", "d1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if restored.IsDeleted || restored.DeletedAt != nil {
+		t.Error("expected note to be restored")
+	}
+	if restored.Version != 3 {
+		t.Errorf("expected version 3, got %d", restored.Version)
+	}
+}
+
+func TestTrashService_Purge(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	tombstones := newMockTombstoneRepo()
+	service := newTestTrashService(repo, tombstones)
+
+	repo.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	repo.Delete(ctx, "n1")
+
+	if err := service.Purge(ctx, "user1", "n1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := repo.FindByID(ctx, "n1"); err == nil {
+		t.Error("expected note to be purged")
+	}
+
+	tombstone, ok := tombstones.tombstones["n1"]
+	if !ok {
+		t.Fatal("expected tombstone to be created")
+	}
+	if tombstone.Version != 3 || tombstone.WorkspaceID != "ws1" {
+		t.Errorf("unexpected tombstone %+v", tombstone)
+	}
+}
+
+func TestTrashService_ArchivedWorkspace(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	workspaces := newMockWorkspaceRepo()
+	service := NewTrashService(repo, workspaces, &mockVersionRepo{}, nil, nil, nil, newMockTombstoneRepo(), nil, nil, 24*time.Hour, 48*time.Hour)
+
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1", IsArchived: true})
+	repo.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	repo.Delete(ctx, "n1")
+
+	if _, err := service.Restore(ctx, "user1", "n1", "d1"); !errors.Is(err, ErrWorkspaceArchived) {
+		t.Errorf("expected ErrWorkspaceArchived on restore, got %v", err)
+	}
+	if err := service.Purge(ctx, "user1", "n1"); !errors.Is(err, ErrWorkspaceArchived) {
+		t.Errorf("expected ErrWorkspaceArchived on purge, got %v", err)
+	}
+	if note, err := repo.FindByID(ctx, "n1"); err != nil || !note.IsDeleted {
+		t.Errorf("expected the note to stay in the trash, got %+v (%v)", note, err)
+	}
+}
+
+func TestTrashService_PurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	tombstones := newMockTombstoneRepo()
+	service := newTestTrashService(repo, tombstones)
+
+	old := time.Now().Add(-72 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+
+	repo.Create(ctx, &domain.Note{ID: "old", UserID: "user1", IsDeleted: true, DeletedAt: &old})
+	repo.Create(ctx, &domain.Note{ID: "recent", UserID: "user1", IsDeleted: true, DeletedAt: &recent})
+	// Trashed before deleted_at was recorded
+	repo.Create(ctx, &domain.Note{ID: "legacy", UserID: "user1", IsDeleted: true, UpdatedAt: old})
+	tombstones.Create(ctx, &domain.Tombstone{NoteID: "stale", UserID: "user1", PurgedAt: old})
+
+	if err := service.PurgeExpired(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := repo.FindByID(ctx, "old"); err == nil {
+		t.Error("expected expired note to be purged")
+	}
+	if _, err := repo.FindByID(ctx, "recent"); err != nil {
+		t.Error("expected recent note to stay in trash")
+	}
+	if _, err := repo.FindByID(ctx, "legacy"); err == nil {
+		t.Error("expected note without deleted_at to be purged by updated_at")
+	}
+	if _, ok := tombstones.tombstones["stale"]; ok {
+		t.Error("expected stale tombstone to be removed")
+	}
+	if _, ok := tombstones.tombstones["old"]; !ok {
+		t.Error("expected tombstone for purged note")
+	}
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+// reconcileVersionLimit caps how many versions per note are read when
+// recalculating usage.
+const reconcileVersionLimit = 1000
+
+// usageUpdateAttempts bounds how often a usage update is retried after a
+// concurrent write changed the stored counters
+const usageUpdateAttempts = 5
+
+// QuotaLimits holds the storage limits in bytes. Zero disables a limit.
+type QuotaLimits struct {
+	PerUser      int64
+	PerWorkspace int64
+	PerNote      int64
+}
+
+type UsageService struct {
+	usageRepo    repository.UsageRepository
+	userRepo     repository.UserRepository
+	noteRepo     repository.NoteRepository
+	versionRepo  repository.NoteVersionRepository
+	conflictRepo repository.ConflictRepository
+	chunkRepo    repository.ChunkRepository
+	crdtRepo     repository.CRDTRepository
+	limits       QuotaLimits
+}
+
+func NewUsageService(
+	usageRepo repository.UsageRepository,
+	userRepo repository.UserRepository,
+	noteRepo repository.NoteRepository,
+	versionRepo repository.NoteVersionRepository,
+	conflictRepo repository.ConflictRepository,
+	chunkRepo repository.ChunkRepository,
+	crdtRepo repository.CRDTRepository,
+	limits QuotaLimits,
+) *UsageService {
+	return &UsageService{
+		usageRepo:    usageRepo,
+		userRepo:     userRepo,
+		noteRepo:     noteRepo,
+		versionRepo:  versionRepo,
+		conflictRepo: conflictRepo,
+		chunkRepo:    chunkRepo,
+		crdtRepo:     crdtRepo,
+		limits:       limits,
+	}
+}
+
+// CheckWrite verifies that storing a note of noteSize bytes, growing the
+// user's total usage by delta bytes, stays within the configured quotas.
+func (s *UsageService) CheckWrite(ctx context.Context, userID, workspaceID string, noteSize, delta int64) error {
+	if s.limits.PerNote > 0 && noteSize > s.limits.PerNote {
+		return ErrNoteTooLarge
+	}
+
+	if delta <= 0 {
+		return nil
+	}
+
+	usage, err := s.usageRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if s.limits.PerUser > 0 && usage.TotalBytes()+delta > s.limits.PerUser {
+		return &QuotaExceededError{
+			Scope:     "user",
+			Limit:     s.limits.PerUser,
+			Used:      usage.TotalBytes(),
+			Requested: delta,
+		}
+	}
+
+	if s.limits.PerWorkspace > 0 {
+		var used int64
+		if ws, ok := usage.Workspaces[workspaceID]; ok {
+			used = ws.TotalBytes()
+		}
+		if used+delta > s.limits.PerWorkspace {
+			return &QuotaExceededError{
+				Scope:     "workspace",
+				Limit:     s.limits.PerWorkspace,
+				Used:      used,
+				Requested: delta,
+			}
+		}
+	}
+
+	return nil
+}
+
+// RecordWrite adjusts the stored usage counters after a successful write.
+// Failures are only logged: the reconciliation job corrects any drift.
+func (s *UsageService) RecordWrite(ctx context.Context, userID, workspaceID string, contentDelta, versionDelta int64) {
+	err := s.update(ctx, userID, func(usage *domain.Usage) {
+		usage.ContentBytes += contentDelta
+		usage.VersionBytes += versionDelta
+
+		ws := workspaceUsage(usage, workspaceID)
+		ws.ContentBytes += contentDelta
+		ws.VersionBytes += versionDelta
+	})
+	if err != nil {
+		log.Printf("failed to record usage for user %s: %v", userID, err)
+	}
+}
+
+// RecordConflict adjusts the conflict bytes of a user by delta: conflicts are
+// charged while they are open and released once resolved or deleted.
+// Failures are only logged like in RecordWrite.
+func (s *UsageService) RecordConflict(ctx context.Context, userID string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	err := s.update(ctx, userID, func(usage *domain.Usage) {
+		usage.ConflictBytes = max(usage.ConflictBytes+delta, 0)
+	})
+	if err != nil {
+		log.Printf("failed to record conflict usage for user %s: %v", userID, err)
+	}
+}
+
+// update applies change to the stored usage of a user, reading it again and
+// retrying when a concurrent write saved it first
+func (s *UsageService) update(ctx context.Context, userID string, change func(usage *domain.Usage)) error {
+	var err error
+	for attempt := 0; attempt < usageUpdateAttempts; attempt++ {
+		var usage *domain.Usage
+		if usage, err = s.usageRepo.Get(ctx, userID); err != nil {
+			return err
+		}
+
+		change(usage)
+		usage.UpdatedAt = time.Now()
+
+		if err = s.usageRepo.Save(ctx, usage); !errors.Is(err, repository.ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+func workspaceUsage(usage *domain.Usage, workspaceID string) *domain.WorkspaceUsage {
+	ws, ok := usage.Workspaces[workspaceID]
+	if !ok {
+		ws = &domain.WorkspaceUsage{}
+		usage.Workspaces[workspaceID] = ws
+	}
+	return ws
+}
+
+// CheckTransfer verifies that moving bytes of existing data into workspaceID
+// stays within the per-workspace quota. The user's total does not change.
+func (s *UsageService) CheckTransfer(ctx context.Context, userID, workspaceID string, bytes int64) error {
+	if s.limits.PerWorkspace <= 0 || bytes <= 0 {
+		return nil
+	}
+
+	usage, err := s.usageRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var used int64
+	if ws, ok := usage.Workspaces[workspaceID]; ok {
+		used = ws.TotalBytes()
+	}
+	if used+bytes > s.limits.PerWorkspace {
+		return &QuotaExceededError{
+			Scope:     "workspace",
+			Limit:     s.limits.PerWorkspace,
+			Used:      used,
+			Requested: bytes,
+		}
+	}
+
+	return nil
+}
+
+// RecordTransfer moves usage between two workspaces of the same user
+func (s *UsageService) RecordTransfer(ctx context.Context, userID, fromWorkspaceID, toWorkspaceID string, contentBytes, versionBytes int64) {
+	err := s.update(ctx, userID, func(usage *domain.Usage) {
+		from := workspaceUsage(usage, fromWorkspaceID)
+		from.ContentBytes -= contentBytes
+		from.VersionBytes -= versionBytes
+
+		to := workspaceUsage(usage, toWorkspaceID)
+		to.ContentBytes += contentBytes
+		to.VersionBytes += versionBytes
+	})
+	if err != nil {
+		log.Printf("failed to record usage transfer for user %s: %v", userID, err)
+	}
+}
+
+// GetUsage returns the current usage of a user together with the applicable quotas
+func (s *UsageService) GetUsage(ctx context.Context, userID string) (*domain.UsageResponse, error) {
+	usage, err := s.usageRepo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	workspaces := make(map[string]*domain.WorkspaceUsageResponse, len(usage.Workspaces))
+	for id, ws := range usage.Workspaces {
+		workspaces[id] = &domain.WorkspaceUsageResponse{
+			ContentBytes: ws.ContentBytes,
+			VersionBytes: ws.VersionBytes,
+			TotalBytes:   ws.TotalBytes(),
+			QuotaBytes:   s.limits.PerWorkspace,
+		}
+	}
+
+	return &domain.UsageResponse{
+		ContentBytes:  usage.ContentBytes,
+		VersionBytes:  usage.VersionBytes,
+		ConflictBytes: usage.ConflictBytes,
+		TotalBytes:    usage.TotalBytes(),
+		QuotaBytes:    s.limits.PerUser,
+		Workspaces:    workspaces,
+		ReconciledAt:  usage.ReconciledAt,
+	}, nil
+}
+
+// Reconcile recalculates a user's usage from the stored notes, versions,
+// CRDT logs and open conflicts
+func (s *UsageService) Reconcile(ctx context.Context, userID string) (*domain.Usage, error) {
+	notes, err := s.noteRepo.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	usage := &domain.Usage{
+		UserID:       userID,
+		Workspaces:   make(map[string]*domain.WorkspaceUsage),
+		ReconciledAt: &now,
+		UpdatedAt:    now,
+	}
+
+	for _, note := range notes {
+		ws, ok := usage.Workspaces[note.WorkspaceID]
+		if !ok {
+			ws = &domain.WorkspaceUsage{}
+			usage.Workspaces[note.WorkspaceID] = ws
+		}
+
+		size := NoteSize(note)
+		usage.ContentBytes += size
+		ws.ContentBytes += size
+
+		versionBytes, err := storedVersionBytes(ctx, s.versionRepo, s.chunkRepo, note)
+		if err != nil {
+			return nil, err
+		}
+		// The CRDT log counts as version history of the note
+		if s.crdtRepo != nil {
+			crdtBytes, err := s.crdtRepo.TotalSize(ctx, note.ID)
+			if err != nil {
+				return nil, err
+			}
+			versionBytes += crdtBytes
+		}
+		usage.VersionBytes += versionBytes
+		ws.VersionBytes += versionBytes
+	}
+
+	if s.conflictRepo != nil {
+		conflicts, err := s.conflictRepo.ListByUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		// Resolved conflicts are kept for the history but no longer charged
+		for _, c := range conflicts {
+			if c.ResolvedAt != nil {
+				continue
+			}
+			usage.ConflictBytes += conflictSize(c)
+		}
+	}
+
+	// The recalculated counters replace whatever is stored
+	err = s.update(ctx, userID, func(stored *domain.Usage) {
+		rev := stored.Rev
+		*stored = *usage
+		stored.Rev = rev
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// ReconcileAll recalculates the usage of every user
+func (s *UsageService) ReconcileAll(ctx context.Context) error {
+	users, err := s.userRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := s.Reconcile(ctx, user.ID); err != nil {
+			log.Printf("failed to reconcile usage for user %s: %v", user.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// RunReconciler periodically recalculates usage until ctx is cancelled
+func (s *UsageService) RunReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ReconcileAll(ctx); err != nil {
+				log.Printf("usage reconciliation failed: %v", err)
+			}
+		}
+	}
+}
+
+// NoteSize returns the number of stored encrypted bytes of a note
+func NoteSize(note *domain.Note) int64 {
+	return int64(len(note.EncryptedTitle)) + contentSize(note)
+}
+
+// contentSize returns the size of the whole or chunked content of a note
+func contentSize(note *domain.Note) int64 {
+	return int64(len(note.EncryptedContent)) + note.ContentSize
+}
+
+// conflictSize returns the bytes a conflict stores. Chunked content only
+// lists hashes; its chunks are counted with the note's.
+func conflictSize(c *domain.Conflict) int64 {
+	var size int64
+	if c.ServerNote != nil {
+		size += int64(len(c.ServerNote.EncryptedTitle) + len(c.ServerNote.EncryptedContent))
+	}
+	if c.ClientData != nil {
+		if c.ClientData.EncryptedTitle != nil {
+			size += int64(len(*c.ClientData.EncryptedTitle))
+		}
+		if c.ClientData.EncryptedContent != nil {
+			size += int64(len(*c.ClientData.EncryptedContent))
+		}
+	}
+	return size
+}
+
+// storedVersionBytes returns the size of the stored versions of a note. A
+// chunk is stored once per note however many versions share it, so chunk
+// bytes are not summed per version: the chunks the current content does not
+// use are counted here instead.
+func storedVersionBytes(ctx context.Context, versionRepo repository.NoteVersionRepository, chunkRepo repository.ChunkRepository, note *domain.Note) (int64, error) {
+	var size int64
+	if versionRepo != nil {
+		versions, err := versionRepo.GetVersions(ctx, note.ID, reconcileVersionLimit)
+		if err != nil {
+			return 0, err
+		}
+		for _, v := range versions {
+			size += int64(len(v.EncryptedTitle) + len(v.EncryptedContent))
+		}
+	}
+
+	if chunkRepo != nil {
+		chunkBytes, err := chunkRepo.TotalSize(ctx, note.ID)
+		if err != nil {
+			return 0, err
+		}
+		size += max(chunkBytes-note.ContentSize, 0)
+	}
+	return size, nil
+}
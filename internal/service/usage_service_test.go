@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type mockUsageRepo struct {
+	usage map[string]*domain.Usage
+	// conflicts is how many of the next saves fail as concurrent writes
+	conflicts int
+}
+
+func newMockUsageRepo() *mockUsageRepo {
+	return &mockUsageRepo{
+		usage: make(map[string]*domain.Usage),
+	}
+}
+
+func (m *mockUsageRepo) Get(ctx context.Context, userID string) (*domain.Usage, error) {
+	if u, exists := m.usage[userID]; exists {
+		copied := *u
+		copied.Workspaces = make(map[string]*domain.WorkspaceUsage)
+		for id, ws := range u.Workspaces {
+			wsCopy := *ws
+			copied.Workspaces[id] = &wsCopy
+		}
+		return &copied, nil
+	}
+	return &domain.Usage{UserID: userID, Workspaces: make(map[string]*domain.WorkspaceUsage)}, nil
+}
+
+func (m *mockUsageRepo) Save(ctx context.Context, usage *domain.Usage) error {
+	if m.conflicts > 0 {
+		m.conflicts--
+		return repository.ErrConflict
+	}
+	m.usage[usage.UserID] = usage
+	return nil
+}
+
+func TestUsageService_CheckWrite(t *testing.T) {
+	ctx := context.Background()
+	usageRepo := newMockUsageRepo()
+	service := NewUsageService(usageRepo, nil, newMockNoteRepo(), nil, nil, nil, nil, QuotaLimits{
+		PerUser:      100,
+		PerWorkspace: 50,
+		PerNote:      40,
+	})
+
+	if err := service.CheckWrite(ctx, "user1", "ws1", 30, 30); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := service.CheckWrite(ctx, "user1", "ws1", 41, 41); !errors.Is(err, ErrNoteTooLarge) {
+		t.Errorf("expected ErrNoteTooLarge, got %v", err)
+	}
+
+	service.RecordWrite(ctx, "user1", "ws1", 30, 0)
+
+	var quotaErr *QuotaExceededError
+	err := service.CheckWrite(ctx, "user1", "ws1", 30, 30)
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("expected QuotaExceededError, got %v", err)
+	}
+	if quotaErr.Scope != "workspace" {
+		t.Errorf("expected workspace scope, got %s", quotaErr.Scope)
+	}
+
+	service.RecordWrite(ctx, "user1", "ws2", 40, 20)
+
+	err = service.CheckWrite(ctx, "user1", "ws3", 20, 20)
+	if !errors.As(err, &quotaErr) || quotaErr.Scope != "user" {
+		t.Errorf("expected user quota error, got %v", err)
+	}
+}
+
+func TestUsageService_RecordWriteRetriesConflict(t *testing.T) {
+	ctx := context.Background()
+	usageRepo := newMockUsageRepo()
+	service := NewUsageService(usageRepo, nil, newMockNoteRepo(), nil, nil, nil, nil, QuotaLimits{})
+
+	service.RecordWrite(ctx, "user1", "ws1", 10, 5)
+
+	// Concurrent writes saved first twice; the delta is applied on top of them
+	usageRepo.conflicts = 2
+	service.RecordWrite(ctx, "user1", "ws1", 10, 5)
+
+	usage, _ := usageRepo.Get(ctx, "user1")
+	if usage.ContentBytes != 20 || usage.VersionBytes != 10 || usage.Workspaces["ws1"].ContentBytes != 20 {
+		t.Errorf("expected both writes to be counted, got %+v", usage)
+	}
+}
+
+func TestUsageService_Reconcile(t *testing.T) {
+	ctx := context.Background()
+	noteRepo := newMockNoteRepo()
+	usageRepo := newMockUsageRepo()
+	service := NewUsageService(usageRepo, nil, noteRepo, &mockVersionRepo{}, nil, nil, nil, QuotaLimits{})
+
+	noteRepo.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", EncryptedTitle: "title", EncryptedContent: "content"})
+	noteRepo.Create(ctx, &domain.Note{ID: "n2", UserID: "user1", WorkspaceID: "ws2", EncryptedTitle: "t", EncryptedContent: "c"})
+	noteRepo.Create(ctx, &domain.Note{ID: "n3", UserID: "user2", WorkspaceID: "ws3", EncryptedTitle: "other"})
+
+	usageRepo.Save(ctx, &domain.Usage{UserID: "user1", ContentBytes: 999})
+
+	usage, err := service.Reconcile(ctx, "user1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if usage.ContentBytes != 14 {
+		t.Errorf("expected 14 content bytes, got %d", usage.ContentBytes)
+	}
+	if usage.Workspaces["ws1"].ContentBytes != 12 {
+		t.Errorf("expected 12 bytes in ws1, got %d", usage.Workspaces["ws1"].ContentBytes)
+	}
+	if usage.ReconciledAt == nil {
+		t.Error("expected reconciled_at to be set")
+	}
+}
+
+func TestNoteService_CreateQuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	usageService := NewUsageService(newMockUsageRepo(), nil, repo, nil, nil, nil, nil, QuotaLimits{PerUser: 10})
+	service := NewNoteService(repo, &mockVersionRepo{}, nil, nil, usageService, nil, nil)
+
+	_, err := service.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "a-very-long-title", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("expected QuotaExceededError, got %v", err)
+	}
+	if len(repo.notes) != 0 {
+		t.Error("expected note not to be stored")
+	}
+}
+
+func TestConflictService_ChargesOpenConflicts(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockNoteRepo()
+	versionRepo := &mockVersionRepo{}
+	conflicts := newMockConflictRepo()
+	usageRepo := newMockUsageRepo()
+	usageService := NewUsageService(usageRepo, nil, repo, versionRepo, conflicts, nil, nil, QuotaLimits{PerUser: 100})
+	conflictService := NewConflictService(conflicts, versionRepo, repo, nil, nil, nil, usageService, ConflictExpiryPolicy{})
+	noteService := NewNoteService(repo, versionRepo, conflictService, nil, usageService, nil, nil)
+
+	note, _ := noteService.Create(ctx, "user1", &domain.CreateNoteRequest{Type: domain.NoteTypeFile, EncryptedTitle: "old", EncryptionAlgo: "algo", Nonce: "n", DeviceID: "d1"})
+	serverTitle := "new"
+	if _, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &serverTitle, DeviceID: "d1"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	baseVersion := note.Version
+	lostTitle := "lost-edit"
+	_, err := noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &lostTitle, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	// The conflict stores both the server note and the losing write
+	if got, want := usageRepo.usage["user1"].ConflictBytes, int64(len(serverTitle)+len(lostTitle)); got != want {
+		t.Errorf("expected %d conflict bytes while open, got %d", want, got)
+	}
+
+	if _, err := conflictService.ApplyResolution(ctx, conflictErr.Conflict.ID, domain.ResolutionServer, nil); err != nil {
+		t.Fatalf("ApplyResolution failed: %v", err)
+	}
+	if got := usageRepo.usage["user1"].ConflictBytes; got != 0 {
+		t.Errorf("expected conflict bytes released on resolution, got %d", got)
+	}
+
+	// A losing write over the quota is rejected instead of stored
+	bigTitle := string(make([]byte, 200))
+	_, err = noteService.Update(ctx, "user1", note.ID, &domain.UpdateNoteRequest{EncryptedTitle: &bigTitle, ExpectedVersion: &baseVersion, DeviceID: "d2"})
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("expected QuotaExceededError, got %v", err)
+	}
+	if len(conflicts.conflicts) != 1 {
+		t.Errorf("expected the rejected conflict not to be stored, got %d conflicts", len(conflicts.conflicts))
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -18,8 +19,8 @@ func NewUserService(userRepo repository.UserRepository) *UserService {
 	}
 }
 
-func (s *UserService) GetByID(id string) (*domain.User, error) {
-	user, err := s.userRepo.FindByID(id)
+func (s *UserService) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	user, err := s.userRepo.FindByID(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("user not found")
 	}
@@ -28,13 +29,13 @@ func (s *UserService) GetByID(id string) (*domain.User, error) {
 	return user, nil
 }
 
-func (s *UserService) UpdateUsername(userID, newUsername string) (*domain.User, error) {
-	user, err := s.userRepo.FindByID(userID)
+func (s *UserService) UpdateUsername(ctx context.Context, userID, newUsername string) (*domain.User, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("user not found")
 	}
 
-	usernameExists, err := s.userRepo.UsernameExists(newUsername)
+	usernameExists, err := s.userRepo.UsernameExists(ctx, newUsername)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check username: %w", err)
 	}
@@ -45,7 +46,7 @@ func (s *UserService) UpdateUsername(userID, newUsername string) (*domain.User,
 	user.Username = newUsername
 	user.UpdatedAt = time.Now()
 
-	if err := s.userRepo.Update(user); err != nil {
+	if err := s.userRepo.Update(ctx, user); err != nil {
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
@@ -0,0 +1,411 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidArchive = errors.New("invalid workspace archive")
+
+// ArchiveService exports workspaces as tar.gz archives and imports them back.
+//
+// An archive holds manifest.json, one notes/<note_id>.json per note and,
+// optionally, one versions/<note_id>/<version>.json per stored version. The
+// chunks of chunked notes and versions are stored once per note as
+// chunks/<note_id>/<hash>.json. Unknown entries are ignored on import.
+type ArchiveService struct {
+	workspaceService *WorkspaceService
+	noteRepo         repository.NoteRepository
+	versionRepo      repository.NoteVersionRepository
+	chunkRepo        repository.ChunkRepository
+	usageService     *UsageService
+}
+
+func NewArchiveService(
+	workspaceService *WorkspaceService,
+	noteRepo repository.NoteRepository,
+	versionRepo repository.NoteVersionRepository,
+	chunkRepo repository.ChunkRepository,
+	usageService *UsageService,
+) *ArchiveService {
+	return &ArchiveService{
+		workspaceService: workspaceService,
+		noteRepo:         noteRepo,
+		versionRepo:      versionRepo,
+		chunkRepo:        chunkRepo,
+		usageService:     usageService,
+	}
+}
+
+// Export streams the non-deleted notes of a workspace to w. Access must be
+// checked by the caller before anything is written.
+func (s *ArchiveService) Export(ctx context.Context, workspace *domain.WorkspaceResponse, includeVersions bool, w io.Writer) error {
+	notes, err := s.noteRepo.ListByWorkspace(ctx, workspace.ID)
+	if err != nil {
+		return err
+	}
+
+	var live []*domain.Note
+	versions := make(map[string][]*domain.NoteVersion)
+	versionCount := 0
+	for _, n := range notes {
+		if n.IsDeleted {
+			continue
+		}
+		live = append(live, n)
+
+		if includeVersions && s.versionRepo != nil {
+			noteVersions, err := s.versionRepo.GetVersions(ctx, n.ID, reconcileVersionLimit)
+			if err != nil {
+				return err
+			}
+			versions[n.ID] = noteVersions
+			versionCount += len(noteVersions)
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifest := &domain.ArchiveManifest{
+		FormatVersion:    domain.ArchiveFormatVersion,
+		Workspace:        domain.ArchiveWorkspace{ID: workspace.ID, Name: workspace.Name},
+		ExportedAt:       time.Now().UTC(),
+		NoteCount:        len(live),
+		VersionCount:     versionCount,
+		IncludesVersions: includeVersions,
+	}
+	if err := writeArchiveEntry(tw, "manifest.json", manifest); err != nil {
+		return err
+	}
+
+	for _, n := range live {
+		if err := writeArchiveEntry(tw, "notes/"+n.ID+".json", noteToResponse(n)); err != nil {
+			return err
+		}
+
+		for _, v := range versions[n.ID] {
+			name := fmt.Sprintf("versions/%s/%d.json", n.ID, v.Version)
+			if err := writeArchiveEntry(tw, name, v); err != nil {
+				return err
+			}
+		}
+
+		if err := s.exportChunks(ctx, tw, n, versions[n.ID]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// exportChunks writes the chunks of a note and of its versions
+func (s *ArchiveService) exportChunks(ctx context.Context, tw *tar.Writer, note *domain.Note, versions []*domain.NoteVersion) error {
+	seen := make(map[string]bool)
+	var hashes []string
+	add := func(chunks []string) {
+		for _, hash := range chunks {
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	add(note.Chunks)
+	for _, v := range versions {
+		add(v.Chunks)
+	}
+	if len(hashes) == 0 || s.chunkRepo == nil {
+		return nil
+	}
+
+	chunks, err := s.chunkRepo.Get(ctx, note.ID, hashes)
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		name := fmt.Sprintf("chunks/%s/%s.json", note.ID, c.Hash)
+		if err := writeArchiveEntry(tw, name, &domain.ChunkData{Hash: c.Hash, Data: c.Data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import recreates an archived workspace for userID. Notes get fresh IDs and
+// their parents are remapped; notes imported with their history keep their
+// version numbers, the others start over at version 1.
+func (s *ArchiveService) Import(ctx context.Context, userID, name string, r io.Reader) (*domain.ImportWorkspaceResponse, error) {
+	contents, err := readArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	manifest, notes, versions := contents.manifest, contents.notes, contents.versions
+
+	var totalBytes int64
+	for _, n := range notes {
+		contentSize, err := contents.chunkedSize(n.ID, n.Chunks)
+		if err != nil {
+			return nil, err
+		}
+		n.ContentSize = contentSize
+
+		size := int64(len(n.EncryptedTitle)+len(n.EncryptedContent)) + contentSize
+		if s.usageService != nil {
+			if err := s.usageService.CheckWrite(ctx, userID, "", size, 0); err != nil {
+				return nil, err
+			}
+		}
+		totalBytes += size
+	}
+
+	var versionBytes int64
+	for noteID, noteVersions := range versions {
+		for _, v := range noteVersions {
+			contentSize, err := contents.chunkedSize(noteID, v.Chunks)
+			if err != nil {
+				return nil, err
+			}
+			v.ContentSize = contentSize
+			versionBytes += int64(len(v.EncryptedTitle)+len(v.EncryptedContent)) + contentSize
+		}
+	}
+
+	if s.usageService != nil {
+		if err := s.usageService.CheckWrite(ctx, userID, "", 0, totalBytes+versionBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if name == "" {
+		name = manifest.Workspace.Name
+	}
+
+	workspace, err := s.workspaceService.Create(ctx, userID, &domain.CreateWorkspaceRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	idMap := make(map[string]string, len(notes))
+	for _, n := range notes {
+		idMap[n.ID] = uuid.New().String()
+	}
+
+	now := time.Now()
+	versionCount := 0
+	for _, archived := range notes {
+		note := &domain.Note{
+			ID:               idMap[archived.ID],
+			UserID:           userID,
+			WorkspaceID:      workspace.ID,
+			Type:             archived.Type,
+			EncryptedTitle:   archived.EncryptedTitle,
+			EncryptedContent: archived.EncryptedContent,
+			EncryptionAlgo:   archived.EncryptionAlgo,
+			Nonce:            archived.Nonce,
+			Chunks:           archived.Chunks,
+			ContentSize:      archived.ContentSize,
+			CreatedAt:        archived.CreatedAt,
+			UpdatedAt:        now,
+			Version:          1,
+			ContentHash:      archived.ContentHash,
+			LastEditDevice:   archived.LastEditDevice,
+		}
+		if archived.ParentID != nil {
+			if parentID, ok := idMap[*archived.ParentID]; ok {
+				note.ParentID = &parentID
+			}
+		}
+
+		if err := s.importChunks(ctx, note.ID, contents.chunks[archived.ID]); err != nil {
+			return nil, err
+		}
+
+		noteVersions := versions[archived.ID]
+		if len(noteVersions) > 0 && s.versionRepo != nil {
+			note.Version = archived.Version
+
+			for _, v := range noteVersions {
+				if err := s.versionRepo.SaveVersion(ctx, &domain.Note{
+					ID:               note.ID,
+					Version:          v.Version,
+					EncryptedTitle:   v.EncryptedTitle,
+					EncryptedContent: v.EncryptedContent,
+					Chunks:           v.Chunks,
+					ContentSize:      v.ContentSize,
+					ContentHash:      v.ContentHash,
+					LastEditDevice:   v.DeviceID,
+				}); err != nil {
+					return nil, err
+				}
+				versionCount++
+			}
+		}
+
+		if err := s.noteRepo.Create(ctx, note); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.usageService != nil {
+		var importedVersionBytes int64
+		if s.versionRepo != nil {
+			importedVersionBytes = versionBytes
+		}
+		s.usageService.RecordWrite(ctx, userID, workspace.ID, totalBytes, importedVersionBytes)
+	}
+
+	return &domain.ImportWorkspaceResponse{
+		Workspace:    workspace,
+		NoteCount:    len(notes),
+		VersionCount: versionCount,
+	}, nil
+}
+
+// importChunks stores the archived chunks of a note for the imported note
+func (s *ArchiveService) importChunks(ctx context.Context, noteID string, archived map[string]string) error {
+	if len(archived) == 0 {
+		return nil
+	}
+	if s.chunkRepo == nil {
+		return ErrInvalidChunks
+	}
+
+	now := time.Now().UTC()
+	chunks := make([]*domain.Chunk, 0, len(archived))
+	for hash, data := range archived {
+		chunks = append(chunks, &domain.Chunk{
+			NoteID:    noteID,
+			Hash:      hash,
+			Data:      data,
+			Size:      int64(len(data)),
+			CreatedAt: now,
+		})
+	}
+	return s.chunkRepo.Save(ctx, chunks)
+}
+
+func writeArchiveEntry(tw *tar.Writer, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	return err
+}
+
+// archiveContents is what an archive holds. chunks maps the archived ID of
+// a note to the data of its chunks by hash.
+type archiveContents struct {
+	manifest *domain.ArchiveManifest
+	notes    []*domain.NoteResponse
+	versions map[string][]*domain.NoteVersion
+	chunks   map[string]map[string]string
+}
+
+// chunkedSize returns the size of the chunked content listed by hashes for
+// an archived note. Every chunk must be in the archive.
+func (c *archiveContents) chunkedSize(noteID string, hashes []string) (int64, error) {
+	var size int64
+	for _, hash := range hashes {
+		data, ok := c.chunks[noteID][hash]
+		if !ok {
+			return 0, fmt.Errorf("%w: chunk %s of note %s is missing", ErrInvalidArchive, hash, noteID)
+		}
+		size += int64(len(data))
+	}
+	return size, nil
+}
+
+func readArchive(r io.Reader) (*archiveContents, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+
+	contents := &archiveContents{
+		versions: make(map[string][]*domain.NoteVersion),
+		chunks:   make(map[string]map[string]string),
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(header.Name)
+		switch {
+		case name == "manifest.json":
+			contents.manifest = &domain.ArchiveManifest{}
+			if err := json.NewDecoder(tr).Decode(contents.manifest); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+			}
+
+		case strings.HasPrefix(name, "notes/"):
+			var note domain.NoteResponse
+			if err := json.NewDecoder(tr).Decode(&note); err != nil || note.ID == "" {
+				return nil, ErrInvalidArchive
+			}
+			contents.notes = append(contents.notes, &note)
+
+		case strings.HasPrefix(name, "versions/"):
+			var version domain.NoteVersion
+			if err := json.NewDecoder(tr).Decode(&version); err != nil || version.NoteID == "" {
+				return nil, ErrInvalidArchive
+			}
+			contents.versions[version.NoteID] = append(contents.versions[version.NoteID], &version)
+
+		case strings.HasPrefix(name, "chunks/"):
+			noteID := path.Base(path.Dir(name))
+			var chunk domain.ChunkData
+			if err := json.NewDecoder(tr).Decode(&chunk); err != nil || chunk.Hash != ChunkHash(chunk.Data) {
+				return nil, ErrInvalidArchive
+			}
+			if contents.chunks[noteID] == nil {
+				contents.chunks[noteID] = make(map[string]string)
+			}
+			contents.chunks[noteID][chunk.Hash] = chunk.Data
+		}
+	}
+
+	if contents.manifest == nil || contents.manifest.FormatVersion != domain.ArchiveFormatVersion {
+		return nil, ErrInvalidArchive
+	}
+
+	return contents, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"inkdown-sync-server/internal/domain"
+)
+
+func TestArchiveService_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	notes := newMockNoteRepo()
+	workspaces := newMockWorkspaceRepo()
+	workspaceService := NewWorkspaceService(workspaces, notes, nil, newMockJobRepo(), nil, nil, nil, nil, nil)
+	service := NewArchiveService(workspaceService, notes, &mockVersionRepo{}, nil, nil)
+
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1", Name: "Notes"})
+	dirID := "dir"
+	notes.Create(ctx, &domain.Note{ID: "dir", UserID: "user1", WorkspaceID: "ws1", Type: domain.NoteTypeDirectory, EncryptedTitle: "d", Version: 3})
+	notes.Create(ctx, &domain.Note{ID: "file", UserID: "user1", WorkspaceID: "ws1", ParentID: &dirID, Type: domain.NoteTypeFile, EncryptedTitle: "f", EncryptedContent: "c", Version: 5})
+	notes.Create(ctx, &domain.Note{ID: "trashed", UserID: "user1", WorkspaceID: "ws1", EncryptedTitle: "t", IsDeleted: true})
+
+	workspace, _ := workspaceService.Get(ctx, "user1", "ws1")
+
+	var archive bytes.Buffer
+	if err := service.Export(ctx, workspace, false, &archive); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	result, err := service.Import(ctx, "user2", "", &archive)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if result.NoteCount != 2 || result.Workspace.Name != "Notes" {
+		t.Fatalf("unexpected import result %+v", result)
+	}
+
+	imported, _ := notes.ListByWorkspace(ctx, result.Workspace.ID)
+	byTitle := make(map[string]*domain.Note)
+	for _, n := range imported {
+		byTitle[n.EncryptedTitle] = n
+	}
+
+	dir, file := byTitle["d"], byTitle["f"]
+	if dir == nil || file == nil {
+		t.Fatalf("expected both notes to be imported, got %d", len(imported))
+	}
+	if dir.ID == "dir" || file.ID == "file" {
+		t.Error("expected fresh note IDs")
+	}
+	if file.ParentID == nil || *file.ParentID != dir.ID {
+		t.Error("expected parent to be remapped")
+	}
+	if file.UserID != "user2" || file.Version != 1 {
+		t.Errorf("unexpected imported note %+v", file)
+	}
+}
+
+func TestArchiveService_ImportInvalid(t *testing.T) {
+	ctx := context.Background()
+	notes := newMockNoteRepo()
+	workspaceService := NewWorkspaceService(newMockWorkspaceRepo(), notes, nil, newMockJobRepo(), nil, nil, nil, nil, nil)
+	service := NewArchiveService(workspaceService, notes, &mockVersionRepo{}, nil, nil)
+
+	if _, err := service.Import(ctx, "user1", "", strings.NewReader("not an archive")); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("expected ErrInvalidArchive, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// EnsureDefaultForUser returns the default workspace of a user, creating it
+// when the user has none yet.
+func (s *WorkspaceService) EnsureDefaultForUser(ctx context.Context, userID string) (*domain.Workspace, error) {
+	workspace, err := s.workspaceRepo.GetDefault(ctx, userID)
+	if err == nil {
+		return workspace, nil
+	}
+	if !errors.Is(err, repository.ErrWorkspaceNotFound) {
+		return nil, err
+	}
+
+	now := time.Now()
+	workspace = &domain.Workspace{
+		ID:        "workspace:" + uuid.New().String(),
+		OwnerID:   userID,
+		Name:      "My Workspace",
+		CreatedAt: now,
+		UpdatedAt: now,
+		IsDefault: true,
+	}
+
+	if err := s.workspaceRepo.Create(ctx, workspace); err != nil {
+		return nil, err
+	}
+
+	return workspace, nil
+}
+
+// MigrateDefaultWorkspaces gives every existing user a default workspace and
+// moves notes without a valid workspace into it. It is safe to run on every
+// start.
+func (s *WorkspaceService) MigrateDefaultWorkspaces(ctx context.Context) error {
+	users, err := s.userRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		workspace, err := s.EnsureDefaultForUser(ctx, user.ID)
+		if err != nil {
+			log.Printf("failed to provision default workspace for user %s: %v", user.ID, err)
+			continue
+		}
+
+		assigned, err := s.assignOrphanNotes(ctx, user.ID, workspace.ID)
+		if err != nil {
+			log.Printf("failed to assign orphan notes for user %s: %v", user.ID, err)
+			continue
+		}
+		if assigned > 0 {
+			log.Printf("assigned %d orphan notes of user %s to workspace %s", assigned, user.ID, workspace.ID)
+		}
+	}
+
+	return nil
+}
+
+// assignOrphanNotes moves the notes of a user whose workspace is empty or
+// unknown into workspaceID. Versions are bumped so devices pick up the change.
+func (s *WorkspaceService) assignOrphanNotes(ctx context.Context, userID, workspaceID string) (int, error) {
+	workspaces, err := s.workspaceRepo.GetByOwner(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	owned := make(map[string]bool, len(workspaces))
+	for _, ws := range workspaces {
+		owned[ws.ID] = true
+	}
+
+	notes, err := s.noteRepo.List(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	assigned := 0
+	for _, note := range notes {
+		if note.WorkspaceID != "" && owned[note.WorkspaceID] {
+			continue
+		}
+
+		note.WorkspaceID = workspaceID
+		note.Version++
+		note.UpdatedAt = time.Now()
+
+		if err := s.noteRepo.Update(ctx, note); err != nil {
+			return assigned, err
+		}
+		assigned++
+	}
+
+	return assigned, nil
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+// jobProgressInterval is how many processed items go by between progress saves
+const jobProgressInterval = 25
+
+// RunJobs processes pending jobs until ctx is cancelled. Jobs left unfinished
+// by a previous run are resumed on start.
+func (s *WorkspaceService) RunJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.ProcessJobs(ctx); err != nil {
+			log.Printf("job processing failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// ProcessJobs runs every pending or interrupted job to completion
+func (s *WorkspaceService) ProcessJobs(ctx context.Context) error {
+	jobs, err := s.jobRepo.ListUnfinished(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.runJob(ctx, job)
+	}
+
+	return nil
+}
+
+func (s *WorkspaceService) runJob(ctx context.Context, job *domain.Job) {
+	job.Status = domain.JobStatusRunning
+	s.saveJob(ctx, job)
+
+	var err error
+	switch job.Type {
+	case domain.JobTypeWorkspaceDelete:
+		err = s.deleteWorkspace(ctx, job)
+	case domain.JobTypeWorkspaceTransfer:
+		err = s.transferWorkspace(ctx, job)
+	default:
+		err = fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	// An interrupted job stays running and is resumed on the next start. Its
+	// progress is saved even though ctx is already canceled.
+	if ctx.Err() != nil {
+		s.saveJob(context.WithoutCancel(ctx), job)
+		return
+	}
+
+	now := time.Now()
+	job.CompletedAt = &now
+	if err != nil {
+		job.Status = domain.JobStatusFailed
+		job.Error = err.Error()
+		log.Printf("job %s failed: %v", job.ID, err)
+	} else {
+		job.Status = domain.JobStatusCompleted
+	}
+	s.saveJob(ctx, job)
+}
+
+func (s *WorkspaceService) saveJob(ctx context.Context, job *domain.Job) {
+	job.UpdatedAt = time.Now()
+	if err := s.jobRepo.Update(ctx, job); err != nil {
+		log.Printf("failed to save job %s: %v", job.ID, err)
+	}
+}
+
+// deleteWorkspace processes every note of the workspace and removes the
+// workspace document once it is empty. Notes created while the job runs are
+// picked up by the next pass.
+func (s *WorkspaceService) deleteWorkspace(ctx context.Context, job *domain.Job) error {
+	workspaceID := job.Params["workspace_id"]
+	mode := job.Params["mode"]
+
+	// Moved notes go to the default workspace, and so do trashed ones so a
+	// restore does not revive them into the deleted workspace
+	var targetID string
+	switch mode {
+	case domain.WorkspaceDeleteMove:
+		target, err := s.workspaceRepo.GetDefault(ctx, job.UserID)
+		if err != nil {
+			return ErrNoDefaultWorkspace
+		}
+		targetID = target.ID
+	case domain.WorkspaceDeleteTrash:
+		target, err := s.EnsureDefaultForUser(ctx, job.UserID)
+		if err != nil {
+			return err
+		}
+		targetID = target.ID
+	}
+
+	for {
+		notes, err := s.noteRepo.ListByWorkspace(ctx, workspaceID)
+		if err != nil {
+			return err
+		}
+
+		var pending []*domain.Note
+		for _, n := range notes {
+			if n.UserID != job.UserID {
+				continue
+			}
+			pending = append(pending, n)
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+
+		job.Total = job.Processed + len(pending)
+		for _, n := range pending {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if err := s.deleteWorkspaceNote(ctx, job, n, targetID); err != nil {
+				return err
+			}
+
+			job.Processed++
+			if job.Processed%jobProgressInterval == 0 {
+				s.saveJob(ctx, job)
+			}
+		}
+	}
+
+	if err := s.workspaceRepo.Delete(ctx, workspaceID); err != nil && !errors.Is(err, repository.ErrWorkspaceNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *WorkspaceService) deleteWorkspaceNote(ctx context.Context, job *domain.Job, note *domain.Note, targetID string) error {
+	deviceID := job.Params["device_id"]
+
+	switch job.Params["mode"] {
+	case domain.WorkspaceDeleteTrash:
+		now := time.Now()
+		trashed := *note
+		trashed.WorkspaceID = targetID
+		trashed.UpdatedAt = now
+		trashed.Version++
+		trashed.LastEditDevice = deviceID
+		if !note.IsDeleted {
+			trashed.IsDeleted = true
+			trashed.DeletedAt = &now
+		}
+
+		if err := s.noteRepo.Update(ctx, &trashed); err != nil {
+			return err
+		}
+
+		if s.usageService != nil {
+			s.usageService.RecordTransfer(ctx, job.UserID, note.WorkspaceID, targetID, NoteSize(note), 0)
+		}
+		if s.syncService != nil && !note.IsDeleted {
+			s.syncService.BroadcastNoteDelete(job.UserID, deviceID, note.WorkspaceID, note.ID, trashed.Version)
+		}
+
+	case domain.WorkspaceDeletePurge:
+		if s.trashService == nil {
+			return errors.New("purging requires the trash service")
+		}
+		if err := s.trashService.purgeNote(ctx, note); err != nil {
+			return err
+		}
+		if s.syncService != nil && !note.IsDeleted {
+			s.syncService.BroadcastNoteDelete(job.UserID, deviceID, note.WorkspaceID, note.ID, note.Version+1)
+		}
+
+	case domain.WorkspaceDeleteMove:
+		moved := *note
+		moved.WorkspaceID = targetID
+		moved.UpdatedAt = time.Now()
+		moved.Version++
+		moved.LastEditDevice = deviceID
+
+		if err := s.noteRepo.Update(ctx, &moved); err != nil {
+			return err
+		}
+
+		// Version and conflict bytes follow on the next reconciliation
+		if s.usageService != nil {
+			s.usageService.RecordTransfer(ctx, job.UserID, note.WorkspaceID, targetID, NoteSize(note), 0)
+		}
+		if s.syncService != nil {
+			s.syncService.BroadcastNoteUpdate(job.UserID, deviceID, noteToResponse(&moved))
+		}
+
+	default:
+		return ErrInvalidDeleteMode
+	}
+
+	return nil
+}
+
+// transferWorkspace moves every note the previous owner still holds in the
+// workspace to the job's user. A note is re-owned after its conflicts and
+// CRDT updates, so an interrupted job picks it up again.
+func (s *WorkspaceService) transferWorkspace(ctx context.Context, job *domain.Job) error {
+	workspaceID := job.Params["workspace_id"]
+	previousOwnerID := job.Params["previous_owner_id"]
+
+	for {
+		notes, err := s.noteRepo.ListByWorkspace(ctx, workspaceID)
+		if err != nil {
+			return err
+		}
+
+		var pending []*domain.Note
+		for _, n := range notes {
+			if n.UserID == previousOwnerID {
+				pending = append(pending, n)
+			}
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+
+		job.Total = job.Processed + len(pending)
+		for _, n := range pending {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if err := s.transferNote(ctx, job.UserID, n); err != nil {
+				// The note changed since it was listed; the next pass
+				// reads it again
+				if errors.Is(err, repository.ErrConflict) {
+					continue
+				}
+				return err
+			}
+
+			job.Processed++
+			if job.Processed%jobProgressInterval == 0 {
+				s.saveJob(ctx, job)
+			}
+		}
+	}
+
+	if s.syncService != nil {
+		s.syncService.BroadcastWorkspaceTransfer(workspaceID, previousOwnerID, job.UserID)
+	}
+
+	return nil
+}
+
+func (s *WorkspaceService) transferNote(ctx context.Context, userID string, note *domain.Note) error {
+	if err := s.conflictRepo.Reassign(ctx, note.ID, userID); err != nil {
+		return err
+	}
+	if err := s.crdtRepo.Reassign(ctx, note.ID, userID); err != nil {
+		return err
+	}
+
+	transferred := *note
+	transferred.UserID = userID
+	transferred.Version++
+	transferred.UpdatedAt = time.Now()
+	if err := s.noteRepo.Update(ctx, &transferred); err != nil {
+		return err
+	}
+
+	// Version and conflict bytes follow on the next reconciliation
+	if s.usageService != nil {
+		size := NoteSize(note)
+		s.usageService.RecordWrite(ctx, note.UserID, note.WorkspaceID, -size, 0)
+		s.usageService.RecordWrite(ctx, userID, note.WorkspaceID, size, 0)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// Archive makes a workspace read-only and hides it from syncs that don't
+// explicitly ask for it
+func (s *WorkspaceService) Archive(ctx context.Context, userID, workspaceID string) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.getOwned(ctx, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workspace.IsDefault {
+		return nil, ErrDefaultImmutable
+	}
+
+	if !workspace.IsArchived {
+		now := time.Now()
+		workspace.IsArchived = true
+		workspace.ArchivedAt = &now
+		workspace.UpdatedAt = now
+
+		if err := s.workspaceRepo.Update(ctx, workspace); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.workspaceToResponse(ctx, workspace), nil
+}
+
+// Unarchive restores an archived workspace
+func (s *WorkspaceService) Unarchive(ctx context.Context, userID, workspaceID string) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.getOwned(ctx, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workspace.IsArchived {
+		workspace.IsArchived = false
+		workspace.ArchivedAt = nil
+		workspace.UpdatedAt = time.Now()
+
+		if err := s.workspaceRepo.Update(ctx, workspace); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.workspaceToResponse(ctx, workspace), nil
+}
+
+// SetDefault makes a workspace the user's default one
+func (s *WorkspaceService) SetDefault(ctx context.Context, userID, workspaceID string) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.getOwned(ctx, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workspace.IsDefault {
+		return s.workspaceToResponse(ctx, workspace), nil
+	}
+
+	if workspace.IsArchived {
+		return nil, ErrWorkspaceArchived
+	}
+
+	now := time.Now()
+	if current, err := s.workspaceRepo.GetDefault(ctx, userID); err == nil {
+		current.IsDefault = false
+		current.UpdatedAt = now
+		if err := s.workspaceRepo.Update(ctx, current); err != nil {
+			return nil, err
+		}
+	}
+
+	workspace.IsDefault = true
+	workspace.UpdatedAt = now
+	if err := s.workspaceRepo.Update(ctx, workspace); err != nil {
+		return nil, err
+	}
+
+	return s.workspaceToResponse(ctx, workspace), nil
+}
+
+// RequestTransfer offers the ownership of a workspace to another user. The
+// transfer only happens once the recipient accepts it.
+func (s *WorkspaceService) RequestTransfer(ctx context.Context, userID, workspaceID string, req *domain.TransferWorkspaceRequest) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.getOwned(ctx, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workspace.IsDefault {
+		return nil, ErrDefaultImmutable
+	}
+
+	recipient, err := s.userRepo.FindByEmail(ctx, req.Email)
+	if err != nil || recipient.ID == userID {
+		return nil, ErrInvalidTransfer
+	}
+
+	now := time.Now()
+	workspace.PendingOwnerID = recipient.ID
+	workspace.TransferRequestedAt = &now
+	workspace.UpdatedAt = now
+
+	if err := s.workspaceRepo.Update(ctx, workspace); err != nil {
+		return nil, err
+	}
+
+	return s.workspaceToResponse(ctx, workspace), nil
+}
+
+// ListIncomingTransfers returns the workspaces offered to a user
+func (s *WorkspaceService) ListIncomingTransfers(ctx context.Context, userID string) ([]*domain.WorkspaceResponse, error) {
+	workspaces, err := s.workspaceRepo.GetPendingTransfers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*domain.WorkspaceResponse, len(workspaces))
+	for i, ws := range workspaces {
+		responses[i] = s.workspaceToResponse(ctx, ws)
+	}
+
+	return responses, nil
+}
+
+// AcceptTransfer makes the recipient the owner of the workspace right away
+// and starts a job that moves its notes, with their conflicts and CRDT
+// updates, to the recipient
+func (s *WorkspaceService) AcceptTransfer(ctx context.Context, userID, workspaceID string) (*domain.Job, error) {
+	workspace, err := s.workspaceRepo.Get(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workspace.PendingOwnerID == "" || workspace.PendingOwnerID != userID {
+		return nil, ErrNoPendingTransfer
+	}
+
+	notes, err := s.noteRepo.ListByWorkspace(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	previousOwnerID := workspace.OwnerID
+
+	var contentBytes int64
+	for _, n := range notes {
+		if n.UserID == previousOwnerID {
+			contentBytes += NoteSize(n)
+		}
+	}
+
+	if s.usageService != nil {
+		if err := s.usageService.CheckWrite(ctx, userID, workspaceID, 0, contentBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	// The update is checked against the revision read above, so a transfer
+	// declined or offered to someone else in the meantime fails here
+	previous := *workspace
+	now := time.Now()
+	workspace.OwnerID = userID
+	workspace.PendingOwnerID = ""
+	workspace.TransferRequestedAt = nil
+	workspace.IsDefault = false
+	workspace.UpdatedAt = now
+
+	if err := s.workspaceRepo.Update(ctx, workspace); err != nil {
+		return nil, err
+	}
+
+	job := &domain.Job{
+		ID:     "job:" + uuid.New().String(),
+		UserID: userID,
+		Type:   domain.JobTypeWorkspaceTransfer,
+		Status: domain.JobStatusPending,
+		Params: map[string]string{
+			"workspace_id":      workspaceID,
+			"previous_owner_id": previousOwnerID,
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		// Hand the workspace back so the transfer can be accepted again
+		previous.Rev = workspace.Rev
+		if rollbackErr := s.workspaceRepo.Update(ctx, &previous); rollbackErr != nil {
+			log.Printf("failed to restore workspace %s after a failed transfer: %v", workspaceID, rollbackErr)
+		}
+		return nil, err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// DeclineTransfer cancels a pending transfer. Both the recipient and the
+// current owner may do so.
+func (s *WorkspaceService) DeclineTransfer(ctx context.Context, userID, workspaceID string) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.workspaceRepo.Get(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workspace.PendingOwnerID == "" || (workspace.PendingOwnerID != userID && workspace.OwnerID != userID) {
+		return nil, ErrNoPendingTransfer
+	}
+
+	workspace.PendingOwnerID = ""
+	workspace.TransferRequestedAt = nil
+	workspace.UpdatedAt = time.Now()
+
+	if err := s.workspaceRepo.Update(ctx, workspace); err != nil {
+		return nil, err
+	}
+
+	return s.workspaceToResponse(ctx, workspace), nil
+}
+
+func (s *WorkspaceService) getOwned(ctx context.Context, userID, workspaceID string) (*domain.Workspace, error) {
+	workspace, err := s.workspaceRepo.Get(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workspace.OwnerID != userID {
+		return nil, ErrAccessDenied
+	}
+
+	return workspace, nil
+}
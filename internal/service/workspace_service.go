@@ -1,7 +1,9 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"log"
 	"time"
 
 	"inkdown-sync-server/internal/domain"
@@ -11,58 +13,99 @@ import (
 )
 
 var (
-	ErrWorkspaceNotFound = errors.New("workspace not found")
-	ErrAccessDenied      = errors.New("access denied")
+	ErrWorkspaceNotFound  = repository.ErrWorkspaceNotFound
+	ErrAccessDenied       = errors.New("access denied")
+	ErrDefaultWorkspace   = errors.New("cannot delete default workspace")
+	ErrInvalidDeleteMode  = errors.New("invalid delete mode")
+	ErrNoDefaultWorkspace = errors.New("user has no default workspace")
+	ErrWorkspaceArchived  = errors.New("workspace is archived")
+	ErrDefaultImmutable   = errors.New("the default workspace cannot be archived or transferred")
+	ErrNoPendingTransfer  = errors.New("no pending transfer for this user")
+	ErrInvalidTransfer    = errors.New("invalid transfer recipient")
+	ErrInvalidPolicy      = errors.New("invalid conflict policy")
 )
 
 type WorkspaceService struct {
 	workspaceRepo repository.WorkspaceRepository
 	noteRepo      repository.NoteRepository
+	userRepo      repository.UserRepository
+	jobRepo       repository.JobRepository
+	conflictRepo  repository.ConflictRepository
+	crdtRepo      repository.CRDTRepository
+	trashService  *TrashService
+	syncService   *SyncService
+	usageService  *UsageService
+	wake          chan struct{}
 }
 
-func NewWorkspaceService(workspaceRepo repository.WorkspaceRepository, noteRepo repository.NoteRepository) *WorkspaceService {
+func NewWorkspaceService(
+	workspaceRepo repository.WorkspaceRepository,
+	noteRepo repository.NoteRepository,
+	userRepo repository.UserRepository,
+	jobRepo repository.JobRepository,
+	conflictRepo repository.ConflictRepository,
+	crdtRepo repository.CRDTRepository,
+	trashService *TrashService,
+	syncService *SyncService,
+	usageService *UsageService,
+) *WorkspaceService {
 	return &WorkspaceService{
 		workspaceRepo: workspaceRepo,
 		noteRepo:      noteRepo,
+		userRepo:      userRepo,
+		jobRepo:       jobRepo,
+		conflictRepo:  conflictRepo,
+		crdtRepo:      crdtRepo,
+		trashService:  trashService,
+		syncService:   syncService,
+		usageService:  usageService,
+		wake:          make(chan struct{}, 1),
 	}
 }
 
 // Create creates a new workspace for the user
-func (s *WorkspaceService) Create(ownerID string, req *domain.CreateWorkspaceRequest) (*domain.WorkspaceResponse, error) {
+func (s *WorkspaceService) Create(ctx context.Context, ownerID string, req *domain.CreateWorkspaceRequest) (*domain.WorkspaceResponse, error) {
+	if req.ConflictPolicy != "" && !domain.IsConflictPolicy(req.ConflictPolicy) {
+		return nil, ErrInvalidPolicy
+	}
+
 	workspace := &domain.Workspace{
-		ID:        "workspace:" + uuid.New().String(),
-		OwnerID:   ownerID,
-		Name:      req.Name,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		IsDefault: false,
+		ID:             "workspace:" + uuid.New().String(),
+		OwnerID:        ownerID,
+		Name:           req.Name,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		IsDefault:      false,
+		ConflictPolicy: req.ConflictPolicy,
 	}
 
-	if err := s.workspaceRepo.Create(workspace); err != nil {
+	if err := s.workspaceRepo.Create(ctx, workspace); err != nil {
 		return nil, err
 	}
 
-	return s.workspaceToResponse(workspace), nil
+	return s.workspaceToResponse(ctx, workspace), nil
 }
 
 // List returns all workspaces for a user
-func (s *WorkspaceService) List(ownerID string) ([]*domain.WorkspaceResponse, error) {
-	workspaces, err := s.workspaceRepo.GetByOwner(ownerID)
+func (s *WorkspaceService) List(ctx context.Context, ownerID string) ([]*domain.WorkspaceResponse, error) {
+	workspaces, err := s.workspaceRepo.GetByOwner(ctx, ownerID)
 	if err != nil {
 		return nil, err
 	}
 
+	stats := s.workspaceStats(ctx, ownerID)
+
 	responses := make([]*domain.WorkspaceResponse, len(workspaces))
 	for i, ws := range workspaces {
-		responses[i] = s.workspaceToResponse(ws)
+		responses[i] = newWorkspaceResponse(ws, stats[ws.ID])
 	}
 
 	return responses, nil
 }
 
 // Get returns a specific workspace if the user has access
-func (s *WorkspaceService) Get(userID, workspaceID string) (*domain.WorkspaceResponse, error) {
-	workspace, err := s.workspaceRepo.Get(workspaceID)
+func (s *WorkspaceService) Get(ctx context.Context, userID, workspaceID string) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.workspaceRepo.Get(ctx, workspaceID)
 	if err != nil {
 		return nil, err
 	}
@@ -71,12 +114,12 @@ func (s *WorkspaceService) Get(userID, workspaceID string) (*domain.WorkspaceRes
 		return nil, ErrAccessDenied
 	}
 
-	return s.workspaceToResponse(workspace), nil
+	return s.workspaceToResponse(ctx, workspace), nil
 }
 
 // Update updates a workspace
-func (s *WorkspaceService) Update(userID, workspaceID string, req *domain.UpdateWorkspaceRequest) (*domain.WorkspaceResponse, error) {
-	workspace, err := s.workspaceRepo.Get(workspaceID)
+func (s *WorkspaceService) Update(ctx context.Context, userID, workspaceID string, req *domain.UpdateWorkspaceRequest) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.workspaceRepo.Get(ctx, workspaceID)
 	if err != nil {
 		return nil, err
 	}
@@ -85,39 +128,98 @@ func (s *WorkspaceService) Update(userID, workspaceID string, req *domain.Update
 		return nil, ErrAccessDenied
 	}
 
+	if req.ConflictPolicy != "" && !domain.IsConflictPolicy(req.ConflictPolicy) {
+		return nil, ErrInvalidPolicy
+	}
+
 	if req.Name != "" {
 		workspace.Name = req.Name
 	}
+	if req.ConflictPolicy != "" {
+		workspace.ConflictPolicy = req.ConflictPolicy
+	}
 	workspace.UpdatedAt = time.Now()
 
-	if err := s.workspaceRepo.Update(workspace); err != nil {
+	if err := s.workspaceRepo.Update(ctx, workspace); err != nil {
 		return nil, err
 	}
 
-	return s.workspaceToResponse(workspace), nil
+	return s.workspaceToResponse(ctx, workspace), nil
 }
 
-// Delete deletes a workspace
-func (s *WorkspaceService) Delete(userID, workspaceID string) error {
-	workspace, err := s.workspaceRepo.Get(workspaceID)
+// Delete schedules the deletion of a workspace and its notes. The notes are
+// processed in the background according to mode; the returned job reports
+// the progress.
+func (s *WorkspaceService) Delete(ctx context.Context, userID, workspaceID, mode, deviceID string) (*domain.Job, error) {
+	workspace, err := s.workspaceRepo.Get(ctx, workspaceID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if workspace.OwnerID != userID {
-		return ErrAccessDenied
+		return nil, ErrAccessDenied
 	}
 
 	if workspace.IsDefault {
-		return errors.New("cannot delete default workspace")
+		return nil, ErrDefaultWorkspace
+	}
+
+	switch mode {
+	case "":
+		mode = domain.WorkspaceDeleteTrash
+	case domain.WorkspaceDeleteTrash, domain.WorkspaceDeletePurge:
+	case domain.WorkspaceDeleteMove:
+		if _, err := s.workspaceRepo.GetDefault(ctx, userID); err != nil {
+			return nil, ErrNoDefaultWorkspace
+		}
+	default:
+		return nil, ErrInvalidDeleteMode
+	}
+
+	now := time.Now()
+	job := &domain.Job{
+		ID:     "job:" + uuid.New().String(),
+		UserID: userID,
+		Type:   domain.JobTypeWorkspaceDelete,
+		Status: domain.JobStatusPending,
+		Params: map[string]string{
+			"workspace_id": workspaceID,
+			"mode":         mode,
+			"device_id":    deviceID,
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// GetJob returns a job if it belongs to the user
+func (s *WorkspaceService) GetJob(ctx context.Context, userID, jobID string) (*domain.Job, error) {
+	job, err := s.jobRepo.Get(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.UserID != userID {
+		return nil, ErrAccessDenied
 	}
 
-	return s.workspaceRepo.Delete(workspaceID)
+	return job, nil
 }
 
 // ValidateAccess checks if a user has access to a workspace
-func (s *WorkspaceService) ValidateAccess(userID, workspaceID string) error {
-	workspace, err := s.workspaceRepo.Get(workspaceID)
+func (s *WorkspaceService) ValidateAccess(ctx context.Context, userID, workspaceID string) error {
+	workspace, err := s.workspaceRepo.Get(ctx, workspaceID)
 	if err != nil {
 		return ErrWorkspaceNotFound
 	}
@@ -130,51 +232,99 @@ func (s *WorkspaceService) ValidateAccess(userID, workspaceID string) error {
 	return nil
 }
 
-// CreateDefaultForUser creates a default workspace for a new user
-func (s *WorkspaceService) CreateDefaultForUser(userID string) (*domain.WorkspaceResponse, error) {
-	workspace := &domain.Workspace{
-		ID:        "workspace:" + uuid.New().String(),
-		OwnerID:   userID,
-		Name:      "My Workspace",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		IsDefault: true,
+// ValidateWriteAccess checks that a user can add notes to a workspace
+func (s *WorkspaceService) ValidateWriteAccess(ctx context.Context, userID, workspaceID string) error {
+	if err := s.ValidateAccess(ctx, userID, workspaceID); err != nil {
+		return err
+	}
+	return s.EnsureWritable(ctx, workspaceID)
+}
+
+// EnsureWritable rejects changes to notes of an archived workspace
+func (s *WorkspaceService) EnsureWritable(ctx context.Context, workspaceID string) error {
+	return ensureWritable(ctx, s.workspaceRepo, workspaceID)
+}
+
+// ensureWritable rejects changes to notes of an archived workspace. Notes
+// without a workspace or whose workspace no longer exists are left writable.
+func ensureWritable(ctx context.Context, workspaceRepo repository.WorkspaceRepository, workspaceID string) error {
+	if workspaceRepo == nil || workspaceID == "" {
+		return nil
+	}
+
+	workspace, err := workspaceRepo.Get(ctx, workspaceID)
+	if errors.Is(err, repository.ErrWorkspaceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if workspace.IsArchived {
+		return ErrWorkspaceArchived
 	}
 
-	if err := s.workspaceRepo.Create(workspace); err != nil {
+	return nil
+}
+
+// CreateDefaultForUser creates a default workspace for a new user
+func (s *WorkspaceService) CreateDefaultForUser(ctx context.Context, userID string) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.EnsureDefaultForUser(ctx, userID)
+	if err != nil {
 		return nil, err
 	}
 
-	return s.workspaceToResponse(workspace), nil
+	return s.workspaceToResponse(ctx, workspace), nil
 }
 
 // GetDefaultWorkspace returns the default workspace for a user
-func (s *WorkspaceService) GetDefaultWorkspace(userID string) (*domain.WorkspaceResponse, error) {
-	workspace, err := s.workspaceRepo.GetDefault(userID)
+func (s *WorkspaceService) GetDefaultWorkspace(ctx context.Context, userID string) (*domain.WorkspaceResponse, error) {
+	workspace, err := s.workspaceRepo.GetDefault(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.workspaceToResponse(workspace), nil
+	return s.workspaceToResponse(ctx, workspace), nil
 }
 
-func (s *WorkspaceService) workspaceToResponse(ws *domain.Workspace) *domain.WorkspaceResponse {
-	noteCount := 0
-	if notes, err := s.noteRepo.ListByWorkspace(ws.ID); err == nil {
-		// Count only non-deleted notes
-		for _, note := range notes {
-			if !note.IsDeleted {
-				noteCount++
-			}
-		}
+func (s *WorkspaceService) workspaceToResponse(ctx context.Context, ws *domain.Workspace) *domain.WorkspaceResponse {
+	return newWorkspaceResponse(ws, s.workspaceStats(ctx, ws.OwnerID)[ws.ID])
+}
+
+// workspaceStats returns the note stats of the user's workspaces. Stats are
+// informational, so a failed lookup only leaves them out of the responses.
+func (s *WorkspaceService) workspaceStats(ctx context.Context, ownerID string) map[string]*domain.WorkspaceStats {
+	stats, err := s.noteRepo.WorkspaceStats(ctx, ownerID)
+	if err != nil {
+		log.Printf("failed to load workspace stats for user %s: %v", ownerID, err)
+		return nil
 	}
+	return stats
+}
 
-	return &domain.WorkspaceResponse{
-		ID:        ws.ID,
-		Name:      ws.Name,
-		CreatedAt: ws.CreatedAt,
-		UpdatedAt: ws.UpdatedAt,
-		IsDefault: ws.IsDefault,
-		NoteCount: noteCount,
+func newWorkspaceResponse(ws *domain.Workspace, stats *domain.WorkspaceStats) *domain.WorkspaceResponse {
+	response := &domain.WorkspaceResponse{
+		ID:                  ws.ID,
+		OwnerID:             ws.OwnerID,
+		Name:                ws.Name,
+		CreatedAt:           ws.CreatedAt,
+		UpdatedAt:           ws.UpdatedAt,
+		IsDefault:           ws.IsDefault,
+		IsArchived:          ws.IsArchived,
+		ArchivedAt:          ws.ArchivedAt,
+		PendingOwnerID:      ws.PendingOwnerID,
+		TransferRequestedAt: ws.TransferRequestedAt,
+		ConflictPolicy:      ws.ConflictPolicy,
+	}
+	if response.ConflictPolicy == "" {
+		response.ConflictPolicy = domain.ResolutionManual
 	}
+
+	if stats != nil {
+		response.NoteCount = stats.NoteCount
+		response.TotalBytes = stats.TotalBytes
+		response.LastModifiedAt = stats.LastModifiedAt
+	}
+
+	return response
 }
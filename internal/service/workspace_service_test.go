@@ -0,0 +1,453 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"inkdown-sync-server/internal/domain"
+	"inkdown-sync-server/internal/repository"
+)
+
+type mockWorkspaceRepo struct {
+	workspaces map[string]*domain.Workspace
+}
+
+func newMockWorkspaceRepo() *mockWorkspaceRepo {
+	return &mockWorkspaceRepo{
+		workspaces: make(map[string]*domain.Workspace),
+	}
+}
+
+func (m *mockWorkspaceRepo) Create(ctx context.Context, workspace *domain.Workspace) error {
+	workspace.Rev = "1"
+	m.workspaces[workspace.ID] = workspace
+	return nil
+}
+
+// Get returns a copy so that updates are checked against the stored revision
+func (m *mockWorkspaceRepo) Get(ctx context.Context, id string) (*domain.Workspace, error) {
+	if ws, exists := m.workspaces[id]; exists {
+		workspace := *ws
+		return &workspace, nil
+	}
+	return nil, repository.ErrWorkspaceNotFound
+}
+
+func (m *mockWorkspaceRepo) GetByOwner(ctx context.Context, ownerID string) ([]*domain.Workspace, error) {
+	var workspaces []*domain.Workspace
+	for _, ws := range m.workspaces {
+		if ws.OwnerID == ownerID {
+			workspaces = append(workspaces, ws)
+		}
+	}
+	return workspaces, nil
+}
+
+func (m *mockWorkspaceRepo) GetDefault(ctx context.Context, ownerID string) (*domain.Workspace, error) {
+	for _, ws := range m.workspaces {
+		if ws.OwnerID == ownerID && ws.IsDefault {
+			return ws, nil
+		}
+	}
+	return nil, repository.ErrWorkspaceNotFound
+}
+
+func (m *mockWorkspaceRepo) GetPendingTransfers(ctx context.Context, userID string) ([]*domain.Workspace, error) {
+	var workspaces []*domain.Workspace
+	for _, ws := range m.workspaces {
+		if ws.PendingOwnerID == userID {
+			workspaces = append(workspaces, ws)
+		}
+	}
+	return workspaces, nil
+}
+
+func (m *mockWorkspaceRepo) Update(ctx context.Context, workspace *domain.Workspace) error {
+	existing, exists := m.workspaces[workspace.ID]
+	if !exists {
+		return repository.ErrWorkspaceNotFound
+	}
+	if workspace.Rev != "" && workspace.Rev != existing.Rev {
+		return repository.ErrConflict
+	}
+
+	rev, _ := strconv.Atoi(existing.Rev)
+	workspace.Rev = strconv.Itoa(rev + 1)
+	m.workspaces[workspace.ID] = workspace
+	return nil
+}
+
+func (m *mockWorkspaceRepo) Delete(ctx context.Context, id string) error {
+	if _, exists := m.workspaces[id]; exists {
+		delete(m.workspaces, id)
+		return nil
+	}
+	return repository.ErrWorkspaceNotFound
+}
+
+func TestWorkspaceService_ValidateAccess(t *testing.T) {
+	ctx := context.Background()
+	repo := newMockWorkspaceRepo()
+	service := NewWorkspaceService(repo, newMockNoteRepo(), nil, newMockJobRepo(), nil, nil, nil, nil, nil)
+
+	repo.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+
+	if err := service.ValidateAccess(ctx, "user1", "ws1"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := service.ValidateAccess(ctx, "user2", "ws1"); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("expected ErrAccessDenied, got %v", err)
+	}
+	if err := service.ValidateAccess(ctx, "user1", "missing"); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Errorf("expected ErrWorkspaceNotFound, got %v", err)
+	}
+}
+
+type mockJobRepo struct {
+	jobs map[string]*domain.Job
+}
+
+func newMockJobRepo() *mockJobRepo {
+	return &mockJobRepo{
+		jobs: make(map[string]*domain.Job),
+	}
+}
+
+func (m *mockJobRepo) Create(ctx context.Context, job *domain.Job) error {
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *mockJobRepo) Get(ctx context.Context, id string) (*domain.Job, error) {
+	if job, exists := m.jobs[id]; exists {
+		return job, nil
+	}
+	return nil, repository.ErrJobNotFound
+}
+
+func (m *mockJobRepo) Update(ctx context.Context, job *domain.Job) error {
+	if _, exists := m.jobs[job.ID]; exists {
+		m.jobs[job.ID] = job
+		return nil
+	}
+	return repository.ErrJobNotFound
+}
+
+func (m *mockJobRepo) ListUnfinished(ctx context.Context) ([]*domain.Job, error) {
+	var jobs []*domain.Job
+	for _, job := range m.jobs {
+		if job.Status == domain.JobStatusPending || job.Status == domain.JobStatusRunning {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func TestWorkspaceService_DeleteMovesNotes(t *testing.T) {
+	ctx := context.Background()
+	workspaces := newMockWorkspaceRepo()
+	notes := newMockNoteRepo()
+	jobs := newMockJobRepo()
+	service := NewWorkspaceService(workspaces, notes, nil, jobs, nil, nil, nil, nil, nil)
+
+	workspaces.Create(ctx, &domain.Workspace{ID: "default", OwnerID: "user1", IsDefault: true})
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	notes.Create(ctx, &domain.Note{ID: "n2", UserID: "user1", WorkspaceID: "ws1", Version: 1, IsDeleted: true})
+
+	if _, err := service.Delete(ctx, "user1", "default", "", "d1"); !errors.Is(err, ErrDefaultWorkspace) {
+		t.Errorf("expected ErrDefaultWorkspace, got %v", err)
+	}
+	if _, err := service.Delete(ctx, "user1", "ws1", "bogus", "d1"); !errors.Is(err, ErrInvalidDeleteMode) {
+		t.Errorf("expected ErrInvalidDeleteMode, got %v", err)
+	}
+
+	job, err := service.Delete(ctx, "user1", "ws1", domain.WorkspaceDeleteMove, "d1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := service.ProcessJobs(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	job, _ = service.GetJob(ctx, "user1", job.ID)
+	if job.Status != domain.JobStatusCompleted || job.Processed != 2 {
+		t.Errorf("unexpected job state %+v", job)
+	}
+
+	for _, id := range []string{"n1", "n2"} {
+		n, _ := notes.FindByID(ctx, id)
+		if n.WorkspaceID != "default" || n.Version != 2 {
+			t.Errorf("expected note %s in default workspace, got %+v", id, n)
+		}
+	}
+
+	if _, err := workspaces.Get(ctx, "ws1"); err == nil {
+		t.Error("expected workspace to be deleted")
+	}
+}
+
+func TestWorkspaceService_DeleteTrashesNotes(t *testing.T) {
+	ctx := context.Background()
+	workspaces := newMockWorkspaceRepo()
+	notes := newMockNoteRepo()
+	service := NewWorkspaceService(workspaces, notes, nil, newMockJobRepo(), nil, nil, nil, nil, nil)
+
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	notes.Create(ctx, &domain.Note{ID: "n2", UserID: "user1", WorkspaceID: "ws1", Version: 1, IsDeleted: true})
+
+	if _, err := service.Delete(ctx, "user1", "ws1", "", "d1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := service.ProcessJobs(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Trashed notes leave the deleted workspace so restoring them is safe
+	defaultWorkspace, err := workspaces.GetDefault(ctx, "user1")
+	if err != nil {
+		t.Fatalf("expected a default workspace, got %v", err)
+	}
+	for _, id := range []string{"n1", "n2"} {
+		n, _ := notes.FindByID(ctx, id)
+		if !n.IsDeleted || n.WorkspaceID != defaultWorkspace.ID {
+			t.Errorf("expected note %s in the trash of the default workspace, got %+v", id, n)
+		}
+	}
+}
+
+func TestWorkspaceService_MigrateDefaultWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	workspaces := newMockWorkspaceRepo()
+	notes := newMockNoteRepo()
+	users := newMockUserRepository()
+	service := NewWorkspaceService(workspaces, notes, users, newMockJobRepo(), nil, nil, nil, nil, nil)
+
+	users.Create(ctx, &domain.User{ID: "user1", Username: "user1", Email: "user1@example.com"})
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+	notes.Create(ctx, &domain.Note{ID: "valid", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	notes.Create(ctx, &domain.Note{ID: "empty", UserID: "user1", Version: 1})
+	notes.Create(ctx, &domain.Note{ID: "unknown", UserID: "user1", WorkspaceID: "gone", Version: 1})
+
+	if err := service.MigrateDefaultWorkspaces(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	def, err := workspaces.GetDefault(ctx, "user1")
+	if err != nil {
+		t.Fatalf("expected default workspace, got %v", err)
+	}
+
+	for _, id := range []string{"empty", "unknown"} {
+		n, _ := notes.FindByID(ctx, id)
+		if n.WorkspaceID != def.ID || n.Version != 2 {
+			t.Errorf("expected note %s in default workspace, got %+v", id, n)
+		}
+	}
+	if n, _ := notes.FindByID(ctx, "valid"); n.WorkspaceID != "ws1" || n.Version != 1 {
+		t.Errorf("expected valid note untouched, got %+v", n)
+	}
+
+	// Running again must not create a second default
+	service.MigrateDefaultWorkspaces(ctx)
+	if all, _ := workspaces.GetByOwner(ctx, "user1"); len(all) != 2 {
+		t.Errorf("expected 2 workspaces, got %d", len(all))
+	}
+}
+
+func TestWorkspaceService_ArchiveIsReadOnly(t *testing.T) {
+	ctx := context.Background()
+	workspaces := newMockWorkspaceRepo()
+	notes := newMockNoteRepo()
+	workspaceService := NewWorkspaceService(workspaces, notes, nil, newMockJobRepo(), nil, nil, nil, nil, nil)
+	noteService := NewNoteService(notes, &mockVersionRepo{}, nil, nil, nil, workspaceService, nil)
+
+	workspaces.Create(ctx, &domain.Workspace{ID: "default", OwnerID: "user1", IsDefault: true})
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+
+	if _, err := workspaceService.Archive(ctx, "user1", "default"); !errors.Is(err, ErrDefaultImmutable) {
+		t.Errorf("expected ErrDefaultImmutable, got %v", err)
+	}
+
+	archived, err := workspaceService.Archive(ctx, "user1", "ws1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !archived.IsArchived || archived.ArchivedAt == nil {
+		t.Error("expected workspace to be archived")
+	}
+
+	title := "changed"
+	if _, err := noteService.Update(ctx, "user1", "n1", &domain.UpdateNoteRequest{EncryptedTitle: &title, DeviceID: "d1"}); !errors.Is(err, ErrWorkspaceArchived) {
+		t.Errorf("expected ErrWorkspaceArchived, got %v", err)
+	}
+	if _, err := workspaceService.SetDefault(ctx, "user1", "ws1"); !errors.Is(err, ErrWorkspaceArchived) {
+		t.Errorf("expected ErrWorkspaceArchived, got %v", err)
+	}
+
+	if _, err := workspaceService.Unarchive(ctx, "user1", "ws1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := noteService.Update(ctx, "user1", "n1", &domain.UpdateNoteRequest{EncryptedTitle: &title, DeviceID: "d1"}); err != nil {
+		t.Errorf("expected no error after unarchive, got %v", err)
+	}
+
+	if _, err := workspaceService.SetDefault(ctx, "user1", "ws1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if def, _ := workspaces.GetDefault(ctx, "user1"); def.ID != "ws1" {
+		t.Errorf("expected ws1 to be the default, got %s", def.ID)
+	}
+	if old, _ := workspaces.Get(ctx, "default"); old.IsDefault {
+		t.Error("expected previous default to be unset")
+	}
+}
+
+func TestWorkspaceService_Transfer(t *testing.T) {
+	ctx := context.Background()
+	workspaces := newMockWorkspaceRepo()
+	notes := newMockNoteRepo()
+	users := newMockUserRepository()
+	conflicts := newMockConflictRepo()
+	crdt := newMockCRDTRepo()
+	service := NewWorkspaceService(workspaces, notes, users, newMockJobRepo(), conflicts, crdt, nil, nil, nil)
+
+	users.Create(ctx, &domain.User{ID: "user1", Username: "one", Email: "one@example.com"})
+	users.Create(ctx, &domain.User{ID: "user2", Username: "two", Email: "two@example.com"})
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1"})
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+	conflicts.Create(ctx, &domain.Conflict{ID: "c1", NoteID: "n1", UserID: "user1"})
+	crdt.AppendUpdate(ctx, &domain.CRDTUpdate{NoteID: "n1", UserID: "user1", Data: "u1"})
+
+	if _, err := service.RequestTransfer(ctx, "user1", "ws1", &domain.TransferWorkspaceRequest{Email: "one@example.com"}); !errors.Is(err, ErrInvalidTransfer) {
+		t.Errorf("expected ErrInvalidTransfer, got %v", err)
+	}
+
+	if _, err := service.RequestTransfer(ctx, "user1", "ws1", &domain.TransferWorkspaceRequest{Email: "two@example.com"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	incoming, _ := service.ListIncomingTransfers(ctx, "user2")
+	if len(incoming) != 1 {
+		t.Fatalf("expected 1 incoming transfer, got %d", len(incoming))
+	}
+
+	if _, err := service.AcceptTransfer(ctx, "user3", "ws1"); !errors.Is(err, ErrNoPendingTransfer) {
+		t.Errorf("expected ErrNoPendingTransfer, got %v", err)
+	}
+
+	job, err := service.AcceptTransfer(ctx, "user2", "ws1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if job.Type != domain.JobTypeWorkspaceTransfer || job.UserID != "user2" {
+		t.Errorf("unexpected transfer job %+v", job)
+	}
+	if ws, _ := workspaces.Get(ctx, "ws1"); ws.OwnerID != "user2" || ws.PendingOwnerID != "" {
+		t.Errorf("unexpected workspace after transfer %+v", ws)
+	}
+
+	if err := service.ProcessJobs(ctx); err != nil {
+		t.Fatalf("ProcessJobs failed: %v", err)
+	}
+	if job.Status != domain.JobStatusCompleted || job.Processed != 1 {
+		t.Errorf("expected the job to complete with 1 note, got %+v", job)
+	}
+
+	if n, _ := notes.FindByID(ctx, "n1"); n.UserID != "user2" || n.Version != 2 {
+		t.Errorf("expected note to follow the workspace, got %+v", n)
+	}
+	if c, _ := conflicts.Get(ctx, "c1"); c.UserID != "user2" {
+		t.Errorf("expected the conflict to follow the note, got %+v", c)
+	}
+	if updates, _ := crdt.ListUpdates(ctx, "n1", 0, 10); len(updates) != 1 || updates[0].UserID != "user2" {
+		t.Errorf("expected the crdt update to follow the note, got %+v", updates)
+	}
+}
+
+// racingWorkspaceRepo runs race once, after the next Get has read the
+// workspace
+type racingWorkspaceRepo struct {
+	*mockWorkspaceRepo
+	race func()
+}
+
+func (r *racingWorkspaceRepo) Get(ctx context.Context, id string) (*domain.Workspace, error) {
+	workspace, err := r.mockWorkspaceRepo.Get(ctx, id)
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+	return workspace, err
+}
+
+func TestWorkspaceService_AcceptRacingDecline(t *testing.T) {
+	ctx := context.Background()
+	workspaces := &racingWorkspaceRepo{mockWorkspaceRepo: newMockWorkspaceRepo()}
+	notes := newMockNoteRepo()
+	jobs := newMockJobRepo()
+	service := NewWorkspaceService(workspaces, notes, nil, jobs, nil, nil, nil, nil, nil)
+
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1", PendingOwnerID: "user2"})
+	notes.Create(ctx, &domain.Note{ID: "n1", UserID: "user1", WorkspaceID: "ws1", Version: 1})
+
+	workspaces.race = func() {
+		if _, err := service.DeclineTransfer(ctx, "user1", "ws1"); err != nil {
+			t.Fatalf("DeclineTransfer failed: %v", err)
+		}
+	}
+	if _, err := service.AcceptTransfer(ctx, "user2", "ws1"); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	if ws, _ := workspaces.Get(ctx, "ws1"); ws.OwnerID != "user1" || ws.PendingOwnerID != "" {
+		t.Errorf("expected the declined transfer to stand, got %+v", ws)
+	}
+	if len(jobs.jobs) != 0 {
+		t.Errorf("expected no transfer job, got %d", len(jobs.jobs))
+	}
+}
+
+func TestWorkspaceService_ListIncludesStats(t *testing.T) {
+	ctx := context.Background()
+	workspaces := newMockWorkspaceRepo()
+	notes := newMockNoteRepo()
+	service := NewWorkspaceService(workspaces, notes, nil, newMockJobRepo(), nil, nil, nil, nil, nil)
+
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws1", OwnerID: "user1", Name: "Work"})
+	workspaces.Create(ctx, &domain.Workspace{ID: "ws2", OwnerID: "user1", Name: "Empty"})
+
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+	notes.Create(ctx, &domain.Note{ID: "a", UserID: "user1", WorkspaceID: "ws1", EncryptedTitle: "ab", EncryptedContent: "cdef", UpdatedAt: older})
+	notes.Create(ctx, &domain.Note{ID: "b", UserID: "user1", WorkspaceID: "ws1", EncryptedTitle: "x", UpdatedAt: newer})
+	notes.Create(ctx, &domain.Note{ID: "c", UserID: "user1", WorkspaceID: "ws1", EncryptedTitle: "deleted", IsDeleted: true, UpdatedAt: newer})
+
+	responses, err := service.List(ctx, "user1")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	byID := make(map[string]*domain.WorkspaceResponse)
+	for _, r := range responses {
+		byID[r.ID] = r
+	}
+
+	work := byID["ws1"]
+	if work.NoteCount != 2 || work.TotalBytes != 7 {
+		t.Errorf("expected 2 notes and 7 bytes, got %d notes and %d bytes", work.NoteCount, work.TotalBytes)
+	}
+	if work.LastModifiedAt == nil || !work.LastModifiedAt.Equal(newer) {
+		t.Errorf("expected last modified %v, got %v", newer, work.LastModifiedAt)
+	}
+
+	if empty := byID["ws2"]; empty.NoteCount != 0 || empty.LastModifiedAt != nil {
+		t.Errorf("expected empty stats for ws2, got %+v", empty)
+	}
+}
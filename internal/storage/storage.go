@@ -0,0 +1,139 @@
+// Package storage opens the repositories of the configured database driver
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"inkdown-sync-server/internal/config"
+	"inkdown-sync-server/internal/migration"
+	"inkdown-sync-server/internal/repository"
+	"inkdown-sync-server/internal/repository/sqlite"
+
+	_ "github.com/go-kivik/kivik/v4/couchdb"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+const (
+	DriverCouchDB = "couchdb"
+	DriverSQLite  = "sqlite"
+	DriverMemory  = "memory"
+)
+
+// Repositories holds one implementation of every repository, all backed by
+// the same database.
+type Repositories struct {
+	Users        repository.UserRepository
+	Devices      repository.DeviceRepository
+	KeyStores    repository.KeyStoreRepository
+	Notes        repository.NoteRepository
+	Workspaces   repository.WorkspaceRepository
+	CLITokens    repository.CLITokenRepository
+	Usage        repository.UsageRepository
+	Tombstones   repository.TombstoneRepository
+	Jobs         repository.JobRepository
+	Versions     repository.NoteVersionRepository
+	SyncMetadata repository.SyncMetadataRepository
+	Conflicts    repository.ConflictRepository
+	CRDT         repository.CRDTRepository
+	Chunks       repository.ChunkRepository
+	close        func() error
+}
+
+// Open connects to the database selected by cfg.Driver, brings its schema up
+// to date and returns the repositories.
+func Open(ctx context.Context, cfg config.DatabaseConfig) (*Repositories, error) {
+	switch cfg.Driver {
+	case DriverCouchDB, "":
+		return openCouchDB(ctx, cfg)
+	case DriverSQLite:
+		return openSQLite(cfg.Path)
+	case DriverMemory:
+		return openSQLite(sqlite.MemoryDSN)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
+
+// Close releases the database connection
+func (r *Repositories) Close() error {
+	if r.close == nil {
+		return nil
+	}
+	return r.close()
+}
+
+func openCouchDB(ctx context.Context, cfg config.DatabaseConfig) (*Repositories, error) {
+	couchURL := fmt.Sprintf("http://%s:%s@%s:%s",
+		cfg.User,
+		cfg.Password,
+		cfg.Host,
+		cfg.Port,
+	)
+
+	client, err := kivik.New("couch", couchURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to CouchDB: %w", err)
+	}
+
+	exists, err := client.DBExists(ctx, cfg.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check database existence: %w", err)
+	}
+
+	if !exists {
+		if err := client.CreateDB(ctx, cfg.Name); err != nil {
+			return nil, fmt.Errorf("failed to create database: %w", err)
+		}
+		log.Printf("Created database: %s", cfg.Name)
+	}
+
+	if _, err := migration.NewRunner(client, cfg.Name).Run(ctx); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return &Repositories{
+		Users:        repository.NewUserRepository(client, cfg.Name),
+		Devices:      repository.NewDeviceRepository(client, cfg.Name),
+		KeyStores:    repository.NewKeyStoreRepository(client, cfg.Name),
+		Notes:        repository.NewNoteRepository(client, cfg.Name),
+		Workspaces:   repository.NewWorkspaceRepository(client, cfg.Name),
+		CLITokens:    repository.NewCLITokenRepository(client, cfg.Name),
+		Usage:        repository.NewUsageRepository(client, cfg.Name),
+		Tombstones:   repository.NewTombstoneRepository(client, cfg.Name),
+		Jobs:         repository.NewJobRepository(client, cfg.Name),
+		Versions:     repository.NewNoteVersionRepository(client, cfg.Name),
+		SyncMetadata: repository.NewSyncMetadataRepository(client, cfg.Name),
+		Conflicts:    repository.NewConflictRepository(client, cfg.Name),
+		CRDT:         repository.NewCRDTRepository(client, cfg.Name),
+		Chunks:       repository.NewChunkRepository(client, cfg.Name),
+		close:        client.Close,
+	}, nil
+}
+
+func openSQLite(dsn string) (*Repositories, error) {
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repositories{
+		Users:        sqlite.NewUserRepository(db),
+		Devices:      sqlite.NewDeviceRepository(db),
+		KeyStores:    sqlite.NewKeyStoreRepository(db),
+		Notes:        sqlite.NewNoteRepository(db),
+		Workspaces:   sqlite.NewWorkspaceRepository(db),
+		CLITokens:    sqlite.NewCLITokenRepository(db),
+		Usage:        sqlite.NewUsageRepository(db),
+		Tombstones:   sqlite.NewTombstoneRepository(db),
+		Jobs:         sqlite.NewJobRepository(db),
+		Versions:     sqlite.NewNoteVersionRepository(db),
+		SyncMetadata: sqlite.NewSyncMetadataRepository(db),
+		Conflicts:    sqlite.NewConflictRepository(db),
+		CRDT:         sqlite.NewCRDTRepository(db),
+		Chunks:       sqlite.NewChunkRepository(db),
+		close:        db.Close,
+	}, nil
+}
@@ -1,12 +1,17 @@
 package websocket
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// crdtQueueSize bounds how many CRDT messages of a client wait to be
+// handled before reading from it blocks
+const crdtQueueSize = 64
+
 type Client struct {
 	ID       string
 	UserID   string
@@ -14,9 +19,27 @@ type Client struct {
 	Conn     *websocket.Conn
 	Manager  *Manager
 	Send     chan []byte
+
+	// workspaces the client receives broadcasts for; empty means all.
+	// Guarded by the manager's clientsMutex.
+	workspaces map[string]bool
+
+	// resume is set when the client continues the session of its device
+	// after lastSeq, the last sequence number it acked
+	resume  bool
+	lastSeq int64
+
+	// crdt queues the client's CRDT messages, which are handled in order
+	// outside the manager's goroutine. Closed when reading stops.
+	crdt chan *Message
+
+	// ctx is canceled when the connection closes
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewClient(id, userID, deviceID string, conn *websocket.Conn, manager *Manager) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
 		ID:       id,
 		UserID:   userID,
@@ -24,11 +47,46 @@ func NewClient(id, userID, deviceID string, conn *websocket.Conn, manager *Manag
 		Conn:     conn,
 		Manager:  manager,
 		Send:     make(chan []byte, 256),
+		crdt:     make(chan *Message, crdtQueueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Context returns a context that is canceled once the client disconnects
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// ResumeFrom makes the client continue its device's session: messages
+// after lastSeq that the device has not acked are replayed on register
+func (c *Client) ResumeFrom(lastSeq int64) {
+	c.resume = true
+	c.lastSeq = lastSeq
+}
+
+// subscribedTo reports whether the client follows any of workspaceIDs
+func (c *Client) subscribedTo(workspaceIDs []string) bool {
+	return subscribed(c.workspaces, workspaceIDs)
+}
+
+func subscribed(workspaces map[string]bool, workspaceIDs []string) bool {
+	if len(workspaces) == 0 {
+		return true
+	}
+	for _, id := range workspaceIDs {
+		if workspaces[id] {
+			return true
+		}
 	}
+	return false
 }
 
 func (c *Client) ReadPump() {
+	go c.handleCRDT()
 	defer func() {
+		close(c.crdt)
+		c.cancel()
 		c.Manager.Unregister <- c
 		c.Conn.Close()
 	}()
@@ -48,10 +106,15 @@ func (c *Client) ReadPump() {
 			break
 		}
 
-		c.Manager.HandleMessage <- &ClientMessage{
-			Client:  c,
-			Message: message,
-		}
+		c.Manager.dispatch(c, message)
+	}
+}
+
+// handleCRDT handles the client's CRDT messages in the order they arrived
+// until reading from the client stops
+func (c *Client) handleCRDT() {
+	for msg := range c.crdt {
+		c.Manager.handle(c, msg)
 	}
 }
 
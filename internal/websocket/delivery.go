@@ -0,0 +1,272 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// DeliveryPolicy controls how messages to a device are kept until acked
+type DeliveryPolicy struct {
+	ReplayBuffer    int           // unacked messages kept per device
+	AckTimeout      time.Duration // resend unacked messages after it, 0 never resends
+	ReplayRetention time.Duration // how long a disconnected device can resume
+}
+
+// cleanupInterval is how often streams of disconnected devices are dropped
+// when neither the ack timeout nor the replay retention sets an interval
+const cleanupInterval = time.Minute
+
+// deviceStream numbers the messages sent to one device and keeps the ones it
+// has not acked, so they can be resent and replayed after a reconnect
+type deviceStream struct {
+	userID   string
+	deviceID string
+	// workspaces the device was subscribed to when it disconnected
+	workspaces map[string]bool
+	lastSeq    int64
+	// evicted is the last sequence number dropped from pending without an ack
+	evicted  int64
+	pending  []*pendingMessage // ordered by seq
+	lastSeen time.Time         // last time the device was seen connected
+	// acks is set once the device shows it confirms messages, by resuming
+	// with a sequence number or sending an ack. Only such devices get resends.
+	acks bool
+}
+
+type pendingMessage struct {
+	seq    int64
+	data   []byte
+	sentAt time.Time
+}
+
+// stream returns the stream of a device, creating it if needed. Callers hold
+// streamsMutex.
+func (m *Manager) stream(userID, deviceID string) *deviceStream {
+	if m.streams[userID] == nil {
+		m.streams[userID] = make(map[string]*deviceStream)
+	}
+
+	s, ok := m.streams[userID][deviceID]
+	if !ok {
+		s = &deviceStream{userID: userID, deviceID: deviceID}
+		m.streams[userID][deviceID] = s
+	}
+	return s
+}
+
+// sequence gives message the next sequence number of the device and keeps it
+// until acked. It returns the encoded message.
+func (m *Manager) sequence(userID, deviceID string, message *Message) ([]byte, error) {
+	m.streamsMutex.Lock()
+	defer m.streamsMutex.Unlock()
+
+	return m.sequenceLocked(m.stream(userID, deviceID), message)
+}
+
+func (m *Manager) sequenceLocked(s *deviceStream, message *Message) ([]byte, error) {
+	if !sequenced(message.Type) {
+		return json.Marshal(message)
+	}
+
+	numbered := *message
+	numbered.Seq = s.lastSeq + 1
+
+	data, err := json.Marshal(&numbered)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lastSeq = numbered.Seq
+	if !s.acks || m.delivery.ReplayBuffer <= 0 {
+		// Nothing is kept for a device that never acks, so a later resume
+		// from an earlier seq gets a resync
+		s.evicted = s.lastSeq
+		return data, nil
+	}
+
+	s.pending = append(s.pending, &pendingMessage{seq: numbered.Seq, data: data, sentAt: time.Now()})
+	if over := len(s.pending) - m.delivery.ReplayBuffer; over > 0 {
+		s.evicted = s.pending[over-1].seq
+		s.pending = s.pending[over:]
+	}
+
+	return data, nil
+}
+
+// sequenced reports whether messages of type msgType are numbered and kept
+// until acked. Replies to pings and acks are only useful right away.
+func sequenced(msgType MessageType) bool {
+	return msgType != TypePong && msgType != TypeAck
+}
+
+// ack drops the messages of a device up to seq
+func (m *Manager) ack(userID, deviceID string, seq int64) {
+	m.streamsMutex.Lock()
+	defer m.streamsMutex.Unlock()
+
+	s := m.stream(userID, deviceID)
+	s.acks = true
+	ackLocked(s, seq)
+}
+
+func ackLocked(s *deviceStream, seq int64) {
+	i := 0
+	for i < len(s.pending) && s.pending[i].seq <= seq {
+		i++
+	}
+	s.pending = s.pending[i:]
+}
+
+// bufferOffline keeps message for the user's disconnected devices that can
+// still resume, except excludeDeviceID and the devices in skip
+func (m *Manager) bufferOffline(userID string, message *Message, excludeDeviceID string, workspaceIDs []string, skip map[string]bool) error {
+	m.streamsMutex.Lock()
+	defer m.streamsMutex.Unlock()
+
+	for deviceID, s := range m.streams[userID] {
+		if deviceID == excludeDeviceID || skip[deviceID] {
+			continue
+		}
+		if workspaceIDs != nil && !subscribed(s.workspaces, workspaceIDs) {
+			continue
+		}
+		if _, err := m.sequenceLocked(s, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disconnected records when a device lost a connection and what it was
+// subscribed to, so messages sent while it is away are kept for it
+func (m *Manager) disconnected(client *Client) {
+	m.streamsMutex.Lock()
+	defer m.streamsMutex.Unlock()
+
+	s := m.stream(client.UserID, client.DeviceID)
+	s.workspaces = client.workspaces
+	s.lastSeen = time.Now()
+}
+
+// resume replays to a newly registered client the messages its device has
+// not acked after the client's last sequence number. A device that missed
+// messages no longer buffered is told to resync. Callers hold clientsMutex.
+func (m *Manager) resume(client *Client) {
+	m.streamsMutex.Lock()
+	defer m.streamsMutex.Unlock()
+
+	s := m.stream(client.UserID, client.DeviceID)
+	s.acks = s.acks || client.resume
+	if !client.resume {
+		// A fresh session starts after everything sent so far
+		s.pending = nil
+		return
+	}
+
+	ackLocked(s, client.lastSeq)
+	if client.lastSeq < s.evicted || client.lastSeq > s.lastSeq {
+		s.lastSeq = max(s.lastSeq, client.lastSeq)
+		s.pending = nil
+
+		resync, _ := NewMessage(TypeResync, nil)
+		data, err := m.sequenceLocked(s, resync)
+		if err != nil {
+			log.Printf("error encoding resync for client %s: %v", client.ID, err)
+			return
+		}
+		deliver(client, data)
+		return
+	}
+
+	now := time.Now()
+	for _, p := range s.pending {
+		if !deliver(client, p.data) {
+			break
+		}
+		p.sentAt = now
+	}
+}
+
+// retryUnacked resends the messages connected devices have not acked within
+// the ack timeout. Devices that stayed away longer than the replay retention
+// are forgotten.
+func (m *Manager) retryUnacked() {
+	m.clientsMutex.RLock()
+	defer m.clientsMutex.RUnlock()
+	m.streamsMutex.Lock()
+	defer m.streamsMutex.Unlock()
+
+	now := time.Now()
+	for userID, devices := range m.streams {
+		for deviceID, s := range devices {
+			clients := m.deviceClients(userID, deviceID)
+			if len(clients) == 0 {
+				if now.Sub(s.lastSeen) > m.delivery.ReplayRetention {
+					delete(devices, deviceID)
+				}
+				continue
+			}
+
+			s.lastSeen = now
+			if m.delivery.AckTimeout > 0 && s.acks {
+				resend(s, clients, now.Add(-m.delivery.AckTimeout))
+			}
+		}
+		if len(devices) == 0 {
+			delete(m.streams, userID)
+		}
+	}
+}
+
+// resend delivers the pending messages of a stream last sent before cutoff
+func resend(s *deviceStream, clients []*Client, cutoff time.Time) {
+	now := time.Now()
+	for _, p := range s.pending {
+		if p.sentAt.After(cutoff) {
+			continue
+		}
+		sent := false
+		for _, client := range clients {
+			sent = deliver(client, p.data) || sent
+		}
+		if !sent {
+			return
+		}
+		p.sentAt = now
+	}
+}
+
+// deviceClients returns the connections of a device. Callers hold
+// clientsMutex.
+func (m *Manager) deviceClients(userID, deviceID string) []*Client {
+	var clients []*Client
+	for clientID := range m.userIndex[userID] {
+		if client := m.clients[clientID]; client.DeviceID == deviceID {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// deliver queues data for client without blocking. A message that does not
+// fit stays pending and is resent once the client catches up.
+func deliver(client *Client, data []byte) bool {
+	select {
+	case client.Send <- data:
+		return true
+	default:
+		log.Printf("client %s send buffer full, deferring message", client.ID)
+		return false
+	}
+}
+
+// resumable reports whether a device without connections can still resume
+func (m *Manager) resumable(userID, deviceID string) bool {
+	m.streamsMutex.Lock()
+	defer m.streamsMutex.Unlock()
+
+	_, ok := m.streams[userID][deviceID]
+	return ok
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestClient(m *Manager, id, deviceID string, buffer int) *Client {
+	client := NewClient(id, "user1", deviceID, nil, m)
+	client.Send = make(chan []byte, buffer)
+	return client
+}
+
+// received drains the messages queued for client
+func received(t *testing.T, client *Client) []*Message {
+	t.Helper()
+
+	var messages []*Message
+	for {
+		select {
+		case data := <-client.Send:
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("invalid message: %v", err)
+			}
+			messages = append(messages, &msg)
+		default:
+			return messages
+		}
+	}
+}
+
+func seqs(messages []*Message) []int64 {
+	result := make([]int64, len(messages))
+	for i, msg := range messages {
+		result[i] = msg.Seq
+	}
+	return result
+}
+
+func TestManager_ResumeReplaysUnacked(t *testing.T) {
+	m := NewManager(5, time.Second, time.Second, time.Second, DeliveryPolicy{ReplayBuffer: 10, AckTimeout: time.Second, ReplayRetention: time.Minute})
+	update, _ := NewMessage(TypeNoteUpdate, nil)
+
+	client := newTestClient(m, "c1", "d1", 16)
+	client.ResumeFrom(0)
+	m.registerClient(client)
+	for i := 0; i < 3; i++ {
+		m.BroadcastToUser("user1", update, "")
+	}
+	if got := seqs(received(t, client)); !slices.Equal(got, []int64{1, 2, 3}) {
+		t.Fatalf("expected seqs 1-3, got %v", got)
+	}
+
+	m.ack("user1", "d1", 1)
+	m.unregisterClient(client)
+
+	// Sent while the device is away
+	m.BroadcastToUser("user1", update, "")
+
+	client = newTestClient(m, "c2", "d1", 16)
+	client.ResumeFrom(1)
+	m.registerClient(client)
+	if got := seqs(received(t, client)); !slices.Equal(got, []int64{2, 3, 4}) {
+		t.Errorf("expected seqs 2-4 to be replayed, got %v", got)
+	}
+}
+
+func TestManager_ResyncAfterEviction(t *testing.T) {
+	m := NewManager(5, time.Second, time.Second, time.Second, DeliveryPolicy{ReplayBuffer: 2, ReplayRetention: time.Minute})
+	update, _ := NewMessage(TypeNoteUpdate, nil)
+
+	client := newTestClient(m, "c1", "d1", 16)
+	client.ResumeFrom(0)
+	m.registerClient(client)
+	m.unregisterClient(client)
+	for i := 0; i < 3; i++ {
+		m.BroadcastToUser("user1", update, "")
+	}
+
+	client = newTestClient(m, "c2", "d1", 16)
+	client.ResumeFrom(0)
+	m.registerClient(client)
+	messages := received(t, client)
+	if len(messages) != 1 || messages[0].Type != TypeResync || messages[0].Seq != 4 {
+		t.Errorf("expected a resync at seq 4, got %+v", messages)
+	}
+}
+
+func TestManager_RetriesWhenBufferFull(t *testing.T) {
+	m := NewManager(5, time.Second, time.Second, time.Second, DeliveryPolicy{ReplayBuffer: 10, AckTimeout: time.Nanosecond, ReplayRetention: time.Minute})
+	update, _ := NewMessage(TypeNoteUpdate, nil)
+
+	client := newTestClient(m, "c1", "d1", 1)
+	client.ResumeFrom(0)
+	m.registerClient(client)
+	m.BroadcastToUser("user1", update, "")
+	m.BroadcastToUser("user1", update, "")
+
+	if m.GetUserConnections("user1") != 1 {
+		t.Fatal("expected the client to stay connected with a full buffer")
+	}
+	if got := seqs(received(t, client)); !slices.Equal(got, []int64{1}) {
+		t.Fatalf("expected seq 1 before the retry, got %v", got)
+	}
+
+	m.ack("user1", "d1", 1)
+	m.retryUnacked()
+	if got := seqs(received(t, client)); !slices.Equal(got, []int64{2}) {
+		t.Errorf("expected seq 2 to be resent, got %v", got)
+	}
+}
+
+func TestManager_RetriesOnlyDevicesThatAck(t *testing.T) {
+	m := NewManager(5, time.Second, time.Second, time.Second, DeliveryPolicy{ReplayBuffer: 10, AckTimeout: time.Nanosecond, ReplayRetention: time.Minute})
+	update, _ := NewMessage(TypeNoteUpdate, nil)
+
+	client := newTestClient(m, "c1", "d1", 16)
+	m.registerClient(client)
+	m.BroadcastToUser("user1", update, "")
+	received(t, client)
+
+	m.retryUnacked()
+	if got := received(t, client); len(got) != 0 {
+		t.Fatalf("expected no resend to a device that never acked, got %v", seqs(got))
+	}
+
+	m.ack("user1", "d1", 1)
+	m.BroadcastToUser("user1", update, "")
+	received(t, client)
+	m.retryUnacked()
+	if got := seqs(received(t, client)); !slices.Equal(got, []int64{2}) {
+		t.Errorf("expected seq 2 to be resent once the device acks, got %v", got)
+	}
+}
+
+func TestManager_NoReplayBuffer(t *testing.T) {
+	m := NewManager(5, time.Second, time.Second, time.Second, DeliveryPolicy{AckTimeout: time.Nanosecond, ReplayRetention: time.Minute})
+	update, _ := NewMessage(TypeNoteUpdate, nil)
+
+	client := newTestClient(m, "c1", "d1", 16)
+	client.ResumeFrom(0)
+	m.registerClient(client)
+	m.BroadcastToUser("user1", update, "")
+	if got := seqs(received(t, client)); !slices.Equal(got, []int64{1}) {
+		t.Fatalf("expected seq 1, got %v", got)
+	}
+
+	m.retryUnacked()
+	if got := received(t, client); len(got) != 0 {
+		t.Errorf("expected nothing kept without a replay buffer, got %v", seqs(got))
+	}
+}
+
+func TestManager_RepliesAreNotSequenced(t *testing.T) {
+	m := NewManager(5, time.Second, time.Second, time.Second, DeliveryPolicy{ReplayBuffer: 10, AckTimeout: time.Nanosecond, ReplayRetention: time.Minute})
+	pong, _ := NewMessage(TypePong, nil)
+	ack, _ := NewMessage(TypeAck, &AckPayload{Success: true})
+
+	client := newTestClient(m, "c1", "d1", 16)
+	client.ResumeFrom(0)
+	m.registerClient(client)
+	m.SendToClient("c1", pong)
+	m.SendToClient("c1", ack)
+
+	messages := received(t, client)
+	if len(messages) != 2 || messages[0].Seq != 0 || messages[1].Seq != 0 {
+		t.Fatalf("expected pong and ack without seq, got %+v", messages)
+	}
+
+	m.retryUnacked()
+	if got := received(t, client); len(got) != 0 {
+		t.Errorf("expected pong and ack not to be resent, got %+v", got)
+	}
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"sync"
@@ -24,13 +25,17 @@ type Manager struct {
 	pongWait       time.Duration
 	pingPeriod     time.Duration
 	messageHandler MessageHandler
+
+	delivery     DeliveryPolicy
+	streams      map[string]map[string]*deviceStream // by user and device
+	streamsMutex sync.Mutex
 }
 
 type MessageHandler interface {
-	HandleWebSocketMessage(client *Client, msg *Message) error
+	HandleWebSocketMessage(ctx context.Context, client *Client, msg *Message) error
 }
 
-func NewManager(maxConnPerUser int, writeWait, pongWait, pingPeriod time.Duration) *Manager {
+func NewManager(maxConnPerUser int, writeWait, pongWait, pingPeriod time.Duration, delivery DeliveryPolicy) *Manager {
 	return &Manager{
 		clients:        make(map[string]*Client),
 		userIndex:      make(map[string]map[string]bool),
@@ -41,6 +46,8 @@ func NewManager(maxConnPerUser int, writeWait, pongWait, pingPeriod time.Duratio
 		writeWait:      writeWait,
 		pongWait:       pongWait,
 		pingPeriod:     pingPeriod,
+		delivery:       delivery,
+		streams:        make(map[string]map[string]*deviceStream),
 	}
 }
 
@@ -49,6 +56,9 @@ func (m *Manager) SetMessageHandler(handler MessageHandler) {
 }
 
 func (m *Manager) Run() {
+	ticker := time.NewTicker(m.retryInterval())
+	defer ticker.Stop()
+
 	for {
 		select {
 		case client := <-m.Register:
@@ -59,10 +69,23 @@ func (m *Manager) Run() {
 
 		case clientMsg := <-m.HandleMessage:
 			m.processMessage(clientMsg)
+
+		case <-ticker.C:
+			m.retryUnacked()
 		}
 	}
 }
 
+func (m *Manager) retryInterval() time.Duration {
+	if m.delivery.AckTimeout > 0 {
+		return m.delivery.AckTimeout
+	}
+	if m.delivery.ReplayRetention > 0 {
+		return m.delivery.ReplayRetention
+	}
+	return cleanupInterval
+}
+
 func (m *Manager) registerClient(client *Client) {
 	m.clientsMutex.Lock()
 	defer m.clientsMutex.Unlock()
@@ -79,6 +102,7 @@ func (m *Manager) registerClient(client *Client) {
 
 	m.clients[client.ID] = client
 	m.userIndex[client.UserID][client.ID] = true
+	m.resume(client)
 
 	log.Printf("client registered: %s (user: %s, device: %s)", client.ID, client.UserID, client.DeviceID)
 }
@@ -96,6 +120,7 @@ func (m *Manager) unregisterClient(client *Client) {
 		}
 
 		close(client.Send)
+		m.disconnected(client)
 		log.Printf("client unregistered: %s", client.ID)
 	}
 }
@@ -107,42 +132,120 @@ func (m *Manager) processMessage(clientMsg *ClientMessage) {
 		return
 	}
 
+	// Acks confirm delivery up to a sequence number and need no reply
+	if msg.Type == TypeAck {
+		var ack AckPayload
+		if err := msg.UnmarshalPayload(&ack); err != nil {
+			log.Printf("error unmarshaling ack: %v", err)
+			return
+		}
+		m.ack(clientMsg.Client.UserID, clientMsg.Client.DeviceID, ack.Seq)
+		return
+	}
+
+	m.handle(clientMsg.Client, &msg)
+}
+
+// dispatch passes a message read from client on. CRDT messages write to
+// storage on every keystroke, so they go to the client's own queue instead of
+// holding up the hub, and with it every other connection.
+func (m *Manager) dispatch(client *Client, data []byte) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err == nil && isCRDT(msg.Type) {
+		client.crdt <- &msg
+		return
+	}
+
+	m.HandleMessage <- &ClientMessage{
+		Client:  client,
+		Message: data,
+	}
+}
+
+func (m *Manager) handle(client *Client, msg *Message) {
 	if m.messageHandler != nil {
-		if err := m.messageHandler.HandleWebSocketMessage(clientMsg.Client, &msg); err != nil {
+		if err := m.messageHandler.HandleWebSocketMessage(client.Context(), client, msg); err != nil {
 			log.Printf("error handling message: %v", err)
 		}
 	}
 }
 
 func (m *Manager) BroadcastToUser(userID string, message *Message, excludeDeviceID string) error {
+	return m.broadcast(userID, message, excludeDeviceID, nil)
+}
+
+// BroadcastToWorkspace sends message to the user's clients subscribed to any
+// of workspaceIDs. Clients without subscriptions receive every message.
+func (m *Manager) BroadcastToWorkspace(userID string, message *Message, excludeDeviceID string, workspaceIDs ...string) error {
+	return m.broadcast(userID, message, excludeDeviceID, workspaceIDs)
+}
+
+func (m *Manager) broadcast(userID string, message *Message, excludeDeviceID string, workspaceIDs []string) error {
 	m.clientsMutex.RLock()
 	defer m.clientsMutex.RUnlock()
 
-	messageBytes, err := json.Marshal(message)
-	if err != nil {
-		return err
-	}
+	// Each device numbers the message once, however many connections it has
+	connected := make(map[string]bool)
+	sequenced := make(map[string][]byte)
+	for clientID := range m.userIndex[userID] {
+		client := m.clients[clientID]
+		connected[client.DeviceID] = true
+		if client.DeviceID == excludeDeviceID {
+			continue
+		}
+		if workspaceIDs != nil && !client.subscribedTo(workspaceIDs) {
+			continue
+		}
 
-	clientIDs, exists := m.userIndex[userID]
-	if !exists {
-		return nil
+		data, ok := sequenced[client.DeviceID]
+		if !ok {
+			var err error
+			if data, err = m.sequence(userID, client.DeviceID, message); err != nil {
+				return err
+			}
+			sequenced[client.DeviceID] = data
+		}
+		deliver(client, data)
 	}
 
-	for clientID := range clientIDs {
-		client := m.clients[clientID]
-		if client.DeviceID != excludeDeviceID {
-			select {
-			case client.Send <- messageBytes:
-			default:
-				log.Printf("client %s send buffer full, closing connection", clientID)
-				m.Unregister <- client
-			}
+	return m.bufferOffline(userID, message, excludeDeviceID, workspaceIDs, connected)
+}
+
+// Subscribe limits the broadcasts a client receives to workspaceIDs. An empty
+// list subscribes the client to every workspace.
+func (m *Manager) Subscribe(client *Client, workspaceIDs []string) {
+	m.clientsMutex.Lock()
+	defer m.clientsMutex.Unlock()
+
+	client.workspaces = workspaceSet(workspaceIDs)
+}
+
+// SubscribeDevice applies Subscribe to every connection of a device
+func (m *Manager) SubscribeDevice(userID, deviceID string, workspaceIDs []string) {
+	m.clientsMutex.Lock()
+	defer m.clientsMutex.Unlock()
+
+	for clientID := range m.userIndex[userID] {
+		if client := m.clients[clientID]; client.DeviceID == deviceID {
+			client.workspaces = workspaceSet(workspaceIDs)
 		}
 	}
+}
 
-	return nil
+func workspaceSet(workspaceIDs []string) map[string]bool {
+	if len(workspaceIDs) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(workspaceIDs))
+	for _, id := range workspaceIDs {
+		set[id] = true
+	}
+	return set
 }
 
+// SendToClient sends message to a single connection, such as the reply to a
+// message it sent
 func (m *Manager) SendToClient(clientID string, message *Message) error {
 	m.clientsMutex.RLock()
 	defer m.clientsMutex.RUnlock()
@@ -152,15 +255,31 @@ func (m *Manager) SendToClient(clientID string, message *Message) error {
 		return nil
 	}
 
-	messageBytes, err := json.Marshal(message)
+	data, err := m.sequence(client.UserID, client.DeviceID, message)
 	if err != nil {
 		return err
 	}
+	deliver(client, data)
+
+	return nil
+}
+
+// SendToDevice sends message to every connection of one of the user's devices
+func (m *Manager) SendToDevice(userID, deviceID string, message *Message) error {
+	m.clientsMutex.RLock()
+	defer m.clientsMutex.RUnlock()
+
+	clients := m.deviceClients(userID, deviceID)
+	if len(clients) == 0 && !m.resumable(userID, deviceID) {
+		return nil
+	}
 
-	select {
-	case client.Send <- messageBytes:
-	default:
-		log.Printf("client %s send buffer full", clientID)
+	data, err := m.sequence(userID, deviceID, message)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		deliver(client, data)
 	}
 
 	return nil
@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingHandler holds CRDT messages until release is closed and records
+// every message it handled
+type blockingHandler struct {
+	release chan struct{}
+	handled chan string
+}
+
+func (h *blockingHandler) HandleWebSocketMessage(ctx context.Context, client *Client, msg *Message) error {
+	if isCRDT(msg.Type) {
+		<-h.release
+	}
+	h.handled <- string(msg.Type) + string(msg.Payload)
+	return nil
+}
+
+func rawMessage(t *testing.T, msgType MessageType, payload interface{}) []byte {
+	t.Helper()
+
+	msg, err := NewMessage(msgType, payload)
+	if err != nil {
+		t.Fatalf("NewMessage failed: %v", err)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	return data
+}
+
+func TestManager_CRDTMessagesDoNotBlockHub(t *testing.T) {
+	m := NewManager(5, time.Second, time.Second, time.Second, DeliveryPolicy{})
+	handler := &blockingHandler{release: make(chan struct{}), handled: make(chan string, 8)}
+	m.SetMessageHandler(handler)
+	go m.Run()
+
+	client := newTestClient(m, "c1", "d1", 16)
+	m.Register <- client
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client.handleCRDT()
+	}()
+
+	m.dispatch(client, rawMessage(t, TypeCRDTUpdate, 1))
+	m.dispatch(client, rawMessage(t, TypeCRDTUpdate, 2))
+
+	// The hub still handles other messages while the updates are pending
+	m.dispatch(client, rawMessage(t, TypePing, nil))
+	select {
+	case got := <-handler.handled:
+		if got != string(TypePing) {
+			t.Fatalf("expected the ping to be handled first, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("hub blocked by a pending CRDT message")
+	}
+
+	close(handler.release)
+	close(client.crdt)
+	wg.Wait()
+
+	var updates []string
+	for len(handler.handled) > 0 {
+		updates = append(updates, <-handler.handled)
+	}
+	if want := []string{"crdt_update1", "crdt_update2"}; !slices.Equal(updates, want) {
+		t.Errorf("expected the updates in order %v, got %v", want, updates)
+	}
+}
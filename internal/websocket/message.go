@@ -12,14 +12,44 @@ const (
 	TypeSyncResponse MessageType = "sync_response"
 	TypeNoteUpdate   MessageType = "note_update"
 	TypeNoteDelete   MessageType = "note_delete"
+	TypeTreeChange   MessageType = "tree_change"
 	TypeConflict     MessageType = "conflict"
-	TypeAck          MessageType = "ack"
-	TypePing         MessageType = "ping"
-	TypePong         MessageType = "pong"
+	// TypeConflictResolved tells devices a conflict no longer needs attention
+	TypeConflictResolved MessageType = "conflict_resolved"
+	// TypeCRDTUpdate relays an encrypted CRDT update of a note. Devices send
+	// it without a sequence number and receive it with one.
+	TypeCRDTUpdate MessageType = "crdt_update"
+	// TypeCRDTSync asks for the updates of a note after a sequence number
+	// and carries the answer
+	TypeCRDTSync MessageType = "crdt_sync"
+	// TypeCRDTSnapshot uploads the document state that compacts a note's log
+	TypeCRDTSnapshot MessageType = "crdt_snapshot"
+	// TypeCRDTSnapshotRequest asks a device to upload a snapshot
+	TypeCRDTSnapshotRequest MessageType = "crdt_snapshot_request"
+	// TypeWorkspaceTransfer tells both owners that a workspace changed hands
+	TypeWorkspaceTransfer MessageType = "workspace_transfer"
+	TypeSubscribe         MessageType = "subscribe"
+	// TypeAck answers a device's message. Devices send it with the last
+	// sequence number they processed to confirm every message up to it.
+	TypeAck MessageType = "ack"
+	// TypeResync tells a reconnecting device that messages it missed are
+	// no longer buffered, so it has to run a full sync
+	TypeResync MessageType = "resync"
+	TypePing   MessageType = "ping"
+	TypePong   MessageType = "pong"
 )
 
+// isCRDT reports whether devices send messages of type msgType to edit or
+// catch up on a note's CRDT log
+func isCRDT(msgType MessageType) bool {
+	return msgType == TypeCRDTUpdate || msgType == TypeCRDTSync || msgType == TypeCRDTSnapshot
+}
+
+// Message is a WebSocket message. Messages from the server carry Seq, their
+// position in the stream of the receiving device.
 type Message struct {
 	Type      MessageType     `json:"type"`
+	Seq       int64           `json:"seq,omitempty"`
 	Timestamp time.Time       `json:"timestamp"`
 	Payload   json.RawMessage `json:"payload,omitempty"`
 }
@@ -28,6 +58,13 @@ type SyncRequestPayload struct {
 	DeviceID     string           `json:"device_id"`
 	LastSyncTime time.Time        `json:"last_sync_time"`
 	NoteVersions map[string]int64 `json:"note_versions"`
+	WorkspaceIDs []string         `json:"workspace_ids,omitempty"`
+}
+
+// SubscribePayload selects the workspaces a connection receives updates for.
+// An empty list subscribes to all of them.
+type SubscribePayload struct {
+	WorkspaceIDs []string `json:"workspace_ids"`
 }
 
 type SyncResponsePayload struct {
@@ -45,31 +82,105 @@ type NoteChange struct {
 
 type NoteUpdatePayload struct {
 	NoteID           string    `json:"note_id"`
+	WorkspaceID      string    `json:"workspace_id"`
 	Version          int64     `json:"version"`
 	EncryptedTitle   string    `json:"encrypted_title"`
 	EncryptedContent string    `json:"encrypted_content"`
+	Chunks           []string  `json:"chunks,omitempty"`
 	UpdatedAt        time.Time `json:"updated_at"`
 	DeviceID         string    `json:"device_id"`
 }
 
 type NoteDeletePayload struct {
-	NoteID   string `json:"note_id"`
-	Version  int64  `json:"version"`
-	DeviceID string `json:"device_id"`
+	NoteID      string `json:"note_id"`
+	WorkspaceID string `json:"workspace_id"`
+	Version     int64  `json:"version"`
+	DeviceID    string `json:"device_id"`
+}
+
+// TreeChangePayload describes an operation applied to a whole directory
+// subtree so devices can update it with a single message.
+type TreeChangePayload struct {
+	Operation       string          `json:"operation"`
+	RootID          string          `json:"root_id"`
+	ParentID        *string         `json:"parent_id"`
+	WorkspaceID     string          `json:"workspace_id"`
+	FromWorkspaceID string          `json:"from_workspace_id,omitempty"`
+	Notes           []TreeNoteEntry `json:"notes"`
+	DeviceID        string          `json:"device_id"`
+}
+
+type TreeNoteEntry struct {
+	NoteID    string `json:"note_id"`
+	Version   int64  `json:"version"`
+	IsDeleted bool   `json:"is_deleted"`
 }
 
 type ConflictPayload struct {
 	ConflictID    string          `json:"conflict_id"`
 	NoteID        string          `json:"note_id"`
+	WorkspaceID   string          `json:"workspace_id"`
+	ConflictType  string          `json:"conflict_type"`
+	BaseVersion   int64           `json:"base_version"`
 	ServerVersion int64           `json:"server_version"`
 	ClientVersion int64           `json:"client_version"`
 	ServerData    json.RawMessage `json:"server_data"`
+	DeviceID      string          `json:"device_id"`
+}
+
+// ConflictResolvedPayload reports how a conflict was resolved and the note
+// version that resulted from it.
+type ConflictResolvedPayload struct {
+	ConflictID  string `json:"conflict_id"`
+	NoteID      string `json:"note_id"`
+	WorkspaceID string `json:"workspace_id"`
+	Strategy    string `json:"strategy"`
+	Version     int64  `json:"version"`
+}
+
+// CRDTUpdatePayload is an encrypted CRDT update. MessageID is chosen by the
+// sending device and echoed in its ack.
+type CRDTUpdatePayload struct {
+	MessageID   string    `json:"message_id,omitempty"`
+	NoteID      string    `json:"note_id"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	Seq         int64     `json:"seq,omitempty"`
+	Data        string    `json:"data"`
+	DeviceID    string    `json:"device_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+}
+
+// CRDTSyncPayload asks for the updates of a note after AfterSeq
+type CRDTSyncPayload struct {
+	NoteID   string `json:"note_id"`
+	AfterSeq int64  `json:"after_seq"`
+}
+
+// CRDTSnapshotPayload uploads the state of a note after every update up to
+// Seq, or asks for it when sent by the server
+type CRDTSnapshotPayload struct {
+	MessageID   string `json:"message_id,omitempty"`
+	NoteID      string `json:"note_id"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+	Seq         int64  `json:"seq"`
+	Data        string `json:"data,omitempty"`
+}
+
+// WorkspaceTransferPayload announces that the notes of a workspace moved
+// from one owner to another
+type WorkspaceTransferPayload struct {
+	WorkspaceID string `json:"workspace_id"`
+	FromUserID  string `json:"from_user_id"`
+	ToUserID    string `json:"to_user_id"`
 }
 
 type AckPayload struct {
 	MessageID string `json:"message_id"`
 	Success   bool   `json:"success"`
 	Error     string `json:"error,omitempty"`
+	// Seq is the sequence number a CRDT update was stored under or, in acks
+	// from devices, the last message sequence number processed
+	Seq int64 `json:"seq,omitempty"`
 }
 
 func NewMessage(msgType MessageType, payload interface{}) (*Message, error) {
@@ -0,0 +1,139 @@
+// Package hlc implements hybrid logical clocks: timestamps that follow
+// physical time but stay ordered across devices whose clocks disagree.
+package hlc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timestamp is a point on a hybrid logical clock. Wall is in Unix
+// milliseconds; Logical orders events within the same millisecond. It is
+// encoded as "<wall>:<logical>".
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+}
+
+// FromTime returns the timestamp of t with no logical component
+func FromTime(t time.Time) Timestamp {
+	return Timestamp{Wall: t.UnixMilli()}
+}
+
+// Parse decodes a timestamp in the form produced by String
+func Parse(s string) (Timestamp, error) {
+	wall, logical, ok := strings.Cut(s, ":")
+	if !ok {
+		return Timestamp{}, fmt.Errorf("invalid hlc timestamp %q", s)
+	}
+
+	w, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil || w < 0 {
+		return Timestamp{}, fmt.Errorf("invalid hlc wall time %q", wall)
+	}
+	l, err := strconv.ParseUint(logical, 10, 32)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid hlc logical counter %q", logical)
+	}
+
+	return Timestamp{Wall: w, Logical: uint32(l)}, nil
+}
+
+func (t Timestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0
+}
+
+// Compare returns -1, 0 or 1 depending on whether t is before, equal to or
+// after u
+func (t Timestamp) Compare(u Timestamp) int {
+	switch {
+	case t.Wall < u.Wall:
+		return -1
+	case t.Wall > u.Wall:
+		return 1
+	case t.Logical < u.Logical:
+		return -1
+	case t.Logical > u.Logical:
+		return 1
+	}
+	return 0
+}
+
+// Time returns the physical part of t
+func (t Timestamp) Time() time.Time {
+	return time.UnixMilli(t.Wall).UTC()
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d:%d", t.Wall, t.Logical)
+}
+
+func (t Timestamp) MarshalText() ([]byte, error) {
+	if t.IsZero() {
+		return []byte{}, nil
+	}
+	return []byte(t.String()), nil
+}
+
+func (t *Timestamp) UnmarshalText(data []byte) error {
+	if len(data) == 0 {
+		*t = Timestamp{}
+		return nil
+	}
+
+	parsed, err := Parse(string(data))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Clock issues timestamps that never go backwards, even if the physical
+// clock does, and that follow every timestamp it has observed.
+type Clock struct {
+	mu   sync.Mutex
+	now  func() time.Time
+	last Timestamp
+}
+
+func NewClock() *Clock {
+	return &Clock{now: time.Now}
+}
+
+// Now returns a timestamp for a local event
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.now().UnixMilli()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Update merges a timestamp received from another node into the clock and
+// returns a timestamp that follows both it and every earlier local one.
+func (c *Clock) Update(remote Timestamp) Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.now().UnixMilli()
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = Timestamp{Wall: wall}
+	case remote.Wall > c.last.Wall:
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	case c.last.Wall > remote.Wall:
+		c.last.Logical++
+	default:
+		c.last.Logical = max(c.last.Logical, remote.Logical) + 1
+	}
+	return c.last
+}
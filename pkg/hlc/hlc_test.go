@@ -0,0 +1,116 @@
+package hlc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// fixedClock returns a clock whose physical time is read from wall
+func fixedClock(wall *int64) *Clock {
+	c := NewClock()
+	c.now = func() time.Time { return time.UnixMilli(*wall) }
+	return c
+}
+
+func TestClockNow(t *testing.T) {
+	wall := int64(1000)
+	c := fixedClock(&wall)
+
+	first := c.Now()
+	second := c.Now()
+	if first != (Timestamp{Wall: 1000}) || second != (Timestamp{Wall: 1000, Logical: 1}) {
+		t.Fatalf("expected logical counter to advance within a millisecond, got %v then %v", first, second)
+	}
+
+	// The physical clock going backwards must not move the clock back
+	wall = 500
+	if got := c.Now(); got.Compare(second) <= 0 {
+		t.Errorf("expected %v to follow %v", got, second)
+	}
+
+	wall = 2000
+	if got := c.Now(); got != (Timestamp{Wall: 2000}) {
+		t.Errorf("expected clock to catch up with wall time, got %v", got)
+	}
+}
+
+func TestClockUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		wall   int64
+		last   Timestamp
+		remote Timestamp
+		want   Timestamp
+	}{
+		{
+			name:   "local wall time ahead",
+			wall:   3000,
+			last:   Timestamp{Wall: 1000},
+			remote: Timestamp{Wall: 2000, Logical: 4},
+			want:   Timestamp{Wall: 3000},
+		},
+		{
+			name:   "remote ahead",
+			wall:   1000,
+			last:   Timestamp{Wall: 1000, Logical: 7},
+			remote: Timestamp{Wall: 5000, Logical: 2},
+			want:   Timestamp{Wall: 5000, Logical: 3},
+		},
+		{
+			name:   "last local event ahead",
+			wall:   1000,
+			last:   Timestamp{Wall: 6000, Logical: 1},
+			remote: Timestamp{Wall: 5000, Logical: 9},
+			want:   Timestamp{Wall: 6000, Logical: 2},
+		},
+		{
+			name:   "same wall time",
+			wall:   1000,
+			last:   Timestamp{Wall: 5000, Logical: 1},
+			remote: Timestamp{Wall: 5000, Logical: 4},
+			want:   Timestamp{Wall: 5000, Logical: 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fixedClock(&tt.wall)
+			c.last = tt.last
+
+			got := c.Update(tt.remote)
+			if got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+			if got.Compare(tt.remote) <= 0 || got.Compare(tt.last) <= 0 {
+				t.Errorf("Update() = %v does not follow remote %v and last %v", got, tt.remote, tt.last)
+			}
+		})
+	}
+}
+
+func TestTimestampText(t *testing.T) {
+	ts := Timestamp{Wall: 1718000000123, Logical: 42}
+
+	data, err := json.Marshal(ts)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `"1718000000123:42"` {
+		t.Errorf("unexpected encoding %s", data)
+	}
+
+	var decoded Timestamp
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded != ts {
+		t.Errorf("expected %v, got %v", ts, decoded)
+	}
+
+	for _, invalid := range []string{"123", "abc:1", "1:-1", "-5:0"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Parse(%q) expected error", invalid)
+		}
+	}
+}